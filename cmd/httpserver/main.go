@@ -1,32 +1,30 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"http/internal/headers"
+	"http/internal/metrics"
+	"http/internal/middleware"
+	"http/internal/proxy"
 	"http/internal/request"
 	"http/internal/response"
+	"http/internal/router"
 	"http/internal/server"
+	"http/internal/websocket"
 	"log"
-	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 )
 
 const port uint16 = 42069
 
-func toStr(bytes []byte) string {
-	out := ""
-	for _, b := range bytes {
-		out += fmt.Sprintf("%02x", b)
-	}
-	return out
-}
-
-func respond200() []byte {
-	return []byte(`<html>
+func respond200() string {
+	return `<html>
   <head>
     <title>200 OK</title>
   </head>
@@ -34,11 +32,11 @@ func respond200() []byte {
     <h1>Success!</h1>
     <p>Your request was an absolute banger.</p>
   </body>
-</html>`)
+</html>`
 }
 
-func respond400() []byte {
-	return []byte(`<html>
+func respond400() string {
+	return `<html>
   <head>
     <title>400 Bad Request</title>
   </head>
@@ -46,11 +44,11 @@ func respond400() []byte {
     <h1>Bad Request</h1>
     <p>Your request honestly kinda sucked.</p>
   </body>
-</html>`)
+</html>`
 }
 
-func respond500() []byte {
-	return []byte(`<html>
+func respond500() string {
+	return `<html>
   <head>
     <title>500 Internal Server Error</title>
   </head>
@@ -58,85 +56,140 @@ func respond500() []byte {
     <h1>Internal Server Error</h1>
     <p>Okay, you know what? This one is on me.</p>
   </body>
-</html>`)
+</html>`
 }
 
-func main() {
-	server, err := server.Serve(port, func(w *response.Writer, req *request.Request) {
-		h := response.GetDefaultHeaders(0)
-		body := respond200()
-		status := response.StatusOK
-		switch {
-		case strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/"):
-			target := req.RequestLine.RequestTarget
-			res, err := http.Get("https://httpbin.org" + target[len("/httpbin"):])
-			if err != nil {
-				body = respond500()
-				status = response.StatusInternalServerError
-			} else {
-				w.WriteStatusLine(response.StatusOK)
-				h.Delete("Content-length")
-				h.Set("Transfer-encoding", "chunked")
-				h.Replace("Content-type", "text/plain")
-				h.Set("Trailer", "X-Content-SHA256")
-				h.Set("Trailer", "X-Content-Length")
-				w.WriteHeaders(*h)
+// errorPage answers with the canned pages, and the server's default page
+// for statuses without one.
+func errorPage(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	switch status {
+	case response.StatusBadRequest:
+		w.WriteHTML(status, respond400())
+	case response.StatusInternalServerError:
+		w.WriteHTML(status, respond500())
+	default:
+		server.DefaultErrorHandler(w, req, status, err)
+	}
+}
+
+// handleVideo serves the video with Range support, so players can seek.
+func handleVideo(w *response.Writer, req *request.Request) {
+	server.ServeFile(w, req, "assets/vim.mp4")
+}
 
-				fullBody := []byte{}
-				for {
-					data := make([]byte, 1024)
-					n, err := res.Body.Read(data)
-					if err != nil {
-						break
-					}
-					if n > 0 {
-						fullBody = append(fullBody, data[:n]...)
-						w.WriteBody(fmt.Appendf(nil, "%x\r\n", n))
-						w.WriteBody(data[:n])
-						w.WriteBody([]byte("\r\n"))
-					}
-				}
-				w.WriteBody([]byte("0\r\n"))
-				trailer := headers.NewHeaders()
-				out := sha256.Sum256(fullBody)
-				trailer.Set("X-Content-SHA256", toStr(out[:]))
-				trailer.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-				w.WriteHeaders(*trailer)
-				return
-			}
-		case req.RequestLine.RequestTarget == "/video":
-			f, err := os.ReadFile("assets/vim.mp4")
-			if err != nil {
-				body = respond500()
-				status = response.StatusInternalServerError
-				break
-			}
-			h.Replace("Content-type", "video/mp4")
-			h.Replace("Content-length", fmt.Sprintf("%d", len(f)))
-			w.WriteStatusLine(response.StatusOK)
-			w.WriteHeaders(*h)
-			w.WriteBody(f)
+// handleEcho sends WebSocket messages back as they come.
+func handleEcho(w *response.Writer, req *request.Request) {
+	conn, err := websocket.Upgrade(w, req)
+	if err != nil {
+		return
+	}
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(typ, msg); err != nil {
 			return
-		case req.RequestLine.RequestTarget == "/yourproblem":
-			body = respond400()
-			status = response.StatusBadRequest
-		case req.RequestLine.RequestTarget == "/myproblem":
-			body = respond500()
-			status = response.StatusInternalServerError
 		}
-		h.Replace("Content-length", fmt.Sprintf("%d", len(body)))
-		h.Replace("Content-type", "text/html")
-		w.WriteStatusLine(status)
-		w.WriteHeaders(*h)
-		w.WriteBody(body)
+	}
+}
+
+// restart starts a new copy of this binary serving the same listener, so
+// that no connection is refused while this one shuts down.
+func restart(srv *server.Server) error {
+	f, err := srv.ListenerFile()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// The first of ExtraFiles is descriptor 3 in the child.
+	cmd := exec.Command(os.Args[0], append(os.Args[1:], "-listen-fd=3")...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("Handed the listener over to process %d", cmd.Process.Pid)
+	return nil
+}
+
+func main() {
+	static := flag.String("static", "", "directory to serve under /static/")
+	certFile := flag.String("cert", "", "PEM certificate file; serves HTTPS together with -key")
+	keyFile := flag.String("key", "", "PEM private key file for -cert")
+	reusePort := flag.Bool("reuseport", false, "listen with SO_REUSEPORT, so other processes can share the port")
+	trace := flag.Bool("trace", false, "answer TRACE requests by echoing them")
+	listenFD := flag.Int("listen-fd", 0, "serve the listener inherited as this file descriptor instead of binding the port")
+	flag.Parse()
+	rt := router.NewRouter()
+	stats := metrics.New()
+	rt.Handle("GET", "/metrics", stats.Serve)
+	if *static != "" {
+		files := &server.FileServer{Root: *static, Prefix: "/static"}
+		rt.Handle("GET", "/static/", files.Handle)
+		rt.Handle("HEAD", "/static/", files.Handle)
+	}
+	rt.Handle("GET", "/", func(w *response.Writer, req *request.Request) {
+		w.WriteHTML(response.StatusOK, respond200())
+	})
+	// The digest of each relayed body is sent as a trailer.
+	httpbin, err := proxy.New(proxy.Config{
+		Upstreams:     []string{"https://httpbin.org"},
+		Path:          proxy.PathRewrite{StripPrefix: "/httpbin"},
+		ContentDigest: true,
 	})
+	if err != nil {
+		log.Fatalf("Error configuring the httpbin proxy: %v", err)
+	}
+	defer httpbin.Close()
+	rt.Handle("GET", "/httpbin/", httpbin.Handle)
+	rt.Handle("GET", "/video", handleVideo)
+	rt.Handle("HEAD", "/video", handleVideo)
+	rt.Handle("GET", "/ws", handleEcho)
+	rt.Handle("GET", "/yourproblem", func(w *response.Writer, req *request.Request) {
+		errorPage(w, req, response.StatusBadRequest, nil)
+	})
+	rt.Handle("GET", "/myproblem", func(w *response.Writer, req *request.Request) {
+		errorPage(w, req, response.StatusInternalServerError, nil)
+	})
+	config := server.Config{
+		Addr:      fmt.Sprintf(":%d", port),
+		ReusePort: *reusePort,
+		Handler:   rt.Serve,
+		Middleware: []server.Middleware{
+			stats.Middleware(),
+			middleware.RequestID(),
+			middleware.AccessLog(os.Stdout, middleware.CombinedLogFormat),
+			middleware.Compress(1024),
+		},
+		ErrorHandler: errorPage,
+	}
+	if *listenFD != 0 {
+		config.Addr = fmt.Sprintf("fd:%d", *listenFD)
+	}
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Error loading certificate: %v", err)
+		}
+		config.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	srv, err := server.New(config, server.WithH2C(true), server.WithTrace(*trace), server.WithConnHooks(stats.ConnHooks()))
 	if err != nil {
 		log.Fatalf("Error starting server: %v ", err)
 	}
-	defer server.Close()
 	log.Printf("Server started on port: %v", port)
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	if <-sigChan == syscall.SIGHUP {
+		if err := restart(srv); err != nil {
+			log.Printf("Error restarting: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown cut connections short: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -77,7 +77,7 @@ func main() {
 		r.Headers().Foreach(func(n, v string) {
 			fmt.Printf("- %s: %s \n", n, v)
 		})
-		fmt.Printf("Body: %s", r.Body())
+		fmt.Printf("Body: %s", r.BodyString())
 	}
 
 	// *** For Reading from file ***
@@ -0,0 +1,155 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"http/internal/headers"
+	"http/internal/request"
+	"strings"
+)
+
+// Header names from RFC 9530.
+const (
+	ContentDigest = "Content-Digest"
+	ReprDigest    = "Repr-Digest"
+)
+
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha-256"
+	SHA512 Algorithm = "sha-512"
+)
+
+var ERROR_MALFORMED_DIGEST = fmt.Errorf("malformed digest field")
+var ERROR_NO_SUPPORTED_DIGEST = fmt.Errorf("no supported digest algorithm")
+var ERROR_DIGEST_MISMATCH = fmt.Errorf("digest mismatch")
+
+func newHash(alg Algorithm) (hash.Hash, bool) {
+	switch alg {
+	case SHA256:
+		return sha256.New(), true
+	case SHA512:
+		return sha512.New(), true
+	}
+	return nil, false
+}
+
+// Hasher computes digests over data written to it, so a body can be hashed
+// while it is being streamed out.
+type Hasher struct {
+	algs   []Algorithm
+	hashes []hash.Hash
+}
+
+func NewHasher(algs ...Algorithm) (*Hasher, error) {
+	if len(algs) == 0 {
+		algs = []Algorithm{SHA256}
+	}
+	d := &Hasher{}
+	for _, alg := range algs {
+		h, ok := newHash(alg)
+		if !ok {
+			return nil, fmt.Errorf("unsupported digest algorithm %q", alg)
+		}
+		d.algs = append(d.algs, alg)
+		d.hashes = append(d.hashes, h)
+	}
+	return d, nil
+}
+
+func (d *Hasher) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Value returns the field value, e.g. "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:".
+func (d *Hasher) Value() string {
+	parts := []string{}
+	for i, alg := range d.algs {
+		parts = append(parts, fmt.Sprintf("%s=:%s:", alg, base64.StdEncoding.EncodeToString(d.hashes[i].Sum(nil))))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func Value(data []byte, algs ...Algorithm) (string, error) {
+	d, err := NewHasher(algs...)
+	if err != nil {
+		return "", err
+	}
+	d.Write(data)
+	return d.Value(), nil
+}
+
+// Set computes the digest of data and sets it as field name (ContentDigest or
+// ReprDigest) on h.
+func Set(h *headers.Headers, name string, data []byte, algs ...Algorithm) error {
+	v, err := Value(data, algs...)
+	if err != nil {
+		return err
+	}
+	h.Set(name, v)
+	return nil
+}
+
+// Parse parses a digest field value into its algorithm/digest pairs.
+func Parse(value string) (map[Algorithm][]byte, error) {
+	out := map[Algorithm][]byte{}
+	for _, member := range strings.Split(value, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		key, val, found := strings.Cut(member, "=")
+		if !found || key == "" || len(val) < 2 || val[0] != ':' || val[len(val)-1] != ':' {
+			return nil, ERROR_MALFORMED_DIGEST
+		}
+		sum, err := base64.StdEncoding.DecodeString(val[1 : len(val)-1])
+		if err != nil {
+			return nil, ERROR_MALFORMED_DIGEST
+		}
+		out[Algorithm(strings.ToLower(key))] = sum
+	}
+	return out, nil
+}
+
+// Verify checks data against every supported algorithm in a digest field
+// value. Unknown algorithms are skipped, but at least one must be supported.
+func Verify(value string, data []byte) error {
+	sums, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	checked := 0
+	for alg, sum := range sums {
+		h, ok := newHash(alg)
+		if !ok {
+			continue
+		}
+		h.Write(data)
+		if !bytes.Equal(h.Sum(nil), sum) {
+			return ERROR_DIGEST_MISMATCH
+		}
+		checked++
+	}
+	if checked == 0 {
+		return ERROR_NO_SUPPORTED_DIGEST
+	}
+	return nil
+}
+
+// VerifyRequest checks the Content-Digest of a request's body. Requests
+// without the field pass.
+func VerifyRequest(req *request.Request) error {
+	value, ok := req.Headers().Get(ContentDigest)
+	if !ok {
+		return nil
+	}
+	return Verify(value, req.Body())
+}
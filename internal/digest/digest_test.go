@@ -0,0 +1,68 @@
+package digest
+
+import (
+	"http/internal/headers"
+	"http/internal/request"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigest(t *testing.T) {
+	// Test: Known values from RFC 9530
+	v, err := Value([]byte("{\"hello\": \"world\"}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:", v)
+	v, err = Value([]byte("{\"hello\": \"world\"}\n"), SHA256, SHA512)
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:, "+
+		"sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:", v)
+
+	// Test: Unsupported algorithm
+	_, err = Value([]byte("x"), "md5")
+	require.Error(t, err)
+
+	// Test: Set on headers
+	h := headers.NewHeaders()
+	require.NoError(t, Set(h, ReprDigest, []byte("hello")))
+	got, ok := h.Get("repr-digest")
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(got, "sha-256=:"))
+
+	// Test: Verify
+	assert.NoError(t, Verify(got, []byte("hello")))
+	assert.Equal(t, ERROR_DIGEST_MISMATCH, Verify(got, []byte("hellO")))
+	assert.Equal(t, ERROR_NO_SUPPORTED_DIGEST, Verify("md5=:AAAA:", []byte("hello")))
+	assert.NoError(t, Verify("md5=:AAAA:, "+got, []byte("hello")))
+	assert.Equal(t, ERROR_MALFORMED_DIGEST, Verify("sha-256=abc", []byte("hello")))
+	assert.Equal(t, ERROR_MALFORMED_DIGEST, Verify("sha-256=:!!!:", []byte("hello")))
+}
+
+func TestVerifyRequest(t *testing.T) {
+	// Test: Matching Content-Digest
+	req, err := request.RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 19\r\n" +
+		"Content-Digest: sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:\r\n" +
+		"\r\n" +
+		"{\"hello\": \"world\"}\n"))
+	require.NoError(t, err)
+	assert.NoError(t, VerifyRequest(req))
+
+	// Test: Tampered body
+	req, err = request.RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 19\r\n" +
+		"Content-Digest: sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:\r\n" +
+		"\r\n" +
+		"{\"hello\": \"there\"}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ERROR_DIGEST_MISMATCH, VerifyRequest(req))
+
+	// Test: No Content-Digest
+	req, err = request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.NoError(t, VerifyRequest(req))
+}
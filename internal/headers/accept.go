@@ -0,0 +1,111 @@
+package headers
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Preference is one entry of an Accept-style field: a media range, content
+// coding, charset or language with its q-value.
+type Preference struct {
+	Value string
+	Q     float64
+}
+
+// ParsePreferences parses the value of Accept, Accept-Encoding,
+// Accept-Charset or Accept-Language. Values are lowercased and stripped of
+// parameters other than q. The result is sorted by descending q, with more
+// specific entries first among equals. Entries with a malformed q are
+// skipped.
+func ParsePreferences(value string) []Preference {
+	prefs := []Preference{}
+	for _, entry := range SplitList(value) {
+		params := splitQuoted(entry, ';')
+		v := strings.ToLower(strings.TrimSpace(params[0]))
+		if v == "" {
+			continue
+		}
+		q, ok := 1.0, true
+		for _, p := range params[1:] {
+			name, pv, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				q, ok = parseQ(strings.TrimSpace(pv))
+			}
+		}
+		if ok {
+			prefs = append(prefs, Preference{Value: v, Q: q})
+		}
+	}
+	slices.SortStableFunc(prefs, func(a, b Preference) int {
+		if a.Q != b.Q {
+			if a.Q > b.Q {
+				return -1
+			}
+			return 1
+		}
+		return specificity(b.Value) - specificity(a.Value)
+	})
+	return prefs
+}
+
+// parseQ reads a weight: 0 to 1 with at most three decimals.
+func parseQ(s string) (float64, bool) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if (whole != "0" && whole != "1") || len(frac) > 3 || strings.Trim(frac, "0123456789") != "" {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+// specificity ranks "*/*" and "*" below "type/*" below anything else.
+func specificity(v string) int {
+	switch {
+	case v == "*" || v == "*/*":
+		return 0
+	case strings.HasSuffix(v, "/*"):
+		return 1
+	}
+	return 2
+}
+
+// matches reports whether the preference value covers offer.
+func matches(pref, offer string) bool {
+	switch specificity(pref) {
+	case 0:
+		return true
+	case 1:
+		return strings.HasPrefix(offer, pref[:len(pref)-1])
+	}
+	return pref == offer
+}
+
+// Weight returns the q-value prefs give offer, taken from the most specific
+// entry that covers it, and whether any entry does.
+func Weight(prefs []Preference, offer string) (float64, bool) {
+	offer = strings.ToLower(offer)
+	best := -1
+	q := 0.0
+	for _, p := range prefs {
+		if s := specificity(p.Value); s > best && matches(p.Value, offer) {
+			best, q = s, p.Q
+		}
+	}
+	return q, best != -1
+}
+
+// Negotiate returns the offer prefs rate highest, earlier offers winning
+// ties, or "" if none is acceptable.
+func Negotiate(prefs []Preference, offers ...string) string {
+	chosen, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q, ok := Weight(prefs, offer); ok && q > bestQ {
+			chosen, bestQ = offer, q
+		}
+	}
+	return chosen
+}
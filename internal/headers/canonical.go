@@ -0,0 +1,41 @@
+package headers
+
+import (
+	"strings"
+)
+
+// canonicalExceptions are names whose usual spelling is not the
+// capitalized-words form.
+var canonicalExceptions = map[string]string{
+	"etag":             "ETag",
+	"te":               "TE",
+	"dnt":              "DNT",
+	"www-authenticate": "WWW-Authenticate",
+	"content-md5":      "Content-MD5",
+	"x-xss-protection": "X-XSS-Protection",
+}
+
+// canonicalWords are dash-separated words written in capitals.
+var canonicalWords = map[string]bool{
+	"md5": true, "sha1": true, "sha256": true, "sha384": true, "sha512": true,
+	"www": true, "xss": true,
+}
+
+// CanonicalName returns name in the MIME-style form most clients expect:
+// each dash-separated word capitalized ("Content-Type"), with a few
+// well-known exceptions ("ETag", "X-Content-SHA256").
+func CanonicalName(name string) string {
+	lower := strings.ToLower(name)
+	if c, ok := canonicalExceptions[lower]; ok {
+		return c
+	}
+	words := strings.Split(lower, "-")
+	for i, w := range words {
+		if canonicalWords[w] {
+			words[i] = strings.ToUpper(w)
+		} else if w != "" && w[0] >= 'a' && w[0] <= 'z' {
+			words[i] = string(w[0]-'a'+'A') + w[1:]
+		}
+	}
+	return strings.Join(words, "-")
+}
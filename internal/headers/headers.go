@@ -3,53 +3,197 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log/slog"
+	"slices"
 	"strings"
 )
 
+// Headers holds field lines in the order they were added. Names are looked
+// up case-insensitively. Repeated fields keep their values apart; Get joins
+// them with commas.
 type Headers struct {
-	headers map[string]string
+	fields []field
+	// last is the most recently parsed field, which an obs-fold line
+	// continues.
+	last string
+	// maxFields caps how many field lines parsing accepts; zero means no
+	// limit.
+	maxFields int
+	// preserveCase writes names the way they were given instead of in
+	// canonical form.
+	preserveCase bool
+}
+
+type field struct {
+	// key is the lowercased name.
+	key   string
+	name  string
+	value string
 }
 
 var rn = []byte("\r\n")
 
+var ERROR_OBS_FOLD = fmt.Errorf("obsolete line folding in field line")
+var ERROR_INVALID_FIELD = fmt.Errorf("invalid field name or value")
+var ERROR_TOO_MANY_FIELDS = fmt.Errorf("too many header fields")
+
 func NewHeaders() *Headers {
-	return &Headers{
-		headers: map[string]string{},
-	}
+	return &Headers{}
 }
 
+// Get returns the values of name joined with commas.
 func (h *Headers) Get(name string) (string, bool) {
-	str, ok := h.headers[strings.ToLower(name)]
-	return str, ok
+	values := h.Values(name)
+	return strings.Join(values, ","), len(values) > 0
 }
 
-func (h *Headers) Set(name, value string) {
-	name = strings.ToLower(name)
-	if v, ok := h.headers[name]; ok {
-		h.headers[name] = fmt.Sprintf("%s,%s", v, value)
-	} else {
-		h.headers[name] = value
+// Values returns the values of every name field, in the order they were
+// added. The slice is a copy.
+func (h *Headers) Values(name string) []string {
+	key := strings.ToLower(name)
+	var values []string
+	for _, f := range h.fields {
+		if f.key == key {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Add appends a name field, keeping any already there. Values holding
+// control characters are dropped, see ValidValue.
+func (h *Headers) Add(name, value string) {
+	if !ValidValue(value) {
+		slog.Warn("dropping header with control characters in its value", "name", name)
+		return
 	}
+	h.fields = append(h.fields, field{strings.ToLower(name), name, value})
 }
 
-func (h *Headers) Replace(name, value string) {
-	name = strings.ToLower(name)
-	h.headers[name] = value
+// Set replaces all name fields with one carrying value, in the place of the
+// first. Like Add, it ignores values holding control characters, leaving
+// the fields as they were.
+func (h *Headers) Set(name, value string) {
+	if !ValidValue(value) {
+		slog.Warn("dropping header with control characters in its value", "name", name)
+		return
+	}
+	key := strings.ToLower(name)
+	i := slices.IndexFunc(h.fields, func(f field) bool { return f.key == key })
+	if i == -1 {
+		h.Add(name, value)
+		return
+	}
+	h.fields[i] = field{key, name, value}
+	rest := slices.DeleteFunc(h.fields[i+1:], func(f field) bool { return f.key == key })
+	h.fields = h.fields[:i+1+len(rest)]
 }
 
 func (h *Headers) Delete(name string) {
-	name = strings.ToLower(name)
-	delete(h.headers, name)
+	key := strings.ToLower(name)
+	h.fields = slices.DeleteFunc(h.fields, func(f field) bool { return f.key == key })
 }
 
+// Clone returns a copy of h that shares nothing with it.
+func (h *Headers) Clone() *Headers {
+	c := *h
+	c.fields = slices.Clone(h.fields)
+	return &c
+}
+
+// Merge copies the fields of other into h. Names other has replace those
+// in h, in the place of the first; the rest of h is kept.
+func (h *Headers) Merge(other *Headers) {
+	seen := map[string]bool{}
+	for _, f := range other.fields {
+		if seen[f.key] {
+			h.Add(f.name, f.value)
+			continue
+		}
+		seen[f.key] = true
+		h.Set(f.name, f.value)
+	}
+}
+
+// HasToken reports whether the comma-separated value of name contains token,
+// compared case-insensitively (e.g. "Connection: keep-alive, close").
+func (h *Headers) HasToken(name, token string) bool {
+	for _, t := range h.List(name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Foreach calls cb for every field line in wire order, once per value of
+// repeated fields. Names are passed as they were given, so compare them
+// case-insensitively.
 func (h *Headers) Foreach(cb func(n, v string)) {
-	for n, v := range h.headers {
-		cb(n, v)
+	for _, f := range h.fields {
+		cb(f.name, f.value)
+	}
+}
+
+// SetPreserveCase makes WriteTo send names the way they were given rather
+// than in canonical form, for clients that care.
+func (h *Headers) SetPreserveCase(preserve bool) {
+	h.preserveCase = preserve
+}
+
+// WriteTo writes the fields in wire form, "Name: value\r\n" each, followed
+// by the empty line that ends a header section. Names are in canonical form
+// unless SetPreserveCase was called. Nothing is written if a name is not a
+// token or a value holds control characters.
+func (h *Headers) WriteTo(w io.Writer) (int64, error) {
+	return h.write(w, true)
+}
+
+// WriteFields is WriteTo without the terminating empty line.
+func (h *Headers) WriteFields(w io.Writer) (int64, error) {
+	return h.write(w, false)
+}
+
+func (h *Headers) write(w io.Writer, terminate bool) (int64, error) {
+	size := len(rn)
+	for _, f := range h.fields {
+		if f.name == "" || !IsToken(f.name) || !ValidValue(f.value) {
+			return 0, fmt.Errorf("%w: %q", ERROR_INVALID_FIELD, f.name)
+		}
+		size += len(f.name) + len(f.value) + 4
+	}
+	b := make([]byte, 0, size)
+	for _, f := range h.fields {
+		if h.preserveCase {
+			b = append(b, f.name...)
+		} else {
+			b = append(b, CanonicalName(f.name)...)
+		}
+		b = append(b, ": "...)
+		b = append(b, f.value...)
+		b = append(b, rn...)
 	}
+	if terminate {
+		b = append(b, rn...)
+	}
+	n, err := w.Write(b)
+	return int64(n), err
 }
 
-func isToken(name string) bool {
+// ValidValue reports whether v can be sent as a field value: no control
+// characters other than HTAB.
+func ValidValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// IsToken reports whether name only contains RFC 9110 tchar characters.
+func IsToken(name string) bool {
 	for _, ch := range name {
 		found := false
 		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' {
@@ -66,16 +210,22 @@ func isToken(name string) bool {
 	return true
 }
 
-func parseHeader(fieldLine []byte) (string, string, error) {
+func parseHeader(fieldLine []byte, spaceBeforeColon bool) (string, string, error) {
 	name, val, found := bytes.Cut(fieldLine, []byte(":"))
 	if found == true {
 		val = bytes.TrimSpace(val)
-		if !isToken(string(name)) {
+		if spaceBeforeColon {
+			name = bytes.TrimRight(name, " \t")
+		}
+		if !IsToken(string(name)) {
 			return "", "", fmt.Errorf("malformed header name")
 		}
 		if bytes.HasSuffix(name, []byte(" ")) {
 			return "", "", fmt.Errorf("malformed field name")
 		}
+		if !ValidValue(string(val)) {
+			return "", "", fmt.Errorf("%w: control character in %q", ERROR_INVALID_FIELD, name)
+		}
 		return string(name), string(val), nil
 	} else {
 		slog.Info("parseHeader", "fieldLine", string(fieldLine))
@@ -83,26 +233,116 @@ func parseHeader(fieldLine []byte) (string, string, error) {
 	}
 }
 
-func (h Headers) Parse(data []byte) (int, bool, error) {
+// nextLine finds the end of the line at the start of data, returning its
+// length and the length of the terminator, or -1 if the line is incomplete.
+// With bareLF lines also end at a LF alone; otherwise those are rejected.
+func nextLine(data []byte, bareLF bool) (int, int, error) {
+	if bareLF {
+		idx := bytes.IndexByte(data, '\n')
+		if idx > 0 && data[idx-1] == '\r' {
+			return idx - 1, 2, nil
+		}
+		return idx, 1, nil
+	}
+	idx := bytes.Index(data, rn)
+	line := data
+	if idx != -1 {
+		line = data[:idx]
+	}
+	if bytes.IndexByte(line, '\n') != -1 {
+		return 0, 0, fmt.Errorf("bare LF in field line")
+	}
+	return idx, len(rn), nil
+}
+
+// SetMaxFields makes parsing fail with ERROR_TOO_MANY_FIELDS once h would
+// hold more than n field lines. Zero means no limit.
+func (h *Headers) SetMaxFields(n int) {
+	h.maxFields = n
+}
+
+// ParseOptions turn on, one by one, the deviations from RFC 9112 that
+// ParseWith tolerates. The zero value is strict.
+type ParseOptions struct {
+	// BareLF accepts lines ending in LF without CR.
+	BareLF bool
+	// SpaceBeforeColon accepts whitespace between a field name and its
+	// colon, which is dropped.
+	SpaceBeforeColon bool
+	// ObsFold unfolds continuation lines into the previous field's value
+	// instead of rejecting them.
+	ObsFold bool
+}
+
+// Parse reads field lines from data. Lines folded onto the previous one
+// (obs-fold) are rejected with ERROR_OBS_FOLD.
+func (h *Headers) Parse(data []byte) (int, bool, error) {
+	return h.ParseWith(data, ParseOptions{})
+}
+
+// ParseLenient is Parse tolerating bare LF line endings and whitespace
+// between a field name and its colon, and unfolding obs-fold lines into the
+// previous field's value.
+func (h *Headers) ParseLenient(data []byte) (int, bool, error) {
+	return h.ParseWith(data, ParseOptions{BareLF: true, SpaceBeforeColon: true, ObsFold: true})
+}
+
+// ParseWith is Parse tolerating what opts allow.
+func (h *Headers) ParseWith(data []byte, opts ParseOptions) (int, bool, error) {
+	return h.parse(data, opts)
+}
+
+// unfold appends an obs-fold continuation line to the last parsed field,
+// replacing the fold with a single space as RFC 9112 section 5.2 allows.
+func (h *Headers) unfold(line []byte, obsFold bool) error {
+	if !obsFold || h.last == "" {
+		return ERROR_OBS_FOLD
+	}
+	if !ValidValue(string(line)) {
+		return fmt.Errorf("%w: control character in %q", ERROR_INVALID_FIELD, h.last)
+	}
+	if cont := bytes.TrimSpace(line); len(cont) > 0 {
+		f := &h.fields[len(h.fields)-1]
+		f.value += " " + string(cont)
+	}
+	return nil
+}
+
+func (h *Headers) parse(data []byte, opts ParseOptions) (int, bool, error) {
 	read := 0
 	done := false
 	for {
-		idx := bytes.Index(data[read:], rn)
+		idx, sep, err := nextLine(data[read:], opts.BareLF)
+		if err != nil {
+			return 0, false, err
+		}
 		if idx == -1 {
 			break
 		}
 		//Empty header
 		if idx == 0 {
 			done = true
-			read += len(rn)
+			read += sep
 			break
 		}
-		name, value, err := parseHeader(data[read : read+idx])
+		line := data[read : read+idx]
+		if line[0] == ' ' || line[0] == '\t' {
+			if err := h.unfold(line, opts.ObsFold); err != nil {
+				return 0, false, err
+			}
+			read += idx + sep
+			continue
+		}
+		if h.maxFields > 0 && len(h.fields) >= h.maxFields {
+			return 0, false, ERROR_TOO_MANY_FIELDS
+		}
+		name, value, err := parseHeader(line, opts.SpaceBeforeColon)
 		if err != nil {
 			return 0, false, err
 		}
-		read += idx + len(rn)
-		h.Set(name, value)
+		read += idx + sep
+		h.Add(name, value)
+		h.last = name
 	}
 	return read, done, nil
 
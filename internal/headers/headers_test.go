@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -49,4 +50,247 @@ func TestHeaderParse(t *testing.T) {
 	assert.True(t, hostMultiOk)
 	assert.Equal(t, "localhost:42069,localhost:42069,localhost:42068", hostMulti)
 	assert.False(t, done)
+
+	// Test: Obsolete line folding is rejected
+	headers = NewHeaders()
+	data = []byte("X-Long: first\r\n  second\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.ErrorIs(t, err, ERROR_OBS_FOLD)
+	assert.Equal(t, 0, n)
+
+	// Test: Lenient parsing unfolds continuation lines, even across calls
+	headers = NewHeaders()
+	n, done, err = headers.ParseLenient([]byte("X-Long: first\r\n"))
+	require.NoError(t, err)
+	_, done, err = headers.ParseLenient([]byte(" \tsecond\r\n\tthird \r\nX-Next: 1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, done)
+	long, _ := headers.Get("X-Long")
+	assert.Equal(t, "first second third", long)
+	next, _ := headers.Get("X-Next")
+	assert.Equal(t, "1", next)
+
+	// Test: A fold with no field before it is rejected even when lenient
+	headers = NewHeaders()
+	_, _, err = headers.ParseLenient([]byte(" orphan\r\n\r\n"))
+	require.ErrorIs(t, err, ERROR_OBS_FOLD)
+}
+
+func TestMultiValueHeaders(t *testing.T) {
+	h := NewHeaders()
+
+	// Test: Add keeps repeated fields apart
+	h.Add("Set-Cookie", "a=1; Path=/")
+	h.Add("set-cookie", "b=2, c=3")
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c=3"}, h.Values("SET-COOKIE"))
+	joined, ok := h.Get("Set-Cookie")
+	assert.True(t, ok)
+	assert.Equal(t, "a=1; Path=/,b=2, c=3", joined)
+
+	// Test: Values of a missing field is empty and returns a copy
+	assert.Empty(t, h.Values("Via"))
+	h.Values("Set-Cookie")[0] = "changed"
+	assert.Equal(t, "a=1; Path=/", h.Values("Set-Cookie")[0])
+
+	// Test: Set replaces every value
+	h.Set("Set-Cookie", "d=4")
+	assert.Equal(t, []string{"d=4"}, h.Values("Set-Cookie"))
+
+	// Test: Foreach visits each value
+	h.Add("Via", "1.1 a")
+	h.Add("Via", "1.1 b")
+	seen := map[string][]string{}
+	h.Foreach(func(n, v string) { seen[n] = append(seen[n], v) })
+	assert.Equal(t, map[string][]string{"Set-Cookie": {"d=4"}, "Via": {"1.1 a", "1.1 b"}}, seen)
+
+	// Test: Parsed repeats are kept apart
+	h = NewHeaders()
+	_, _, err := h.Parse([]byte("Via: 1.0 x\r\nVia: 1.1 y, 1.1 z\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0 x", "1.1 y, 1.1 z"}, h.Values("Via"))
+}
+
+func TestHeaderOrder(t *testing.T) {
+	fields := func(h *Headers) []string {
+		out := []string{}
+		h.Foreach(func(n, v string) { out = append(out, n+": "+v) })
+		return out
+	}
+
+	// Test: Foreach yields fields in wire order
+	h := NewHeaders()
+	_, _, err := h.Parse([]byte("Zeta: 1\r\nAlpha: 2\r\nVia: a\r\nMid: 3\r\nVia: b\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Zeta: 1", "Alpha: 2", "Via: a", "Mid: 3", "Via: b"}, fields(h))
+
+	// Test: Set keeps the place of the first field and drops the others
+	h.Set("Via", "c")
+	assert.Equal(t, []string{"Zeta: 1", "Alpha: 2", "Via: c", "Mid: 3"}, fields(h))
+
+	// Test: Add appends, Delete removes every value
+	h.Add("Alpha", "4")
+	h.Delete("zeta")
+	assert.Equal(t, []string{"Alpha: 2", "Via: c", "Mid: 3", "Alpha: 4"}, fields(h))
+}
+
+func TestWriteTo(t *testing.T) {
+	h := NewHeaders()
+	h.Add("Content-Type", "text/plain")
+	h.Add("Set-Cookie", "a=1")
+	h.Add("Set-Cookie", "b=2")
+
+	// Test: Fields are written in order and the section is terminated
+	var b strings.Builder
+	n, err := h.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "Content-Type: text/plain\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\n\r\n", b.String())
+	assert.Equal(t, int64(b.Len()), n)
+
+	// Test: WriteFields leaves the section open
+	b.Reset()
+	_, err = h.WriteFields(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "Content-Type: text/plain\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\n", b.String())
+
+	// Test: Invalid names and values write nothing
+	for _, bad := range [][2]string{{"Bad Name", "v"}, {"", "v"}} {
+		h := NewHeaders()
+		h.Add("X-Ok", "fine")
+		h.Add(bad[0], bad[1])
+		b.Reset()
+		n, err := h.WriteTo(&b)
+		require.ErrorIs(t, err, ERROR_INVALID_FIELD, bad[0])
+		assert.Equal(t, int64(0), n)
+		assert.Empty(t, b.String())
+	}
+}
+
+func TestControlCharacters(t *testing.T) {
+	// Test: Parsed values may not hold control characters other than HTAB
+	for _, v := range []string{"a\x00b", "a\rb", "a\x1bb", "a\x7fb"} {
+		h := NewHeaders()
+		_, _, err := h.Parse([]byte("X-Bad: " + v + "\r\n\r\n"))
+		require.ErrorIs(t, err, ERROR_INVALID_FIELD, v)
+		_, _, err = h.ParseLenient([]byte("X-Bad: " + v + "\r\n\r\n"))
+		require.ErrorIs(t, err, ERROR_INVALID_FIELD, v)
+	}
+	h := NewHeaders()
+	_, _, err := h.Parse([]byte("X-Ok: a\tb \xe9\r\n\r\n"))
+	require.NoError(t, err)
+	_, _, err = h.ParseLenient([]byte("X-Ok: a\r\n b\x00\r\n\r\n"))
+	require.ErrorIs(t, err, ERROR_INVALID_FIELD)
+
+	// Test: Set and Add ignore such values, so they cannot split a response
+	h = NewHeaders()
+	h.Set("X-Echo", "safe")
+	h.Set("X-Echo", "a\r\nInjected: yes")
+	h.Add("X-Echo", "b\nInjected: yes")
+	assert.Equal(t, []string{"safe"}, h.Values("X-Echo"))
+}
+
+func TestPreferences(t *testing.T) {
+	// Test: Entries are sorted by q, then specificity, then order
+	prefs := ParsePreferences("text/*;q=0.5, */*;q=0.1, Text/HTML;level=1, application/json, text/plain;q=0.5")
+	assert.Equal(t, []Preference{
+		{"text/html", 1}, {"application/json", 1}, {"text/plain", 0.5}, {"text/*", 0.5}, {"*/*", 0.1},
+	}, prefs)
+
+	// Test: Malformed weights are skipped
+	prefs = ParsePreferences("gzip;q=2, br;q=0.1234, deflate;q=abc, zstd;q=0.25, ,")
+	assert.Equal(t, []Preference{{"zstd", 0.25}}, prefs)
+
+	// Test: The most specific range decides an offer's weight
+	prefs = ParsePreferences("text/*;q=0.3, text/html;q=0.7, */*;q=0.1")
+	q, ok := Weight(prefs, "text/html")
+	assert.True(t, ok)
+	assert.Equal(t, 0.7, q)
+	q, _ = Weight(prefs, "text/css")
+	assert.Equal(t, 0.3, q)
+	q, _ = Weight(prefs, "image/png")
+	assert.Equal(t, 0.1, q)
+
+	// Test: Negotiate picks the best offer, earlier offers winning ties
+	assert.Equal(t, "text/html", Negotiate(prefs, "image/png", "text/css", "text/html"))
+	assert.Equal(t, "image/png", Negotiate(ParsePreferences("*/*"), "image/png", "text/html"))
+	assert.Equal(t, "", Negotiate(ParsePreferences("text/html, */*;q=0"), "application/json"))
+	assert.Equal(t, "", Negotiate(ParsePreferences("text/html"), "application/json"))
+}
+
+func TestCanonicalNames(t *testing.T) {
+	// Test: Words are capitalized, with well-known exceptions
+	for in, want := range map[string]string{
+		"content-type":     "Content-Type",
+		"X-CONTENT-SHA256": "X-Content-SHA256",
+		"etag":             "ETag",
+		"www-authenticate": "WWW-Authenticate",
+		"x--odd-":          "X--Odd-",
+		"x_1":              "X_1",
+	} {
+		assert.Equal(t, want, CanonicalName(in), in)
+	}
+
+	// Test: Lookups ignore case, output is canonical unless preserved
+	h := NewHeaders()
+	h.Add("x-request-id", "1")
+	h.Add("CONTENT-type", "text/plain")
+	v, _ := h.Get("X-Request-ID")
+	assert.Equal(t, "1", v)
+	var b strings.Builder
+	_, err := h.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "X-Request-Id: 1\r\nContent-Type: text/plain\r\n\r\n", b.String())
+	h.SetPreserveCase(true)
+	b.Reset()
+	_, err = h.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "x-request-id: 1\r\nCONTENT-type: text/plain\r\n\r\n", b.String())
+}
+
+func TestCloneAndMerge(t *testing.T) {
+	h := NewHeaders()
+	h.Add("Content-Type", "text/plain")
+	h.Add("Via", "a")
+
+	// Test: Clones share nothing with the original
+	c := h.Clone()
+	c.Set("Content-Type", "text/html")
+	c.Add("Via", "b")
+	v, _ := h.Get("Content-Type")
+	assert.Equal(t, "text/plain", v)
+	assert.Equal(t, []string{"a"}, h.Values("Via"))
+	assert.Equal(t, []string{"a", "b"}, c.Values("Via"))
+
+	// Test: Merge replaces same-named fields and keeps the rest
+	other := NewHeaders()
+	other.Add("via", "x")
+	other.Add("X-Extra", "1")
+	other.Add("Via", "y")
+	h.Add("Server", "s")
+	h.Merge(other)
+	fields := []string{}
+	h.Foreach(func(n, v string) { fields = append(fields, n+": "+v) })
+	assert.Equal(t, []string{"Content-Type: text/plain", "via: x", "Server: s", "X-Extra: 1", "Via: y"}, fields)
+	assert.Equal(t, []string{"x", "y"}, other.Values("Via"))
+}
+
+func TestSplitList(t *testing.T) {
+	// Test: Commas in quoted strings and comments do not split
+	assert.Equal(t, []string{`a`, `"b,c"`, `d;p="x,y"`}, SplitList(`a, "b,c" ,d;p="x,y"`))
+	assert.Equal(t, []string{`1.1 proxy (note, with comma)`, `1.0 other`}, SplitList(`1.1 proxy (note, with comma), 1.0 other`))
+
+	// Test: Escapes inside quotes are honored, empty elements dropped
+	assert.Equal(t, []string{`"a\",b"`, `c`}, SplitList(`, "a\",b",, c ,`))
+	assert.Equal(t, []string{}, SplitList(" , "))
+
+	// Test: List splits every field on its own
+	h := NewHeaders()
+	h.Add("Accept", `text/html;note="a,b"`)
+	h.Add("Accept", "application/json, */*")
+	assert.Equal(t, []string{`text/html;note="a,b"`, "application/json", "*/*"}, h.List("Accept"))
+	assert.True(t, h.HasToken("Accept", "*/*"))
+	assert.False(t, h.HasToken("Accept", `b"`))
+
+	// Test: Quoted commas stay inside one preference
+	prefs := ParsePreferences(`text/html;note="a,b;q=0";q=0.5, text/plain`)
+	assert.Equal(t, []Preference{{"text/plain", 1}, {"text/html", 0.5}}, prefs)
 }
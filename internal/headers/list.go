@@ -0,0 +1,54 @@
+package headers
+
+import (
+	"strings"
+)
+
+// SplitList splits a list-valued field ("a, "b,c", d") into its elements.
+// Commas inside quoted strings and comments, and characters escaped with a
+// backslash in them, do not split. Elements are trimmed and empty ones
+// dropped; quotes are kept. Fields that are not lists, like Expires or
+// Set-Cookie, must not be split.
+func SplitList(value string) []string {
+	elems := []string{}
+	for _, e := range splitQuoted(value, ',') {
+		if e = strings.TrimSpace(e); e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// splitQuoted splits s at sep, except inside quoted strings and (possibly
+// nested) comments.
+func splitQuoted(s string, sep byte) []string {
+	parts := []string{}
+	quoted, depth, start := false, 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && (quoted || depth > 0):
+			i++
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted && depth > 0:
+			depth--
+		case c == sep && !quoted && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// List returns the elements of every name field, split with SplitList.
+// Unlike splitting the value from Get, it cannot be misled by fields that
+// were joined together.
+func (h *Headers) List(name string) []string {
+	elems := []string{}
+	for _, v := range h.Values(name) {
+		elems = append(elems, SplitList(v)...)
+	}
+	return elems
+}
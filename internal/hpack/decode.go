@@ -0,0 +1,117 @@
+package hpack
+
+import (
+	"fmt"
+)
+
+var ERROR_LIST_TOO_LARGE = fmt.Errorf("hpack: header list too large")
+
+// DefaultMaxStringLength bounds names and values unless the Decoder is
+// told otherwise.
+const DefaultMaxStringLength = 16 << 10
+
+// Decoder decodes the header blocks sent in one direction of a connection,
+// which must be decoded in the order they were sent. A decoding error
+// leaves the table out of sync with the encoder's, so the connection can
+// only be closed.
+type Decoder struct {
+	table table
+	// limit is the largest table the encoder may use, the size announced
+	// in SETTINGS_HEADER_TABLE_SIZE.
+	limit int
+	// MaxStringLength bounds each name and value.
+	MaxStringLength int
+	// MaxListSize, when positive, bounds a block's fields as counted by
+	// SETTINGS_MAX_HEADER_LIST_SIZE: their lengths plus 32 per field.
+	MaxListSize int
+}
+
+// NewDecoder returns a decoder for an encoder whose table may grow to
+// limit bytes.
+func NewDecoder(limit int) *Decoder {
+	return &Decoder{
+		table:           table{max: limit},
+		limit:           limit,
+		MaxStringLength: DefaultMaxStringLength,
+	}
+}
+
+// Decode decodes a whole header block: a HEADERS frame's fragment and those
+// of its CONTINUATION frames.
+func (d *Decoder) Decode(block []byte) ([]Field, error) {
+	var fields []Field
+	listSize := 0
+	for len(block) > 0 {
+		var f Field
+		var err error
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			var i uint64
+			i, block, err = readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			if f, ok = d.table.field(i); !ok {
+				return nil, ERROR_INVALID_INDEX
+			}
+		case b&0xc0 == 0x40:
+			f, block, err = d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+		case b&0xe0 == 0x20:
+			// Size updates only come first in a block.
+			var size uint64
+			size, block, err = readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if len(fields) > 0 || size > uint64(d.limit) {
+				return nil, ERROR_TABLE_SIZE
+			}
+			d.table.setMax(int(size))
+			continue
+		default:
+			f, block, err = d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x10 != 0
+		}
+		listSize += f.size()
+		if d.MaxListSize > 0 && listSize > d.MaxListSize {
+			return nil, ERROR_LIST_TOO_LARGE
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// readLiteral decodes a literal field whose name index has an n-bit prefix.
+func (d *Decoder) readLiteral(p []byte, n uint) (Field, []byte, error) {
+	i, p, err := readInt(p, n)
+	if err != nil {
+		return Field{}, nil, err
+	}
+	var f Field
+	if i == 0 {
+		f.Name, p, err = readString(p, d.MaxStringLength)
+		if err != nil {
+			return Field{}, nil, err
+		}
+	} else {
+		named, ok := d.table.field(i)
+		if !ok {
+			return Field{}, nil, ERROR_INVALID_INDEX
+		}
+		f.Name = named.Name
+	}
+	f.Value, p, err = readString(p, d.MaxStringLength)
+	if err != nil {
+		return Field{}, nil, err
+	}
+	return f, p, nil
+}
@@ -0,0 +1,69 @@
+package hpack
+
+// Encoder encodes the header blocks sent in one direction of a connection.
+// Blocks must be sent in the order they were encoded.
+type Encoder struct {
+	table table
+	// minSize is the smallest table size since the last block, when the
+	// size changed; the decoder must see it before the current one.
+	minSize int
+	resized bool
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{table: table{max: DefaultTableSize}}
+}
+
+// SetMaxTableSize applies the limit the decoder announced in
+// SETTINGS_HEADER_TABLE_SIZE. The table never grows past
+// DefaultTableSize, however much the decoder allows.
+func (e *Encoder) SetMaxTableSize(n int) {
+	n = min(n, DefaultTableSize)
+	if n == e.table.max {
+		return
+	}
+	if !e.resized || n < e.minSize {
+		e.minSize = n
+	}
+	e.resized = true
+	e.table.setMax(n)
+}
+
+// Encode appends the header block for fields to dst. Fields are added to
+// the dynamic table, except sensitive ones, which are sent as never
+// indexed.
+func (e *Encoder) Encode(dst []byte, fields []Field) []byte {
+	if e.resized {
+		if e.minSize < e.table.max {
+			dst = appendInt(dst, 0x20, 5, uint64(e.minSize))
+		}
+		dst = appendInt(dst, 0x20, 5, uint64(e.table.max))
+		e.resized = false
+	}
+	for _, f := range fields {
+		i, match := e.table.search(f)
+		switch {
+		case f.Sensitive:
+			dst = e.appendLiteral(dst, 0x10, 4, i, f)
+		case match:
+			dst = appendInt(dst, 0x80, 7, i)
+		case f.size() > e.table.max:
+			// It would only empty the table.
+			dst = e.appendLiteral(dst, 0, 4, i, f)
+		default:
+			dst = e.appendLiteral(dst, 0x40, 6, i, f)
+			e.table.add(f)
+		}
+	}
+	return dst
+}
+
+// appendLiteral encodes f as a literal, naming it by index i unless it is
+// zero.
+func (e *Encoder) appendLiteral(dst []byte, first byte, n uint, i uint64, f Field) []byte {
+	dst = appendInt(dst, first, n, i)
+	if i == 0 {
+		dst = appendString(dst, f.Name)
+	}
+	return appendString(dst, f.Value)
+}
@@ -0,0 +1,255 @@
+// Package hpack implements HPACK, the header compression of HTTP/2
+// (RFC 7541).
+package hpack
+
+import (
+	"fmt"
+)
+
+var ERROR_INVALID_INDEX = fmt.Errorf("hpack: invalid table index")
+var ERROR_TRUNCATED = fmt.Errorf("hpack: truncated header block")
+var ERROR_INTEGER_OVERFLOW = fmt.Errorf("hpack: integer overflow")
+var ERROR_INVALID_HUFFMAN = fmt.Errorf("hpack: invalid Huffman string")
+var ERROR_STRING_TOO_LONG = fmt.Errorf("hpack: string too long")
+var ERROR_TABLE_SIZE = fmt.Errorf("hpack: invalid dynamic table size update")
+
+// DefaultTableSize is the dynamic table size both ends start with.
+const DefaultTableSize = 4096
+
+// Field is one header field. Sensitive fields, such as credentials, are
+// never added to a dynamic table, nor by any intermediary that forwards
+// them.
+type Field struct {
+	Name      string
+	Value     string
+	Sensitive bool
+}
+
+// size is the space the field takes up in a dynamic table.
+func (f Field) size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+// staticTable is RFC 7541 Appendix A; index 1 is its first entry.
+var staticTable = []Field{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// table is a dynamic table. Entries are kept oldest first, so the newest,
+// which has the lowest index, is last.
+type table struct {
+	entries []Field
+	size    int
+	max     int
+}
+
+func (t *table) add(f Field) {
+	t.entries = append(t.entries, f)
+	t.size += f.size()
+	t.evict()
+}
+
+// evict drops the oldest entries until the table fits its maximum size.
+// An entry larger than the whole table empties it.
+func (t *table) evict() {
+	n := 0
+	for t.size > t.max && n < len(t.entries) {
+		t.size -= t.entries[n].size()
+		n++
+	}
+	t.entries = append(t.entries[:0], t.entries[n:]...)
+}
+
+func (t *table) setMax(max int) {
+	t.max = max
+	t.evict()
+}
+
+// field returns the entry at index i of the combined index space: the
+// static table, then the dynamic table from its newest entry.
+func (t *table) field(i uint64) (Field, bool) {
+	if i == 0 {
+		return Field{}, false
+	}
+	if i <= uint64(len(staticTable)) {
+		return staticTable[i-1], true
+	}
+	i -= uint64(len(staticTable))
+	if i > uint64(len(t.entries)) {
+		return Field{}, false
+	}
+	return t.entries[len(t.entries)-int(i)], true
+}
+
+// search looks f up, preferring an entry with its value too. It returns
+// 0 when not even the name is there.
+func (t *table) search(f Field) (i uint64, valueMatch bool) {
+	for j, e := range staticTable {
+		if e.Name != f.Name {
+			continue
+		}
+		if e.Value == f.Value {
+			return uint64(j + 1), true
+		}
+		if i == 0 {
+			i = uint64(j + 1)
+		}
+	}
+	for j := len(t.entries) - 1; j >= 0; j-- {
+		e := t.entries[j]
+		if e.Name != f.Name {
+			continue
+		}
+		index := uint64(len(staticTable) + len(t.entries) - j)
+		if e.Value == f.Value {
+			return index, true
+		}
+		if i == 0 {
+			i = index
+		}
+	}
+	return i, false
+}
+
+// appendInt encodes i with an n-bit prefix, the rest of the first byte
+// being first.
+func appendInt(dst []byte, first byte, n uint, i uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if i < limit {
+		return append(dst, first|byte(i))
+	}
+	dst = append(dst, first|byte(limit))
+	i -= limit
+	for i >= 0x80 {
+		dst = append(dst, byte(i)|0x80)
+		i >>= 7
+	}
+	return append(dst, byte(i))
+}
+
+// readInt decodes an integer with an n-bit prefix from the start of p.
+func readInt(p []byte, n uint) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, ERROR_TRUNCATED
+	}
+	limit := uint64(1)<<n - 1
+	i := uint64(p[0]) & limit
+	p = p[1:]
+	if i < limit {
+		return i, p, nil
+	}
+	for shift := uint(0); ; shift += 7 {
+		if len(p) == 0 {
+			return 0, nil, ERROR_TRUNCATED
+		}
+		// Anything past 2^63 is an attack, not a header block.
+		if shift > 56 {
+			return 0, nil, ERROR_INTEGER_OVERFLOW
+		}
+		b := p[0]
+		p = p[1:]
+		i += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+	}
+}
+
+// appendString encodes s as a string literal, Huffman coded when that is
+// shorter.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanLength(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// readString decodes a string literal of at most max bytes from the start
+// of p.
+func readString(p []byte, max int) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, ERROR_TRUNCATED
+	}
+	huffman := p[0]&0x80 != 0
+	n, p, err := readInt(p, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(p)) {
+		return "", nil, ERROR_TRUNCATED
+	}
+	raw, p := p[:n], p[n:]
+	if !huffman {
+		if len(raw) > max {
+			return "", nil, ERROR_STRING_TOO_LONG
+		}
+		return string(raw), p, nil
+	}
+	s, err := huffmanDecode(raw, max)
+	if err != nil {
+		return "", nil, err
+	}
+	return s, p, nil
+}
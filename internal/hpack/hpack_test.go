@@ -0,0 +1,150 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func TestIntegers(t *testing.T) {
+	// Test: The examples of RFC 7541 section C.1
+	assert.Equal(t, []byte{0x0a}, appendInt(nil, 0, 5, 10))
+	assert.Equal(t, []byte{0x1f, 0x9a, 0x0a}, appendInt(nil, 0, 5, 1337))
+	assert.Equal(t, []byte{0x2a}, appendInt(nil, 0, 8, 42))
+	i, rest, err := readInt([]byte{0xff, 0x9a, 0x0a, 0x01}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1337), i)
+	assert.Equal(t, []byte{0x01}, rest)
+
+	// Test: Truncated and endless integers are refused
+	_, _, err = readInt([]byte{0x1f, 0x9a}, 5)
+	assert.ErrorIs(t, err, ERROR_TRUNCATED)
+	_, _, err = readInt(append([]byte{0x1f}, []byte(strings.Repeat("\xff", 10))...), 5)
+	assert.ErrorIs(t, err, ERROR_INTEGER_OVERFLOW)
+}
+
+func TestHuffman(t *testing.T) {
+	// Test: The Huffman coded strings of RFC 7541 section C.4.1
+	assert.Equal(t, unhex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), huffmanEncode(nil, "www.example.com"))
+	s, err := huffmanDecode(unhex(t, "a8eb 1064 9cbf"), 100)
+	require.NoError(t, err)
+	assert.Equal(t, "no-cache", s)
+
+	// Test: Every byte value round-trips
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	s, err = huffmanDecode(huffmanEncode(nil, string(all)), 1000)
+	require.NoError(t, err)
+	assert.Equal(t, string(all), s)
+
+	// Test: Padding must be short and all 1 bits
+	s, err = huffmanDecode([]byte{0x1f}, 100)
+	require.NoError(t, err)
+	assert.Equal(t, "a", s)
+	_, err = huffmanDecode([]byte{0xff}, 100)
+	assert.ErrorIs(t, err, ERROR_INVALID_HUFFMAN)
+	_, err = huffmanDecode([]byte{0x1e}, 100)
+	assert.ErrorIs(t, err, ERROR_INVALID_HUFFMAN)
+
+	// Test: Decoded strings are held to the limit
+	_, err = huffmanDecode(huffmanEncode(nil, "abcdef"), 5)
+	assert.ErrorIs(t, err, ERROR_STRING_TOO_LONG)
+}
+
+func TestDecoder(t *testing.T) {
+	d := NewDecoder(DefaultTableSize)
+
+	// Test: The requests of RFC 7541 section C.4 share a dynamic table
+	fields, err := d.Decode(unhex(t, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"))
+	require.NoError(t, err)
+	assert.Equal(t, []Field{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+	}, fields)
+	assert.Equal(t, 57, d.table.size)
+	fields, err = d.Decode(unhex(t, "8286 84be 5886 a8eb 1064 9cbf"))
+	require.NoError(t, err)
+	assert.Equal(t, Field{Name: ":authority", Value: "www.example.com"}, fields[3])
+	assert.Equal(t, Field{Name: "cache-control", Value: "no-cache"}, fields[4])
+	fields, err = d.Decode(unhex(t, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"))
+	require.NoError(t, err)
+	assert.Equal(t, Field{Name: ":path", Value: "/index.html"}, fields[2])
+	assert.Equal(t, Field{Name: ":authority", Value: "www.example.com"}, fields[3])
+	assert.Equal(t, Field{Name: "custom-key", Value: "custom-value"}, fields[4])
+	assert.Equal(t, 164, d.table.size)
+
+	// Test: Never indexed fields are marked sensitive
+	fields, err = d.Decode(unhex(t, "1008 7061 7373 776f 7264 0673 6563 7265 74"))
+	require.NoError(t, err)
+	assert.Equal(t, []Field{{Name: "password", Value: "secret", Sensitive: true}}, fields)
+
+	// Test: Size updates shrink the table, and only come first
+	_, err = d.Decode([]byte{0x20})
+	require.NoError(t, err)
+	assert.Empty(t, d.table.entries)
+	_, err = d.Decode([]byte{0x82, 0x20})
+	assert.ErrorIs(t, err, ERROR_TABLE_SIZE)
+	_, err = d.Decode(appendInt(nil, 0x20, 5, DefaultTableSize+1))
+	assert.ErrorIs(t, err, ERROR_TABLE_SIZE)
+
+	// Test: Indexes past the tables are refused
+	_, err = d.Decode([]byte{0x80 | 62})
+	assert.ErrorIs(t, err, ERROR_INVALID_INDEX)
+	_, err = d.Decode([]byte{0x80})
+	assert.ErrorIs(t, err, ERROR_INVALID_INDEX)
+
+	// Test: Header lists past the limit are refused
+	d = NewDecoder(DefaultTableSize)
+	d.MaxListSize = 100
+	_, err = d.Decode(NewEncoder().Encode(nil, []Field{{Name: "a", Value: strings.Repeat("x", 100)}}))
+	assert.ErrorIs(t, err, ERROR_LIST_TOO_LARGE)
+}
+
+func TestEncoder(t *testing.T) {
+	e, d := NewEncoder(), NewDecoder(DefaultTableSize)
+	fields := []Field{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "text/html"},
+		{Name: "x-custom", Value: "value"},
+		{Name: "authorization", Value: "secret", Sensitive: true},
+	}
+
+	// Test: Blocks round-trip, and repeated fields shrink to an index
+	first := e.Encode(nil, fields)
+	decoded, err := d.Decode(first)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+	second := e.Encode(nil, fields)
+	assert.Less(t, len(second), len(first))
+	decoded, err = d.Decode(second)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+
+	// Test: Sensitive fields stay out of the table
+	for _, f := range e.table.entries {
+		assert.NotEqual(t, "authorization", f.Name)
+	}
+
+	// Test: A smaller table is announced in the next block
+	e.SetMaxTableSize(0)
+	e.SetMaxTableSize(64)
+	block := e.Encode(nil, fields[2:3])
+	assert.Equal(t, []byte{0x20, 0x3f, 0x21}, block[:3])
+	decoded, err = d.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields[2:3], decoded)
+	assert.Equal(t, 64, d.table.max)
+}
@@ -0,0 +1,95 @@
+package hpack
+
+// eos is the end-of-string symbol, which must never appear in a string.
+const eos = 256
+
+// huffmanNode is a node of the decoding tree. Leaves have a symbol, the
+// others children for a 0 and a 1 bit.
+type huffmanNode struct {
+	children [2]uint16
+	leaf     bool
+	sym      uint16
+}
+
+var huffmanTree = buildHuffmanTree()
+
+func buildHuffmanTree() []huffmanNode {
+	tree := []huffmanNode{{}}
+	insert := func(code uint32, length uint8, sym uint16) {
+		node := 0
+		for i := int(length) - 1; i >= 0; i-- {
+			bit := code >> i & 1
+			if tree[node].children[bit] == 0 {
+				tree = append(tree, huffmanNode{})
+				tree[node].children[bit] = uint16(len(tree) - 1)
+			}
+			node = int(tree[node].children[bit])
+		}
+		tree[node].leaf, tree[node].sym = true, sym
+	}
+	for sym := range huffmanCodes {
+		insert(huffmanCodes[sym], huffmanLengths[sym], uint16(sym))
+	}
+	insert(0x3fffffff, 30, eos)
+	return tree
+}
+
+// huffmanDecode decodes src, refusing results longer than max bytes. The
+// padding must be the shortest possible prefix of EOS.
+func huffmanDecode(src []byte, max int) (string, error) {
+	dst := make([]byte, 0, min(len(src)*8/5, max))
+	node := 0
+	// Bits read since the last symbol, and whether they were all 1.
+	pending, ones := 0, true
+	for _, b := range src {
+		for i := 7; i >= 0; i-- {
+			bit := b >> i & 1
+			node = int(huffmanTree[node].children[bit])
+			pending++
+			ones = ones && bit == 1
+			if !huffmanTree[node].leaf {
+				continue
+			}
+			if huffmanTree[node].sym == eos {
+				return "", ERROR_INVALID_HUFFMAN
+			}
+			if len(dst) == max {
+				return "", ERROR_STRING_TOO_LONG
+			}
+			dst = append(dst, byte(huffmanTree[node].sym))
+			node, pending, ones = 0, 0, true
+		}
+	}
+	if pending > 7 || !ones {
+		return "", ERROR_INVALID_HUFFMAN
+	}
+	return string(dst), nil
+}
+
+// huffmanLength is the length of s Huffman coded.
+func huffmanLength(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanLengths[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// huffmanEncode appends s Huffman coded, padded with 1 bits.
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	n := uint(0)
+	for i := 0; i < len(s); i++ {
+		length := uint(huffmanLengths[s[i]])
+		acc = acc<<length | uint64(huffmanCodes[s[i]])
+		n += length
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
@@ -0,0 +1,740 @@
+// Package http2 serves HTTP/2 over connections the HTTP/1 server hands
+// over, running each stream's request through the same handlers.
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"http/internal/hpack"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var ERROR_STREAM_RESET = fmt.Errorf("http2: stream reset")
+var ERROR_STREAM_CLOSED = fmt.Errorf("http2: stream closed")
+var ERROR_CONN_CLOSED = fmt.Errorf("http2: connection closed")
+
+// Handler serves one stream's request. It reports false when the response
+// could not be completed, which resets the stream.
+type Handler func(w *response.Writer, req *request.Request) bool
+
+// ErrorHandler answers requests that fail before a handler sees them.
+type ErrorHandler func(w *response.Writer, req *request.Request, status response.StatusCode, err error)
+
+// DefaultMaxConcurrentStreams is how many streams a client may have open
+// at once unless Options say otherwise.
+const DefaultMaxConcurrentStreams = 100
+
+// goAwayTimeout is how long frames are still read after GOAWAY, so that
+// frames the client had in flight do not make the connection reset before
+// it has read the last responses.
+const goAwayTimeout = time.Second
+
+// Options configure a connection; zero values mean defaults.
+type Options struct {
+	// Context is the parent of every stream's context; it defaults to
+	// context.Background.
+	Context context.Context
+	// Conn is attached to every request.
+	Conn request.ConnInfo
+	// RejectEncodedControl fails requests whose path encodes NUL, CR or LF.
+	RejectEncodedControl bool
+	// PrepareWriter sets up each response's Writer, e.g. with default
+	// headers, before the handler gets it.
+	PrepareWriter func(w *response.Writer)
+	// Logf receives what the connection logs; it defaults to log.Printf.
+	Logf func(format string, args ...any)
+	// HandleError answers requests that are invalid, e.g. with an unknown
+	// method. It defaults to a bare status.
+	HandleError ErrorHandler
+	// SetIdle is told when the connection runs out of streams and when it
+	// gets one again. Returning false makes the connection go away.
+	SetIdle func(idle bool) bool
+	// Draining reports whether the server is shutting down. The stream
+	// being opened is then still served, but GOAWAY tells the client to
+	// open no more.
+	Draining func() bool
+	// IdleTimeout closes the connection once it has been without streams
+	// that long.
+	IdleTimeout          time.Duration
+	MaxConcurrentStreams uint32
+	// MaxHeaderListSize bounds the header list of a request, counted as
+	// HPACK does; it defaults to request.DefaultLimits.MaxHeaderBytes.
+	MaxHeaderListSize uint32
+	// Upgrade is a request that switched to HTTP/2 with "Upgrade: h2c",
+	// served as stream 1, and UpgradeSettings its decoded HTTP2-Settings.
+	Upgrade         *request.Request
+	UpgradeSettings []byte
+}
+
+type conn struct {
+	rwc     io.ReadWriteCloser
+	br      *bufio.Reader
+	handler Handler
+	opts    Options
+	dec     *hpack.Decoder
+	// block collects a header block that continues in CONTINUATION frames
+	// on stream blockStream; endStream is its HEADERS frame's flag.
+	block       []byte
+	blockStream uint32
+	endStream   bool
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// streams holds every stream whose handler has not finished.
+	streams      map[uint32]*stream
+	lastStreamID uint32
+	// Send windows are how much the client lets the server send, receive
+	// windows how much the server lets the client send.
+	sendWindow    int64
+	recvWindow    int64
+	peerWindow    int64
+	peerFrameSize uint32
+	// goingAway is set once either side sent GOAWAY: no new streams.
+	goingAway  bool
+	goAwaySent bool
+	closed     bool
+
+	// wmu orders writes, and with them the header blocks enc encodes.
+	wmu sync.Mutex
+	enc *hpack.Encoder
+
+	handlers sync.WaitGroup
+}
+
+type stream struct {
+	id     uint32
+	ctx    context.Context
+	cancel context.CancelFunc
+	// body is nil for requests that came without one.
+	body *pipe
+	req  *request.Request
+	// declared is the request's Content-Length, or -1.
+	declared int64
+	received int64
+	// The rest is guarded by the connection's mu.
+	remoteDone bool
+	reset      bool
+	sendWindow int64
+	recvWindow int64
+}
+
+// ServeConn speaks HTTP/2 on rwc, starting with the client preface, until
+// the client goes away or an error ends the connection. It closes rwc.
+func ServeConn(rwc io.ReadWriteCloser, handler Handler, opts Options) {
+	if opts.Logf == nil {
+		opts.Logf = log.Printf
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	if opts.MaxConcurrentStreams == 0 {
+		opts.MaxConcurrentStreams = DefaultMaxConcurrentStreams
+	}
+	if opts.MaxHeaderListSize == 0 {
+		opts.MaxHeaderListSize = uint32(request.DefaultLimits.MaxHeaderBytes)
+	}
+	c := &conn{
+		rwc:           rwc,
+		br:            bufio.NewReader(rwc),
+		handler:       handler,
+		opts:          opts,
+		dec:           hpack.NewDecoder(hpack.DefaultTableSize),
+		enc:           hpack.NewEncoder(),
+		streams:       map[uint32]*stream{},
+		sendWindow:    defaultWindow,
+		recvWindow:    defaultWindow,
+		peerWindow:    defaultWindow,
+		peerFrameSize: minFrameSize,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.dec.MaxListSize = int(opts.MaxHeaderListSize)
+	defer c.close()
+	if err := c.serve(); err != nil {
+		c.opts.Logf("HTTP/2 connection ended: %v", err)
+	}
+}
+
+func (c *conn) serve() error {
+	settings := appendSettings(nil,
+		setting{settingMaxConcurrentStreams, c.opts.MaxConcurrentStreams},
+		setting{settingMaxHeaderListSize, c.opts.MaxHeaderListSize},
+	)
+	if err := c.writeFrame(frameSettings, 0, 0, settings); err != nil {
+		return err
+	}
+	if c.opts.Upgrade != nil {
+		// The client's settings came in HTTP2-Settings, acknowledged by
+		// the switch itself.
+		settings, err := parseSettings(c.opts.UpgradeSettings)
+		if err == nil {
+			err = c.applySettings(settings)
+		}
+		if err != nil {
+			return c.connFailed(err)
+		}
+		c.mu.Lock()
+		c.lastStreamID = 1
+		st := c.addStream(1, true)
+		c.mu.Unlock()
+		st.req = c.opts.Upgrade
+		c.start(st, nil)
+	} else {
+		c.mu.Lock()
+		c.becameIdle()
+		c.mu.Unlock()
+	}
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(c.br, preface); err != nil {
+		return c.connFailed(err)
+	}
+	if !bytes.Equal(preface, ClientPreface) {
+		return c.connFailed(connError{codeProtocol, "no client preface"})
+	}
+	for first := true; ; first = false {
+		f, err := readFrame(c.br, minFrameSize)
+		if err == nil && first && f.typ != frameSettings {
+			err = connError{codeProtocol, "first frame is not SETTINGS"}
+		}
+		if err == nil {
+			err = c.process(f)
+		}
+		var se streamError
+		if errors.As(err, &se) {
+			c.opts.Logf("HTTP/2 stream reset: %v", se)
+			c.resetStream(se.streamID, se.code)
+			continue
+		}
+		if err != nil {
+			return c.connFailed(err)
+		}
+	}
+}
+
+// connFailed says goodbye with the error's code, when it is a protocol
+// error, and returns it.
+func (c *conn) connFailed(err error) error {
+	var ce connError
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if errors.As(err, &ce) {
+		c.goAway(ce.code)
+		return err
+	}
+	// The client hung up, or stayed idle too long.
+	c.goAway(codeNo)
+	if c.closed || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+// close ends every stream and waits for their handlers.
+func (c *conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	streams := make([]*stream, 0, len(c.streams))
+	for _, st := range c.streams {
+		streams = append(streams, st)
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	for _, st := range streams {
+		st.cancel()
+		if st.body != nil {
+			st.body.closeWithError(ERROR_CONN_CLOSED)
+		}
+	}
+	c.rwc.Close()
+	c.handlers.Wait()
+}
+
+func (c *conn) process(f frame) error {
+	if c.blockStream != 0 && (f.typ != frameContinuation || f.streamID != c.blockStream) {
+		return connError{codeProtocol, "header block interrupted"}
+	}
+	switch f.typ {
+	case frameData:
+		return c.processData(f)
+	case frameHeaders:
+		return c.processHeaders(f)
+	case frameContinuation:
+		return c.processContinuation(f)
+	case framePriority:
+		if f.streamID == 0 {
+			return connError{codeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.payload) != 5 {
+			return streamError{f.streamID, codeFrameSize, "PRIORITY length"}
+		}
+	case frameRSTStream:
+		return c.processRSTStream(f)
+	case frameSettings:
+		return c.processSettings(f)
+	case framePushPromise:
+		return connError{codeProtocol, "PUSH_PROMISE from a client"}
+	case framePing:
+		if f.streamID != 0 {
+			return connError{codeProtocol, "PING on a stream"}
+		}
+		if len(f.payload) != 8 {
+			return connError{codeFrameSize, "PING length"}
+		}
+		if !f.has(flagAck) {
+			return c.writeFrame(framePing, flagAck, 0, f.payload)
+		}
+	case frameGoAway:
+		if f.streamID != 0 {
+			return connError{codeProtocol, "GOAWAY on a stream"}
+		}
+		if len(f.payload) < 8 {
+			return connError{codeFrameSize, "GOAWAY length"}
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.goingAway = true
+		if len(c.streams) == 0 {
+			c.closeSoon()
+		}
+	case frameWindowUpdate:
+		return c.processWindowUpdate(f)
+	}
+	// Frames of unknown types are ignored.
+	return nil
+}
+
+func (c *conn) processHeaders(f frame) error {
+	if f.streamID == 0 || f.streamID%2 == 0 {
+		return connError{codeProtocol, "HEADERS on a stream the client cannot open"}
+	}
+	p, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	if f.has(flagPriority) {
+		if len(p) < 5 {
+			return connError{codeFrameSize, "HEADERS too short for its priority"}
+		}
+		if binary.BigEndian.Uint32(p)&maxWindow == f.streamID {
+			// Decoded all the same, to keep the table in sync.
+			defer func() {
+				if err == nil {
+					err = streamError{f.streamID, codeProtocol, "stream depends on itself"}
+				}
+			}()
+		}
+		p = p[5:]
+	}
+	c.block = append(c.block[:0], p...)
+	c.blockStream = f.streamID
+	c.endStream = f.has(flagEndStream)
+	if f.has(flagEndHeaders) {
+		return c.endBlock()
+	}
+	return nil
+}
+
+func (c *conn) processContinuation(f frame) error {
+	if c.blockStream == 0 {
+		return connError{codeProtocol, "CONTINUATION without HEADERS"}
+	}
+	c.block = append(c.block, f.payload...)
+	if len(c.block) > 4*int(c.opts.MaxHeaderListSize) {
+		return connError{codeEnhanceYourCalm, "header block too large"}
+	}
+	if f.has(flagEndHeaders) {
+		return c.endBlock()
+	}
+	return nil
+}
+
+// endBlock decodes a complete header block, opening a stream or ending one
+// with trailers.
+func (c *conn) endBlock() error {
+	id := c.blockStream
+	c.blockStream = 0
+	fields, err := c.dec.Decode(c.block)
+	if err != nil {
+		return connError{codeCompression, err.Error()}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st := c.streams[id]; st != nil {
+		return c.trailers(st, fields)
+	}
+	// Streams that are closed, or opened after GOAWAY, are ignored.
+	if id <= c.lastStreamID || c.goingAway {
+		return nil
+	}
+	c.lastStreamID = id
+	if len(c.streams) >= int(c.opts.MaxConcurrentStreams) {
+		return streamError{id, codeRefusedStream, "too many streams"}
+	}
+	parts, err := c.requestParts(fields)
+	if err != nil {
+		return streamError{id, codeProtocol, err.Error()}
+	}
+	st := c.addStream(id, c.endStream)
+	if st.body != nil {
+		parts.Body = st.body
+	}
+	req, err := request.NewRequest(parts)
+	if err == nil {
+		st.req = req
+		st.declared = req.ContentLength()
+		if st.remoteDone && st.declared > 0 {
+			err = fmt.Errorf("body shorter than its Content-Length")
+		}
+	}
+	c.start(st, err)
+	if c.opts.Draining != nil && c.opts.Draining() {
+		c.goAway(codeNo)
+	}
+	return nil
+}
+
+// addStream opens stream id; mu must be held.
+func (c *conn) addStream(id uint32, remoteDone bool) *stream {
+	st := &stream{
+		id:         id,
+		declared:   -1,
+		remoteDone: remoteDone,
+		sendWindow: c.peerWindow,
+		recvWindow: defaultWindow,
+	}
+	st.ctx, st.cancel = context.WithCancel(c.opts.Context)
+	if !remoteDone {
+		st.body = newPipe(func(n int) { c.consumed(st, n) })
+	}
+	c.streams[id] = st
+	if len(c.streams) == 1 {
+		c.becameBusy()
+	}
+	return st
+}
+
+// trailers ends a stream's request with the fields that follow its body;
+// mu must be held.
+func (c *conn) trailers(st *stream, fields []hpack.Field) error {
+	if st.remoteDone {
+		return streamError{st.id, codeStreamClosed, "HEADERS after the request ended"}
+	}
+	if !c.endStream {
+		return streamError{st.id, codeProtocol, "trailers without END_STREAM"}
+	}
+	for _, f := range fields {
+		if len(f.Name) > 0 && f.Name[0] == ':' {
+			return streamError{st.id, codeProtocol, "pseudo-header in trailers"}
+		}
+	}
+	if st.req != nil {
+		for _, f := range fields {
+			st.req.Trailers().Add(f.Name, f.Value)
+		}
+	}
+	return c.endRequest(st)
+}
+
+// endRequest marks the client's side of the stream done; mu must be held.
+func (c *conn) endRequest(st *stream) error {
+	st.remoteDone = true
+	if st.declared >= 0 && st.received != st.declared {
+		return streamError{st.id, codeProtocol, "body length differs from Content-Length"}
+	}
+	st.body.closeWithError(io.EOF)
+	return nil
+}
+
+func (c *conn) processData(f frame) error {
+	if f.streamID == 0 {
+		return connError{codeProtocol, "DATA on stream 0"}
+	}
+	n := int64(len(f.payload))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > c.recvWindow {
+		return connError{codeFlowControl, "DATA past the connection window"}
+	}
+	c.recvWindow -= n
+	p, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	st := c.streams[f.streamID]
+	if st == nil {
+		if f.streamID > c.lastStreamID {
+			return connError{codeProtocol, "DATA on an idle stream"}
+		}
+		// The stream was reset; its data is dropped.
+		c.returnWindow(nil, n)
+		return nil
+	}
+	if st.remoteDone || st.reset {
+		c.returnWindow(nil, n)
+		return streamError{st.id, codeStreamClosed, "DATA after the request ended"}
+	}
+	if n > st.recvWindow {
+		c.returnWindow(nil, n)
+		return streamError{st.id, codeFlowControl, "DATA past the stream window"}
+	}
+	st.recvWindow -= n
+	if padding := n - int64(len(p)); padding > 0 {
+		c.returnWindow(st, padding)
+	}
+	st.received += int64(len(p))
+	if st.declared >= 0 && st.received > st.declared {
+		return streamError{st.id, codeProtocol, "body longer than its Content-Length"}
+	}
+	if len(p) > 0 && !st.body.write(p) {
+		c.returnWindow(nil, int64(len(p)))
+	}
+	if f.has(flagEndStream) {
+		return c.endRequest(st)
+	}
+	return nil
+}
+
+// consumed gives the client back the window a handler's body read freed.
+func (c *conn) consumed(st *stream, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.returnWindow(st, int64(n))
+}
+
+// returnWindow lets the client send n more bytes on the connection, and on
+// st unless it is nil or done sending; mu must be held.
+func (c *conn) returnWindow(st *stream, n int64) {
+	c.recvWindow += n
+	increment := binary.BigEndian.AppendUint32(nil, uint32(n))
+	frames := appendFrame(nil, frameWindowUpdate, 0, 0, increment)
+	if st != nil && !st.remoteDone && !st.reset {
+		st.recvWindow += n
+		frames = appendFrame(frames, frameWindowUpdate, 0, st.id, increment)
+	}
+	c.write(frames)
+}
+
+func (c *conn) processRSTStream(f frame) error {
+	if f.streamID == 0 {
+		return connError{codeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(f.payload) != 4 {
+		return connError{codeFrameSize, "RST_STREAM length"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID > c.lastStreamID {
+		return connError{codeProtocol, "RST_STREAM on an idle stream"}
+	}
+	if st := c.streams[f.streamID]; st != nil {
+		c.cancelStream(st)
+	}
+	return nil
+}
+
+// cancelStream stops a stream's handler from reading and writing; mu must
+// be held.
+func (c *conn) cancelStream(st *stream) {
+	st.reset = true
+	st.cancel()
+	if st.body != nil {
+		st.body.closeWithError(ERROR_STREAM_RESET)
+	}
+	c.cond.Broadcast()
+}
+
+// resetStream sends RST_STREAM, unless the stream was reset already.
+func (c *conn) resetStream(id uint32, code uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st := c.streams[id]; st != nil {
+		if st.reset {
+			return
+		}
+		c.cancelStream(st)
+	}
+	c.writeFrame(frameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, code))
+}
+
+func (c *conn) processSettings(f frame) error {
+	if f.streamID != 0 {
+		return connError{codeProtocol, "SETTINGS on a stream"}
+	}
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return connError{codeFrameSize, "SETTINGS ack with a payload"}
+		}
+		return nil
+	}
+	settings, err := parseSettings(f.payload)
+	if err != nil {
+		return err
+	}
+	if err := c.applySettings(settings); err != nil {
+		return err
+	}
+	return c.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+func (c *conn) applySettings(settings []setting) error {
+	for _, s := range settings {
+		switch s.id {
+		case settingHeaderTableSize:
+			c.wmu.Lock()
+			c.enc.SetMaxTableSize(int(s.value))
+			c.wmu.Unlock()
+		case settingEnablePush:
+			if s.value > 1 {
+				return connError{codeProtocol, "SETTINGS_ENABLE_PUSH not 0 or 1"}
+			}
+		case settingInitialWindowSize:
+			if s.value > maxWindow {
+				return connError{codeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+			}
+			c.mu.Lock()
+			// The change applies to the windows of open streams too.
+			delta := int64(s.value) - c.peerWindow
+			c.peerWindow = int64(s.value)
+			for _, st := range c.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindow {
+					c.mu.Unlock()
+					return connError{codeFlowControl, "stream window too large"}
+				}
+			}
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		case settingMaxFrameSize:
+			if s.value < minFrameSize || s.value > maxFrameSize {
+				return connError{codeProtocol, "SETTINGS_MAX_FRAME_SIZE out of range"}
+			}
+			c.mu.Lock()
+			c.peerFrameSize = s.value
+			c.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (c *conn) processWindowUpdate(f frame) error {
+	if len(f.payload) != 4 {
+		return connError{codeFrameSize, "WINDOW_UPDATE length"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.payload) & maxWindow)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID == 0 {
+		if increment == 0 {
+			return connError{codeProtocol, "WINDOW_UPDATE of 0"}
+		}
+		c.sendWindow += increment
+		if c.sendWindow > maxWindow {
+			return connError{codeFlowControl, "connection window too large"}
+		}
+		c.cond.Broadcast()
+		return nil
+	}
+	st := c.streams[f.streamID]
+	if st == nil {
+		if f.streamID > c.lastStreamID {
+			return connError{codeProtocol, "WINDOW_UPDATE on an idle stream"}
+		}
+		return nil
+	}
+	if increment == 0 {
+		return streamError{st.id, codeProtocol, "WINDOW_UPDATE of 0"}
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindow {
+		return streamError{st.id, codeFlowControl, "stream window too large"}
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+// becameBusy is called as the first stream opens; mu must be held.
+func (c *conn) becameBusy() {
+	setReadDeadline(c.rwc, time.Time{})
+	if c.opts.SetIdle != nil && !c.opts.SetIdle(false) {
+		c.goAway(codeNo)
+		c.closeSoon()
+	}
+}
+
+// becameIdle is called as the last stream closes; mu must be held.
+func (c *conn) becameIdle() {
+	if c.opts.SetIdle != nil && !c.opts.SetIdle(true) {
+		c.goAway(codeNo)
+	}
+	if c.goingAway {
+		c.closeSoon()
+		return
+	}
+	if c.opts.IdleTimeout > 0 {
+		setReadDeadline(c.rwc, time.Now().Add(c.opts.IdleTimeout))
+	}
+}
+
+// goAway tells the client to open no more streams, once; mu must be held.
+func (c *conn) goAway(code uint32) {
+	c.goingAway = true
+	if c.goAwaySent {
+		return
+	}
+	c.goAwaySent = true
+	payload := binary.BigEndian.AppendUint32(nil, c.lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, code)
+	c.writeFrame(frameGoAway, 0, 0, payload)
+}
+
+// closeSoon ends the read loop once the frames the client already sent
+// have had time to arrive.
+func (c *conn) closeSoon() {
+	if !setReadDeadline(c.rwc, time.Now().Add(goAwayTimeout)) {
+		c.rwc.Close()
+	}
+}
+
+type deadlineConn interface {
+	SetReadDeadline(time.Time) error
+}
+
+// setReadDeadline reports whether conn has deadlines.
+func setReadDeadline(conn io.ReadWriteCloser, t time.Time) bool {
+	c, ok := conn.(deadlineConn)
+	if ok {
+		c.SetReadDeadline(t)
+	}
+	return ok
+}
+
+func (c *conn) writeFrame(typ, flags byte, streamID uint32, payload []byte) error {
+	return c.write(appendFrame(nil, typ, flags, streamID, payload))
+}
+
+// write sends whole frames. A failed write closes the connection, which
+// ends the read loop.
+func (c *conn) write(frames []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.send(frames)
+}
+
+// send is write with wmu held.
+func (c *conn) send(frames []byte) error {
+	if _, err := c.rwc.Write(frames); err != nil {
+		c.rwc.Close()
+		return err
+	}
+	return nil
+}
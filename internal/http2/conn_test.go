@@ -0,0 +1,309 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"http/internal/headers"
+	"http/internal/hpack"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	enc  *hpack.Encoder
+	dec  *hpack.Decoder
+}
+
+// reply is what came back on a stream.
+type reply struct {
+	fields   map[string]string
+	body     []byte
+	trailers map[string]string
+	// reset is the RST_STREAM error code, or -1.
+	reset int64
+}
+
+// dial serves a connection with handler and starts it as a client would,
+// with settings of its own.
+func dial(t *testing.T, handler Handler, opts Options, settings ...setting) *client {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err == nil {
+			ServeConn(conn, handler, opts)
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c := &client{t: t, conn: conn, enc: hpack.NewEncoder(), dec: hpack.NewDecoder(hpack.DefaultTableSize)}
+	_, err = conn.Write(ClientPreface)
+	require.NoError(t, err)
+	c.write(frameSettings, 0, 0, appendSettings(nil, settings...))
+	f := c.read()
+	require.Equal(t, byte(frameSettings), f.typ)
+	c.write(frameSettings, flagAck, 0, nil)
+	f = c.read()
+	require.Equal(t, byte(frameSettings), f.typ)
+	require.True(t, f.has(flagAck))
+	return c
+}
+
+func (c *client) write(typ, flags byte, streamID uint32, payload []byte) {
+	_, err := c.conn.Write(appendFrame(nil, typ, flags, streamID, payload))
+	require.NoError(c.t, err)
+}
+
+// request opens stream id with fields given as name, value pairs.
+func (c *client) request(id uint32, end bool, fields ...string) {
+	var list []hpack.Field
+	for i := 0; i < len(fields); i += 2 {
+		list = append(list, hpack.Field{Name: fields[i], Value: fields[i+1]})
+	}
+	flags := byte(flagEndHeaders)
+	if end {
+		flags |= flagEndStream
+	}
+	c.write(frameHeaders, flags, id, c.enc.Encode(nil, list))
+}
+
+func (c *client) read() frame {
+	f, err := readFrame(c.conn, maxFrameSize)
+	require.NoError(c.t, err)
+	return f
+}
+
+// reply reads frames until stream id ends, answering nothing.
+func (c *client) reply(id uint32) reply {
+	r := reply{reset: -1}
+	for {
+		f := c.read()
+		if f.streamID != id {
+			continue
+		}
+		switch f.typ {
+		case frameHeaders:
+			fields, err := c.dec.Decode(f.payload)
+			require.NoError(c.t, err)
+			m := map[string]string{}
+			for _, field := range fields {
+				m[field.Name] = field.Value
+			}
+			if r.fields == nil {
+				r.fields = m
+			} else {
+				r.trailers = m
+			}
+		case frameData:
+			r.body = append(r.body, f.payload...)
+		case frameRSTStream:
+			r.reset = int64(binary.BigEndian.Uint32(f.payload))
+			return r
+		}
+		if f.has(flagEndStream) {
+			return r
+		}
+	}
+}
+
+func hello(w *response.Writer, req *request.Request) bool {
+	w.WriteText(response.StatusOK, "hello "+req.RequestLine.RequestTarget)
+	return true
+}
+
+func TestRequests(t *testing.T) {
+	c := dial(t, hello, Options{})
+
+	// Test: A GET is answered on its stream
+	c.request(1, true, ":method", "GET", ":scheme", "http", ":path", "/a", ":authority", "example.com")
+	r := c.reply(1)
+	assert.Equal(t, "200", r.fields[":status"])
+	assert.Equal(t, "text/plain; charset=utf-8", r.fields["content-type"])
+	assert.Equal(t, "hello /a", string(r.body))
+
+	// Test: Later streams reuse the header table
+	c.request(3, true, ":method", "GET", ":scheme", "http", ":path", "/b", ":authority", "example.com")
+	r = c.reply(3)
+	assert.Equal(t, "hello /b", string(r.body))
+
+	// Test: HEAD keeps the headers and drops the body
+	c.request(5, true, ":method", "HEAD", ":scheme", "http", ":path", "/c", ":authority", "example.com")
+	r = c.reply(5)
+	assert.Equal(t, "8", r.fields["content-length"])
+	assert.Empty(t, r.body)
+
+	// Test: PING is echoed
+	c.write(framePing, 0, 0, []byte("12345678"))
+	f := c.read()
+	assert.Equal(t, byte(framePing), f.typ)
+	assert.True(t, f.has(flagAck))
+	assert.Equal(t, []byte("12345678"), f.payload)
+}
+
+func TestRequestFields(t *testing.T) {
+	var got *request.Request
+	c := dial(t, func(w *response.Writer, req *request.Request) bool {
+		got = req
+		return hello(w, req)
+	}, Options{})
+
+	// Test: Pseudo-headers become the request line and Host, and split
+	// cookies are joined
+	c.request(1, true, ":method", "GET", ":scheme", "http", ":path", "/x?y=1", ":authority", "example.com",
+		"cookie", "a=1", "cookie", "b=2")
+	c.reply(1)
+	require.NotNil(t, got)
+	assert.Equal(t, request.RequestLine{Method: "GET", RequestTarget: "/x?y=1", HttpVersion: "2.0"}, got.RequestLine)
+	host, _ := got.Headers().Get("host")
+	assert.Equal(t, "example.com", host)
+	cookie, _ := got.Headers().Get("cookie")
+	assert.Equal(t, "a=1; b=2", cookie)
+
+	// Test: Malformed requests reset their stream only
+	for i, fields := range [][]string{
+		{":method", "GET", ":scheme", "http", ":authority", "x"},
+		{":method", "GET", ":scheme", "http", ":path", "/", "Upper", "x"},
+		{":method", "GET", ":scheme", "http", ":path", "/", "connection", "close"},
+		{":method", "GET", "accept", "*/*", ":scheme", "http", ":path", "/"},
+		{":method", "GET", ":scheme", "http", ":path", "/", ":status", "200"},
+	} {
+		id := uint32(3 + 2*i)
+		c.request(id, true, fields...)
+		assert.Equal(t, int64(codeProtocol), c.reply(id).reset, fields)
+	}
+	c.request(21, true, ":method", "GET", ":scheme", "http", ":path", "/ok")
+	assert.Equal(t, "hello /ok", string(c.reply(21).body))
+
+	// Test: Unknown methods are answered by the error handler
+	c.request(23, true, ":method", "BREW", ":scheme", "http", ":path", "/")
+	assert.Equal(t, "501", c.reply(23).fields[":status"])
+}
+
+func TestRequestBody(t *testing.T) {
+	c := dial(t, func(w *response.Writer, req *request.Request) bool {
+		body, err := io.ReadAll(req.BodyReader())
+		if err != nil {
+			return false
+		}
+		w.WriteText(response.StatusOK, string(body))
+		return true
+	}, Options{}, setting{settingInitialWindowSize, 1 << 20})
+	c.write(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, 1<<20))
+
+	// Test: A body larger than the initial window flows as the handler
+	// reads it
+	body := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	c.request(1, false, ":method", "POST", ":scheme", "http", ":path", "/", "content-length", "160000")
+	window, rest := defaultWindow, body
+	for len(rest) > 0 {
+		for window == 0 {
+			f := c.read()
+			if f.typ == frameWindowUpdate && f.streamID == 0 {
+				window += int(binary.BigEndian.Uint32(f.payload))
+			}
+		}
+		n := min(len(rest), window, minFrameSize)
+		var flags byte
+		if n == len(rest) {
+			flags = flagEndStream
+		}
+		c.write(frameData, flags, 1, rest[:n])
+		rest, window = rest[n:], window-n
+	}
+	r := c.reply(1)
+	assert.Equal(t, "200", r.fields[":status"])
+	assert.Equal(t, body, r.body)
+
+	// Test: A body that disagrees with its Content-Length resets the
+	// stream
+	c.request(3, false, ":method", "POST", ":scheme", "http", ":path", "/", "content-length", "2")
+	c.write(frameData, flagEndStream, 3, []byte("abc"))
+	assert.Equal(t, int64(codeProtocol), c.reply(3).reset)
+}
+
+func TestResponseFlowControl(t *testing.T) {
+	c := dial(t, func(w *response.Writer, req *request.Request) bool {
+		w.WriteText(response.StatusOK, strings.Repeat("x", 100))
+		return true
+	}, Options{}, setting{settingInitialWindowSize, 30})
+
+	// Test: The response waits for the client's window updates
+	c.request(1, true, ":method", "GET", ":scheme", "http", ":path", "/")
+	f := c.read()
+	require.Equal(t, byte(frameHeaders), f.typ)
+	f = c.read()
+	require.Equal(t, byte(frameData), f.typ)
+	assert.Len(t, f.payload, 30)
+	c.conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := readFrame(c.conn, maxFrameSize)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "no more data without a window update")
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	c.write(frameWindowUpdate, 0, 1, binary.BigEndian.AppendUint32(nil, 70))
+	r := c.reply(1)
+	assert.Len(t, r.body, 70)
+}
+
+func TestResponseTrailers(t *testing.T) {
+	c := dial(t, func(w *response.Writer, req *request.Request) bool {
+		w.DeclareTrailers("x-checksum")
+		h := headers.NewHeaders()
+		h.Set("content-type", "text/plain")
+		h.Set("transfer-encoding", "chunked")
+		w.WriteHeaders(*h)
+		w.WriteChunk([]byte("part one, "))
+		w.Flush()
+		w.WriteChunk([]byte("part two"))
+		trailers := headers.NewHeaders()
+		trailers.Set("x-checksum", "abc")
+		return w.WriteTrailers(trailers) == nil
+	}, Options{})
+
+	// Test: A chunked response loses its chunking and sends its trailers
+	// in a last HEADERS frame
+	c.request(1, true, ":method", "GET", ":scheme", "http", ":path", "/")
+	r := c.reply(1)
+	assert.Equal(t, "part one, part two", string(r.body))
+	assert.NotContains(t, r.fields, "transfer-encoding")
+	assert.Equal(t, map[string]string{"x-checksum": "abc"}, r.trailers)
+}
+
+func TestStreamLimits(t *testing.T) {
+	release := make(chan struct{})
+	c := dial(t, func(w *response.Writer, req *request.Request) bool {
+		<-release
+		return hello(w, req)
+	}, Options{MaxConcurrentStreams: 1})
+
+	// Test: Streams past the limit are refused
+	c.request(1, true, ":method", "GET", ":scheme", "http", ":path", "/1")
+	c.request(3, true, ":method", "GET", ":scheme", "http", ":path", "/3")
+	assert.Equal(t, int64(codeRefusedStream), c.reply(3).reset)
+	close(release)
+	assert.Equal(t, "hello /1", string(c.reply(1).body))
+
+	// Test: Streams the client cannot open end the connection
+	c.request(4, true, ":method", "GET", ":scheme", "http", ":path", "/4")
+	f := c.read()
+	require.Equal(t, byte(frameGoAway), f.typ)
+	assert.Equal(t, uint32(3), binary.BigEndian.Uint32(f.payload))
+	assert.Equal(t, uint32(codeProtocol), binary.BigEndian.Uint32(f.payload[4:]))
+}
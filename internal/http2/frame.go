@@ -0,0 +1,166 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ClientPreface is what a client sends first on an HTTP/2 connection,
+// before its SETTINGS frame.
+var ClientPreface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// Frame types of RFC 9113 section 6.
+const (
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameRSTStream    = 0x3
+	frameSettings     = 0x4
+	framePushPromise  = 0x5
+	framePing         = 0x6
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+)
+
+// Frame flags; which ones a frame may carry depends on its type.
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// Error codes of RFC 9113 section 7.
+const (
+	codeNo              = 0x0
+	codeProtocol        = 0x1
+	codeInternal        = 0x2
+	codeFlowControl     = 0x3
+	codeStreamClosed    = 0x5
+	codeFrameSize       = 0x6
+	codeRefusedStream   = 0x7
+	codeCompression     = 0x9
+	codeEnhanceYourCalm = 0xb
+)
+
+// Settings of RFC 9113 section 6.5.2.
+const (
+	settingHeaderTableSize      = 0x1
+	settingEnablePush           = 0x2
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+	settingMaxFrameSize         = 0x5
+	settingMaxHeaderListSize    = 0x6
+)
+
+const (
+	// defaultWindow is every flow-control window's initial size.
+	defaultWindow = 65535
+	// maxWindow is the largest a flow-control window may grow.
+	maxWindow = 1<<31 - 1
+	// minFrameSize is the frame size every endpoint accepts.
+	minFrameSize = 16384
+	// maxFrameSize is the largest SETTINGS_MAX_FRAME_SIZE there is.
+	maxFrameSize   = 1<<24 - 1
+	frameHeaderLen = 9
+)
+
+type frame struct {
+	typ      byte
+	flags    byte
+	streamID uint32
+	payload  []byte
+}
+
+func (f frame) has(flag byte) bool {
+	return f.flags&flag != 0
+}
+
+// connError ends the connection with a GOAWAY frame.
+type connError struct {
+	code   uint32
+	reason string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.code, e.reason)
+}
+
+// streamError resets one stream with a RST_STREAM frame.
+type streamError struct {
+	streamID uint32
+	code     uint32
+	reason   string
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d: %s", e.streamID, e.code, e.reason)
+}
+
+// readFrame reads one frame whose payload may be up to max bytes.
+func readFrame(r io.Reader, max uint32) (frame, error) {
+	var head [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+	length := uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2])
+	f := frame{
+		typ:      head[3],
+		flags:    head[4],
+		streamID: binary.BigEndian.Uint32(head[5:]) & maxWindow,
+	}
+	if length > max {
+		return frame{}, connError{codeFrameSize, fmt.Sprintf("frame of %d bytes", length)}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+	return f, nil
+}
+
+func appendFrame(dst []byte, typ, flags byte, streamID uint32, payload []byte) []byte {
+	n := len(payload)
+	dst = append(dst, byte(n>>16), byte(n>>8), byte(n), typ, flags)
+	dst = binary.BigEndian.AppendUint32(dst, streamID)
+	return append(dst, payload...)
+}
+
+// unpad strips the padding of a DATA or HEADERS frame.
+func unpad(f frame) ([]byte, error) {
+	p := f.payload
+	if !f.has(flagPadded) {
+		return p, nil
+	}
+	if len(p) == 0 || int(p[0]) >= len(p) {
+		return nil, connError{codeProtocol, "padding longer than the frame"}
+	}
+	return p[1 : len(p)-int(p[0])], nil
+}
+
+type setting struct {
+	id    uint16
+	value uint32
+}
+
+func parseSettings(p []byte) ([]setting, error) {
+	if len(p)%6 != 0 {
+		return nil, connError{codeFrameSize, "SETTINGS length not a multiple of 6"}
+	}
+	settings := make([]setting, 0, len(p)/6)
+	for ; len(p) > 0; p = p[6:] {
+		settings = append(settings, setting{binary.BigEndian.Uint16(p), binary.BigEndian.Uint32(p[2:])})
+	}
+	return settings, nil
+}
+
+func appendSettings(dst []byte, settings ...setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, s.id)
+		dst = binary.BigEndian.AppendUint32(dst, s.value)
+	}
+	return dst
+}
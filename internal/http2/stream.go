@@ -0,0 +1,519 @@
+package http2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"http/internal/headers"
+	"http/internal/hpack"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"strings"
+	"sync"
+)
+
+// pipe hands a request body from the read loop to the handler. The flow
+// control windows bound how much it buffers.
+type pipe struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	buf  bytes.Buffer
+	// err is io.EOF once the body ended, or why it was cut short.
+	err error
+	// consumed is called with the bytes each read takes out.
+	consumed func(n int)
+}
+
+func newPipe(consumed func(n int)) *pipe {
+	p := &pipe{consumed: consumed}
+	p.cond.L = &p.mu
+	return p
+}
+
+func (p *pipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	for p.buf.Len() == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+	if p.buf.Len() == 0 {
+		defer p.mu.Unlock()
+		return 0, p.err
+	}
+	n, _ := p.buf.Read(b)
+	p.mu.Unlock()
+	p.consumed(n)
+	return n, nil
+}
+
+// write adds body bytes, reporting false if nobody will read them.
+func (p *pipe) write(b []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return false
+	}
+	p.buf.Write(b)
+	p.cond.Signal()
+	return true
+}
+
+// closeWithError ends the body. Buffered bytes can still be read after
+// io.EOF; any other error drops them.
+func (p *pipe) closeWithError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+	if err != io.EOF {
+		p.buf.Reset()
+	}
+	p.cond.Signal()
+}
+
+// discard drops the unread rest of the body and returns its size.
+func (p *pipe) discard() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.buf.Len()
+	p.buf.Reset()
+	p.err = ERROR_STREAM_CLOSED
+	return n
+}
+
+// connectionFields are HTTP/1 fields about the connection, which HTTP/2
+// messages must not carry.
+var connectionFields = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// requestParts checks a request's fields as RFC 9113 section 8.3 asks and
+// turns the pseudo-header fields into a request line and Host.
+func (c *conn) requestParts(fields []hpack.Field) (request.Parts, error) {
+	pseudo := map[string]string{}
+	h := headers.NewHeaders()
+	var cookies []string
+	regular := false
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			switch f.Name {
+			case ":method", ":scheme", ":path", ":authority":
+			default:
+				return request.Parts{}, fmt.Errorf("unknown pseudo-header %q", f.Name)
+			}
+			if _, ok := pseudo[f.Name]; ok || regular {
+				return request.Parts{}, fmt.Errorf("misplaced or repeated %q", f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+		regular = true
+		switch {
+		case f.Name == "" || !headers.IsToken(f.Name) || strings.ToLower(f.Name) != f.Name:
+			return request.Parts{}, fmt.Errorf("invalid field name %q", f.Name)
+		case !headers.ValidValue(f.Value):
+			return request.Parts{}, fmt.Errorf("invalid value for %q", f.Name)
+		case connectionFields[f.Name], f.Name == "te" && f.Value != "trailers":
+			return request.Parts{}, fmt.Errorf("connection-specific field %q", f.Name)
+		case f.Name == "cookie":
+			// Cookies may come split up for better compression.
+			cookies = append(cookies, f.Value)
+			continue
+		}
+		h.Add(f.Name, f.Value)
+	}
+	if len(cookies) > 0 {
+		h.Add("cookie", strings.Join(cookies, "; "))
+	}
+	method, target := pseudo[":method"], pseudo[":path"]
+	_, hasScheme := pseudo[":scheme"]
+	_, hasPath := pseudo[":path"]
+	if method == "CONNECT" {
+		if pseudo[":authority"] == "" || hasScheme || hasPath {
+			return request.Parts{}, fmt.Errorf("malformed CONNECT request")
+		}
+		target = pseudo[":authority"]
+	} else if method == "" || pseudo[":scheme"] == "" || target == "" {
+		return request.Parts{}, fmt.Errorf("missing pseudo-header fields")
+	}
+	if authority := pseudo[":authority"]; authority != "" {
+		h.Set("host", authority)
+	}
+	return request.Parts{
+		Line:                 request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "2.0"},
+		Headers:              h,
+		Conn:                 c.opts.Conn,
+		RejectEncodedControl: c.opts.RejectEncodedControl,
+	}, nil
+}
+
+// start runs a stream's handler, or answers reqErr, in its own goroutine.
+func (c *conn) start(st *stream, reqErr error) {
+	c.handlers.Add(1)
+	go func() {
+		defer c.handlers.Done()
+		c.runStream(st, reqErr)
+	}()
+}
+
+func (c *conn) runStream(st *stream, reqErr error) {
+	head := st.req != nil && st.req.RequestLine.Method == "HEAD"
+	out := &responseStream{c: c, st: st, head: head}
+	w := response.NewBufferedWriter(out, 0)
+	if c.opts.PrepareWriter != nil {
+		c.opts.PrepareWriter(w)
+	}
+	if head {
+		w.SuppressBody()
+	}
+	w.SetContext(st.ctx)
+	ok := true
+	if reqErr != nil {
+		status := response.StatusBadRequest
+		if errors.Is(reqErr, request.ERROR_UNKNOWN_METHOD) {
+			status = response.StatusNotImplemented
+		}
+		handleError := c.opts.HandleError
+		if handleError == nil {
+			handleError = bareStatus
+		}
+		handleError(w, nil, status, reqErr)
+	} else {
+		ok = c.handler(w, st.req.WithContext(st.ctx))
+	}
+	if ok {
+		// Handlers that write nothing send an empty 200.
+		if !w.Started() {
+			w.WriteHeaders(*w.Header())
+		}
+		err := w.Finish(nil)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err == nil {
+			err = out.end()
+		}
+		ok = err == nil
+	}
+	c.mu.Lock()
+	done := st.remoteDone
+	c.mu.Unlock()
+	if !ok {
+		c.resetStream(st.id, codeInternal)
+	} else if !done {
+		// The response is complete but the client is still sending a
+		// body nobody reads: tell it to stop.
+		c.resetStream(st.id, codeNo)
+	}
+	c.closeStream(st)
+}
+
+// bareStatus is the default ErrorHandler.
+func bareStatus(w *response.Writer, _ *request.Request, status response.StatusCode, _ error) {
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*response.GetDefaultHeaders(0))
+}
+
+// closeStream forgets a stream whose handler has finished.
+func (c *conn) closeStream(st *stream) {
+	st.cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st.body != nil {
+		if unread := st.body.discard(); unread > 0 {
+			c.returnWindow(nil, int64(unread))
+		}
+	}
+	st.reset = true
+	delete(c.streams, st.id)
+	if len(c.streams) == 0 && !c.closed {
+		c.becameIdle()
+	}
+}
+
+// writeHeaders sends a header block, split into CONTINUATION frames as the
+// client's frame size requires.
+func (c *conn) writeHeaders(st *stream, fields []hpack.Field, end bool) error {
+	c.mu.Lock()
+	size, gone := int(c.peerFrameSize), st.reset || c.closed
+	c.mu.Unlock()
+	if gone {
+		return ERROR_STREAM_RESET
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	block := c.enc.Encode(nil, fields)
+	var flags byte
+	if end {
+		flags = flagEndStream
+	}
+	var frames []byte
+	typ := byte(frameHeaders)
+	for {
+		n := min(len(block), size)
+		if n == len(block) {
+			flags |= flagEndHeaders
+		}
+		frames = appendFrame(frames, typ, flags, st.id, block[:n])
+		block = block[n:]
+		if len(block) == 0 {
+			break
+		}
+		typ, flags = frameContinuation, 0
+	}
+	return c.send(frames)
+}
+
+// writeData sends p as DATA frames as fast as the flow-control windows
+// allow, the last one ending the stream if end is set.
+func (c *conn) writeData(st *stream, p []byte, end bool) error {
+	for {
+		c.mu.Lock()
+		n := 0
+		for {
+			if st.reset || c.closed {
+				c.mu.Unlock()
+				return ERROR_STREAM_RESET
+			}
+			n = int(min(int64(len(p)), st.sendWindow, c.sendWindow, int64(c.peerFrameSize)))
+			if n > 0 || len(p) == 0 {
+				break
+			}
+			c.cond.Wait()
+		}
+		st.sendWindow -= int64(n)
+		c.sendWindow -= int64(n)
+		c.mu.Unlock()
+		chunk := p[:n]
+		p = p[n:]
+		var flags byte
+		if end && len(p) == 0 {
+			flags = flagEndStream
+		}
+		if err := c.writeFrame(frameData, flags, st.id, chunk); err != nil {
+			return err
+		}
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+// Phases of the HTTP/1 response a responseStream takes apart.
+const (
+	phaseStatus = iota
+	phaseHeaders
+	phaseBody
+	phaseChunkSize
+	phaseChunkData
+	phaseChunkEnd
+	phaseTrailers
+	phaseDone
+)
+
+// responseStream sends a response the Writer produced as HTTP/1 text as
+// HTTP/2 frames: the status line and headers become a HEADERS frame, the
+// body, unchunked, DATA frames and trailers a last HEADERS frame.
+type responseStream struct {
+	c     *conn
+	st    *stream
+	head  bool
+	phase int
+	// buf holds what is not parsed yet.
+	buf    []byte
+	status string
+	fields *headers.Headers
+	// remaining is what is left of a body with a Content-Length, or -1.
+	remaining int64
+	chunk     int64
+	ended     bool
+}
+
+func (r *responseStream) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	for {
+		progressed, err := r.step()
+		if err != nil {
+			return 0, err
+		}
+		if !progressed {
+			return len(p), nil
+		}
+	}
+}
+
+// step parses what it can of buf, reporting whether it got anywhere.
+func (r *responseStream) step() (bool, error) {
+	switch r.phase {
+	case phaseStatus:
+		line, ok := r.line()
+		if !ok {
+			return false, nil
+		}
+		// "HTTP/1.1 200 OK"
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) < 2 {
+			return false, fmt.Errorf("http2: bad status line %q", line)
+		}
+		r.status = parts[1]
+		r.fields = headers.NewHeaders()
+		r.phase = phaseHeaders
+	case phaseHeaders:
+		n, done, err := r.fields.Parse(r.buf)
+		if err != nil {
+			return false, err
+		}
+		r.buf = r.buf[n:]
+		if !done {
+			return n > 0, nil
+		}
+		return true, r.startBody()
+	case phaseBody:
+		if len(r.buf) == 0 {
+			return false, nil
+		}
+		n := int64(len(r.buf))
+		if r.remaining >= 0 {
+			n = min(n, r.remaining)
+			r.remaining -= n
+		}
+		end := r.remaining == 0
+		if end {
+			r.phase = phaseDone
+		}
+		return true, r.data(r.take(int(n)), end)
+	case phaseChunkSize:
+		line, ok := r.line()
+		if !ok {
+			return false, nil
+		}
+		size, _, _ := strings.Cut(line, ";")
+		var err error
+		if _, err = fmt.Sscanf(strings.TrimSpace(size), "%x", &r.chunk); err != nil {
+			return false, fmt.Errorf("http2: bad chunk size %q", line)
+		}
+		r.phase = phaseChunkData
+		if r.chunk == 0 {
+			r.fields = headers.NewHeaders()
+			r.phase = phaseTrailers
+		}
+	case phaseChunkData:
+		if len(r.buf) == 0 {
+			return false, nil
+		}
+		n := min(int64(len(r.buf)), r.chunk)
+		r.chunk -= n
+		if r.chunk == 0 {
+			r.phase = phaseChunkEnd
+		}
+		return true, r.data(r.take(int(n)), false)
+	case phaseChunkEnd:
+		if len(r.buf) < 2 {
+			return false, nil
+		}
+		r.take(2)
+		r.phase = phaseChunkSize
+	case phaseTrailers:
+		n, done, err := r.fields.Parse(r.buf)
+		if err != nil {
+			return false, err
+		}
+		r.buf = r.buf[n:]
+		if !done {
+			return n > 0, nil
+		}
+		r.phase = phaseDone
+		fields := r.fieldList("")
+		if len(fields) == 0 {
+			return true, r.data(nil, true)
+		}
+		r.ended = true
+		return true, r.c.writeHeaders(r.st, fields, true)
+	case phaseDone:
+		r.buf = r.buf[:0]
+		return false, nil
+	}
+	return true, nil
+}
+
+// startBody sends the header block and works out how the body is framed.
+func (r *responseStream) startBody() error {
+	informational := len(r.status) == 3 && r.status[0] == '1'
+	if informational {
+		r.phase = phaseStatus
+		return r.c.writeHeaders(r.st, r.fieldList(r.status), false)
+	}
+	length, hasLength := r.fields.Get("content-length")
+	chunked := r.fields.HasToken("transfer-encoding", "chunked")
+	r.remaining = -1
+	switch {
+	case r.head || r.status == "204" || r.status == "304" || hasLength && length == "0":
+		r.phase = phaseDone
+	case chunked:
+		r.phase = phaseChunkSize
+	default:
+		r.phase = phaseBody
+		if hasLength {
+			fmt.Sscanf(length, "%d", &r.remaining)
+		}
+	}
+	end := r.phase == phaseDone
+	r.ended = end
+	return r.c.writeHeaders(r.st, r.fieldList(r.status), end)
+}
+
+// fieldList lists the parsed fields for HPACK, without those HTTP/2 has no
+// use for, after a :status field unless status is empty.
+func (r *responseStream) fieldList(status string) []hpack.Field {
+	var fields []hpack.Field
+	if status != "" {
+		fields = append(fields, hpack.Field{Name: ":status", Value: status})
+	}
+	r.fields.Foreach(func(n, v string) {
+		n = strings.ToLower(n)
+		if !connectionFields[n] {
+			fields = append(fields, hpack.Field{Name: n, Value: v})
+		}
+	})
+	return fields
+}
+
+func (r *responseStream) data(p []byte, end bool) error {
+	r.ended = r.ended || end
+	return r.c.writeData(r.st, p, end)
+}
+
+// line takes a CRLF-terminated line off buf.
+func (r *responseStream) line() (string, bool) {
+	i := bytes.Index(r.buf, []byte("\r\n"))
+	if i < 0 {
+		return "", false
+	}
+	line := string(r.buf[:i])
+	r.take(i + 2)
+	return line, true
+}
+
+// take removes n bytes from the front of buf and returns a copy.
+func (r *responseStream) take(n int) []byte {
+	p := bytes.Clone(r.buf[:n])
+	r.buf = r.buf[n:]
+	return p
+}
+
+// end ends the stream once the Writer is done, for bodies that had no
+// framing telling where they end.
+func (r *responseStream) end() error {
+	if r.ended {
+		return nil
+	}
+	if r.phase == phaseStatus || r.phase == phaseHeaders {
+		return fmt.Errorf("http2: response ended before its headers")
+	}
+	return r.data(nil, true)
+}
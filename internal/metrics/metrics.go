@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the request duration
+// histogram; the same as Prometheus clients use by default.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	method string
+	status int
+}
+
+// Metrics collects counters about a server. Middleware instruments
+// requests and ConnHooks connections; Serve renders everything in the
+// Prometheus text format.
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	buckets  []float64
+	counts   []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum      float64
+	conns    map[net.Conn]bool
+
+	inFlight     atomic.Int64
+	openConns    atomic.Int64
+	acceptedConn atomic.Uint64
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+}
+
+// New returns empty metrics whose duration histogram uses buckets, or
+// DefaultBuckets when none are given.
+func New(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{
+		requests: map[requestKey]uint64{},
+		buckets:  buckets,
+		counts:   make([]uint64, len(buckets)+1),
+		conns:    map[net.Conn]bool{},
+	}
+}
+
+// Middleware counts requests by method and status, tracks those in flight
+// and observes how long the handler took. Requests whose handler panics
+// count as 500. Methods make a bounded label set, as the parser refuses
+// those nobody registered.
+func (m *Metrics) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			m.inFlight.Add(1)
+			status := int(response.StatusInternalServerError)
+			defer func() {
+				m.inFlight.Add(-1)
+				m.observe(req.RequestLine.Method, status, time.Since(start))
+			}()
+			next(w, req)
+			// A handler that writes nothing gets the default 200.
+			status = int(w.Status())
+			if status == 0 {
+				status = int(response.StatusOK)
+			}
+		}
+	}
+}
+
+func (m *Metrics) observe(method string, status int, d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(m.buckets, seconds)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{method, status}]++
+	m.counts[i]++
+	m.sum += seconds
+}
+
+// ConnHooks counts connections and the bytes read from and written to
+// them; install them with server.WithConnHooks.
+func (m *Metrics) ConnHooks() server.ConnHooks {
+	return server.ConnHooks{
+		OnAccept: func(conn net.Conn) {
+			m.mu.Lock()
+			m.conns[conn] = true
+			m.mu.Unlock()
+			m.acceptedConn.Add(1)
+			m.openConns.Add(1)
+		},
+		OnRead:  func(ev server.ConnEvent) { m.bytesRead.Add(uint64(ev.Bytes)) },
+		OnWrite: func(ev server.ConnEvent) { m.bytesWritten.Add(uint64(ev.Bytes)) },
+		OnClose: func(conn net.Conn) {
+			// Connections may be closed more than once.
+			m.mu.Lock()
+			open := m.conns[conn]
+			delete(m.conns, conn)
+			m.mu.Unlock()
+			if open {
+				m.openConns.Add(-1)
+			}
+		},
+	}
+}
+
+// Serve answers with the metrics in the Prometheus text format, so it can
+// be routed at /metrics for scraping.
+func (m *Metrics) Serve(w *response.Writer, req *request.Request) {
+	var b strings.Builder
+	m.WriteTo(&b)
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(b.Len()))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody([]byte(b.String()))
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(out io.Writer) (int64, error) {
+	var b strings.Builder
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	m.mu.Lock()
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	metric("http_requests_total", "counter", "Requests handled, by method and status.")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "http_requests_total{method=%q,status=\"%d\"} %d\n", k.method, k.status, m.requests[k])
+	}
+	metric("http_request_duration_seconds", "histogram", "Time spent handling requests.")
+	var cumulative uint64
+	for i, bound := range m.buckets {
+		cumulative += m.counts[i]
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{le=\"%s\"} %d\n", formatFloat(bound), cumulative)
+	}
+	cumulative += m.counts[len(m.buckets)]
+	fmt.Fprintf(&b, "http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(&b, "http_request_duration_seconds_sum %s\n", formatFloat(m.sum))
+	fmt.Fprintf(&b, "http_request_duration_seconds_count %d\n", cumulative)
+	m.mu.Unlock()
+
+	metric("http_requests_in_flight", "gauge", "Requests being handled.")
+	fmt.Fprintf(&b, "http_requests_in_flight %d\n", m.inFlight.Load())
+	metric("http_connections_open", "gauge", "Connections currently open.")
+	fmt.Fprintf(&b, "http_connections_open %d\n", m.openConns.Load())
+	metric("http_connections_total", "counter", "Connections accepted.")
+	fmt.Fprintf(&b, "http_connections_total %d\n", m.acceptedConn.Load())
+	metric("http_read_bytes_total", "counter", "Bytes read from connections.")
+	fmt.Fprintf(&b, "http_read_bytes_total %d\n", m.bytesRead.Load())
+	metric("http_written_bytes_total", "counter", "Bytes written to connections.")
+	fmt.Fprintf(&b, "http_written_bytes_total %d\n", m.bytesWritten.Load())
+
+	n, err := io.WriteString(out, b.String())
+	return int64(n), err
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
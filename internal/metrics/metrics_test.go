@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"bufio"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New(0.5, 0.1)
+	handler := func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/metrics":
+			m.Serve(w, req)
+		case "/missing":
+			w.WriteText(response.StatusNotFound, "missing")
+		case "/slow":
+			time.Sleep(150 * time.Millisecond)
+			w.WriteText(response.StatusOK, "slow")
+		case "/panic":
+			panic("boom")
+		default:
+			w.WriteText(response.StatusOK, "ok")
+		}
+	}
+	s, err := server.ServeAddr("127.0.0.1:0", handler, server.Use(m.Middleware()), server.WithConnHooks(m.ConnHooks()))
+	require.NoError(t, err)
+	defer s.Close()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	get := func(method, target string) *http.Response {
+		_, err := io.WriteString(conn, method+" "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		res, err := http.ReadResponse(br, &http.Request{Method: method})
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, res.Body)
+		require.NoError(t, err)
+		return res
+	}
+
+	get("GET", "/")
+	get("GET", "/")
+	get("POST", "/missing")
+	get("GET", "/slow")
+	res := get("GET", "/metrics")
+
+	// Test: The exposition is Prometheus text
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header.Get("Content-Type"))
+
+	var out strings.Builder
+	_, err = m.WriteTo(&out)
+	require.NoError(t, err)
+	text := out.String()
+
+	// Test: Requests are counted by method and status
+	assert.Contains(t, text, "# TYPE http_requests_total counter\n")
+	assert.Contains(t, text, `http_requests_total{method="GET",status="200"} 4`+"\n")
+	assert.Contains(t, text, `http_requests_total{method="POST",status="404"} 1`+"\n")
+
+	// Test: Durations land in sorted, cumulative buckets
+	assert.Contains(t, text, `http_request_duration_seconds_bucket{le="0.1"} 4`+"\n")
+	assert.Contains(t, text, `http_request_duration_seconds_bucket{le="0.5"} 5`+"\n")
+	assert.Contains(t, text, `http_request_duration_seconds_bucket{le="+Inf"} 5`+"\n")
+	assert.Contains(t, text, "http_request_duration_seconds_count 5\n")
+
+	// Test: Connections and their bytes are tracked
+	assert.Contains(t, text, "http_requests_in_flight 0\n")
+	assert.Contains(t, text, "http_connections_open 1\n")
+	assert.Contains(t, text, "http_connections_total 1\n")
+	assert.NotContains(t, text, "http_read_bytes_total 0\n")
+	assert.NotContains(t, text, "http_written_bytes_total 0\n")
+
+	// Test: A panicking handler counts as 500, and closed connections are
+	// no longer open
+	_, err = io.WriteString(conn, "GET /panic HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	io.Copy(io.Discard, br)
+	conn.Close()
+	require.Eventually(t, func() bool {
+		out.Reset()
+		m.WriteTo(&out)
+		return strings.Contains(out.String(), "http_connections_open 0\n")
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Contains(t, out.String(), `http_requests_total{method="GET",status="500"} 1`+"\n")
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LogFormat selects how AccessLog writes entries.
+type LogFormat int
+
+const (
+	// CommonLogFormat is the NCSA Common Log Format.
+	CommonLogFormat LogFormat = iota
+	// CombinedLogFormat adds the Referer and User-Agent to it.
+	CombinedLogFormat
+	// JSONLogFormat writes one JSON object per line, duration included.
+	JSONLogFormat
+)
+
+// clfTime is the timestamp layout of the Common Log Format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// LogEntry describes one request for the access log.
+type LogEntry struct {
+	Time       time.Time     `json:"time"`
+	RemoteAddr string        `json:"remote_addr"`
+	Method     string        `json:"method"`
+	Target     string        `json:"target"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	// RequestID is set by the RequestID middleware; only JSON entries
+	// show it.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// orDash returns s, or "-" for empty fields as CLF has it.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Format renders the entry as a single line, without the line break.
+func (e LogEntry) Format(format LogFormat) string {
+	if format == JSONLogFormat {
+		out, _ := json.Marshal(struct {
+			LogEntry
+			DurationMS float64 `json:"duration_ms"`
+		}{e, float64(e.Duration) / float64(time.Millisecond)})
+		return string(out)
+	}
+	requestLine := strconv.Quote(e.Method + " " + e.Target + " " + e.Proto)
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	line := fmt.Sprintf("%s - - [%s] %s %d %s", orDash(e.RemoteAddr), e.Time.Format(clfTime), requestLine, e.Status, bytes)
+	if format == CombinedLogFormat {
+		line += fmt.Sprintf(" %s %s", strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)))
+	}
+	return line
+}
+
+// AccessLog writes an entry per request to sink once the handler returns.
+// Bytes counts the body as the handler wrote it, before compression.
+// Requests whose handler panics are logged with status 500.
+func AccessLog(sink io.Writer, format LogFormat) server.Middleware {
+	var mu sync.Mutex
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			status := 0
+			defer func() {
+				v := recover()
+				if v != nil {
+					status = int(response.StatusInternalServerError)
+				}
+				entry := newLogEntry(req, start, status, w.BodyBytes())
+				mu.Lock()
+				_, err := io.WriteString(sink, entry.Format(format)+"\n")
+				mu.Unlock()
+				if err != nil {
+					log.Printf("Writing access log failed: %v", err)
+				}
+				if v != nil {
+					panic(v)
+				}
+			}()
+			next(w, req)
+			// A handler that writes nothing gets the default 200.
+			status = int(w.Status())
+			if status == 0 {
+				status = int(response.StatusOK)
+			}
+		}
+	}
+}
+
+func newLogEntry(req *request.Request, start time.Time, status int, bytes int64) LogEntry {
+	entry := LogEntry{
+		Time:     start,
+		Method:   req.RequestLine.Method,
+		Target:   req.RequestLine.RequestTarget,
+		Proto:    "HTTP/" + req.RequestLine.HttpVersion,
+		Status:   status,
+		Bytes:    bytes,
+		Duration: time.Since(start),
+	}
+	if addr := req.RemoteAddr(); addr != nil {
+		entry.RemoteAddr = addr.String()
+		if host, _, err := net.SplitHostPort(entry.RemoteAddr); err == nil {
+			entry.RemoteAddr = host
+		}
+	}
+	entry.Referer, _ = req.Headers().Get("referer")
+	entry.UserAgent, _ = req.Headers().Get("user-agent")
+	entry.RequestID = RequestIDFrom(req.Context())
+	return entry
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusNotFound, "nothing here")
+	}
+	serve := func(format LogFormat, h func(w *response.Writer, req *request.Request)) string {
+		raw := "GET /missing?x=1 HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/8.0\r\nReferer: http://example.com/\r\n\r\n"
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		sink := &bytes.Buffer{}
+		AccessLog(sink, format)(h)(response.NewWriter(io.Discard), req)
+		return sink.String()
+	}
+
+	// Test: Common Log Format has the request line, status and size
+	line := serve(CommonLogFormat, handler)
+	assert.Regexp(t, `^- - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /missing\?x=1 HTTP/1.1" 404 12\n$`, line)
+
+	// Test: Combined adds the Referer and User-Agent
+	line = serve(CombinedLogFormat, handler)
+	assert.True(t, strings.HasSuffix(line, `404 12 "http://example.com/" "curl/8.0"`+"\n"))
+
+	// Test: JSON entries carry the duration
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(serve(JSONLogFormat, handler)), &entry))
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/missing?x=1", entry["target"])
+	assert.Equal(t, float64(404), entry["status"])
+	assert.Equal(t, float64(12), entry["bytes"])
+	assert.Equal(t, "curl/8.0", entry["user_agent"])
+	assert.Contains(t, entry, "duration_ms")
+
+	// Test: Empty responses log the default status and no size
+	line = serve(CommonLogFormat, func(w *response.Writer, req *request.Request) {})
+	assert.True(t, strings.HasSuffix(line, `" 200 -`+"\n"))
+
+	// Test: Panics are logged as 500 and passed on
+	var sink bytes.Buffer
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Panics(t, func() {
+		AccessLog(&sink, CommonLogFormat)(func(w *response.Writer, req *request.Request) {
+			panic("boom")
+		})(response.NewWriter(io.Discard), req)
+	})
+	assert.Contains(t, sink.String(), `"GET / HTTP/1.1" 500 -`)
+
+	// Test: Entries format on their own for custom sinks
+	e := LogEntry{Time: time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)), RemoteAddr: "127.0.0.1", Method: "GET", Target: "/a.gif", Proto: "HTTP/1.0", Status: 200, Bytes: 2326}
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326`, e.Format(CommonLogFormat))
+}
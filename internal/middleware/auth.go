@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"strconv"
+	"strings"
+)
+
+// AuthConfig says which Authorization schemes Auth accepts and how to
+// check them. A nil validator turns its scheme off.
+type AuthConfig struct {
+	// Realm is named in the challenges; defaults to "restricted".
+	Realm string
+	// Basic checks a user name and password.
+	Basic func(user, password string) bool
+	// Bearer checks a token and returns who it belongs to.
+	Bearer func(token string) (subject string, ok bool)
+}
+
+// Principal is who a request was authenticated as.
+type Principal struct {
+	// Name is the Basic user name or the subject of the Bearer token.
+	Name string
+	// Scheme is "Basic" or "Bearer".
+	Scheme string
+}
+
+type principalKey struct{}
+
+// Auth lets through only requests whose Authorization header passes one of
+// the configured schemes, with the Principal in their context where
+// PrincipalFrom finds it. Everything else gets a 401 that challenges the
+// client with each scheme.
+func Auth(config AuthConfig) server.Middleware {
+	if config.Realm == "" {
+		config.Realm = "restricted"
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			principal, invalidToken := config.authenticate(req)
+			if principal.Scheme == "" {
+				config.challenge(w, invalidToken)
+				return
+			}
+			next(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, principal)))
+		}
+	}
+}
+
+// PrincipalFrom returns the Principal Auth attached to ctx.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// authenticate returns the request's Principal, or a zero one when it has
+// none. invalidToken reports a Bearer token that was offered but refused.
+func (c AuthConfig) authenticate(req *request.Request) (p Principal, invalidToken bool) {
+	value, _ := req.Headers().Get("Authorization")
+	scheme, credentials, _ := strings.Cut(value, " ")
+	credentials = strings.TrimSpace(credentials)
+	switch {
+	case strings.EqualFold(scheme, "Basic") && c.Basic != nil:
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return Principal{}, false
+		}
+		user, password, ok := strings.Cut(string(decoded), ":")
+		if ok && c.Basic(user, password) {
+			return Principal{Name: user, Scheme: "Basic"}, false
+		}
+	case strings.EqualFold(scheme, "Bearer") && c.Bearer != nil:
+		if credentials == "" {
+			return Principal{}, false
+		}
+		if subject, ok := c.Bearer(credentials); ok {
+			return Principal{Name: subject, Scheme: "Bearer"}, false
+		}
+		return Principal{}, true
+	}
+	return Principal{}, false
+}
+
+// challenge answers 401 with a WWW-Authenticate field per enabled scheme.
+func (c AuthConfig) challenge(w *response.Writer, invalidToken bool) {
+	realm := "realm=" + strconv.Quote(c.Realm)
+	if c.Basic != nil {
+		w.Header().Add("WWW-Authenticate", "Basic "+realm+`, charset="UTF-8"`)
+	}
+	if c.Bearer != nil {
+		bearer := "Bearer " + realm
+		if invalidToken {
+			bearer += `, error="invalid_token"`
+		}
+		w.Header().Add("WWW-Authenticate", bearer)
+	}
+	w.WriteText(response.StatusUnauthorized, "unauthorized")
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/base64"
+	"http/internal/request"
+	"http/internal/response"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth(t *testing.T) {
+	var seen Principal
+	var called bool
+	handler := func(w *response.Writer, req *request.Request) {
+		seen, called = PrincipalFrom(req.Context())
+		w.WriteText(response.StatusOK, "ok")
+	}
+	config := AuthConfig{
+		Realm: "admin",
+		Basic: func(user, password string) bool { return user == "alice" && password == "s3cr:et" },
+		Bearer: func(token string) (string, bool) {
+			return "service", token == "t0ken"
+		},
+	}
+	serve := func(config AuthConfig, authorization string) *http.Response {
+		seen, called = Principal{}, false
+		raw := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n"
+		if authorization != "" {
+			raw += "Authorization: " + authorization + "\r\n"
+		}
+		req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+		out := &strings.Builder{}
+		w := response.NewWriter(out)
+		Auth(config)(handler)(w, req)
+		require.NoError(t, w.Finish(nil))
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out.String())), nil)
+		require.NoError(t, err)
+		return res
+	}
+	basic := func(credentials string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	// Test: Valid Basic credentials reach the handler with the principal
+	res := serve(config, basic("alice:s3cr:et"))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, called)
+	assert.Equal(t, Principal{Name: "alice", Scheme: "Basic"}, seen)
+
+	// Test: Valid Bearer tokens do too, whatever the scheme's case
+	res = serve(config, "bearer t0ken")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, Principal{Name: "service", Scheme: "Bearer"}, seen)
+
+	// Test: Missing or wrong credentials get 401 with both challenges
+	for _, authorization := range []string{"", basic("alice:wrong"), basic("alice"), "Basic !!!", "Digest x", "Bearer"} {
+		res = serve(config, authorization)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode, authorization)
+		assert.False(t, called)
+		assert.Equal(t, []string{`Basic realm="admin", charset="UTF-8"`, `Bearer realm="admin"`},
+			res.Header.Values("WWW-Authenticate"), authorization)
+	}
+
+	// Test: A refused token is flagged in the Bearer challenge
+	res = serve(config, "Bearer nope")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Contains(t, res.Header.Values("WWW-Authenticate"), `Bearer realm="admin", error="invalid_token"`)
+
+	// Test: Only configured schemes are accepted and challenged
+	res = serve(AuthConfig{Basic: config.Basic}, "Bearer t0ken")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, []string{`Basic realm="restricted", charset="UTF-8"`}, res.Header.Values("WWW-Authenticate"))
+}
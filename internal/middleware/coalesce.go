@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// credentialFields make a request's response its own, so requests with
+// any of them are never coalesced.
+var credentialFields = []string{"authorization", "proxy-authorization", "cookie"}
+
+// negotiationFields pick among representations of a resource, so they are
+// part of the default key.
+var negotiationFields = []string{"accept", "accept-encoding", "accept-language"}
+
+type flight struct {
+	done      chan struct{}
+	waiters   int
+	recording *response.Recording
+	// leader holds the header fields of the request that ran the handler.
+	leader *headers.Headers
+}
+
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+	key     func(req *request.Request) string
+}
+
+func defaultCoalesceKey(req *request.Request) string {
+	key := req.RequestLine.Method + " " + req.Host() + req.RequestLine.RequestTarget
+	for _, name := range negotiationFields {
+		if v, ok := req.Headers().Get(name); ok {
+			key += "\n" + name + ": " + v
+		}
+	}
+	return key
+}
+
+// Coalesce collapses concurrent GET and HEAD requests with the same key into
+// a single handler call: the first request runs the handler, the others wait
+// for it and are sent a replay of its response. key defaults to method, Host,
+// target and the Accept fields. Requests carrying credentials are never
+// coalesced, and waiters that differ from the first request in a field the
+// response varies on are served on their own, as are all of them when the
+// first response did not go out whole. Waiters whose client goes away stop
+// waiting. Replays leave out Set-Cookie, Date and the fields the waiter's
+// own default headers set, such as its request ID.
+func Coalesce(key func(req *request.Request) string) server.Middleware {
+	if key == nil {
+		key = defaultCoalesceKey
+	}
+	g := &flightGroup{
+		flights: map[string]*flight{},
+		key:     key,
+	}
+	return g.middleware
+}
+
+func (g *flightGroup) waiting(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f.waiters
+	}
+	return 0
+}
+
+// join returns the flight in progress for key, or starts a new one. The
+// boolean reports whether the caller leads the flight.
+func (g *flightGroup) join(key string, req *request.Request) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		f.waiters++
+		return f, false
+	}
+	f := &flight{done: make(chan struct{}), leader: req.Headers()}
+	g.flights[key] = f
+	return f, true
+}
+
+// leave drops a waiter that went away before the flight landed.
+func (g *flightGroup) leave(f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f.waiters--
+}
+
+// land ends the flight with rec, which is nil unless the leader's response
+// went out whole.
+func (g *flightGroup) land(key string, f *flight, rec *response.Recording) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	f.recording = rec
+	close(f.done)
+}
+
+// suits reports whether the leader's response may answer req, by the
+// fields its Vary lists.
+func (f *flight) suits(req *request.Request) bool {
+	for _, name := range f.recording.Headers.List("vary") {
+		if name == "*" || !slices.Equal(f.leader.Values(name), req.Headers().Values(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// replayExcluded are the recorded fields that belonged to the first
+// response alone.
+var replayExcluded = []string{"date", "set-cookie", RequestIDHeader}
+
+// replay sends rec through w, without the fields that belong to the request
+// it was recorded for: cookies, and what w adds for its own request.
+func replay(w *response.Writer, rec response.Recording) {
+	rec.Headers = rec.Headers.Clone()
+	for _, name := range replayExcluded {
+		rec.Headers.Delete(name)
+	}
+	w.DefaultHeaders().Foreach(func(n, v string) {
+		// Vary describes the response, and the waiter's tokens are
+		// merged into it.
+		if !strings.EqualFold(n, "vary") {
+			rec.Headers.Delete(n)
+		}
+	})
+	rec.Replay(w)
+}
+
+func (g *flightGroup) middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		method := req.RequestLine.Method
+		credentialed := slices.ContainsFunc(credentialFields, func(name string) bool {
+			_, ok := req.Headers().Get(name)
+			return ok
+		})
+		if (method != "GET" && method != "HEAD") || credentialed {
+			next(w, req)
+			return
+		}
+		key := g.key(req)
+		f, leader := g.join(key, req)
+		if !leader {
+			select {
+			case <-f.done:
+			case <-req.Context().Done():
+				g.leave(f)
+				return
+			}
+			// The leader never produced a complete response, or one
+			// that does not fit this request, so it has to be served
+			// on its own.
+			if f.recording == nil || !f.suits(req) {
+				next(w, req)
+				return
+			}
+			replay(w, *f.recording)
+			return
+		}
+		rec := w.Record()
+		landed := false
+		defer func() {
+			// The handler panicked.
+			if !landed {
+				g.land(key, f, nil)
+			}
+		}()
+		next(w, req)
+		// Held bodies only go out on Finish, and only then is it known
+		// whether the response was whole.
+		w.Finish(nil)
+		if !w.Complete() {
+			rec = nil
+		}
+		g.land(key, f, rec)
+		landed = true
+	}
+}
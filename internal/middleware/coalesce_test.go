@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesce(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		n := calls.Add(1)
+		<-release
+		body := []byte(fmt.Sprintf("upstream fetch #%d", n))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	g := &flightGroup{flights: map[string]*flight{}, key: defaultCoalesceKey}
+	h := g.middleware(handler)
+	get := "GET /httpbin/get HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+
+	// Test: Concurrent misses share one handler call
+	outs := make([]string, 10)
+	wg := sync.WaitGroup{}
+	for i := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outs[i] = run(t, h, get)
+		}()
+	}
+	for g.waiting("GET localhost:42069/httpbin/get") < len(outs)-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	for _, out := range outs {
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+		assert.True(t, strings.HasSuffix(out, "\r\n\r\nupstream fetch #1"))
+	}
+
+	// Test: Later requests start a new flight
+	release = make(chan struct{})
+	close(release)
+	out := run(t, h, get)
+	assert.True(t, strings.HasSuffix(out, "upstream fetch #"+fmt.Sprint(calls.Load())))
+	assert.Greater(t, calls.Load(), int32(1))
+
+	// Test: Unsafe methods and credentialed requests bypass coalescing
+	before := calls.Load()
+	run(t, h, "POST /httpbin/post HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	run(t, h, "GET /httpbin/get HTTP/1.1\r\nHost: localhost:42069\r\nAuthorization: Bearer x\r\n\r\n")
+	run(t, h, "GET /httpbin/get HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=x\r\n\r\n")
+	run(t, h, "GET /httpbin/get HTTP/1.1\r\nHost: localhost:42069\r\nProxy-Authorization: Basic eA==\r\n\r\n")
+	assert.Equal(t, before+4, calls.Load())
+
+	// Test: The Accept fields are part of the default key
+	req := func(raw string) *request.Request {
+		r, err := request.RequestFromReader(strings.NewReader(raw))
+		assert.NoError(t, err)
+		return r
+	}
+	assert.NotEqual(t, defaultCoalesceKey(req(get)), defaultCoalesceKey(req("GET /httpbin/get HTTP/1.1\r\nHost: localhost:42069\r\nAccept-Encoding: gzip\r\n\r\n")))
+}
+
+func TestCoalesceReplay(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("Set-Cookie", "session=leader")
+		w.WriteText(response.StatusOK, "hello")
+	}
+	// One key for everything, so only Vary keeps responses apart.
+	g := &flightGroup{flights: map[string]*flight{}, key: func(req *request.Request) string { return "all" }}
+	h := g.middleware(handler)
+	// Stands in for middleware setting per-request fields, like RequestID.
+	withID := func(w *response.Writer, req *request.Request) {
+		id, _ := req.Headers().Get("X-Client")
+		w.DefaultHeaders().Set("X-Request-Id", id)
+		h(w, req)
+	}
+	send := func(client, lang string) string {
+		return run(t, withID, "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Client: "+client+"\r\nAccept-Language: "+lang+"\r\n\r\n")
+	}
+
+	outs := make([]string, 3)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		outs[0] = send("leader", "en")
+	}()
+	for calls.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for i, lang := range []string{"en", "de"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outs[i+1] = send(fmt.Sprint("waiter", i), lang)
+		}()
+	}
+	for g.waiting("all") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	// Test: Replays carry the waiter's own per-request fields and no cookie
+	assert.Contains(t, outs[0], "Set-Cookie: session=leader\r\n")
+	assert.Contains(t, outs[1], "X-Request-Id: waiter0\r\n")
+	assert.NotContains(t, outs[1], "leader")
+	assert.True(t, strings.HasSuffix(outs[1], "\r\n\r\nhello"))
+
+	// Test: Waiters differing in a field the response varies on are
+	// served on their own
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Contains(t, outs[2], "Set-Cookie: session=leader\r\n")
+	assert.Contains(t, outs[2], "X-Request-Id: waiter1\r\n")
+}
+
+func TestCoalesceIncomplete(t *testing.T) {
+	var calls atomic.Int32
+	var release chan struct{}
+	abort := false
+	handler := func(w *response.Writer, req *request.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			<-release
+		}
+		if abort && n == 1 {
+			w.WriteHeaders(*response.GetDefaultHeaders(20))
+			w.WriteBody([]byte("part"))
+			w.Abort()
+			return
+		}
+		// No framing: small bodies are held for a Content-Length.
+		w.WriteBody([]byte(fmt.Sprintf("fetch #%d", n)))
+	}
+	g := &flightGroup{flights: map[string]*flight{}, key: defaultCoalesceKey}
+	h := g.middleware(handler)
+	get := "GET /a HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+	flight := func() []string {
+		calls.Store(0)
+		release = make(chan struct{})
+		outs := make([]string, 3)
+		wg := sync.WaitGroup{}
+		for i := range outs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				outs[i] = run(t, h, get)
+			}()
+		}
+		for g.waiting("GET localhost:42069/a") < len(outs)-1 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+		wg.Wait()
+		return outs
+	}
+
+	// Test: Held responses are replayed once sent
+	for _, out := range flight() {
+		assert.True(t, strings.HasSuffix(out, "Content-Length: 8\r\n\r\nfetch #1"))
+	}
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Test: Waiters on a response cut short are served on their own
+	abort = true
+	flight()
+	assert.Equal(t, int32(3), calls.Load())
+	abort = false
+
+	// Test: Waiters whose client goes away stop waiting
+	calls.Store(0)
+	release = make(chan struct{})
+	go run(t, h, get)
+	for calls.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	req, err := request.RequestFromReader(strings.NewReader(get))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h(response.NewWriter(&strings.Builder{}), req.WithContext(ctx))
+		close(done)
+	}()
+	for g.waiting("GET localhost:42069/a") < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	assert.Equal(t, 0, g.waiting("GET localhost:42069/a"))
+	close(release)
+}
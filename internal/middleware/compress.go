@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"http/internal/transfer"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// incompressible lists media types whose bodies are compressed already.
+var incompressible = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/zstd":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-bzip2":          true,
+	"application/x-rar-compressed": true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+// Compress sends response bodies with the content coding the client
+// prefers by Accept-Encoding, gzip or deflate, compressing them as they are
+// written. Bodies that are already encoded, partial, of a compressed media
+// type (images, audio, video, archives) or known to be shorter than minSize
+// bytes are sent as they are.
+func Compress(minSize int64) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.Method != "HEAD" {
+				coding := req.NegotiateEncoding("gzip", "deflate", "identity")
+				if coding == "gzip" || coding == "deflate" {
+					w.AddFilter(compressFilter{coding: coding, minSize: minSize})
+				}
+			}
+			next(w, req)
+		}
+	}
+}
+
+// compressFilter applies a content coding to the response body.
+type compressFilter struct {
+	coding  string
+	minSize int64
+}
+
+func (f compressFilter) Applies(status response.StatusCode, h headers.Headers) bool {
+	switch {
+	case status < response.StatusOK, status == response.StatusPartialContent:
+		return false
+	case status == response.StatusNoContent, status == response.StatusNotModified:
+		// Nothing to compress, and no coding to announce.
+		return false
+	}
+	if _, ok := h.Get("content-encoding"); ok {
+		return false
+	}
+	if v, ok := h.Get("content-length"); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n < f.minSize {
+			return false
+		}
+	}
+	return compressible(h)
+}
+
+func compressible(h headers.Headers) bool {
+	ct, _ := h.Get("content-type")
+	ct, _, _ = strings.Cut(ct, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	major, _, _ := strings.Cut(ct, "/")
+	switch {
+	case ct == "image/svg+xml":
+		return true
+	case major == "image" || major == "audio" || major == "video":
+		return false
+	}
+	return !incompressible[ct]
+}
+
+func (f compressFilter) Headers(h *headers.Headers) {
+	h.Set("content-encoding", f.coding)
+	// Ranges would refer to the compressed bytes, and a strong validator
+	// to the uncompressed ones.
+	h.Delete("accept-ranges")
+	if etag, ok := h.Get("etag"); ok && strings.HasPrefix(etag, `"`) {
+		h.Set("etag", "W/"+etag)
+	}
+	if !h.HasToken("vary", "accept-encoding") && !h.HasToken("vary", "*") {
+		h.Add("vary", "Accept-Encoding")
+	}
+}
+
+func (f compressFilter) Wrap(dst io.Writer) io.WriteCloser {
+	// gzip and deflate are the same as content and transfer codings, and
+	// creating their writers never fails.
+	enc, err := transfer.Encode(dst, []string{f.coding})
+	if err != nil {
+		panic(err)
+	}
+	return enc
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("compress me please ", 100)
+	contentType := "text/plain"
+	handler := func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteBodyReader(strings.NewReader(text), int64(len(text)))
+	}
+	h := Compress(256)(handler)
+	serve := func(acceptEncoding string) *http.Response {
+		raw := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n"
+		if acceptEncoding != "" {
+			raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+		}
+		req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+		out := &strings.Builder{}
+		w := response.NewWriter(out)
+		h(w, req)
+		require.NoError(t, w.Finish(nil))
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out.String())), nil)
+		require.NoError(t, err)
+		return res
+	}
+
+	// Test: gzip is used when the client prefers it
+	res := serve("deflate;q=0.5, gzip")
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	assert.Equal(t, `W/"v1"`, res.Header.Get("ETag"))
+	assert.Equal(t, int64(-1), res.ContentLength)
+	zr, err := gzip.NewReader(res.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(body))
+
+	// Test: deflate is the zlib format
+	res = serve("deflate")
+	assert.Equal(t, "deflate", res.Header.Get("Content-Encoding"))
+	fr, err := zlib.NewReader(res.Body)
+	require.NoError(t, err)
+	body, err = io.ReadAll(fr)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(body))
+
+	// Test: Clients without Accept-Encoding get the body as it is
+	res = serve("")
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	assert.Equal(t, int64(len(text)), res.ContentLength)
+	assert.Equal(t, `"v1"`, res.Header.Get("ETag"))
+
+	// Test: Compressed media types are left alone
+	contentType = "image/png"
+	res = serve("gzip")
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	assert.Equal(t, int64(len(text)), res.ContentLength)
+	contentType = "image/svg+xml"
+	res = serve("gzip")
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+
+	// Test: Small bodies are left alone
+	contentType = "text/plain"
+	text = "tiny"
+	res = serve("gzip")
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "tiny", string(body))
+
+	// Test: Responses without a body are not compressed
+	f := compressFilter{coding: "gzip", minSize: 256}
+	for _, status := range []response.StatusCode{response.StatusNoContent, response.StatusNotModified} {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+		assert.False(t, f.Applies(status, *h), status)
+	}
+	assert.True(t, f.Applies(response.StatusOK, *headers.NewHeaders()))
+}
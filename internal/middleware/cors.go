@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/router"
+	"http/internal/server"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig says which cross-origin requests browsers may make.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://example.com". An entry
+	// "https://*.example.com" allows every subdomain, and "*" any origin.
+	AllowedOrigins []string
+	// AllowedMethods defaults to what Router serves for the path, or, for
+	// CORS wrapping a route's handler, what router.Allowed reports; GET,
+	// HEAD and POST otherwise.
+	AllowedMethods []string
+	// Router is the router CORS runs in front of as server middleware.
+	// Preflights have to reach CORS before the router, whose own OPTIONS
+	// answers carry no CORS fields.
+	Router *router.Router
+	// AllowedHeaders lists the request fields a preflight may ask for;
+	// "*" allows any.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response fields scripts may read beyond
+	// the safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and authorization. The
+	// origin is then always echoed rather than answered with "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight's answer; zero
+	// leaves it to them.
+	MaxAge time.Duration
+}
+
+// defaultCORSMethods are the methods preflights are allowed outside a
+// router.
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+// CORS answers preflight requests and adds the CORS fields to responses to
+// allowed origins. Preflights that ask for more than the config allows are
+// answered without them, which makes the browser refuse the request.
+func CORS(config CORSConfig) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin, ok := req.Headers().Get("Origin")
+			if !ok {
+				next(w, req)
+				return
+			}
+			method, preflight := req.Headers().Get("Access-Control-Request-Method")
+			if preflight && req.RequestLine.Method == "OPTIONS" {
+				config.preflight(w, req, origin, method)
+				return
+			}
+			h := w.DefaultHeaders()
+			// The answer depends on the origin unless it is always "*".
+			if !config.anyOrigin() || config.AllowCredentials {
+				h.Add("Vary", "Origin")
+			}
+			if config.allowsOrigin(origin) {
+				config.allowOrigin(h, origin)
+				if len(config.ExposedHeaders) > 0 {
+					h.Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+				}
+			}
+			next(w, req)
+		}
+	}
+}
+
+func (c CORSConfig) preflight(w *response.Writer, req *request.Request, origin, method string) {
+	h := headers.NewHeaders()
+	h.Set("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	requested := req.Headers().List("Access-Control-Request-Headers")
+	methods := c.methods(req)
+	if c.allowsOrigin(origin) && slices.Contains(methods, method) && c.allowsHeaders(requested) {
+		c.allowOrigin(h, origin)
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requested) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+	}
+	w.WriteStatusLine(response.StatusNoContent)
+	w.WriteHeaders(*h)
+}
+
+func (c CORSConfig) allowOrigin(h *headers.Headers, origin string) {
+	if c.anyOrigin() && !c.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c CORSConfig) anyOrigin() bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// "https://*.example.com" matches "https://api.example.com".
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok {
+			rest, found := strings.CutPrefix(origin, prefix)
+			if found && strings.HasSuffix(rest, suffix) && len(rest) > len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c CORSConfig) methods(req *request.Request) []string {
+	if len(c.AllowedMethods) > 0 {
+		return c.AllowedMethods
+	}
+	if c.Router != nil {
+		return c.Router.Allow(req.Path())
+	}
+	if allowed := router.Allowed(req); allowed != nil {
+		return allowed
+	}
+	return defaultCORSMethods
+}
+
+func (c CORSConfig) allowsHeaders(requested []string) bool {
+	for _, name := range requested {
+		found := false
+		for _, allowed := range c.AllowedHeaders {
+			if allowed == "*" || strings.EqualFold(allowed, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
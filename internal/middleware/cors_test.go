@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"bufio"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/router"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORS(t *testing.T) {
+	called := false
+	handler := func(w *response.Writer, req *request.Request) {
+		called = true
+		w.WriteText(response.StatusOK, "ok")
+	}
+	serve := func(config CORSConfig, raw string) *http.Response {
+		called = false
+		req, err := request.RequestFromReader(strings.NewReader(raw + "Host: api.example.com\r\n\r\n"))
+		require.NoError(t, err)
+		out := &strings.Builder{}
+		w := response.NewWriter(out)
+		CORS(config)(handler)(w, req)
+		require.NoError(t, w.Finish(nil))
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out.String())), nil)
+		require.NoError(t, err)
+		return res
+	}
+	config := CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Content-Type", "X-Token"},
+		ExposedHeaders: []string{"X-Request-Id"},
+		MaxAge:         10 * time.Minute,
+	}
+
+	// Test: Requests without Origin pass through untouched
+	res := serve(config, "GET / HTTP/1.1\r\n")
+	assert.True(t, called)
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+
+	// Test: Allowed origins get the CORS fields on actual responses
+	res = serve(config, "GET / HTTP/1.1\r\nOrigin: https://app.example.com\r\n")
+	assert.True(t, called)
+	assert.Equal(t, "https://app.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", res.Header.Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "Origin", res.Header.Get("Vary"))
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Credentials"))
+
+	// Test: Wildcard entries match subdomains only
+	res = serve(config, "GET / HTTP/1.1\r\nOrigin: https://a.b.example.org\r\n")
+	assert.Equal(t, "https://a.b.example.org", res.Header.Get("Access-Control-Allow-Origin"))
+	res = serve(config, "GET / HTTP/1.1\r\nOrigin: https://example.org\r\n")
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+	res = serve(config, "GET / HTTP/1.1\r\nOrigin: http://a.example.org\r\n")
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+
+	// Test: Other origins are still served, without the fields
+	res = serve(config, "GET / HTTP/1.1\r\nOrigin: https://evil.example\r\n")
+	assert.True(t, called)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", res.Header.Get("Vary"))
+
+	// Test: Preflights are answered without calling the handler
+	res = serve(config, "OPTIONS /items HTTP/1.1\r\nOrigin: https://app.example.com\r\n"+
+		"Access-Control-Request-Method: PUT\r\nAccess-Control-Request-Headers: x-token, content-type\r\n")
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, "https://app.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, PUT", res.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "x-token, content-type", res.Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", res.Header.Get("Access-Control-Max-Age"))
+	assert.Contains(t, res.Header.Get("Vary"), "Access-Control-Request-Headers")
+
+	// Test: Preflights asking for a disallowed origin, method or header
+	// get no allow fields
+	for _, raw := range []string{
+		"Origin: https://evil.example\r\nAccess-Control-Request-Method: PUT\r\n",
+		"Origin: https://app.example.com\r\nAccess-Control-Request-Method: DELETE\r\n",
+		"Origin: https://app.example.com\r\nAccess-Control-Request-Method: GET\r\nAccess-Control-Request-Headers: X-Other\r\n",
+	} {
+		res = serve(config, "OPTIONS / HTTP/1.1\r\n"+raw)
+		assert.False(t, called)
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"), raw)
+		assert.Empty(t, res.Header.Get("Access-Control-Allow-Methods"), raw)
+	}
+
+	// Test: OPTIONS without Access-Control-Request-Method is not a preflight
+	serve(config, "OPTIONS / HTTP/1.1\r\nOrigin: https://app.example.com\r\n")
+	assert.True(t, called)
+
+	// Test: "*" answers with "*", unless credentials are allowed
+	open := CORSConfig{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}}
+	res = serve(open, "GET / HTTP/1.1\r\nOrigin: https://any.example\r\n")
+	assert.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, res.Header.Get("Vary"))
+	res = serve(open, "OPTIONS / HTTP/1.1\r\nOrigin: https://any.example\r\n"+
+		"Access-Control-Request-Method: POST\r\nAccess-Control-Request-Headers: X-Anything\r\n")
+	assert.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD, POST", res.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "X-Anything", res.Header.Get("Access-Control-Allow-Headers"))
+	open.AllowCredentials = true
+	res = serve(open, "GET / HTTP/1.1\r\nOrigin: https://any.example\r\n")
+	assert.Equal(t, "https://any.example", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
+
+	// Test: Vary: Origin is kept when the handler sets Vary too
+	handler = func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.WriteText(response.StatusOK, "ok")
+	}
+	res = serve(config, "GET / HTTP/1.1\r\nOrigin: https://app.example.com\r\n")
+	assert.Equal(t, []string{"Accept-Encoding", "Origin"}, res.Header.Values("Vary"))
+	assert.Equal(t, "https://app.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+
+	// Test: Preflights are allowed the methods the router serves for the
+	// path unless the config lists them
+	rt := router.NewRouter()
+	cors := CORS(CORSConfig{AllowedOrigins: []string{"*"}})
+	rt.Handle("PUT", "/items", cors(handler))
+	rt.Handle("OPTIONS", "/items", cors(handler))
+	req, err := request.RequestFromReader(strings.NewReader("OPTIONS /items HTTP/1.1\r\nHost: api.example.com\r\n" +
+		"Origin: https://any.example\r\nAccess-Control-Request-Method: PUT\r\n\r\n"))
+	require.NoError(t, err)
+	out := &strings.Builder{}
+	rt.Serve(response.NewWriter(out), req)
+	res, err = http.ReadResponse(bufio.NewReader(strings.NewReader(out.String())), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "OPTIONS, PUT", res.Header.Get("Access-Control-Allow-Methods"))
+
+	// Test: In front of a router, preflights are allowed what it serves for
+	// the path, without OPTIONS routes of their own
+	rt = router.NewRouter()
+	rt.Handle("PUT", "/items", handler)
+	rt.Handle("DELETE", "/items/{id}", handler)
+	front := CORS(CORSConfig{AllowedOrigins: []string{"*"}, Router: rt})(rt.Serve)
+	preflight := func(target, method string) *http.Response {
+		req, err := request.RequestFromReader(strings.NewReader("OPTIONS " + target + " HTTP/1.1\r\nHost: api.example.com\r\n" +
+			"Origin: https://any.example\r\nAccess-Control-Request-Method: " + method + "\r\n\r\n"))
+		require.NoError(t, err)
+		out := &strings.Builder{}
+		front(response.NewWriter(out), req)
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out.String())), nil)
+		require.NoError(t, err)
+		return res
+	}
+	res = preflight("/items", "PUT")
+	assert.Equal(t, "OPTIONS, PUT", res.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+	res = preflight("/items/7", "DELETE")
+	assert.Equal(t, "DELETE, OPTIONS", res.Header.Get("Access-Control-Allow-Methods"))
+	res = preflight("/items", "DELETE")
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+	res = preflight("/missing", "GET")
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+}
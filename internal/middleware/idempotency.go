@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"sync"
+	"time"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// MaxIdempotentBodyBytes caps the bodies Idempotency fingerprints; keyed
+// requests with larger ones are answered 413.
+const MaxIdempotentBodyBytes = 1 << 20
+
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	recording   *response.Recording
+	expires     time.Time
+}
+
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxBody int64
+	entries map[string]*idempotencyEntry
+	now     func() time.Time
+	// nextSweep is when expired entries for keys that were not retried
+	// are next dropped.
+	nextSweep time.Time
+}
+
+func fingerprint(req *request.Request, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + "\n"))
+	h.Write(body)
+	var out [sha256.Size]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// scope names who sent req, so that one caller's key never replays to
+// another: the Principal Auth found, or else a hash of the credentials
+// sent, which is empty for anonymous callers.
+func scope(req *request.Request) string {
+	if p, ok := PrincipalFrom(req.Context()); ok {
+		return "principal " + p.Scheme + " " + p.Name
+	}
+	h := sha256.New()
+	for _, name := range credentialFields {
+		for _, v := range req.Headers().Values(name) {
+			h.Write([]byte(name + ": " + v + "\n"))
+		}
+	}
+	return "credentials " + hex.EncodeToString(h.Sum(nil))
+}
+
+func writeStatus(w *response.Writer, status response.StatusCode) {
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*response.GetDefaultHeaders(0))
+}
+
+// lookup returns a snapshot of the live entry for key. When there is none
+// the key is reserved with an empty entry, which is returned instead.
+func (c *idempotencyCache) lookup(key string, fp [sha256.Size]byte) (idempotencyEntry, *idempotencyEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if now.After(c.nextSweep) {
+		for k, e := range c.entries {
+			if e.expired(now) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = now.Add(c.ttl)
+	}
+	if e, ok := c.entries[key]; ok && !e.expired(now) {
+		return *e, nil, true
+	}
+	e := &idempotencyEntry{fingerprint: fp}
+	c.entries[key] = e
+	return idempotencyEntry{}, e, false
+}
+
+func (e *idempotencyEntry) expired(now time.Time) bool {
+	return e.recording != nil && now.After(e.expires)
+}
+
+// store caches rec for key, or releases the key when rec is nil.
+func (c *idempotencyCache) store(key string, e *idempotencyEntry, rec *response.Recording) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Server errors and incomplete responses are not cached so the
+	// client can retry them.
+	if rec == nil || rec.StatusCode >= 500 {
+		delete(c.entries, key)
+		return
+	}
+	e.recording = rec
+	e.expires = c.now().Add(c.ttl)
+}
+
+// Idempotency caches the response to POST and PATCH requests carrying an
+// Idempotency-Key for ttl and replays it when the same caller retries the
+// key with the same method, target and body. Callers are told apart by the
+// Principal Auth found or by their credentials. Only responses that went
+// out whole are cached, and replays leave out what belonged to the first
+// one alone, such as its cookies and request ID. Reusing a key for a
+// different request answers 422; retrying while the first request is still
+// running answers 409.
+// Keyed requests with bodies past MaxIdempotentBodyBytes answer 413.
+func Idempotency(ttl time.Duration) server.Middleware {
+	c := &idempotencyCache{
+		ttl:     ttl,
+		maxBody: MaxIdempotentBodyBytes,
+		entries: map[string]*idempotencyEntry{},
+		now:     time.Now,
+	}
+	return c.middleware
+}
+
+func (c *idempotencyCache) middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		key, ok := req.Headers().Get(IdempotencyKeyHeader)
+		method := req.RequestLine.Method
+		if !ok || key == "" || (method != "POST" && method != "PATCH") {
+			next(w, req)
+			return
+		}
+		body, err := req.ReadBody(c.maxBody)
+		if errors.Is(err, request.ERROR_BODY_TOO_LARGE) {
+			writeStatus(w, response.StatusContentTooLarge)
+			return
+		}
+		if err != nil {
+			writeStatus(w, response.StatusBadRequest)
+			return
+		}
+		fp := fingerprint(req, body)
+		key = scope(req) + "\n" + key
+		existing, reserved, existed := c.lookup(key, fp)
+		if existed {
+			switch {
+			case existing.fingerprint != fp:
+				writeStatus(w, response.StatusUnprocessableEntity)
+			case existing.recording == nil:
+				writeStatus(w, response.StatusConflict)
+			default:
+				replay(w, *existing.recording)
+			}
+			return
+		}
+		rec := w.Record()
+		stored := false
+		defer func() {
+			// The handler panicked.
+			if !stored {
+				c.store(key, reserved, nil)
+			}
+		}()
+		next(w, req)
+		// Held bodies only go out on Finish, and only then is it known
+		// whether the response was whole.
+		w.Finish(nil)
+		if !w.Complete() {
+			rec = nil
+		}
+		c.store(key, reserved, rec)
+		stored = true
+	}
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, h func(w *response.Writer, req *request.Request), raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	h(response.NewWriter(out), req)
+	return out.String()
+}
+
+func post(key, body string) string {
+	return fmt.Sprintf("POST /pay HTTP/1.1\r\nHost: localhost:42069\r\nIdempotency-Key: %s\r\nContent-Length: %d\r\n\r\n%s", key, len(body), body)
+}
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	status := response.StatusOK
+	handler := func(w *response.Writer, req *request.Request) {
+		calls++
+		body := []byte(fmt.Sprintf("charge #%d", calls))
+		w.WriteStatusLine(status)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	c := &idempotencyCache{ttl: time.Minute, maxBody: 16, entries: map[string]*idempotencyEntry{}, now: time.Now}
+	h := c.middleware(handler)
+
+	// Test: First request runs the handler
+	first := run(t, h, post("abc", "amount=10"))
+	assert.Equal(t, 1, calls)
+	assert.Contains(t, first, "charge #1")
+
+	// Test: Retry with the same key and body is replayed
+	retry := run(t, h, post("abc", "amount=10"))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, len(first), len(retry))
+	assert.True(t, strings.HasPrefix(retry, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(retry, "\r\n\r\ncharge #1"))
+
+	// Test: Same key with a different body is rejected
+	out := run(t, h, post("abc", "amount=99"))
+	assert.Equal(t, 1, calls)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 422 Unprocessable Entity\r\n"))
+
+	// Test: Requests without a key or with a safe method are not cached
+	run(t, h, "POST /pay HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	run(t, h, "GET /pay HTTP/1.1\r\nHost: localhost:42069\r\nIdempotency-Key: abc\r\n\r\n")
+	assert.Equal(t, 3, calls)
+
+	// Test: Bodies past the limit are rejected before the handler runs
+	out = run(t, h, post("big", "amount=1000000000"))
+	assert.Equal(t, 3, calls)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Entries expire after the TTL, including those never retried
+	run(t, h, post("xyz", "amount=10"))
+	assert.Equal(t, 4, calls)
+	c.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	out = run(t, h, post("abc", "amount=10"))
+	assert.Equal(t, 5, calls)
+	assert.Contains(t, out, "charge #5")
+	assert.NotContains(t, c.entries, "xyz")
+	c.now = time.Now
+
+	// Test: Server errors are not cached
+	status = response.StatusInternalServerError
+	run(t, h, post("def", "amount=10"))
+	status = response.StatusOK
+	out = run(t, h, post("def", "amount=10"))
+	assert.Equal(t, 7, calls)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Retry while the first request is still in flight
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := c.middleware(func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		handler(w, req)
+	})
+	done := make(chan string)
+	go func() {
+		done <- run(t, slow, post("ghi", "amount=10"))
+	}()
+	<-started
+	out = run(t, slow, post("ghi", "amount=10"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 409 Conflict\r\n"))
+	close(release)
+	assert.Contains(t, <-done, "HTTP/1.1 200 OK\r\n")
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	calls := 0
+	mode := ""
+	handler := func(w *response.Writer, req *request.Request) {
+		calls++
+		if mode == "panic" {
+			panic("boom")
+		}
+		w.Header().Set("Set-Cookie", fmt.Sprintf("session=%d", calls))
+		w.Header().Set("Date", fmt.Sprintf("day %d", calls))
+		if mode == "abort" {
+			w.WriteHeaders(*response.GetDefaultHeaders(20))
+			w.WriteBody([]byte("part"))
+			w.Abort()
+			return
+		}
+		// No framing: small bodies are held for a Content-Length.
+		w.WriteBody([]byte(fmt.Sprintf("charge #%d", calls)))
+	}
+	c := &idempotencyCache{ttl: time.Minute, maxBody: 16, entries: map[string]*idempotencyEntry{}, now: time.Now}
+	h := c.middleware(handler)
+	// Stands in for RequestID, which sets a per-request default field.
+	withID := func(w *response.Writer, req *request.Request) {
+		id, _ := req.Headers().Get("X-Client")
+		w.DefaultHeaders().Set(RequestIDHeader, id)
+		h(w, req)
+	}
+	send := func(key, client, auth string) string {
+		raw := fmt.Sprintf("POST /pay HTTP/1.1\r\nHost: localhost:42069\r\nIdempotency-Key: %s\r\nX-Client: %s\r\n", key, client)
+		if auth != "" {
+			raw += "Authorization: " + auth + "\r\n"
+		}
+		return run(t, withID, raw+"Content-Length: 9\r\n\r\namount=10")
+	}
+
+	// Test: Held responses are cached once sent, and replays leave out the
+	// first response's cookie, date and request ID
+	first := send("abc", "first", "Bearer alice")
+	assert.Contains(t, first, "Set-Cookie: session=1\r\n")
+	assert.Contains(t, first, "Content-Length: 9\r\n")
+	retry := send("abc", "second", "Bearer alice")
+	assert.Equal(t, 1, calls)
+	assert.True(t, strings.HasSuffix(retry, "\r\n\r\ncharge #1"))
+	assert.Contains(t, retry, "X-Request-Id: second\r\n")
+	assert.NotContains(t, retry, "session=1")
+	assert.NotContains(t, retry, "day 1")
+	assert.NotContains(t, retry, "first")
+
+	// Test: Another caller using the same key gets its own response
+	out := send("abc", "third", "Bearer mallory")
+	assert.Equal(t, 2, calls)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ncharge #2"))
+	out = send("abc", "fourth", "")
+	assert.Equal(t, 3, calls)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ncharge #3"))
+
+	// Test: Responses cut short are not cached
+	mode = "abort"
+	send("def", "first", "")
+	mode = ""
+	out = send("def", "second", "")
+	assert.Equal(t, 5, calls)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ncharge #5"))
+
+	// Test: Nor are those of handlers that panicked
+	mode = "panic"
+	assert.Panics(t, func() { send("ghi", "first", "") })
+	mode = ""
+	out = send("ghi", "second", "")
+	assert.Equal(t, 7, calls)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ncharge #7"))
+}
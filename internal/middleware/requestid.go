@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+)
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the IDs accepted from clients.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID gives every request an ID: the X-Request-ID the client or a
+// proxy in front sent, if usable, or a new random one. The ID goes into the
+// request context, where RequestIDFrom finds it, into the request headers,
+// so the reverse proxy passes it upstream, and into the response. Put it
+// before AccessLog for JSON entries to include it.
+func RequestID() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, _ := req.Headers().Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+				req.Headers().Set(RequestIDHeader, id)
+			}
+			w.DefaultHeaders().Set(RequestIDHeader, id)
+			next(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+		}
+	}
+}
+
+// RequestIDFrom returns the ID RequestID attached to ctx, or "".
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts short IDs of visible ASCII characters, so they
+// are safe to log and to send on.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
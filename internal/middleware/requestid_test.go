@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"http/internal/request"
+	"http/internal/response"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen, forwarded string
+	handler := func(w *response.Writer, req *request.Request) {
+		seen = RequestIDFrom(req.Context())
+		forwarded, _ = req.Headers().Get(RequestIDHeader)
+		// Handlers building their own header block still echo the ID.
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}
+	serve := func(id string, sink *bytes.Buffer) *http.Response {
+		raw := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n"
+		if id != "" {
+			raw += RequestIDHeader + ": " + id + "\r\n"
+		}
+		req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+		out := &strings.Builder{}
+		w := response.NewWriter(out)
+		RequestID()(AccessLog(sink, JSONLogFormat)(handler))(w, req)
+		require.NoError(t, w.Finish(nil))
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out.String())), nil)
+		require.NoError(t, err)
+		return res
+	}
+
+	// Test: Incoming IDs are kept, echoed and logged
+	sink := &bytes.Buffer{}
+	res := serve("abc-123", sink)
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", forwarded)
+	assert.Equal(t, "abc-123", res.Header.Get(RequestIDHeader))
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(sink.Bytes(), &entry))
+	assert.Equal(t, "abc-123", entry["request_id"])
+
+	// Test: Missing IDs are generated and passed on in the request headers
+	res = serve("", &bytes.Buffer{})
+	assert.Regexp(t, `^[0-9a-f]{32}$`, seen)
+	assert.Equal(t, seen, forwarded)
+	assert.Equal(t, seen, res.Header.Get(RequestIDHeader))
+
+	// Test: Unusable IDs are replaced
+	serve(strings.Repeat("x", 200), &bytes.Buffer{})
+	assert.Regexp(t, `^[0-9a-f]{32}$`, seen)
+	serve("has space", &bytes.Buffer{})
+	assert.Regexp(t, `^[0-9a-f]{32}$`, seen)
+}
@@ -0,0 +1,204 @@
+// Package nethttp runs net/http handlers and middleware on this server.
+package nethttp
+
+import (
+	"context"
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Handler adapts h to a server.Handler. Requests that cannot be converted
+// get a 400.
+func Handler(h http.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		r, err := NewRequest(req)
+		if err != nil {
+			log.Printf("Converting request failed: %v", err)
+			w.WriteStatusLine(response.StatusBadRequest)
+			w.WriteHeaders(*response.GetDefaultHeaders(0))
+			return
+		}
+		rw := NewResponseWriter(w)
+		h.ServeHTTP(rw, r)
+		if err := rw.finish(); err != nil {
+			log.Printf("Finishing response failed: %v", err)
+		}
+	}
+}
+
+// NewRequest converts req into an *http.Request as net/http's server would
+// build it. Body reads go to req's body and the context is req's.
+func NewRequest(req *request.Request) (*http.Request, error) {
+	line := req.RequestLine
+	target := line.RequestTarget
+	if line.Method == "CONNECT" && !strings.HasPrefix(target, "/") {
+		target = "http://" + target
+	}
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, err
+	}
+	if line.Method == "CONNECT" {
+		u.Scheme = ""
+	}
+	proto := "HTTP/" + line.HttpVersion
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		return nil, request.ERROR_UNSUPPORTED_HTTP_VERSION
+	}
+	h := http.Header{}
+	req.Headers().Foreach(func(n, v string) {
+		h.Add(n, v)
+	})
+	host, ok := req.Headers().Get("host")
+	if !ok {
+		host = u.Host
+	}
+	h.Del("Host")
+	ctx := req.Context()
+	if addr := req.LocalAddr(); addr != nil {
+		ctx = context.WithValue(ctx, http.LocalAddrContextKey, addr)
+	}
+	r := &http.Request{
+		Method:        line.Method,
+		URL:           u,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        h,
+		Host:          host,
+		RequestURI:    line.RequestTarget,
+		ContentLength: req.ContentLength(),
+		TLS:           req.TLS(),
+		Body:          http.NoBody,
+	}
+	if r.ContentLength != 0 {
+		r.Body = io.NopCloser(req.BodyReader())
+	}
+	if r.ContentLength < 0 {
+		r.TransferEncoding = []string{"chunked"}
+	}
+	if addr := req.RemoteAddr(); addr != nil {
+		r.RemoteAddr = addr.String()
+	}
+	if r.ProtoAtLeast(1, 1) {
+		r.Close = req.Headers().HasToken("connection", "close")
+	} else {
+		r.Close = !req.Headers().HasToken("connection", "keep-alive")
+	}
+	return r.WithContext(ctx), nil
+}
+
+// ResponseWriter is an http.ResponseWriter writing to a response.Writer.
+// Trailers follow net/http's rules: names announced in the Trailer header
+// make the body chunked, and their values, as well as fields prefixed with
+// http.TrailerPrefix, are sent after the body once the handler returns.
+type ResponseWriter struct {
+	w           *response.Writer
+	header      http.Header
+	wroteHeader bool
+	// chunked is set once the headers declared a chunked body, which the
+	// Writer frames.
+	chunked bool
+}
+
+var _ http.Flusher = (*ResponseWriter)(nil)
+
+// NewResponseWriter wraps w.
+func NewResponseWriter(w *response.Writer) *ResponseWriter {
+	return &ResponseWriter{w: w, header: http.Header{}}
+}
+
+func (rw *ResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+// WriteHeader sends the status line and headers. Later calls are ignored,
+// as are informational statuses, which the server sends itself.
+func (rw *ResponseWriter) WriteHeader(code int) {
+	if rw.wroteHeader || code < 200 {
+		return
+	}
+	rw.wroteHeader = true
+	if err := rw.w.WriteStatusLine(response.StatusCode(code)); err != nil {
+		log.Printf("Writing status line failed: %v", err)
+		return
+	}
+	h := headers.NewHeaders()
+	names := make([]string, 0, len(rw.header))
+	for name := range rw.header {
+		if !strings.HasPrefix(name, http.TrailerPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range rw.header[name] {
+			h.Add(name, v)
+		}
+	}
+	if _, ok := h.Get("trailer"); ok && !h.HasToken("transfer-encoding", "chunked") {
+		h.Delete("content-length")
+		h.Add("transfer-encoding", "chunked")
+	}
+	rw.chunked = h.HasToken("transfer-encoding", "chunked")
+	if err := rw.w.WriteHeaders(*h); err != nil {
+		log.Printf("Writing headers failed: %v", err)
+	}
+}
+
+// Write sends p as part of the body, writing a 200 status first if needed.
+// Like net/http it sniffs a Content-Type when none was set.
+func (rw *ResponseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		if _, ok := rw.header["Content-Type"]; !ok && len(p) > 0 {
+			rw.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.chunked {
+		return rw.w.WriteChunk(p)
+	}
+	return rw.w.WriteBody(p)
+}
+
+// Flush sends what was written so far, writing a 200 status first if
+// needed.
+func (rw *ResponseWriter) Flush() {
+	rw.WriteHeader(http.StatusOK)
+	if err := rw.w.Flush(); err != nil {
+		log.Printf("Flushing response failed: %v", err)
+	}
+}
+
+// finish writes the headers if the handler never did, then the trailers.
+func (rw *ResponseWriter) finish() error {
+	rw.WriteHeader(http.StatusOK)
+	trailers := headers.NewHeaders()
+	for _, name := range rw.header.Values("Trailer") {
+		for _, name := range headers.SplitList(name) {
+			for _, v := range rw.header.Values(name) {
+				trailers.Add(name, v)
+			}
+		}
+	}
+	for name, values := range rw.header {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			for _, v := range values {
+				trailers.Add(strings.TrimPrefix(name, http.TrailerPrefix), v)
+			}
+		}
+	}
+	if rw.chunked {
+		return rw.w.FinishChunked(trailers)
+	}
+	return rw.w.Finish(trailers)
+}
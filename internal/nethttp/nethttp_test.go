@@ -0,0 +1,113 @@
+package nethttp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, h http.Handler, raw string) (*http.Response, string) {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	w := response.NewWriter(out)
+	Handler(h)(w, req)
+	require.NoError(t, w.Finish(nil))
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestNewRequest(t *testing.T) {
+	// Test: Requests carry what net/http's server would set
+	raw := "POST /echo?x=1 HTTP/1.1\r\nHost: Example.com\r\nX-Tag: a\r\nX-Tag: b\r\nContent-Length: 5\r\n\r\nhello"
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	r, err := NewRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, "POST", r.Method)
+	assert.Equal(t, "/echo", r.URL.Path)
+	assert.Equal(t, "1", r.URL.Query().Get("x"))
+	assert.Equal(t, "/echo?x=1", r.RequestURI)
+	assert.Equal(t, "Example.com", r.Host)
+	assert.Empty(t, r.Header.Get("Host"))
+	assert.Equal(t, []string{"a", "b"}, r.Header.Values("X-Tag"))
+	assert.Equal(t, 1, r.ProtoMinor)
+	assert.Equal(t, int64(5), r.ContentLength)
+	assert.False(t, r.Close)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: CONNECT targets are authorities
+	req, err = request.RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	r, err = NewRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", r.URL.Host)
+	assert.Empty(t, r.URL.Scheme)
+	assert.Equal(t, http.NoBody, r.Body)
+}
+
+func TestHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		fmt.Fprintf(w, "<html>hello %s</html>", r.URL.Query().Get("name"))
+	})
+	mux.HandleFunc("/created", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, `{"ok":true}`)
+	})
+	mux.HandleFunc("/trailers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		io.WriteString(w, "data")
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "yes")
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {})
+
+	// Test: Bodies are framed and Content-Type is sniffed
+	res, body := serve(t, mux, "GET /hello?name=go HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "<html>hello go</html>", body)
+	assert.Equal(t, int64(len(body)), res.ContentLength)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(t, "GET", res.Header.Get("X-Method"))
+
+	// Test: The first WriteHeader wins
+	res, body = serve(t, mux, "GET /created HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, `{"ok":true}`, body)
+
+	// Test: Announced and prefixed trailers follow a chunked body
+	res, body = serve(t, mux, "GET /trailers HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "data", body)
+	assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"))
+	assert.Equal(t, "yes", res.Trailer.Get("X-Late"))
+
+	// Test: Handlers that write nothing send an empty 200
+	res, body = serve(t, mux, "GET /empty HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, int64(0), res.ContentLength)
+	assert.Empty(t, body)
+
+	// Test: Unknown paths get the mux's 404
+	res, _ = serve(t, mux, "GET /missing HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 404, res.StatusCode)
+}
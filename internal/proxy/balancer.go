@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"http/internal/request"
+	"sync/atomic"
+)
+
+// Candidate describes an upstream a Balancer may pick.
+type Candidate struct {
+	Target string
+	Active int64
+}
+
+// Balancer chooses which of the candidates serves req, returning its index.
+// candidates is never empty.
+type Balancer interface {
+	Pick(candidates []Candidate, req *request.Request) int
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(candidates []Candidate, req *request.Request) int {
+	return int((b.next.Add(1) - 1) % uint64(len(candidates)))
+}
+
+type leastConnections struct {
+	tieBreak roundRobin
+}
+
+// LeastConnections picks the upstream with the fewest in-flight requests,
+// rotating between upstreams that are tied.
+func LeastConnections() Balancer {
+	return &leastConnections{}
+}
+
+func (b *leastConnections) Pick(candidates []Candidate, req *request.Request) int {
+	least := []int{}
+	for i, c := range candidates {
+		if len(least) > 0 && c.Active > candidates[least[0]].Active {
+			continue
+		}
+		if len(least) > 0 && c.Active < candidates[least[0]].Active {
+			least = least[:0]
+		}
+		least = append(least, i)
+	}
+	return least[b.tieBreak.Pick(make([]Candidate, len(least)), req)]
+}
+
+type consistentHash struct {
+	key      func(req *request.Request) string
+	fallback roundRobin
+}
+
+// ConsistentHash sends requests with the same key to the same upstream
+// (rendezvous hashing), so only keys of a removed upstream move when the
+// pool changes. Requests without a key are balanced round-robin.
+func ConsistentHash(key func(req *request.Request) string) Balancer {
+	return &consistentHash{key: key}
+}
+
+func (b *consistentHash) Pick(candidates []Candidate, req *request.Request) int {
+	key := b.key(req)
+	if key == "" {
+		return b.fallback.Pick(candidates, req)
+	}
+	best, bestScore := 0, uint64(0)
+	for i, c := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(c.Target))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := mix(h.Sum64()); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// mix is the murmur3 finalizer; FNV alone barely separates keys that only
+// differ in their last bytes.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// HeaderKey keys ConsistentHash on a request header.
+func HeaderKey(name string) func(req *request.Request) string {
+	return func(req *request.Request) string {
+		v, _ := req.Headers().Get(name)
+		return v
+	}
+}
+
+// CookieKey keys ConsistentHash on the value of a cookie.
+func CookieKey(name string) func(req *request.Request) string {
+	return func(req *request.Request) string {
+		c, _ := req.Cookie(name)
+		return c.Value
+	}
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"http/internal/request"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, headerLines ...string) *request.Request {
+	raw := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n"
+	for _, line := range headerLines {
+		raw += line + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestBalancers(t *testing.T) {
+	req := newRequest(t)
+	candidates := []Candidate{{Target: "a", Active: 3}, {Target: "b", Active: 1}, {Target: "c", Active: 1}}
+
+	// Test: Round-robin cycles through every candidate
+	rr := RoundRobin()
+	picks := []int{}
+	for range 6 {
+		picks = append(picks, rr.Pick(candidates, req))
+	}
+	assert.Equal(t, []int{0, 1, 2, 0, 1, 2}, picks)
+
+	// Test: Least-connections rotates among the least busy
+	lc := LeastConnections()
+	assert.Equal(t, 1, lc.Pick(candidates, req))
+	assert.Equal(t, 2, lc.Pick(candidates, req))
+	assert.Equal(t, 1, lc.Pick(candidates, req))
+
+	// Test: Consistent hashing is stable per key and spreads keys
+	ch := ConsistentHash(HeaderKey("X-User"))
+	seen := map[int]bool{}
+	for i := range 50 {
+		r := newRequest(t, fmt.Sprintf("X-User: user-%d", i))
+		first := ch.Pick(candidates, r)
+		assert.Equal(t, first, ch.Pick(candidates, r))
+		seen[first] = true
+	}
+	assert.Len(t, seen, 3)
+
+	// Test: Removing an upstream only moves the keys it owned
+	for i := range 50 {
+		r := newRequest(t, fmt.Sprintf("X-User: user-%d", i))
+		before := candidates[ch.Pick(candidates, r)].Target
+		after := candidates[:2][ch.Pick(candidates[:2], r)].Target
+		if before != "c" {
+			assert.Equal(t, before, after)
+		}
+	}
+
+	// Test: Cookie keys
+	ck := ConsistentHash(CookieKey("session"))
+	r := newRequest(t, "Cookie: theme=dark; session=abc123")
+	assert.Equal(t, ck.Pick(candidates, r), ck.Pick(candidates, newRequest(t, "Cookie: session=abc123")))
+}
+
+func TestProxyBalancesUpstreams(t *testing.T) {
+	targets := []string{}
+	for i := range 3 {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "upstream %d", i)
+		}))
+		defer upstream.Close()
+		targets = append(targets, upstream.URL)
+	}
+	p, err := New(Config{Upstreams: targets})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: Round-robin is the default strategy
+	for i := range 6 {
+		res := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+		assert.Equal(t, fmt.Sprintf("upstream %d", i%3), readBody(t, res))
+	}
+	assert.Len(t, p.Stats(), 3)
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type circuitState string
+
+const (
+	CircuitClosed   circuitState = "closed"
+	CircuitOpen     circuitState = "open"
+	CircuitHalfOpen circuitState = "half-open"
+)
+
+// HealthCheckConfig controls when an upstream is ejected from rotation and
+// when it is let back in. Failures are counted from both active probes and
+// real traffic (connect errors and 502/503/504 answers).
+type HealthCheckConfig struct {
+	// Path is probed with GET every Interval; empty disables active checks.
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// FailureThreshold consecutive failures open the circuit. Zero
+	// disables ejection.
+	FailureThreshold int
+	// SuccessThreshold consecutive successful probes close it again.
+	SuccessThreshold int
+	// Cooldown lets a single trial request through an open circuit
+	// (half-open) after this long, which is how upstreams recover without
+	// active checks. Zero means the default's.
+	Cooldown time.Duration
+}
+
+var DefaultHealthCheckConfig = HealthCheckConfig{
+	Interval:         10 * time.Second,
+	Timeout:          2 * time.Second,
+	FailureThreshold: 5,
+	SuccessThreshold: 2,
+	Cooldown:         30 * time.Second,
+}
+
+type health struct {
+	mu        sync.Mutex
+	config    HealthCheckConfig
+	state     circuitState
+	failures  int
+	successes int
+	openedAt  time.Time
+	// trial is set while the one request a half-open circuit lets
+	// through is in flight.
+	trial bool
+	now   func() time.Time
+}
+
+func newHealth(config HealthCheckConfig) *health {
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultHealthCheckConfig.Cooldown
+	}
+	return &health{config: config, state: CircuitClosed, now: time.Now}
+}
+
+// available reports whether traffic may be sent, moving an open circuit to
+// half-open once its cooldown has passed. A half-open circuit is only
+// available while its trial request is not taken.
+func (h *health) available() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.check()
+}
+
+// admit is available for a request about to be sent, which becomes the
+// trial of a half-open circuit. Every admitted request ends with record or
+// forget.
+func (h *health) admit() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.check() {
+		return false
+	}
+	if h.state == CircuitHalfOpen {
+		h.trial = true
+	}
+	return true
+}
+
+func (h *health) check() bool {
+	if h.state == CircuitOpen && h.now().Sub(h.openedAt) >= h.config.Cooldown {
+		h.state, h.trial = CircuitHalfOpen, false
+	}
+	switch h.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return !h.trial
+	}
+	return true
+}
+
+// forget ends an admitted request that says nothing about the upstream,
+// such as one whose client went away.
+func (h *health) forget() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trial = false
+}
+
+func (h *health) record(ok bool, probe bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	trial := !probe && h.state == CircuitHalfOpen
+	if !probe {
+		h.trial = false
+	}
+	if ok {
+		h.failures = 0
+		h.successes++
+		switch {
+		case trial:
+			h.state = CircuitClosed
+		case h.state != CircuitClosed && probe && h.successes >= max(h.config.SuccessThreshold, 1):
+			h.state = CircuitClosed
+		}
+		return
+	}
+	h.successes = 0
+	h.failures++
+	if h.state == CircuitHalfOpen || (h.config.FailureThreshold > 0 && h.failures >= h.config.FailureThreshold) {
+		if h.state != CircuitOpen {
+			h.openedAt = h.now()
+		}
+		h.state = CircuitOpen
+	}
+}
+
+func (h *health) snapshot() (circuitState, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state, h.failures
+}
+
+func isUpstreamFailure(status int) bool {
+	return status == 502 || status == 503 || status == 504
+}
+
+// probe runs active health checks against u until ctx is done.
+func (u *upstream) probe(ctx context.Context, config HealthCheckConfig) {
+	client := &http.Client{Timeout: config.Timeout}
+	url := u.target.Scheme + "://" + u.target.Host + config.Path
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return
+		}
+		res, err := client.Do(req)
+		ok := err == nil && res.StatusCode >= 200 && res.StatusCode < 400
+		if err == nil {
+			res.Body.Close()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		u.health.record(ok, true)
+	}
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	h := newHealth(HealthCheckConfig{FailureThreshold: 2, SuccessThreshold: 2, Cooldown: time.Minute})
+	h.now = func() time.Time { return now }
+
+	// Test: Consecutive failures open the circuit
+	h.record(false, false)
+	assert.True(t, h.available())
+	h.record(false, false)
+	assert.False(t, h.available())
+
+	// Test: Probes close it after SuccessThreshold successes
+	h.record(true, true)
+	assert.False(t, h.available())
+	h.record(true, true)
+	assert.True(t, h.available())
+
+	// Test: Cooldown moves to half-open, a failed trial reopens
+	h.record(false, false)
+	h.record(false, false)
+	assert.False(t, h.available())
+	now = now.Add(time.Minute)
+	assert.True(t, h.available())
+	state, _ := h.snapshot()
+	assert.Equal(t, CircuitHalfOpen, state)
+	h.record(false, false)
+	assert.False(t, h.available())
+
+	// Test: A successful trial closes it
+	now = now.Add(time.Minute)
+	assert.True(t, h.available())
+	h.record(true, false)
+	state, failures := h.snapshot()
+	assert.Equal(t, CircuitClosed, state)
+	assert.Equal(t, 0, failures)
+
+	// Test: Half-open lets a single trial through at a time
+	h.record(false, false)
+	h.record(false, false)
+	now = now.Add(time.Minute)
+	assert.True(t, h.admit())
+	assert.False(t, h.available())
+	assert.False(t, h.admit())
+	h.forget()
+	assert.True(t, h.admit())
+
+	// Test: Probes need SuccessThreshold successes while half-open too
+	h.record(true, true)
+	state, _ = h.snapshot()
+	assert.Equal(t, CircuitHalfOpen, state)
+	h.record(true, true)
+	state, _ = h.snapshot()
+	assert.Equal(t, CircuitClosed, state)
+	h.record(false, false)
+	h.record(false, false)
+	now = now.Add(time.Minute)
+	assert.True(t, h.admit())
+
+	// Test: Without a Cooldown the default one applies
+	h = newHealth(HealthCheckConfig{FailureThreshold: 1})
+	h.now = func() time.Time { return now }
+	h.record(false, false)
+	assert.False(t, h.available())
+	now = now.Add(DefaultHealthCheckConfig.Cooldown)
+	assert.True(t, h.available())
+}
+
+func TestProxyEjectsUnhealthyUpstreams(t *testing.T) {
+	var broken atomic.Bool
+	broken.Store(true)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken.Load() {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("flaky"))
+	}))
+	defer flaky.Close()
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("stable"))
+	}))
+	defer stable.Close()
+	p, err := New(Config{
+		Upstreams: []string{flaky.URL, stable.URL},
+		HealthCheck: HealthCheckConfig{
+			Path:             "/healthz",
+			Interval:         10 * time.Millisecond,
+			Timeout:          time.Second,
+			FailureThreshold: 2,
+			SuccessThreshold: 1,
+		},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+	get := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+
+	// Test: Failing upstream is ejected and traffic goes to the healthy one
+	for p.Stats()[flaky.URL].State != CircuitOpen {
+		proxyRequest(t, p, get)
+	}
+	for range 4 {
+		assert.Equal(t, "stable", readBody(t, proxyRequest(t, p, get)))
+	}
+
+	// Test: Active probes re-admit it once it recovers
+	broken.Store(false)
+	for p.Stats()[flaky.URL].State != CircuitClosed {
+		time.Sleep(5 * time.Millisecond)
+	}
+	bodies := map[string]bool{}
+	for range 4 {
+		bodies[readBody(t, proxyRequest(t, p, get))] = true
+	}
+	assert.Equal(t, map[string]bool{"flaky": true, "stable": true}, bodies)
+
+	// Test: Stats are served as JSON
+	res := proxyRequestWith(t, p.StatsHandler, get)
+	stats := map[string]UpstreamStats{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+	assert.Equal(t, CircuitClosed, stats[stable.URL].State)
+}
+
+func TestProxyAllUpstreamsDown(t *testing.T) {
+	p, err := New(Config{
+		Upstreams:   []string{"http://127.0.0.1:1"},
+		HealthCheck: HealthCheckConfig{FailureThreshold: 1},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+	get := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+
+	// Test: 502 while trying, then 503 once ejected
+	assert.Equal(t, 502, proxyRequest(t, p, get).StatusCode)
+	assert.Equal(t, 503, proxyRequest(t, p, get).StatusCode)
+}
+
+func TestProxyClientBodyErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+	p, err := New(Config{
+		Upstreams:   []string{upstream.URL},
+		HealthCheck: HealthCheckConfig{FailureThreshold: 1},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: An upload the client stops sending is not held against the
+	// upstream
+	for range 3 {
+		res := proxyRequest(t, p, "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 10\r\n\r\nhe")
+		assert.Equal(t, 400, res.StatusCode)
+	}
+	stats := p.Stats()[upstream.URL]
+	assert.Equal(t, CircuitClosed, stats.State)
+	assert.Equal(t, 0, stats.Failures)
+}
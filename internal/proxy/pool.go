@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ERROR_POOL_TIMEOUT = fmt.Errorf("timed out waiting for an upstream connection")
+
+// PoolConfig bounds the connections kept to a single upstream.
+type PoolConfig struct {
+	// MaxConns caps concurrent connections, and so in-flight requests, to
+	// the upstream. Zero means no limit.
+	MaxConns int
+	// MaxIdleConns caps connections kept open between requests. Zero keeps
+	// no idle connections.
+	MaxIdleConns int
+	// QueueTimeout is how long a request waits for a free connection once
+	// MaxConns is reached. Zero fails immediately.
+	QueueTimeout time.Duration
+	// IdleConnTimeout closes idle connections after this long.
+	IdleConnTimeout time.Duration
+	// DialTimeout bounds establishing a new connection.
+	DialTimeout time.Duration
+}
+
+var DefaultPoolConfig = PoolConfig{
+	MaxConns:        64,
+	MaxIdleConns:    8,
+	QueueTimeout:    5 * time.Second,
+	IdleConnTimeout: 90 * time.Second,
+	DialTimeout:     10 * time.Second,
+}
+
+// PoolStats is a snapshot of an upstream pool's utilization.
+type PoolStats struct {
+	Open          int64
+	Active        int64
+	Idle          int64
+	Waiting       int64
+	Dials         int64
+	QueueTimeouts int64
+}
+
+type pool struct {
+	config        PoolConfig
+	slots         chan struct{}
+	transport     *http.Transport
+	open          atomic.Int64
+	active        atomic.Int64
+	waiting       atomic.Int64
+	dials         atomic.Int64
+	queueTimeouts atomic.Int64
+}
+
+// countedConn keeps the pool's count of open connections accurate.
+type countedConn struct {
+	net.Conn
+	pool *pool
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.pool.open.Add(-1)
+	})
+	return c.Conn.Close()
+}
+
+func newPool(config PoolConfig) *pool {
+	p := &pool{config: config}
+	if config.MaxConns > 0 {
+		p.slots = make(chan struct{}, config.MaxConns)
+	}
+	dialer := &net.Dialer{Timeout: config.DialTimeout}
+	p.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			p.dials.Add(1)
+			p.open.Add(1)
+			return &countedConn{Conn: conn, pool: p}, nil
+		},
+		MaxConnsPerHost:     config.MaxConns,
+		MaxIdleConnsPerHost: config.MaxIdleConns,
+		DisableKeepAlives:   config.MaxIdleConns == 0,
+		IdleConnTimeout:     config.IdleConnTimeout,
+	}
+	return p
+}
+
+// acquire takes a connection slot, queueing for up to QueueTimeout when the
+// pool is full.
+func (p *pool) acquire() error {
+	if p.slots == nil {
+		p.active.Add(1)
+		return nil
+	}
+	select {
+	case p.slots <- struct{}{}:
+		p.active.Add(1)
+		return nil
+	default:
+	}
+	p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+	timer := time.NewTimer(p.config.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		p.active.Add(1)
+		return nil
+	case <-timer.C:
+		p.queueTimeouts.Add(1)
+		return ERROR_POOL_TIMEOUT
+	}
+}
+
+func (p *pool) release() {
+	p.active.Add(-1)
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+func (p *pool) stats() PoolStats {
+	s := PoolStats{
+		Open:          p.open.Load(),
+		Active:        p.active.Load(),
+		Waiting:       p.waiting.Load(),
+		Dials:         p.dials.Load(),
+		QueueTimeouts: p.queueTimeouts.Load(),
+	}
+	s.Idle = max(s.Open-s.Active, 0)
+	return s
+}
+
+func (p *pool) close() {
+	p.transport.CloseIdleConnections()
+}
@@ -0,0 +1,581 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"http/internal/digest"
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hop-by-hop fields only describe a single connection and are never
+// forwarded.
+var hopByHop = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func isHopByHop(name string, connection []string) bool {
+	for _, h := range hopByHop {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	for _, h := range connection {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return true
+		}
+	}
+	return false
+}
+
+type upstream struct {
+	target  *url.URL
+	pool    *pool
+	health  *health
+	headers HeaderRules
+}
+
+// Config describes the upstreams a ReverseProxy forwards to.
+type Config struct {
+	// Upstreams are base URLs such as "http://10.0.0.1:8080".
+	Upstreams []string
+	// Pool bounds connections per upstream; the zero value means
+	// DefaultPoolConfig.
+	Pool PoolConfig
+	// Balancer spreads requests over Upstreams; defaults to RoundRobin.
+	Balancer Balancer
+	// HealthCheck ejects failing upstreams; the zero value means
+	// DefaultHealthCheckConfig (passive checks only).
+	HealthCheck HealthCheckConfig
+	// Retry controls retrying idempotent requests on another upstream; the
+	// zero value sends every request once.
+	Retry RetryPolicy
+	// Headers rewrites headers for every upstream; UpstreamHeaders, keyed
+	// by upstream URL, is applied after it for that upstream only.
+	Headers         HeaderRules
+	UpstreamHeaders map[string]HeaderRules
+	// Path rewrites the request target before it is forwarded.
+	Path PathRewrite
+	// Filters transform response bodies as they are relayed; filtered
+	// responses are always sent chunked.
+	Filters []response.BodyFilter
+	// ContentDigest adds a Content-Digest trailer (RFC 9530) computed as
+	// the body is relayed, which makes every response chunked. It cannot be
+	// combined with Filters.
+	ContentDigest bool
+	// Trace echoes TRACE requests whose Max-Forwards ran out at the proxy,
+	// as server.WithTrace does; otherwise they are answered 501.
+	Trace bool
+}
+
+// ReverseProxy forwards requests to a pool of upstream servers, each with a
+// bounded connection pool.
+type ReverseProxy struct {
+	upstreams []*upstream
+	balancer  Balancer
+	retry     RetryPolicy
+	headers   HeaderRules
+	path      PathRewrite
+	filters   []response.BodyFilter
+	digest    bool
+	trace     bool
+	stop      context.CancelFunc
+}
+
+// UpstreamStats is the pool utilization and circuit state of an upstream.
+type UpstreamStats struct {
+	PoolStats
+	State    circuitState
+	Failures int
+}
+
+func New(config Config) (*ReverseProxy, error) {
+	if len(config.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstreams configured")
+	}
+	if config.Pool == (PoolConfig{}) {
+		config.Pool = DefaultPoolConfig
+	}
+	if config.Balancer == nil {
+		config.Balancer = RoundRobin()
+	}
+	if config.HealthCheck == (HealthCheckConfig{}) {
+		config.HealthCheck = DefaultHealthCheckConfig
+	}
+	if config.ContentDigest && len(config.Filters) > 0 {
+		return nil, fmt.Errorf("ContentDigest cannot be combined with Filters")
+	}
+	if err := config.Headers.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Path.compile(); err != nil {
+		return nil, err
+	}
+	p := &ReverseProxy{
+		balancer: config.Balancer,
+		retry:    config.Retry,
+		headers:  config.Headers,
+		path:     config.Path,
+		filters:  config.Filters,
+		trace:    config.Trace,
+		digest:   config.ContentDigest,
+	}
+	for _, target := range config.Upstreams {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("invalid upstream %q", target)
+		}
+		rules := config.UpstreamHeaders[target]
+		if err := rules.validate(); err != nil {
+			return nil, err
+		}
+		p.upstreams = append(p.upstreams, &upstream{
+			target:  u,
+			pool:    newPool(config.Pool),
+			health:  newHealth(config.HealthCheck),
+			headers: rules,
+		})
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	p.stop = stop
+	if config.HealthCheck.Path != "" && config.HealthCheck.Interval > 0 {
+		for _, u := range p.upstreams {
+			go u.probe(ctx, config.HealthCheck)
+		}
+	}
+	return p, nil
+}
+
+// Stats returns pool utilization and circuit state keyed by upstream.
+func (p *ReverseProxy) Stats() map[string]UpstreamStats {
+	out := map[string]UpstreamStats{}
+	for _, u := range p.upstreams {
+		state, failures := u.health.snapshot()
+		out[u.target.String()] = UpstreamStats{
+			PoolStats: u.pool.stats(),
+			State:     state,
+			Failures:  failures,
+		}
+	}
+	return out
+}
+
+// StatsHandler serves Stats as JSON, for mounting on an admin route.
+func (p *ReverseProxy) StatsHandler(w *response.Writer, req *request.Request) {
+	body, err := json.Marshal(p.Stats())
+	if err != nil {
+		writeError(w, response.StatusInternalServerError)
+		return
+	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Type", "application/json")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
+
+func (p *ReverseProxy) Close() {
+	p.stop()
+	for _, u := range p.upstreams {
+		u.pool.close()
+	}
+}
+
+// pick chooses an upstream among those whose circuit is not open, or nil if
+// every upstream is ejected, and admits the request to it. Upstreams in
+// tried are only picked again when no other upstream is available.
+func (p *ReverseProxy) pick(req *request.Request, tried map[*upstream]bool) *upstream {
+	// taken holds half-open upstreams whose trial went to another request
+	// between the check and the pick.
+	taken := map[*upstream]bool{}
+	for {
+		candidates := []Candidate{}
+		available := []*upstream{}
+		fallback := []*upstream{}
+		for _, u := range p.upstreams {
+			if taken[u] || !u.health.available() {
+				continue
+			}
+			if tried[u] {
+				fallback = append(fallback, u)
+				continue
+			}
+			available = append(available, u)
+		}
+		if len(available) == 0 {
+			available = fallback
+		}
+		if len(available) == 0 {
+			return nil
+		}
+		for _, u := range available {
+			candidates = append(candidates, Candidate{Target: u.target.String(), Active: u.pool.active.Load()})
+		}
+		u := available[p.balancer.Pick(candidates, req)]
+		if u.health.admit() {
+			return u
+		}
+		taken[u] = true
+	}
+}
+
+func writeError(w *response.Writer, status response.StatusCode) {
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*response.GetDefaultHeaders(0))
+}
+
+// clientBody streams a request body upstream, keeping the error reading it
+// from the client failed with so that it is not blamed on the upstream.
+type clientBody struct {
+	r   io.Reader
+	mu  sync.Mutex
+	err error
+}
+
+func (b *clientBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		b.err = err
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+// readErr is the error reading from the client failed with, if any.
+func (b *clientBody) readErr() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// outgoing builds the upstream request. A request that is sent only once
+// streams its body, returned for the caller to check; one that may be
+// retried resends the copy Handle kept.
+func (u *upstream) outgoing(req *request.Request, path PathRewrite, stream bool) (*http.Request, *clientBody, error) {
+	// The upstream gets the path that was routed rather than the raw
+	// target, which may be in absolute-form or hold dot segments.
+	rewritten := &url.URL{Path: path.rewrite(req.Path())}
+	target := strings.TrimSuffix(u.target.Path, "/") + rewritten.EscapedPath()
+	if query := req.Target().RawQuery; query != "" {
+		target += "?" + query
+	}
+	var streamed *clientBody
+	var body io.Reader
+	if stream {
+		streamed = &clientBody{r: req.BodyReader()}
+		body = streamed
+	} else {
+		body = bytes.NewReader(req.Body())
+	}
+	out, err := http.NewRequest(req.RequestLine.Method, u.target.Scheme+"://"+u.target.Host+target, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream {
+		out.ContentLength = req.ContentLength()
+		if out.ContentLength == 0 {
+			out.Body = http.NoBody
+		}
+	}
+	connection := req.Headers().List("Connection")
+	req.Headers().Foreach(func(n, v string) {
+		if strings.EqualFold(n, "host") || strings.EqualFold(n, "content-length") || isHopByHop(n, connection) {
+			return
+		}
+		out.Header.Add(n, v)
+	})
+	return out, streamed, nil
+}
+
+// try sends req to u. On success the caller must close the response body
+// and then call release to give the connection slot back.
+func (p *ReverseProxy) try(u *upstream, req *request.Request, attempt int, stream bool) (*http.Response, func(), error) {
+	if err := u.pool.acquire(); err != nil {
+		u.health.forget()
+		return nil, nil, err
+	}
+	out, body, err := u.outgoing(req, p.path, stream)
+	if err != nil {
+		u.health.forget()
+		u.pool.release()
+		return nil, nil, ERROR_BAD_REQUEST
+	}
+	out.Header.Set("X-Forwarded-Attempts", strconv.Itoa(attempt))
+	if n, ok := maxForwards(req); ok {
+		out.Header.Set("Max-Forwards", strconv.Itoa(n-1))
+	}
+	setForwarded(out.Header, req)
+	applyHeaderRules(p.headers.Request, out.Header, req, u)
+	applyHeaderRules(u.headers.Request, out.Header, req, u)
+	if host := out.Header.Get("Host"); host != "" {
+		out.Host = host
+		out.Header.Del("Host")
+	}
+	// A client that goes away cancels the upstream request too.
+	ctx, cancel := context.WithCancel(req.Context())
+	release := func() {
+		cancel()
+		u.pool.release()
+	}
+	// The per-try timeout only covers waiting for the response head, a
+	// slow body is not a reason to start over.
+	timer := (*time.Timer)(nil)
+	if p.retry.TryTimeout > 0 {
+		timer = time.AfterFunc(p.retry.TryTimeout, cancel)
+	}
+	res, err := u.pool.transport.RoundTrip(out.WithContext(ctx))
+	if timer != nil {
+		timer.Stop()
+	}
+	if err != nil {
+		// A client that went away or stopped sending its body says
+		// nothing about the upstream.
+		clientErr := body.readErr()
+		aborted := req.Context().Err() != nil || clientErr != nil
+		if aborted {
+			u.health.forget()
+		} else {
+			u.health.record(false, false)
+		}
+		timedOut := !aborted && ctx.Err() != nil
+		release()
+		if clientErr != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ERROR_BAD_REQUEST, clientErr)
+		}
+		if timedOut {
+			return nil, nil, ERROR_TRY_TIMEOUT
+		}
+		return nil, nil, err
+	}
+	u.health.record(!isUpstreamFailure(res.StatusCode), false)
+	return res, release, nil
+}
+
+// setForwarded tells the upstream where the request came from: the
+// client's address is appended to X-Forwarded-For, and X-Forwarded-Host and
+// X-Forwarded-Proto carry the host and scheme the client asked for.
+func setForwarded(h http.Header, req *request.Request) {
+	if addr := req.RemoteAddr(); addr != nil {
+		client := addr.String()
+		if host, _, err := net.SplitHostPort(client); err == nil {
+			client = host
+		}
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			client = strings.Join(prior, ", ") + ", " + client
+		}
+		h.Set("X-Forwarded-For", client)
+	}
+	if host := req.Host(); host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+	proto := "http"
+	if req.TLS() != nil {
+		proto = "https"
+	}
+	h.Set("X-Forwarded-Proto", proto)
+}
+
+func errorStatus(err error) response.StatusCode {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, request.ERROR_BODY_TOO_LARGE):
+		return response.StatusContentTooLarge
+	case errors.Is(err, ERROR_BAD_REQUEST):
+		return response.StatusBadRequest
+	case errors.Is(err, ERROR_POOL_TIMEOUT):
+		return response.StatusServiceUnavailable
+	case errors.Is(err, ERROR_TRY_TIMEOUT), errors.Is(err, context.DeadlineExceeded):
+		return response.StatusGatewayTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return response.StatusGatewayTimeout
+	}
+	return response.StatusBadGateway
+}
+
+// maxForwards reads the Max-Forwards field of TRACE and OPTIONS requests,
+// the only ones it applies to (RFC 9110, section 7.6.2).
+func maxForwards(req *request.Request) (int, bool) {
+	if m := req.RequestLine.Method; m != "TRACE" && m != "OPTIONS" {
+		return 0, false
+	}
+	v, ok := req.Headers().Get("Max-Forwards")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 31)
+	if err != nil {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// answerLast answers a request that may not be forwarded any further, as
+// its final recipient.
+func (p *ReverseProxy) answerLast(w *response.Writer, req *request.Request) {
+	switch {
+	case req.RequestLine.Method == "OPTIONS":
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(*headers.NewHeaders())
+	case p.trace:
+		server.WriteTrace(w, req)
+	default:
+		writeError(w, response.StatusNotImplemented)
+	}
+}
+
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	if n, ok := maxForwards(req); ok && n == 0 {
+		p.answerLast(w, req)
+		return
+	}
+	attempts := p.retry.attempts(req)
+	if attempts > 1 {
+		// Retries resend a kept copy of the body, so all of it has to
+		// arrive first; a partial one must not go out as if complete.
+		if _, err := req.ReadBody(p.retry.maxBody()); err != nil {
+			log.Printf("proxy: reading request body: %v", err)
+			status := response.StatusBadRequest
+			if errors.Is(err, request.ERROR_BODY_TOO_LARGE) {
+				status = response.StatusContentTooLarge
+			}
+			writeError(w, status)
+			return
+		}
+	}
+	tried := map[*upstream]bool{}
+	status := response.StatusServiceUnavailable
+	for attempt := 1; attempt <= attempts; attempt++ {
+		u := p.pick(req, tried)
+		if u == nil {
+			log.Printf("proxy: no healthy upstream")
+			break
+		}
+		tried[u] = true
+		res, release, err := p.try(u, req, attempt, attempts == 1)
+		if err != nil {
+			log.Printf("proxy: %s: attempt %d: %v", u.target, attempt, err)
+			status = errorStatus(err)
+			if status == response.StatusBadRequest || req.Context().Err() != nil {
+				break
+			}
+			continue
+		}
+		if attempt < attempts && p.retry.retryOn(res.StatusCode) {
+			log.Printf("proxy: %s: attempt %d: status %d, retrying", u.target, attempt, res.StatusCode)
+			res.Body.Close()
+			release()
+			continue
+		}
+		defer release()
+		defer res.Body.Close()
+		p.relay(w, req, u, res)
+		return
+	}
+	writeError(w, status)
+}
+
+func (p *ReverseProxy) relay(w *response.Writer, req *request.Request, u *upstream, res *http.Response) {
+	// Responses to HEAD, 204 and 304 have no body, so the upstream's
+	// Content-Length is relayed as is.
+	noBody := req.RequestLine.Method == "HEAD" || res.StatusCode == 204 || res.StatusCode == 304
+	applyHeaderRules(p.headers.Response, res.Header, req, u)
+	applyHeaderRules(u.headers.Response, res.Header, req, u)
+	h := headers.NewHeaders()
+	var connection []string
+	for _, v := range res.Header.Values("Connection") {
+		connection = append(connection, strings.Split(v, ",")...)
+	}
+	for name, values := range res.Header {
+		if isHopByHop(name, connection) || (!noBody && strings.EqualFold(name, "Content-Length")) {
+			continue
+		}
+		for _, v := range values {
+			h.Add(name, v)
+		}
+	}
+	filtered := !noBody && len(p.filters) > 0
+	digested := !noBody && p.digest
+	chunked := !noBody && (res.ContentLength < 0 || filtered || digested)
+	if chunked {
+		h.Set("Transfer-Encoding", "chunked")
+		names := make([]string, 0, len(res.Trailer)+1)
+		for name := range res.Trailer {
+			names = append(names, name)
+		}
+		if digested {
+			names = append(names, digest.ContentDigest)
+		}
+		if len(names) > 0 {
+			h.Set("Trailer", strings.Join(names, ", "))
+		}
+	} else if !noBody {
+		h.Set("Content-Length", fmt.Sprintf("%d", res.ContentLength))
+	}
+	if filtered {
+		for _, f := range p.filters {
+			w.AddFilter(f)
+		}
+	}
+	w.WriteStatusLine(response.StatusCode(res.StatusCode))
+	w.WriteHeaders(*h)
+	if noBody {
+		return
+	}
+
+	body := io.Reader(res.Body)
+	var hasher *digest.Hasher
+	if digested {
+		hasher, _ = digest.NewHasher()
+		body = io.TeeReader(res.Body, hasher)
+	}
+	if err := w.WriteBodyReader(body, res.ContentLength); err != nil {
+		log.Printf("proxy: %s: relaying body: %v", u.target, err)
+		w.CloseAfterResponse()
+		return
+	}
+	if !chunked {
+		return
+	}
+	// Trailers are only known once the body has been read.
+	trailers := headers.NewHeaders()
+	for name, values := range res.Trailer {
+		for _, v := range values {
+			trailers.Add(name, v)
+		}
+	}
+	if hasher != nil {
+		trailers.Set(digest.ContentDigest, hasher.Value())
+	}
+	if err := w.Finish(trailers); err != nil {
+		log.Printf("proxy: %s: finishing body: %v", u.target, err)
+	}
+}
@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"http/internal/digest"
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyRequest(t *testing.T, p *ReverseProxy, raw string) *http.Response {
+	return proxyRequestWith(t, p.Handle, raw)
+}
+
+func proxyRequestWith(t *testing.T, handler func(w *response.Writer, req *request.Request), raw string) *http.Response {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	handler(response.NewWriter(out), req)
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	return res
+}
+
+func readBody(t *testing.T, res *http.Response) string {
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestReverseProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream-Path", r.URL.RequestURI())
+		w.Header().Set("X-Seen-Connection", r.Header.Get("Connection")+r.Header.Get("X-Hop"))
+		if r.URL.Path == "/internal" {
+			w.Header().Set("Connection", "keep-alive, X-Internal")
+			w.Header().Set("X-Internal", "secret")
+		}
+		if r.URL.Path == "/stream" {
+			w.Write([]byte("part one "))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("part two"))
+			return
+		}
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	}))
+	defer upstream.Close()
+	p, err := New(Config{Upstreams: []string{upstream.URL}})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: Request is forwarded with its body and target
+	res := proxyRequest(t, p, "POST /echo?x=1 HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "/echo?x=1", res.Header.Get("X-Upstream-Path"))
+	assert.Equal(t, "POST hello", readBody(t, res))
+
+	// Test: Hop-by-hop headers are not forwarded
+	res = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: localhost:42069\r\nConnection: X-Hop\r\nX-Hop: yes\r\n\r\n")
+	assert.Equal(t, "", res.Header.Get("X-Seen-Connection"))
+	res = proxyRequest(t, p, "GET /internal HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Empty(t, res.Header.Get("X-Internal"))
+	assert.Empty(t, res.Header.Get("Connection"))
+
+	// Test: Responses of unknown length are streamed chunked
+	res = proxyRequest(t, p, "GET /stream HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "part one part two", readBody(t, res))
+
+	// Test: Unreachable upstream answers 502
+	dead, err := New(Config{Upstreams: []string{"http://127.0.0.1:1"}})
+	require.NoError(t, err)
+	res = proxyRequest(t, dead, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, 502, res.StatusCode)
+
+	// Test: Invalid upstreams are rejected
+	_, err = New(Config{Upstreams: []string{"localhost:8080"}})
+	require.Error(t, err)
+	_, err = New(Config{})
+	require.Error(t, err)
+}
+
+func TestForwarding(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s|%s|%s", r.Host, r.Header.Get("X-Forwarded-For"),
+			r.Header.Get("X-Forwarded-Host"), r.Header.Get("X-Forwarded-Proto"))
+	}))
+	defer upstream.Close()
+	p, err := New(Config{Upstreams: []string{upstream.URL}, ContentDigest: true})
+	require.NoError(t, err)
+	defer p.Close()
+	reader := request.NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\nX-Forwarded-For: 10.0.0.1\r\n\r\n"))
+	reader.Conn = request.ConnInfo{RemoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 5555}}
+	req, err := reader.ReadRequest()
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	p.Handle(response.NewWriter(out), req)
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+
+	// Test: The client is appended to X-Forwarded-For, and the host and
+	// scheme it asked for are passed on
+	body := readBody(t, res)
+	assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://")+"|10.0.0.1, 192.0.2.7|example.com|http", body)
+
+	// Test: A Content-Digest trailer covers the relayed body
+	value, err := digest.Value([]byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, value, res.Trailer.Get(digest.ContentDigest))
+	_, err = New(Config{Upstreams: []string{upstream.URL}, ContentDigest: true, Filters: []response.BodyFilter{upperFilter{}}})
+	require.Error(t, err)
+
+	// Test: Upstream timeouts answer 504, other failures 502
+	assert.Equal(t, response.StatusGatewayTimeout, errorStatus(fmt.Errorf("dial: %w", context.DeadlineExceeded)))
+	assert.Equal(t, response.StatusGatewayTimeout, errorStatus(&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}))
+	assert.Equal(t, response.StatusBadGateway, errorStatus(io.ErrUnexpectedEOF))
+}
+
+func TestMaxForwards(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Method, r.Header.Get("Max-Forwards"))
+	}))
+	defer upstream.Close()
+	p, err := New(Config{Upstreams: []string{upstream.URL}})
+	require.NoError(t, err)
+	defer p.Close()
+	send := func(p *ReverseProxy, method, maxForwards string) *http.Response {
+		return proxyRequest(t, p, method+" / HTTP/1.1\r\nHost: example.com\r\nCookie: a=b\r\nMax-Forwards: "+maxForwards+"\r\n\r\n")
+	}
+
+	// Test: TRACE and OPTIONS are forwarded with one hop less
+	assert.Equal(t, "TRACE 1", readBody(t, send(p, "TRACE", "2")))
+	assert.Equal(t, "OPTIONS 0", readBody(t, send(p, "OPTIONS", "1")))
+
+	// Test: Other methods, and invalid values, pass it on as is
+	assert.Equal(t, "GET 0", readBody(t, send(p, "GET", "0")))
+	assert.Equal(t, "TRACE -1", readBody(t, send(p, "TRACE", "-1")))
+
+	// Test: Once it runs out the proxy answers itself, echoing TRACE only
+	// when enabled
+	res := send(p, "OPTIONS", "0")
+	assert.Equal(t, 204, res.StatusCode)
+	assert.Equal(t, 501, send(p, "TRACE", "0").StatusCode)
+	tracing, err := New(Config{Upstreams: []string{upstream.URL}, Trace: true})
+	require.NoError(t, err)
+	defer tracing.Close()
+	res = send(tracing, "TRACE", "0")
+	assert.Equal(t, "message/http", res.Header.Get("Content-Type"))
+	assert.Equal(t, "TRACE / HTTP/1.1\r\nHost: example.com\r\nMax-Forwards: 0\r\n\r\n", readBody(t, res))
+}
+
+type upperFilter struct{}
+
+func (upperFilter) Headers(h *headers.Headers) {
+	h.Set("x-filtered", "upper")
+}
+
+func (upperFilter) Wrap(dst io.Writer) io.WriteCloser {
+	return upperWriter{dst}
+}
+
+type upperWriter struct {
+	dst io.Writer
+}
+
+func (u upperWriter) Write(p []byte) (int, error) {
+	return u.dst.Write(bytes.ToUpper(p))
+}
+
+func (u upperWriter) Close() error {
+	return nil
+}
+
+func TestProxyFilters(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/trailer" {
+			w.Header().Set("Trailer", "X-Sum")
+			w.Write([]byte("with trailer"))
+			w.Header().Set("X-Sum", "42")
+			return
+		}
+		w.Header().Set("Content-Length", "5")
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	// Test: Upstream trailers are relayed
+	p, err := New(Config{Upstreams: []string{upstream.URL}})
+	require.NoError(t, err)
+	defer p.Close()
+	res := proxyRequest(t, p, "GET /trailer HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, "with trailer", readBody(t, res))
+	assert.Equal(t, "42", res.Trailer.Get("X-Sum"))
+
+	// Test: Filtered bodies are streamed chunked with trailers kept
+	p, err = New(Config{Upstreams: []string{upstream.URL}, Filters: []response.BodyFilter{upperFilter{}}})
+	require.NoError(t, err)
+	defer p.Close()
+	res = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "upper", res.Header.Get("X-Filtered"))
+	assert.Equal(t, "HELLO", readBody(t, res))
+	res = proxyRequest(t, p, "GET /trailer HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, "WITH TRAILER", readBody(t, res))
+	assert.Equal(t, "42", res.Trailer.Get("X-Sum"))
+
+	// Test: Bodiless responses are not filtered
+	res = proxyRequest(t, p, "HEAD / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, "", res.Header.Get("X-Filtered"))
+}
+
+func TestPoolLimits(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	p, err := New(Config{
+		Upstreams: []string{upstream.URL},
+		Pool:      PoolConfig{MaxConns: 1, MaxIdleConns: 1, QueueTimeout: 50 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+	get := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+
+	// Test: A second request queues, then times out with 503
+	done := make(chan *http.Response)
+	go func() {
+		done <- proxyRequest(t, p, get)
+	}()
+	for p.Stats()[upstream.URL].Active == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	res := proxyRequest(t, p, get)
+	assert.Equal(t, 503, res.StatusCode)
+	stats := p.Stats()[upstream.URL]
+	assert.Equal(t, int64(1), stats.Active)
+	assert.Equal(t, int64(1), stats.QueueTimeouts)
+
+	// Test: Once the slot frees up the connection is kept idle and reused
+	close(release)
+	res = <-done
+	assert.Equal(t, "ok", readBody(t, res))
+	res = proxyRequest(t, p, get)
+	assert.Equal(t, "ok", readBody(t, res))
+	stats = p.Stats()[upstream.URL]
+	assert.Equal(t, int64(0), stats.Active)
+	assert.Equal(t, int64(0), stats.Waiting)
+	assert.Equal(t, int64(1), stats.Dials)
+	assert.Equal(t, int64(1), stats.Idle)
+}
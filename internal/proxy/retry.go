@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"fmt"
+	"http/internal/request"
+	"slices"
+	"time"
+)
+
+var ERROR_BAD_REQUEST = fmt.Errorf("request cannot be forwarded")
+var ERROR_TRY_TIMEOUT = fmt.Errorf("upstream did not respond in time")
+
+// RetryPolicy retries idempotent requests on a different upstream after
+// connection failures, timeouts or one of the OnStatus answers. Each try is
+// announced to the upstream with an X-Forwarded-Attempts header.
+type RetryPolicy struct {
+	// Attempts is the total number of tries, the first one included.
+	Attempts int
+	// OnStatus lists upstream statuses worth retrying, e.g. 502 and 503.
+	OnStatus []int
+	// TryTimeout bounds how long each try waits for the response head.
+	TryTimeout time.Duration
+	// MaxRetryBody bounds the request bodies buffered so they can be
+	// resent; larger and chunked ones are streamed and sent once. Zero
+	// means DefaultMaxRetryBody.
+	MaxRetryBody int64
+}
+
+// DefaultMaxRetryBody is the largest request body buffered for retries
+// unless changed with RetryPolicy.MaxRetryBody.
+const DefaultMaxRetryBody = 1 << 20
+
+func (r RetryPolicy) retryOn(status int) bool {
+	return slices.Contains(r.OnStatus, status)
+}
+
+// attempts is how many tries req gets: one, unless it is idempotent and
+// its body small enough to keep for resending.
+func (r RetryPolicy) attempts(req *request.Request) int {
+	if !isIdempotent(req.RequestLine.Method) {
+		return 1
+	}
+	if n := req.ContentLength(); n < 0 || n > r.maxBody() {
+		return 1
+	}
+	return max(r.Attempts, 1)
+}
+
+func (r RetryPolicy) maxBody() int64 {
+	if r.MaxRetryBody <= 0 {
+		return DefaultMaxRetryBody
+	}
+	return r.MaxRetryBody
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer unavailable.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("healthy, attempt " + r.Header.Get("X-Forwarded-Attempts")))
+	}))
+	defer healthy.Close()
+	retry := RetryPolicy{Attempts: 2, OnStatus: []int{502, 503}, TryTimeout: 50 * time.Millisecond}
+	get := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+	post := "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 0\r\n\r\n"
+
+	// Test: Retryable status fails over to the other upstream
+	p, err := New(Config{Upstreams: []string{unavailable.URL, healthy.URL}, Retry: retry})
+	require.NoError(t, err)
+	defer p.Close()
+	for range 4 {
+		res := proxyRequest(t, p, get)
+		assert.Equal(t, 200, res.StatusCode)
+		body := readBody(t, res)
+		assert.Contains(t, []string{"healthy, attempt 1", "healthy, attempt 2"}, body)
+	}
+
+	// Test: Non-idempotent requests are sent once
+	statuses := map[int]bool{}
+	for range 4 {
+		statuses[proxyRequest(t, p, post).StatusCode] = true
+	}
+	assert.Equal(t, map[int]bool{200: true, 503: true}, statuses)
+
+	// Test: Idempotent requests are sent once when their body is too large
+	// to keep for resending, and retried when it is not
+	capped := retry
+	capped.MaxRetryBody = 4
+	p, err = New(Config{Upstreams: []string{unavailable.URL, healthy.URL}, Retry: capped})
+	require.NoError(t, err)
+	defer p.Close()
+	statuses = map[int]bool{}
+	for range 4 {
+		statuses[proxyRequest(t, p, "PUT / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello").StatusCode] = true
+	}
+	assert.Equal(t, map[int]bool{200: true, 503: true}, statuses)
+	for range 4 {
+		assert.Equal(t, 200, proxyRequest(t, p, "PUT / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 4\r\n\r\nhell").StatusCode)
+	}
+
+	// Test: Bodies kept for retries that stop short are refused, not sent
+	// on as if complete
+	res := proxyRequest(t, p, "PUT / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 4\r\n\r\nhe")
+	assert.Equal(t, 400, res.StatusCode)
+
+	// Test: Connect failures fail over
+	p, err = New(Config{Upstreams: []string{"http://127.0.0.1:1", healthy.URL}, Retry: retry})
+	require.NoError(t, err)
+	defer p.Close()
+	for range 4 {
+		assert.Equal(t, 200, proxyRequest(t, p, get).StatusCode)
+	}
+
+	// Test: Per-try timeout fails over to a faster upstream
+	p, err = New(Config{Upstreams: []string{slow.URL, healthy.URL}, Retry: retry})
+	require.NoError(t, err)
+	defer p.Close()
+	for range 4 {
+		assert.Equal(t, 200, proxyRequest(t, p, get).StatusCode)
+	}
+
+	// Test: Running out of attempts on timeouts answers 504
+	p, err = New(Config{Upstreams: []string{slow.URL}, Retry: retry})
+	require.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, 504, proxyRequest(t, p, get).StatusCode)
+
+	// Test: The last attempt's answer is relayed as is
+	p, err = New(Config{Upstreams: []string{unavailable.URL}, Retry: retry})
+	require.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, 503, proxyRequest(t, p, get).StatusCode)
+}
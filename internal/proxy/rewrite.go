@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"fmt"
+	"http/internal/request"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type HeaderOp string
+
+const (
+	HeaderAdd    HeaderOp = "add"
+	HeaderSet    HeaderOp = "set"
+	HeaderRemove HeaderOp = "remove"
+	HeaderCopy   HeaderOp = "copy"
+)
+
+// HeaderRule edits one header field. For add and set, Value is a template
+// expanded against the incoming request; for copy it names the field whose
+// value is copied into Name. Value is unused by remove.
+//
+// Template variables: {method}, {target}, {path}, {query}, {host},
+// {upstream} and {header.<Name>}. Unknown variables expand to "".
+type HeaderRule struct {
+	Op    HeaderOp
+	Name  string
+	Value string
+}
+
+// HeaderRules are applied in order to the request sent upstream and to the
+// response relayed back.
+type HeaderRules struct {
+	Request  []HeaderRule
+	Response []HeaderRule
+}
+
+var templateVar = regexp.MustCompile(`\{[a-zA-Z_][a-zA-Z0-9_.-]*\}`)
+
+func (rules HeaderRules) validate() error {
+	for _, r := range append(append([]HeaderRule{}, rules.Request...), rules.Response...) {
+		switch r.Op {
+		case HeaderAdd, HeaderSet, HeaderRemove, HeaderCopy:
+		default:
+			return fmt.Errorf("unknown header op %q", r.Op)
+		}
+		if r.Name == "" || (r.Op == HeaderCopy && r.Value == "") {
+			return fmt.Errorf("incomplete %s header rule", r.Op)
+		}
+	}
+	return nil
+}
+
+func expand(tmpl string, req *request.Request, u *upstream) string {
+	return templateVar.ReplaceAllStringFunc(tmpl, func(v string) string {
+		name := v[1 : len(v)-1]
+		target := req.RequestLine.RequestTarget
+		path, query, _ := strings.Cut(target, "?")
+		switch name {
+		case "method":
+			return req.RequestLine.Method
+		case "target":
+			return target
+		case "path":
+			return path
+		case "query":
+			return query
+		case "host":
+			return req.Host()
+		case "upstream":
+			return u.target.Host
+		}
+		if field, ok := strings.CutPrefix(name, "header."); ok {
+			value, _ := req.Headers().Get(field)
+			return value
+		}
+		return ""
+	})
+}
+
+func applyHeaderRules(rules []HeaderRule, h http.Header, req *request.Request, u *upstream) {
+	for _, r := range rules {
+		switch r.Op {
+		case HeaderAdd:
+			h.Add(r.Name, expand(r.Value, req, u))
+		case HeaderSet:
+			h.Set(r.Name, expand(r.Value, req, u))
+		case HeaderRemove:
+			h.Del(r.Name)
+		case HeaderCopy:
+			values := append([]string{}, h.Values(r.Value)...)
+			h.Del(r.Name)
+			for _, v := range values {
+				h.Add(r.Name, v)
+			}
+		}
+	}
+}
+
+// PathRewrite transforms the request path before it is forwarded: the
+// prefix is stripped first, then Pattern is replaced with Replacement
+// (regexp.ReplaceAllString syntax, e.g. "$1"), then AddPrefix is prepended.
+// The query string is left untouched.
+type PathRewrite struct {
+	StripPrefix string
+	Pattern     string
+	Replacement string
+	AddPrefix   string
+	pattern     *regexp.Regexp
+}
+
+func (r *PathRewrite) compile() error {
+	if r.Pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return err
+	}
+	r.pattern = re
+	return nil
+}
+
+// Apply rewrites an origin-form request target.
+func (r PathRewrite) Apply(target string) string {
+	path, query, hasQuery := strings.Cut(target, "?")
+	path = r.rewrite(path)
+	if hasQuery {
+		return path + "?" + query
+	}
+	return path
+}
+
+// rewrite rewrites a path alone, which may be encoded or not.
+func (r PathRewrite) rewrite(path string) string {
+	if r.StripPrefix != "" {
+		if rest, ok := strings.CutPrefix(path, r.StripPrefix); ok && (rest == "" || rest[0] == '/' || strings.HasSuffix(r.StripPrefix, "/")) {
+			path = rest
+		}
+	}
+	if r.Pattern != "" {
+		re := r.pattern
+		if re == nil {
+			re = regexp.MustCompile(r.Pattern)
+		}
+		path = re.ReplaceAllString(path, r.Replacement)
+	}
+	return strings.TrimSuffix(r.AddPrefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderRewrite(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"X-Forwarded-Method", "X-Tag", "X-Trace", "Authorization", "X-User", "X-Only-Here"} {
+			w.Header()["Seen-"+name] = r.Header.Values(name)
+		}
+		w.Header().Set("Seen-Host", r.Host)
+		w.Header().Set("Server", "upstream/1.0")
+		w.Header().Set("X-Internal", "secret")
+	}))
+	defer upstream.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Seen-X-Only-Here"] = r.Header.Values("X-Only-Here")
+	}))
+	defer other.Close()
+
+	p, err := New(Config{
+		Upstreams: []string{upstream.URL, other.URL},
+		Headers: HeaderRules{
+			Request: []HeaderRule{
+				{Op: HeaderSet, Name: "X-Forwarded-Method", Value: "{method} {path}"},
+				{Op: HeaderAdd, Name: "X-Tag", Value: "proxy"},
+				{Op: HeaderSet, Name: "X-Trace", Value: "{header.X-Request-Id}@{upstream}"},
+				{Op: HeaderCopy, Name: "X-User", Value: "Authorization"},
+				{Op: HeaderRemove, Name: "Authorization"},
+				{Op: HeaderSet, Name: "Host", Value: "backend.internal"},
+			},
+			Response: []HeaderRule{
+				{Op: HeaderRemove, Name: "X-Internal"},
+				{Op: HeaderSet, Name: "Server", Value: "http-from-scratch"},
+				{Op: HeaderAdd, Name: "X-Served-For", Value: "{host}{target}"},
+			},
+		},
+		UpstreamHeaders: map[string]HeaderRules{
+			upstream.URL: {Request: []HeaderRule{{Op: HeaderSet, Name: "X-Only-Here", Value: "yes"}}},
+		},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: Request and response rules are applied in order
+	res := proxyRequest(t, p, "GET /items?id=1 HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"X-Tag: client\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"Authorization: Bearer token\r\n"+
+		"\r\n")
+	assert.Equal(t, "GET /items", res.Header.Get("Seen-X-Forwarded-Method"))
+	assert.Equal(t, []string{"client", "proxy"}, res.Header.Values("Seen-X-Tag"))
+	assert.Equal(t, "abc@"+strings.TrimPrefix(upstream.URL, "http://"), res.Header.Get("Seen-X-Trace"))
+	assert.Equal(t, "Bearer token", res.Header.Get("Seen-X-User"))
+	assert.Equal(t, "", res.Header.Get("Seen-Authorization"))
+	assert.Equal(t, "backend.internal", res.Header.Get("Seen-Host"))
+	assert.Equal(t, "yes", res.Header.Get("Seen-X-Only-Here"))
+	assert.Equal(t, "", res.Header.Get("X-Internal"))
+	assert.Equal(t, "http-from-scratch", res.Header.Get("Server"))
+	assert.Equal(t, "localhost:42069/items?id=1", res.Header.Get("X-Served-For"))
+
+	// Test: Upstream-specific rules stay with their upstream
+	res = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, "", res.Header.Get("Seen-X-Only-Here"))
+
+	// Test: Invalid rules are rejected
+	_, err = New(Config{Upstreams: []string{upstream.URL}, Headers: HeaderRules{Request: []HeaderRule{{Op: "rename", Name: "X"}}}})
+	require.Error(t, err)
+	_, err = New(Config{Upstreams: []string{upstream.URL}, Headers: HeaderRules{Response: []HeaderRule{{Op: HeaderCopy, Name: "X"}}}})
+	require.Error(t, err)
+}
+
+func TestPathRewrite(t *testing.T) {
+	// Test: Prefix stripping only matches whole segments
+	strip := PathRewrite{StripPrefix: "/httpbin"}
+	assert.Equal(t, "/get?a=1", strip.Apply("/httpbin/get?a=1"))
+	assert.Equal(t, "/", strip.Apply("/httpbin"))
+	assert.Equal(t, "/httpbinx/get", strip.Apply("/httpbinx/get"))
+	assert.Equal(t, "/other", strip.Apply("/other"))
+
+	// Test: Adding a prefix
+	add := PathRewrite{StripPrefix: "/api/", AddPrefix: "/v2/"}
+	assert.Equal(t, "/v2/users", add.Apply("/api/users"))
+	assert.Equal(t, "/v2/", add.Apply("/api/"))
+
+	// Test: Regex rewrite keeps the query
+	re := PathRewrite{Pattern: `^/users/(\d+)/profile$`, Replacement: "/profiles/$1"}
+	require.NoError(t, re.compile())
+	assert.Equal(t, "/profiles/42?full=1", re.Apply("/users/42/profile?full=1"))
+	assert.Equal(t, "/users/abc/profile", re.Apply("/users/abc/profile"))
+
+	// Test: Rewrites are applied before forwarding
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer upstream.Close()
+	p, err := New(Config{
+		Upstreams: []string{upstream.URL + "/base"},
+		Path:      PathRewrite{StripPrefix: "/public", Pattern: `\.json$`, AddPrefix: "/api"},
+	})
+	require.NoError(t, err)
+	defer p.Close()
+	res := proxyRequest(t, p, "GET /public/items.json?page=2 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, "/base/api/items?page=2", readBody(t, res))
+
+	// Test: Absolute-form targets and dot segments forward the routed path
+	res = proxyRequest(t, p, "GET http://localhost:42069/public/x/../items.json?page=3 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	assert.Equal(t, "/base/api/items?page=3", readBody(t, res))
+
+	// Test: Invalid patterns are rejected
+	_, err = New(Config{Upstreams: []string{upstream.URL}, Path: PathRewrite{Pattern: "("}})
+	require.Error(t, err)
+}
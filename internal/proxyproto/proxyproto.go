@@ -0,0 +1,224 @@
+// Package proxyproto reads the PROXY protocol header that load balancers
+// such as HAProxy put in front of a connection to pass on the addresses of
+// the client they accepted it from. Both the text format of version 1 and
+// the binary one of version 2 are understood.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ERROR_NO_HEADER = fmt.Errorf("proxyproto: connection does not start with a PROXY header")
+var ERROR_MALFORMED_HEADER = fmt.Errorf("proxyproto: malformed PROXY header")
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Length is the longest a version 1 header may be, CRLF included.
+const maxV1Length = 107
+
+// Header is what a PROXY header says about a connection. Source and
+// Destination are nil when the proxy did not give them, as for its own
+// health checks.
+type Header struct {
+	Version     int
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// ReadHeader reads a PROXY header of either version from r.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readV1(r)
+	case v2Signature[0]:
+		return readV2(r)
+	}
+	return nil, ERROR_NO_HEADER
+}
+
+// readV1 reads a header such as "PROXY TCP4 192.0.2.1 192.0.2.2 5678 80".
+func readV1(r *bufio.Reader) (*Header, error) {
+	line := make([]byte, 0, maxV1Length)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxV1Length {
+			return nil, ERROR_MALFORMED_HEADER
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" {
+		return nil, ERROR_NO_HEADER
+	}
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// Whatever follows is to be ignored.
+		return h, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, ERROR_MALFORMED_HEADER
+	}
+	src, err := parseV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseV1Addr(ip, port string, v4 bool) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != v4 || addr.Zone() != "" {
+		return nil, ERROR_MALFORMED_HEADER
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || len(port) > 1 && port[0] == '0' {
+		return nil, ERROR_MALFORMED_HEADER
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// readV2 reads a binary header: the signature, the version and command,
+// the address family and transport, the length of the rest, then the
+// addresses and optional TLVs, which are skipped.
+func readV2(r *bufio.Reader) (*Header, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head[:12], v2Signature) {
+		return nil, ERROR_NO_HEADER
+	}
+	version, command := head[12]>>4, head[12]&0xf
+	family, transport := head[13]>>4, head[13]&0xf
+	if version != 2 || command > 1 || family > 3 || transport > 2 {
+		return nil, ERROR_MALFORMED_HEADER
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	h := &Header{Version: 2}
+	// LOCAL connections come from the proxy itself.
+	if command == 0 || transport == 0 {
+		return h, nil
+	}
+	addr := func(ip []byte, port []byte) net.Addr {
+		a, _ := netip.AddrFromSlice(ip)
+		ap := netip.AddrPortFrom(a, binary.BigEndian.Uint16(port))
+		if transport == 2 {
+			return net.UDPAddrFromAddrPort(ap)
+		}
+		return net.TCPAddrFromAddrPort(ap)
+	}
+	switch family {
+	case 1:
+		if len(payload) < 12 {
+			return nil, ERROR_MALFORMED_HEADER
+		}
+		h.Source = addr(payload[0:4], payload[8:10])
+		h.Destination = addr(payload[4:8], payload[10:12])
+	case 2:
+		if len(payload) < 36 {
+			return nil, ERROR_MALFORMED_HEADER
+		}
+		h.Source = addr(payload[0:16], payload[32:34])
+		h.Destination = addr(payload[16:32], payload[34:36])
+	case 3:
+		if len(payload) < 216 {
+			return nil, ERROR_MALFORMED_HEADER
+		}
+		network := "unix"
+		if transport == 2 {
+			network = "unixgram"
+		}
+		name := func(b []byte) string {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[:i]
+			}
+			return string(b)
+		}
+		h.Source = &net.UnixAddr{Name: name(payload[0:108]), Net: network}
+		h.Destination = &net.UnixAddr{Name: name(payload[108:216]), Net: network}
+	}
+	return h, nil
+}
+
+// Conn is a connection that starts with a PROXY header. The header is read
+// on the first Read, RemoteAddr or LocalAddr, which then report the
+// addresses it gives. A connection without a valid header fails every
+// Read.
+type Conn struct {
+	net.Conn
+	once   sync.Once
+	r      *bufio.Reader
+	header *Header
+	err    error
+	logf   func(format string, args ...any)
+}
+
+// NewConn wraps conn. Invalid headers are reported through logf, which
+// may be nil.
+func NewConn(conn net.Conn, logf func(format string, args ...any)) *Conn {
+	return &Conn{Conn: conn, logf: logf}
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.r = bufio.NewReader(c.Conn)
+		c.header, c.err = ReadHeader(c.r)
+		if c.err != nil && !errors.Is(c.err, io.EOF) && c.logf != nil {
+			c.logf("Rejecting connection from %v: %v", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+// Header returns the connection's PROXY header, reading it if need be.
+func (c *Conn) Header() (*Header, error) {
+	c.init()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,137 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v2Header builds a version 2 header.
+func v2Header(verCmd, famTransport byte, payload []byte) string {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, verCmd, famTransport)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	return string(append(b, payload...))
+}
+
+func TestReadHeader(t *testing.T) {
+	read := func(raw string) (*Header, string, error) {
+		r := bufio.NewReader(strings.NewReader(raw))
+		h, err := ReadHeader(r)
+		rest, _ := io.ReadAll(r)
+		return h, string(rest), err
+	}
+
+	// Test: Version 1 headers give TCP addresses, and the bytes after
+	// them are left
+	h, rest, err := read("PROXY TCP4 203.0.113.7 192.0.2.1 51000 443\r\nGET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 1, h.Version)
+	assert.Equal(t, "203.0.113.7:51000", h.Source.String())
+	assert.Equal(t, "192.0.2.1:443", h.Destination.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+	h, _, err = read("PROXY TCP6 2001:db8::1 2001:db8::2 51000 443\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:51000", h.Source.String())
+	h, _, err = read("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")
+	require.NoError(t, err)
+	assert.Nil(t, h.Source)
+
+	// Test: Malformed version 1 headers are rejected
+	for _, raw := range []string{
+		"PROXY TCP4 203.0.113.7 192.0.2.1 51000\r\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.1 51000 443\r\n",
+		"PROXY TCP6 203.0.113.7 192.0.2.1 51000 443\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 51000 65536\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 051000 443\r\n",
+		"PROXY UDP4 203.0.113.7 192.0.2.1 51000 443\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 51000 443\n",
+		"PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n",
+	} {
+		_, _, err = read(raw)
+		assert.Error(t, err, raw)
+	}
+	_, _, err = read("PROXX TCP4\r\n")
+	assert.ErrorIs(t, err, ERROR_NO_HEADER)
+	_, _, err = read("GET / HTTP/1.1\r\n")
+	assert.ErrorIs(t, err, ERROR_NO_HEADER)
+
+	// Test: Version 2 headers give IPv4, IPv6 and Unix addresses, and skip
+	// TLVs
+	payload := []byte{203, 0, 113, 7, 192, 0, 2, 1, 0xc7, 0x38, 0x01, 0xbb, 0x04, 0x00, 0x01, 0x00}
+	h, rest, err = read(v2Header(0x21, 0x11, payload) + "GET")
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Version)
+	assert.Equal(t, &net.TCPAddr{IP: net.IP{203, 0, 113, 7}, Port: 51000}, h.Source)
+	assert.Equal(t, "192.0.2.1:443", h.Destination.String())
+	assert.Equal(t, "GET", rest)
+	v6 := make([]byte, 36)
+	v6[15], v6[31], v6[33], v6[35] = 1, 2, 80, 81
+	h, _, err = read(v2Header(0x21, 0x22, v6))
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:80", h.Source.String())
+	assert.Equal(t, "udp", h.Source.Network())
+	unix := make([]byte, 216)
+	copy(unix, "/run/client.sock")
+	h, _, err = read(v2Header(0x21, 0x31, unix))
+	require.NoError(t, err)
+	assert.Equal(t, &net.UnixAddr{Name: "/run/client.sock", Net: "unix"}, h.Source)
+
+	// Test: LOCAL connections carry no addresses
+	h, _, err = read(v2Header(0x20, 0x11, payload))
+	require.NoError(t, err)
+	assert.Nil(t, h.Source)
+
+	// Test: Malformed version 2 headers are rejected
+	for _, raw := range []string{
+		v2Header(0x11, 0x11, payload),
+		v2Header(0x22, 0x11, payload),
+		v2Header(0x21, 0x41, payload),
+		v2Header(0x21, 0x21, payload),
+		v2Header(0x21, 0x11, payload)[:20],
+		"\r\n\r\n\x00\r\nQUIX\n\x21\x11\x00\x00",
+	} {
+		_, _, err = read(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := NewConn(server, nil)
+	defer c.Close()
+	go client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 51000 443\r\nhello"))
+
+	// Test: Asking for the address reads the header, and reads get what
+	// follows it
+	assert.Equal(t, "203.0.113.7:51000", c.RemoteAddr().String())
+	assert.Equal(t, "192.0.2.1:443", c.LocalAddr().String())
+	b := make([]byte, 5)
+	_, err := io.ReadFull(c, b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// Test: Without a header reads fail and the real addresses stay
+	client2, server2 := net.Pipe()
+	defer client2.Close()
+	var logged []string
+	c = NewConn(server2, func(format string, args ...any) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	})
+	go client2.Write([]byte("hello"))
+	_, err = c.Read(b)
+	assert.ErrorIs(t, err, ERROR_NO_HEADER)
+	assert.Equal(t, server2.RemoteAddr(), c.RemoteAddr())
+
+	// Test: Invalid headers are logged through the given function
+	require.Len(t, logged, 1)
+	assert.Contains(t, logged[0], ERROR_NO_HEADER.Error())
+}
@@ -0,0 +1,244 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"http/internal/transfer"
+	"io"
+	"strings"
+)
+
+var ERROR_BODY_NOT_SENT = fmt.Errorf("client is waiting for 100 Continue before sending the body")
+
+// bodyStream reads a request's body off the connection on demand. Copies of
+// a request made with WithContext share it, and with it the parser state.
+type bodyStream struct {
+	req     *Request
+	rr      *Reader
+	decoded io.Reader
+	// limit caps the bytes handed out; zero means no limit.
+	limit    int64
+	n        int64
+	err      error
+	onDone   func()
+	finished bool
+	// expect sends "100 Continue" before the first read, if the client
+	// asked for it.
+	expect func() error
+	cached []byte
+	// src, when set, holds the body instead of the connection.
+	src io.Reader
+}
+
+func newBodyStream(req *Request, rr *Reader) *bodyStream {
+	s := &bodyStream{req: req, rr: rr, onDone: rr.OnBodyRead}
+	req.stream = s
+	if len(req.codings) > 0 {
+		// Decoded bodies are held to the same limit as chunked ones.
+		s.limit = int64(req.limits.MaxChunkedBodyBytes)
+	}
+	if req.done() {
+		s.finish()
+	} else if rr.OnContinue != nil && req.headers.HasToken("expect", "100-continue") {
+		s.expect = rr.OnContinue
+	}
+	return s
+}
+
+func (s *bodyStream) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.expect != nil {
+		expect := s.expect
+		s.expect = nil
+		if err := expect(); err != nil {
+			s.err = err
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if s.src != nil {
+		n, err = s.src.Read(p)
+		if err == io.EOF {
+			s.finish()
+		}
+	} else if len(s.req.codings) > 0 {
+		n, err = s.readDecoded(p)
+	} else {
+		n, err = s.readRaw(p)
+	}
+	s.n += int64(n)
+	if s.limit > 0 && s.n > s.limit {
+		n, err = 0, ERROR_BODY_TOO_LARGE
+	}
+	if err != nil {
+		s.err = err
+	}
+	return n, err
+}
+
+func (s *bodyStream) readDecoded(p []byte) (int, error) {
+	if s.decoded == nil {
+		dec, err := transfer.Decode(rawBody{s}, s.req.codings)
+		if err != nil {
+			return 0, err
+		}
+		s.decoded = dec
+	}
+	n, err := s.decoded.Read(p)
+	if err == io.EOF {
+		// Consume the framing left after the decoded data, including the
+		// last chunk.
+		if _, err := io.Copy(io.Discard, rawBody{s}); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// readRaw runs the parser's body states, copying body bytes into p.
+func (s *bodyStream) readRaw(p []byte) (int, error) {
+	r, br := s.req, s.rr.br
+	if r.done() {
+		s.finish()
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r.dst, r.dstN = p, 0
+	defer func() { r.dst = nil }()
+	for {
+		buffered := br.Buffered()
+		data, _ := br.Peek(buffered)
+		readN, err := r.parse(data)
+		if err != nil {
+			return r.dstN, err
+		}
+		br.Discard(readN)
+		if r.done() {
+			s.finish()
+		}
+		if r.dstN > 0 || r.done() {
+			return r.dstN, nil
+		}
+		buffered -= readN
+		_, err = br.Peek(buffered + 1)
+		if err == errBufferFull {
+			return 0, ERROR_MALFORMED_CHUNK
+		} else if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *bodyStream) finish() {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	if s.onDone != nil {
+		s.onDone()
+	}
+}
+
+// rawBody reads the body with transfer codings still applied.
+type rawBody struct{ s *bodyStream }
+
+func (b rawBody) Read(p []byte) (int, error) {
+	return b.s.readRaw(p)
+}
+
+// BodyReader streams the body as it arrives on the connection, with any
+// transfer codings undone. Reads fail once a body limit is passed. After
+// Body has been called it reads the kept copy instead.
+func (r *Request) BodyReader() io.Reader {
+	if r.stream == nil {
+		return strings.NewReader("")
+	}
+	if r.stream.cached != nil {
+		return bytes.NewReader(r.stream.cached)
+	}
+	return r.stream
+}
+
+// maxBodyPrealloc caps how much Body allocates up front from a declared
+// Content-Length, which the client may be lying about.
+const maxBodyPrealloc = 1 << 20
+
+// Body reads the rest of the body into memory and keeps it, so every call
+// returns the same bytes. It is meant for small bodies; read errors are
+// reported by BodyReader.
+func (r *Request) Body() []byte {
+	s := r.stream
+	if s == nil {
+		return []byte{}
+	}
+	if s.cached == nil {
+		buf := bytes.NewBuffer(make([]byte, 0, min(max(r.contentLength, 512), maxBodyPrealloc)))
+		buf.ReadFrom(s)
+		s.cached = buf.Bytes()
+	}
+	return s.cached
+}
+
+// BodyString is Body as a string.
+func (r *Request) BodyString() string {
+	return string(r.Body())
+}
+
+// ReadBody is Body for bodies that may be large: it fails with
+// ERROR_BODY_TOO_LARGE rather than keep more than max bytes, and reports
+// read errors. The limit stays on the request, as with LimitBody.
+func (r *Request) ReadBody(max int64) ([]byte, error) {
+	r.LimitBody(max)
+	body := r.Body()
+	if int64(len(body)) > max {
+		return nil, ERROR_BODY_TOO_LARGE
+	}
+	if s := r.stream; s != nil && s.err != nil && s.err != io.EOF {
+		return nil, s.err
+	}
+	return body, nil
+}
+
+// DiscardBody skips whatever is left of the body. It gives up with
+// ERROR_BODY_TOO_LARGE rather than skip more than max bytes, and with
+// ERROR_BODY_NOT_SENT if the client is still waiting for "100 Continue".
+func (r *Request) DiscardBody(max int64) error {
+	if r.stream == nil {
+		return nil
+	}
+	if r.stream.expect != nil {
+		return ERROR_BODY_NOT_SENT
+	}
+	_, err := io.CopyN(io.Discard, r.stream, max+1)
+	if err == io.EOF {
+		return nil
+	}
+	if err == nil {
+		return ERROR_BODY_TOO_LARGE
+	}
+	return err
+}
+
+// ContentLength is the declared body length, or -1 for chunked bodies.
+func (r *Request) ContentLength() int64 {
+	return int64(r.contentLength)
+}
+
+// LimitBody makes body reads fail with ERROR_BODY_TOO_LARGE past n bytes.
+// It only ever tightens an existing limit.
+func (r *Request) LimitBody(n int64) {
+	s := r.stream
+	if s == nil || n <= 0 {
+		return
+	}
+	if s.limit == 0 || n < s.limit {
+		s.limit = n
+	}
+}
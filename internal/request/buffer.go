@@ -0,0 +1,82 @@
+package request
+
+import (
+	"fmt"
+	"io"
+)
+
+var errBufferFull = fmt.Errorf("read buffer full")
+
+// initialBufferBytes is where every connection's read buffer starts.
+const initialBufferBytes = 4096
+
+// readBuffer is a bufio.Reader-like buffer that doubles in size, up to max,
+// when a peek asks for more than fits.
+type readBuffer struct {
+	src  io.Reader
+	buf  []byte
+	r, w int
+	max  int
+	err  error
+}
+
+func newReadBuffer(src io.Reader, max int) *readBuffer {
+	return &readBuffer{src: src, buf: make([]byte, min(initialBufferBytes, max)), max: max}
+}
+
+// Buffered returns the number of bytes that can be peeked without reading.
+func (b *readBuffer) Buffered() int {
+	return b.w - b.r
+}
+
+// Discard drops the next n buffered bytes.
+func (b *readBuffer) Discard(n int) {
+	b.r += min(n, b.Buffered())
+}
+
+// Peek returns the next n bytes without consuming them, reading from the
+// source until they are there. With fewer bytes it also returns why:
+// errBufferFull when n is more than max, or the source's error.
+func (b *readBuffer) Peek(n int) ([]byte, error) {
+	if n > b.max {
+		return b.buf[b.r:b.w], errBufferFull
+	}
+	for b.Buffered() < n && b.err == nil {
+		b.fill(n)
+	}
+	if b.Buffered() < n {
+		return b.buf[b.r:b.w], b.err
+	}
+	return b.buf[b.r : b.r+n], nil
+}
+
+// fill makes room for n bytes and reads once from the source.
+func (b *readBuffer) fill(n int) {
+	if b.r > 0 {
+		copy(b.buf, b.buf[b.r:b.w])
+		b.w -= b.r
+		b.r = 0
+	}
+	if n > len(b.buf) {
+		size := len(b.buf)
+		for size < n {
+			size *= 2
+		}
+		buf := make([]byte, min(size, b.max))
+		copy(buf, b.buf[:b.w])
+		b.buf = buf
+	}
+	// Like bufio, give up on sources that keep returning nothing.
+	for range 100 {
+		m, err := b.src.Read(b.buf[b.w:])
+		b.w += m
+		if err != nil {
+			b.err = err
+			return
+		}
+		if m > 0 {
+			return
+		}
+	}
+	b.err = io.ErrNoProgress
+}
@@ -0,0 +1,145 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"http/internal/headers"
+	"strings"
+)
+
+var ERROR_MALFORMED_CHUNK = fmt.Errorf("malformed chunk")
+var ERROR_CHUNK_LINE_TOO_LONG = fmt.Errorf("chunk size line too long")
+var ERROR_CHUNK_TOO_LARGE = fmt.Errorf("chunk too large")
+var ERROR_BODY_TOO_LARGE = fmt.Errorf("body too large")
+
+// ChunkExtension is a single "name=value" (or bare "name") extension found
+// on a chunk-size line. Quoted values are unquoted.
+type ChunkExtension struct {
+	Name  string
+	Value string
+}
+
+// ChunkExtensionHook observes the extensions sent with each chunk. Extensions
+// are ignored when no hook is set.
+type ChunkExtensionHook func(size int, extensions []ChunkExtension)
+
+func hexValue(ch byte) (int, bool) {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return int(ch - '0'), true
+	case ch >= 'a' && ch <= 'f':
+		return int(ch-'a') + 10, true
+	case ch >= 'A' && ch <= 'F':
+		return int(ch-'A') + 10, true
+	}
+	return 0, false
+}
+
+// parseChunkSize parses the hex chunk-size of a size line, rejecting anything
+// that is not a hex digit and sizes above maxSize.
+func parseChunkSize(line []byte, maxSize int) (int, error) {
+	if len(line) == 0 {
+		return 0, ERROR_MALFORMED_CHUNK
+	}
+	size := 0
+	for _, ch := range line {
+		v, ok := hexValue(ch)
+		if !ok {
+			return 0, ERROR_MALFORMED_CHUNK
+		}
+		size = size*16 + v
+		if size > maxSize {
+			return 0, ERROR_CHUNK_TOO_LARGE
+		}
+	}
+	return size, nil
+}
+
+func trimBWS(s string) string {
+	return strings.TrimLeft(s, " \t")
+}
+
+// parseChunkExtensions parses the part of a size line after the first ';':
+// ext-name [ "=" ( token / quoted-string ) ] *( ";" ... ).
+func parseChunkExtensions(s string) ([]ChunkExtension, error) {
+	exts := []ChunkExtension{}
+	for {
+		s = trimBWS(s)
+		end := strings.IndexAny(s, "=; \t")
+		if end == -1 {
+			end = len(s)
+		}
+		ext := ChunkExtension{Name: s[:end]}
+		if ext.Name == "" || !headers.IsToken(ext.Name) {
+			return nil, ERROR_MALFORMED_CHUNK
+		}
+		s = trimBWS(s[end:])
+		if strings.HasPrefix(s, "=") {
+			s = trimBWS(s[1:])
+			if strings.HasPrefix(s, "\"") {
+				value, rest, err := parseQuoted(s)
+				if err != nil {
+					return nil, err
+				}
+				ext.Value = value
+				s = rest
+			} else {
+				end = strings.IndexAny(s, "; \t")
+				if end == -1 {
+					end = len(s)
+				}
+				ext.Value = s[:end]
+				if ext.Value == "" || !headers.IsToken(ext.Value) {
+					return nil, ERROR_MALFORMED_CHUNK
+				}
+				s = s[end:]
+			}
+			s = trimBWS(s)
+		}
+		exts = append(exts, ext)
+		if s == "" {
+			return exts, nil
+		}
+		if s[0] != ';' {
+			return nil, ERROR_MALFORMED_CHUNK
+		}
+		s = s[1:]
+	}
+}
+
+// parseQuoted reads a quoted-string at the start of s, returning its unescaped
+// content and whatever follows the closing quote.
+func parseQuoted(s string) (string, string, error) {
+	b := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", ERROR_MALFORMED_CHUNK
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return "", "", ERROR_MALFORMED_CHUNK
+}
+
+// parseChunkLine splits a size line into the chunk size and its extensions.
+func parseChunkLine(line []byte, maxSize int) (int, []ChunkExtension, error) {
+	sizePart, extPart, hasExt := bytes.Cut(line, []byte(";"))
+	if !hasExt {
+		size, err := parseChunkSize(sizePart, maxSize)
+		return size, nil, err
+	}
+	size, err := parseChunkSize(bytes.TrimRight(sizePart, " \t"), maxSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	exts, err := parseChunkExtensions(string(extPart))
+	if err != nil {
+		return 0, nil, err
+	}
+	return size, exts, nil
+}
@@ -0,0 +1,30 @@
+package request
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// ConnInfo describes the connection a request arrived on.
+type ConnInfo struct {
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	// TLS is nil for plain-text connections.
+	TLS *tls.ConnectionState
+}
+
+// RemoteAddr is the client's address, or nil if it is not known.
+func (r *Request) RemoteAddr() net.Addr {
+	return r.conn.RemoteAddr
+}
+
+// LocalAddr is the address the request was received on.
+func (r *Request) LocalAddr() net.Addr {
+	return r.conn.LocalAddr
+}
+
+// TLS describes the TLS session the request was received over; it is nil
+// for plain-text connections.
+func (r *Request) TLS() *tls.ConnectionState {
+	return r.conn.TLS
+}
@@ -0,0 +1,51 @@
+package request
+
+import (
+	"http/internal/headers"
+	"strings"
+)
+
+// Cookie is one name/value pair from the Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Cookies parses the Cookie header into its pairs, in the order sent.
+// Quoted values are unquoted. Pairs that are not name=value with a valid
+// name are skipped. Several Cookie fields are read as one.
+func (r *Request) Cookies() []Cookie {
+	value, ok := r.headers.Get("Cookie")
+	if !ok {
+		return nil
+	}
+	cookies := []Cookie{}
+	// Repeated fields were joined with commas, which cookie values may
+	// not contain.
+	for _, pair := range strings.FieldsFunc(value, func(c rune) bool { return c == ';' || c == ',' }) {
+		name, v, found := strings.Cut(strings.TrimSpace(pair), "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || !headers.IsToken(name) {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = v[1 : len(v)-1]
+		}
+		if strings.ContainsAny(v, "\"\\ \t") {
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: v})
+	}
+	return cookies
+}
+
+// Cookie returns the first cookie called name.
+func (r *Request) Cookie(name string) (Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Cookie{}, false
+}
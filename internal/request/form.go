@@ -0,0 +1,71 @@
+package request
+
+import (
+	"io"
+	"mime"
+	"net/url"
+)
+
+// MaxFormBytes caps the urlencoded body read by Form and PostForm.
+const MaxFormBytes = 10 << 20
+
+type forms struct {
+	parsed bool
+	form   url.Values
+	post   url.Values
+	err    error
+}
+
+// Form returns the urlencoded body fields followed by the query parameters,
+// merged into one map. It is parsed on first use, which reads the body.
+func (r *Request) Form() (url.Values, error) {
+	r.parseForm()
+	return r.forms.form, r.forms.err
+}
+
+// PostForm returns only the urlencoded body fields of a POST, PUT or PATCH.
+func (r *Request) PostForm() (url.Values, error) {
+	r.parseForm()
+	return r.forms.post, r.forms.err
+}
+
+func (r *Request) parseForm() {
+	f := r.forms
+	if f.parsed {
+		return
+	}
+	f.parsed = true
+	f.post = url.Values{}
+	if r.hasFormBody() {
+		body, err := io.ReadAll(io.LimitReader(r.BodyReader(), MaxFormBytes+1))
+		if err == nil && len(body) > MaxFormBytes {
+			err = ERROR_BODY_TOO_LARGE
+		}
+		if err == nil {
+			f.post, err = url.ParseQuery(string(body))
+		}
+		f.err = err
+	}
+	f.form = url.Values{}
+	for name, values := range f.post {
+		f.form[name] = append([]string{}, values...)
+	}
+	values, err := url.ParseQuery(r.target.RawQuery)
+	for name, v := range values {
+		f.form[name] = append(f.form[name], v...)
+	}
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (r *Request) hasFormBody() bool {
+	switch r.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+	default:
+		return false
+	}
+	ct, _ := r.headers.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(ct)
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
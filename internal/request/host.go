@@ -0,0 +1,78 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+var ERROR_MISSING_HOST = fmt.Errorf("HTTP/1.1 request without a Host header")
+var ERROR_INVALID_HOST = fmt.Errorf("invalid or repeated Host header")
+
+// checkHost enforces RFC 9112, section 3.2: HTTP/1.1 requests carry exactly
+// one Host field, unless RepeatedHost lets through repeats that agree. The
+// authority the request is for is kept for Host.
+func (r *Request) checkHost() error {
+	values := r.headers.Values("host")
+	if len(values) == 0 {
+		if r.RequestLine.HttpVersion == "1.1" {
+			return ERROR_MISSING_HOST
+		}
+		values = []string{""}
+	}
+	for _, v := range values[1:] {
+		if !r.opts.RepeatedHost || v != values[0] {
+			return ERROR_INVALID_HOST
+		}
+	}
+	host := values[0]
+	if host != "" && !validHost(host) {
+		return ERROR_INVALID_HOST
+	}
+	switch r.target.Form {
+	case AbsoluteForm, AuthorityForm:
+		// The target's authority wins over the Host field.
+		host = r.target.Host
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if r.target.Port != "" {
+			host += ":" + r.target.Port
+		}
+	}
+	r.host = strings.ToLower(host)
+	return nil
+}
+
+// validHost reports whether h is a uri-host with an optional port.
+func validHost(h string) bool {
+	host, port := h, ""
+	if i := strings.LastIndexByte(h, ':'); i != -1 && !strings.HasSuffix(h, "]") {
+		host, port = h[:i], h[i+1:]
+		if !isPort(port) {
+			return false
+		}
+	}
+	if strings.HasPrefix(host, "[") {
+		return strings.HasSuffix(host, "]") && net.ParseIP(host[1:len(host)-1]) != nil
+	}
+	if host == "" {
+		return false
+	}
+	for _, ch := range host {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case strings.ContainsRune("-._~%!$&'()*+;=", ch):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Host is the authority the request is for: the host and optional port of
+// an absolute-form or authority-form target, else the Host field. It is
+// lowercased, and empty for HTTP/1.0 requests that name no host.
+func (r *Request) Host() string {
+	return r.host
+}
@@ -0,0 +1,38 @@
+package request
+
+import "fmt"
+
+var ERROR_REQUEST_LINE_TOO_LONG = fmt.Errorf("request line too long")
+var ERROR_HEADERS_TOO_LARGE = fmt.Errorf("header section too large")
+
+// Limits bounds how much a single request may make the parser buffer.
+type Limits struct {
+	// MaxRequestLineBytes is the longest request line accepted, CRLF
+	// excluded.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes caps the header section, every field line and its
+	// CRLF included.
+	MaxHeaderBytes int
+	// MaxHeaderFields caps the number of field lines in the header
+	// section, and separately in the trailers. Zero means no limit.
+	MaxHeaderFields int
+	// MaxBufferBytes caps how far the read buffer grows. Every request
+	// line, field line and chunk-size line must fit in it.
+	MaxBufferBytes int
+	// MaxChunkLineBytes is the longest chunk-size line accepted, CRLF excluded.
+	MaxChunkLineBytes int
+	// MaxChunkSize is the largest size a single chunk may declare.
+	MaxChunkSize int
+	// MaxChunkedBodyBytes caps the total decoded size of a chunked body.
+	MaxChunkedBodyBytes int
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8000,
+	MaxHeaderBytes:      1 << 20,
+	MaxHeaderFields:     100,
+	MaxBufferBytes:      1 << 16,
+	MaxChunkLineBytes:   1024,
+	MaxChunkSize:        1 << 24,
+	MaxChunkedBodyBytes: 1 << 26,
+}
@@ -0,0 +1,52 @@
+package request
+
+import (
+	"fmt"
+	"http/internal/headers"
+	"sync"
+)
+
+var ERROR_INVALID_METHOD = fmt.Errorf("invalid method")
+var ERROR_UNKNOWN_METHOD = fmt.Errorf("unknown method")
+
+var (
+	methodsMu sync.RWMutex
+	// Methods are case-sensitive, so they are stored as sent.
+	methods = map[string]bool{
+		"GET":     true,
+		"HEAD":    true,
+		"POST":    true,
+		"PUT":     true,
+		"DELETE":  true,
+		"CONNECT": true,
+		"OPTIONS": true,
+		"TRACE":   true,
+		"PATCH":   true,
+	}
+)
+
+// RegisterMethod lets requests use a method beyond those of RFC 9110 and
+// PATCH, e.g. WebDAV's PROPFIND.
+func RegisterMethod(method string) error {
+	if method == "" || !headers.IsToken(method) {
+		return ERROR_INVALID_METHOD
+	}
+	methodsMu.Lock()
+	defer methodsMu.Unlock()
+	methods[method] = true
+	return nil
+}
+
+// checkMethod tells a method that is not a token (ERROR_INVALID_METHOD)
+// from a well-formed one nobody registered (ERROR_UNKNOWN_METHOD).
+func checkMethod(method string) error {
+	if method == "" || !headers.IsToken(method) {
+		return ERROR_INVALID_METHOD
+	}
+	methodsMu.RLock()
+	defer methodsMu.RUnlock()
+	if !methods[method] {
+		return ERROR_UNKNOWN_METHOD
+	}
+	return nil
+}
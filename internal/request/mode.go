@@ -0,0 +1,81 @@
+package request
+
+import (
+	"bytes"
+	"http/internal/headers"
+)
+
+// ParseMode picks how forgiving the parser is. Strict follows RFC 9112 to
+// the letter and suits internet-facing servers; Lenient interoperates with
+// sloppy internal clients. For anything in between, use ParserOptions.
+type ParseMode int
+
+const (
+	// Strict requires CRLF line endings, single spaces in the request
+	// line, no whitespace before a field's colon, no obs-fold and only
+	// registered transfer codings.
+	Strict ParseMode = iota
+	// Lenient turns on every ParserOptions field.
+	Lenient
+)
+
+func (m ParseMode) String() string {
+	if m == Lenient {
+		return "lenient"
+	}
+	return "strict"
+}
+
+// Options returns the ParserOptions the mode stands for.
+func (m ParseMode) Options() ParserOptions {
+	if m == Lenient {
+		return ParserOptions{
+			BareLF:           true,
+			LooseRequestLine: true,
+			SpaceBeforeColon: true,
+			ObsFold:          true,
+			UnknownCodings:   true,
+			RepeatedHost:     true,
+		}
+	}
+	return ParserOptions{}
+}
+
+// ParserOptions turn on, one by one, the deviations from RFC 9112 the
+// parser tolerates. The zero value is Strict.
+type ParserOptions struct {
+	// BareLF accepts lines ending in LF without CR, in the head, the
+	// chunked framing and the trailers.
+	BareLF bool
+	// LooseRequestLine skips empty lines before the request line and
+	// accepts runs of whitespace around its parts.
+	LooseRequestLine bool
+	// SpaceBeforeColon accepts whitespace between a field name and its
+	// colon.
+	SpaceBeforeColon bool
+	// ObsFold unfolds continuation lines into the previous field.
+	ObsFold bool
+	// UnknownCodings accepts transfer codings besides the registered ones;
+	// such bodies are passed on undecoded.
+	UnknownCodings bool
+	// RepeatedHost accepts repeated Host fields that agree.
+	RepeatedHost bool
+}
+
+// fields is what applies to field sections.
+func (o ParserOptions) fields() headers.ParseOptions {
+	return headers.ParseOptions{BareLF: o.BareLF, SpaceBeforeColon: o.SpaceBeforeColon, ObsFold: o.ObsFold}
+}
+
+// nextLine finds the end of the line at the start of data, returning its
+// length and the length of the terminator, or -1 if the line is incomplete.
+func (o ParserOptions) nextLine(data []byte) (int, int) {
+	if o.BareLF {
+		idx := bytes.IndexByte(data, '\n')
+		if idx > 0 && data[idx-1] == '\r' {
+			return idx - 1, 2
+		}
+		return idx, 1
+	}
+	return bytes.Index(data, SEPARATOR), len(SEPARATOR)
+}
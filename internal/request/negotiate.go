@@ -0,0 +1,51 @@
+package request
+
+import (
+	"http/internal/headers"
+	"slices"
+)
+
+// negotiate picks from offers by the preferences in field. Without the
+// field every offer is acceptable and the first is taken.
+func (r *Request) negotiate(field string, offers []string) string {
+	value, ok := r.headers.Get(field)
+	if !ok {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+	return headers.Negotiate(headers.ParsePreferences(value), offers...)
+}
+
+// Negotiate picks the media type to respond with from offers, by the Accept
+// header. Earlier offers win ties; "" means none is acceptable and the
+// response should be 406 Not Acceptable.
+func (r *Request) Negotiate(offers ...string) string {
+	return r.negotiate("Accept", offers)
+}
+
+// NegotiateCharset is Negotiate for Accept-Charset.
+func (r *Request) NegotiateCharset(offers ...string) string {
+	return r.negotiate("Accept-Charset", offers)
+}
+
+// NegotiateEncoding picks a content coding from offers by Accept-Encoding.
+// "identity" is acceptable unless the client refuses it, and is what a
+// request without Accept-Encoding gets. "" means no offer is acceptable;
+// unless identity was refused, the body is then sent without coding.
+func (r *Request) NegotiateEncoding(offers ...string) string {
+	value, ok := r.headers.Get("Accept-Encoding")
+	if !ok {
+		if slices.Contains(offers, "identity") {
+			return "identity"
+		}
+		return ""
+	}
+	prefs := headers.ParsePreferences(value)
+	if _, ok := headers.Weight(prefs, "identity"); !ok {
+		// Least preferred, but acceptable.
+		prefs = append(prefs, headers.Preference{Value: "identity", Q: 0.001})
+	}
+	return headers.Negotiate(prefs, offers...)
+}
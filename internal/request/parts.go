@@ -0,0 +1,59 @@
+package request
+
+import (
+	"http/internal/headers"
+	"io"
+	"strings"
+)
+
+// Parts is a request that arrived already split up, as on an HTTP/2
+// stream, rather than as HTTP/1 text.
+type Parts struct {
+	Line    RequestLine
+	Headers *headers.Headers
+	// Body is read until EOF; nil means there is none.
+	Body io.Reader
+	Conn ConnInfo
+	// RejectEncodedControl fails requests whose path encodes NUL, CR or LF.
+	RejectEncodedControl bool
+}
+
+// NewRequest builds a request from its parts, checking the method, target,
+// Host and Content-Length as parsing would. Trailers that follow the body
+// are up to the caller to add to Trailers before Body reaches EOF.
+func NewRequest(p Parts) (*Request, error) {
+	r := newRequest(DefaultLimits)
+	r.conn = p.Conn
+	r.rejectControl = p.RejectEncodedControl
+	if p.Headers != nil {
+		r.headers = p.Headers
+	}
+	if err := checkMethod(p.Line.Method); err != nil {
+		return nil, err
+	}
+	if err := r.setRequestLine(p.Line); err != nil {
+		return nil, err
+	}
+	if err := r.checkHost(); err != nil {
+		return nil, err
+	}
+	// Without a Content-Length the body's size is not known up front.
+	r.contentLength = -1
+	if p.Body == nil {
+		r.contentLength = 0
+	}
+	if _, ok := r.headers.Get("content-length"); ok {
+		length, err := contentLength(r.headers)
+		if err != nil {
+			return nil, err
+		}
+		r.contentLength = length
+	}
+	r.state = StateDone
+	body := p.Body
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	r.stream = &bodyStream{req: r, src: body}
+	return r, nil
+}
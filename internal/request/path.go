@@ -0,0 +1,54 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var ERROR_INVALID_PATH = fmt.Errorf("invalid request path")
+var ERROR_ENCODED_CONTROL = fmt.Errorf("encoded control character in request path")
+
+// CleanPath percent-decodes the path of an origin-form request target and
+// removes its dot segments, so "/a/%2e%2e/b" and "/b" name the same thing.
+// The query is not part of the result. With rejectControl set, encoded NUL,
+// CR and LF are an error.
+func CleanPath(target string, rejectControl bool) (string, error) {
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		target = target[:i]
+	}
+	p, err := url.PathUnescape(target)
+	if err != nil {
+		return "", ERROR_INVALID_PATH
+	}
+	if rejectControl && strings.ContainsAny(p, "\x00\r\n") {
+		return "", ERROR_ENCODED_CONTROL
+	}
+	return RemoveDotSegments(p), nil
+}
+
+// RemoveDotSegments resolves "." and ".." in an absolute path as in RFC
+// 3986, section 5.2.4. ".." never climbs above the root.
+func RemoveDotSegments(p string) string {
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+		case "..":
+			// out[0] is the empty segment before the leading slash.
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, seg)
+			continue
+		}
+		// A trailing dot segment leaves a trailing slash.
+		if last {
+			out = append(out, "")
+		}
+	}
+	return strings.Join(out, "/")
+}
@@ -2,19 +2,28 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"http/internal/headers"
+	"http/internal/transfer"
 	"io"
 	"strconv"
+	"strings"
 )
 
 type parserState string
 
 const (
-	StateInit    parserState = "init"
-	StateHeaders parserState = "headers"
-	StateDone    parserState = "done"
-	StateBody    parserState = "body"
+	StateInit         parserState = "init"
+	StateHeaders      parserState = "headers"
+	StateDone         parserState = "done"
+	StateBody         parserState = "body"
+	StateBodyData     parserState = "body-data"
+	StateChunkSize    parserState = "chunk-size"
+	StateChunkData    parserState = "chunk-data"
+	StateChunkDataEnd parserState = "chunk-data-end"
+	StateTrailers     parserState = "trailers"
 )
 
 type RequestLine struct {
@@ -24,51 +33,131 @@ type RequestLine struct {
 }
 
 type Request struct {
-	RequestLine RequestLine
-	state       parserState
-	headers     *headers.Headers
-	body        string
+	RequestLine    RequestLine
+	conn           ConnInfo
+	target         Target
+	host           string
+	path           string
+	rejectControl  bool
+	headerBytes    int
+	state          parserState
+	headers        *headers.Headers
+	trailers       *headers.Headers
+	limits         Limits
+	onChunkExt     ChunkExtensionHook
+	contentLength  int
+	bodyRead       int
+	chunkRemaining int
+	codings        []string
+	opts           ParserOptions
+	ctx            context.Context
+	// dst receives body bytes while the body stream is being read.
+	dst    []byte
+	dstN   int
+	stream *bodyStream
+	// forms is shared with copies so the body is only parsed once.
+	forms *forms
+	// params holds the path parameters set by a router.
+	params map[string]string
 }
 
-func getInt(headers *headers.Headers, name string, defaultValue int) int {
-	valStr, exists := headers.Get(name)
-	if !exists {
-		return defaultValue
+// Context is cancelled when the client goes away while the request is being
+// handled. It defaults to context.Background().
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
 	}
-	value, err := strconv.Atoi(valStr)
-	if err != nil {
-		return defaultValue
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r using ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// contentLength reads the Content-Length field. Repeated fields, which
+// arrive comma-joined, must all carry the same value.
+func contentLength(headers *headers.Headers) (int, error) {
+	value, ok := headers.Get("content-length")
+	if !ok {
+		return 0, nil
+	}
+	length := -1
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.Trim(v, "0123456789") != "" {
+			return 0, ERROR_INVALID_CONTENT_LENGTH
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || (length != -1 && n != length) {
+			return 0, ERROR_INVALID_CONTENT_LENGTH
+		}
+		length = n
 	}
-	return value
+	return length, nil
 }
 
-func newRequest() *Request {
-	return &Request{
-		state:   StateInit,
-		headers: headers.NewHeaders(),
-		body:    "",
+func newRequest(limits Limits) *Request {
+	r := &Request{
+		state:    StateInit,
+		headers:  headers.NewHeaders(),
+		trailers: headers.NewHeaders(),
+		limits:   limits,
+		forms:    &forms{},
 	}
+	r.headers.SetMaxFields(limits.MaxHeaderFields)
+	r.trailers.SetMaxFields(limits.MaxHeaderFields)
+	return r
 }
 
 var ERROR_MALFORMED_REQUESTLINE = fmt.Errorf("malformed request-line")
 var ERROR_UNSUPPORTED_HTTP_VERSION = fmt.Errorf("unsupported http version")
+var ERROR_INVALID_CONTENT_LENGTH = fmt.Errorf("invalid content-length")
+var ERROR_CONFLICTING_FRAMING = fmt.Errorf("both content-length and transfer-encoding present")
 var SEPARATOR = []byte("\r\n")
 
-func parseRequestLine(b []byte) (*RequestLine, int, error) {
-	idx := bytes.Index(b, SEPARATOR)
+func parseRequestLine(b []byte, opts ParserOptions) (*RequestLine, int, error) {
+	read := 0
+	if opts.LooseRequestLine {
+		// Empty lines before the request line are skipped.
+		for {
+			idx, sep := opts.nextLine(b[read:])
+			if idx != 0 {
+				break
+			}
+			read += sep
+		}
+	}
+	idx, sep := opts.nextLine(b[read:])
 	if idx == -1 {
 		return nil, 0, nil
 	}
-	startLine := b[:idx]
-	read := idx + len(SEPARATOR)
-	parts := bytes.Split(startLine, []byte(" "))
+	startLine := b[read : read+idx]
+	read += idx + sep
+	var parts [][]byte
+	if opts.LooseRequestLine {
+		parts = bytes.Fields(startLine)
+	} else {
+		if bytes.IndexByte(startLine, '\n') != -1 {
+			return nil, 0, ERROR_MALFORMED_REQUESTLINE
+		}
+		parts = bytes.Split(startLine, []byte(" "))
+	}
 	if len(parts) != 3 {
 		return nil, 0, ERROR_MALFORMED_REQUESTLINE
 	}
 	httpParts := bytes.Split(parts[2], []byte("/"))
-	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" || string(httpParts[1]) != "1.1" {
+	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" || !isVersion(httpParts[1]) {
 		return nil, 0, ERROR_MALFORMED_REQUESTLINE
 	}
+	if v := string(httpParts[1]); v != "1.1" && v != "1.0" {
+		return nil, 0, ERROR_UNSUPPORTED_HTTP_VERSION
+	}
+	if err := checkMethod(string(parts[0])); err != nil {
+		return nil, 0, err
+	}
 	rl := &RequestLine{
 		Method:        string(parts[0]),
 		RequestTarget: string(parts[1]),
@@ -79,6 +168,11 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 
 }
 
+// isVersion reports whether v looks like an HTTP version, "DIGIT.DIGIT".
+func isVersion(v []byte) bool {
+	return len(v) == 3 && v[0] >= '0' && v[0] <= '9' && v[1] == '.' && v[2] >= '0' && v[2] <= '9'
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	read := 0
 outer:
@@ -86,48 +180,167 @@ outer:
 		currentData := data[read:]
 		switch r.state {
 		case StateInit:
-			rl, n, err := parseRequestLine(currentData)
+			rl, n, err := parseRequestLine(currentData, r.opts)
 			if err != nil {
 				return 0, err
 			}
 			if n == 0 {
+				if len(currentData) > r.limits.MaxRequestLineBytes {
+					return 0, ERROR_REQUEST_LINE_TOO_LONG
+				}
 				break outer
 			}
-			r.RequestLine = *rl
+			// n may include empty lines skipped by lenient parsing, so
+			// the line is measured from its parts.
+			if len(rl.Method)+len(rl.RequestTarget)+len("HTTP/1.1")+2 > r.limits.MaxRequestLineBytes {
+				return 0, ERROR_REQUEST_LINE_TOO_LONG
+			}
+			if err := r.setRequestLine(*rl); err != nil {
+				return 0, err
+			}
 			read += n
 			r.state = StateHeaders
 		case StateHeaders:
-			n, done, err := r.headers.Parse(currentData)
-			if err != nil {
+			n, done, err := r.headers.ParseWith(currentData, r.opts.fields())
+			if errors.Is(err, headers.ERROR_TOO_MANY_FIELDS) {
+				return 0, fmt.Errorf("%w: %w", ERROR_HEADERS_TOO_LARGE, err)
+			} else if err != nil {
 				return 0, err
 			}
+			r.headerBytes += n
+			if r.headerBytes > r.limits.MaxHeaderBytes {
+				return 0, ERROR_HEADERS_TOO_LARGE
+			}
 			if n == 0 {
+				if r.headerBytes+len(currentData) > r.limits.MaxHeaderBytes {
+					return 0, ERROR_HEADERS_TOO_LARGE
+				}
 				break outer
 			}
 
 			read += n
 			if done {
+				if err := r.checkHost(); err != nil {
+					return 0, err
+				}
 				r.state = StateBody
 			}
 		case StateBody:
-			//currentData = current chunk of raw bytes being processed
-			//length = total expected body size
-			length := getInt(r.headers, "content-length", 0)
-			if length == 0 {
+			te, hasTE := r.headers.Get("transfer-encoding")
+			if _, ok := r.headers.Get("content-length"); ok && hasTE {
+				// Proxies that disagree on which one wins can be made
+				// to see different requests.
+				return 0, ERROR_CONFLICTING_FRAMING
+			}
+			if hasTE {
+				codings, err := transfer.Parse(te)
+				if errors.Is(err, transfer.ERROR_UNKNOWN_CODING) && r.opts.UnknownCodings {
+					codings, err = transfer.Known(te), nil
+				}
+				if err != nil {
+					return 0, err
+				}
+				// Only chunked framing tells where the body ends.
+				if len(codings) == 0 || codings[len(codings)-1] != "chunked" {
+					return 0, transfer.ERROR_CHUNKED_NOT_LAST
+				}
+				r.codings = codings[:len(codings)-1]
+				r.contentLength = -1
+				r.state = StateChunkSize
+				break
+			}
+			length, err := contentLength(r.headers)
+			if err != nil {
+				return 0, err
+			}
+			r.contentLength = length
+			if r.contentLength == 0 {
 				r.state = StateDone
 				break
 			}
-			remaining := length - len(r.body)
-			// toRead = data left to be read
-			toRead := min(remaining, len(currentData))
+			r.state = StateBodyData
+		case StateBodyData:
+			// Body bytes are only consumed while the body stream is read.
+			toRead := min(r.contentLength-r.bodyRead, len(currentData), len(r.dst)-r.dstN)
 			if toRead == 0 {
 				break outer
 			}
-			// r.body == string that accumulates the body data as its parsed
-			r.body += string(currentData[:toRead])
-			// read = counter tracking how many bytes have been consumed from currentData
+			r.dstN += copy(r.dst[r.dstN:], currentData[:toRead])
 			read += toRead
-			if len(r.body) == length {
+			r.bodyRead += toRead
+			if r.bodyRead == r.contentLength {
+				r.state = StateDone
+			}
+		case StateChunkSize:
+			if r.dst == nil {
+				// Reading the headers stops here; chunks are parsed as
+				// the body stream is read.
+				break outer
+			}
+			idx, sep := r.opts.nextLine(currentData)
+			if idx == -1 {
+				if len(currentData) > r.limits.MaxChunkLineBytes {
+					return 0, ERROR_CHUNK_LINE_TOO_LONG
+				}
+				break outer
+			}
+			if idx > r.limits.MaxChunkLineBytes {
+				return 0, ERROR_CHUNK_LINE_TOO_LONG
+			}
+			size, exts, err := parseChunkLine(currentData[:idx], r.limits.MaxChunkSize)
+			if err != nil {
+				return 0, err
+			}
+			if exts != nil && r.onChunkExt != nil {
+				r.onChunkExt(size, exts)
+			}
+			read += idx + sep
+			if size == 0 {
+				r.state = StateTrailers
+				break
+			}
+			if r.bodyRead+size > r.limits.MaxChunkedBodyBytes {
+				return 0, ERROR_BODY_TOO_LARGE
+			}
+			r.chunkRemaining = size
+			r.state = StateChunkData
+		case StateChunkData:
+			toRead := min(r.chunkRemaining, len(currentData), len(r.dst)-r.dstN)
+			if toRead == 0 {
+				break outer
+			}
+			r.dstN += copy(r.dst[r.dstN:], currentData[:toRead])
+			read += toRead
+			r.bodyRead += toRead
+			r.chunkRemaining -= toRead
+			if r.chunkRemaining == 0 {
+				r.state = StateChunkDataEnd
+			}
+		case StateChunkDataEnd:
+			// Every chunk's data ends in CRLF
+			sep := len(SEPARATOR)
+			if r.opts.BareLF && len(currentData) > 0 && currentData[0] == '\n' {
+				sep = 1
+			} else if len(currentData) < len(SEPARATOR) {
+				break outer
+			} else if !bytes.HasPrefix(currentData, SEPARATOR) {
+				return 0, ERROR_MALFORMED_CHUNK
+			}
+			read += sep
+			r.state = StateChunkSize
+		case StateTrailers:
+			// The last chunk is followed by a field section like the
+			// headers, which may be empty.
+			n, done, err := r.trailers.ParseWith(currentData, r.opts.fields())
+			if err != nil {
+				return 0, err
+			}
+			if n == 0 {
+				break outer
+			}
+			read += n
+			if done {
+				r.keepDeclaredTrailers()
 				r.state = StateDone
 			}
 		case StateDone:
@@ -138,50 +351,194 @@ outer:
 
 }
 
+// setRequestLine takes the request line, splitting up its target.
+func (r *Request) setRequestLine(rl RequestLine) error {
+	var err error
+	r.RequestLine = rl
+	r.target, err = ParseTarget(rl.Method, rl.RequestTarget)
+	if err != nil {
+		return err
+	}
+	r.path = rl.RequestTarget
+	if r.target.Form == OriginForm || r.target.Form == AbsoluteForm {
+		r.path, err = CleanPath(r.target.Path, r.rejectControl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *Request) done() bool {
 	return r.state == StateDone
 }
 
+func (r *Request) headersDone() bool {
+	return r.state != StateInit && r.state != StateHeaders && r.state != StateBody
+}
+
+// Target is the request target split into its parts.
+func (r *Request) Target() Target {
+	return r.target
+}
+
+// Path is the request target's path, percent-decoded and with dot segments
+// removed. Targets without a path, like "*", are returned as is.
+func (r *Request) Path() string {
+	return r.path
+}
+
+// PathValue returns the path parameter name captured by the route that
+// matched the request, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.params[name]
+}
+
+// WithPathValues returns a shallow copy of r with the given path
+// parameters, replacing any it had.
+func (r *Request) WithPathValues(params map[string]string) *Request {
+	r2 := *r
+	r2.params = params
+	return &r2
+}
+
 func (r *Request) Headers() *headers.Headers {
 	return r.headers
 }
 
-func (r *Request) Body() string {
-	return r.body
+// Trailers holds the fields sent after a chunked body. They are only there
+// once the body has been read to its end.
+func (r *Request) Trailers() *headers.Headers {
+	return r.trailers
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := newRequest()
-	buf := make([]byte, 8192)
-	bufLen := 0
-	for !request.done() {
-		n, err := reader.Read(buf[bufLen:])
-
-		// Handle EOF: if we get EOF and no data, we're done reading
-		if err == io.EOF {
-			if n == 0 {
-				return nil, fmt.Errorf("unexpected EOF: request incomplete (state: %s)", request.state)
-			}
-			// If n > 0, process the final chunk of data before handling EOF
-		} else if err != nil {
+// Reader reads consecutive requests off a connection. All reads go through
+// one buffer shared by every parser state, so bytes past the end of a
+// request stay buffered for the next one. The buffer grows as needed up to
+// Limits.MaxBufferBytes.
+type Reader struct {
+	Limits Limits
+	// Options say what the parser tolerates; the zero value is Strict.
+	Options ParserOptions
+	// Conn is attached to every request read.
+	Conn ConnInfo
+	// RejectEncodedControl fails requests whose path encodes NUL, CR or LF.
+	RejectEncodedControl bool
+	// OnChunkExtension, when set, is called for every chunk of a chunked
+	// body that carries extensions.
+	OnChunkExtension ChunkExtensionHook
+	// OnContinue, when set, is called before the first read of a body whose
+	// request carries "Expect: 100-continue", to send the interim response.
+	OnContinue func() error
+	// OnBodyRead, when set, is called once a request's body has been read
+	// to its end, or right away for requests without one.
+	OnBodyRead func()
+	br         *readBuffer
+	body       *bodyStream
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		Limits: DefaultLimits,
+		br:     newReadBuffer(reader, DefaultLimits.MaxBufferBytes),
+	}
+}
+
+// Buffered returns the number of bytes already read from the connection
+// that have not been consumed by a request yet.
+func (rr *Reader) Buffered() int {
+	return rr.br.Buffered()
+}
+
+// ReadRequest reads the next request's line and headers. Its body is left
+// on the connection for BodyReader; whatever the previous request's handler
+// did not read is discarded first.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	if rr.body != nil {
+		if _, err := io.Copy(io.Discard, rr.body); err != nil {
 			return nil, err
 		}
-
-		bufLen += n
-		readN, err := request.parse(buf[:bufLen])
+		rr.body = nil
+	}
+	request := newRequest(rr.Limits)
+	request.onChunkExt = rr.OnChunkExtension
+	request.opts = rr.Options
+	request.rejectControl = rr.RejectEncodedControl
+	request.conn = rr.Conn
+	rr.br.max = rr.Limits.MaxBufferBytes
+	for {
+		buffered := rr.br.Buffered()
+		data, _ := rr.br.Peek(buffered)
+		readN, err := request.parse(data)
 		if err != nil {
 			return nil, err
 		}
-		//Checks only when the buffer is full and no progress has been made
-		if bufLen >= len(buf) && readN == 0 {
-			return nil, fmt.Errorf("request too large or malformed: buffer full but unable to parse (state: %s)", request.state)
+		rr.br.Discard(readN)
+		if request.headersDone() {
+			rr.body = newBodyStream(request, rr)
+			return request, nil
 		}
-		if n == 0 && readN == 0 {
+		buffered -= readN
+
+		// Peeking one byte past what is buffered reads more from the
+		// connection.
+		_, err = rr.br.Peek(buffered + 1)
+		if err == errBufferFull {
+			// A single line does not fit in the buffer.
+			if request.state == StateInit {
+				return nil, ERROR_REQUEST_LINE_TOO_LONG
+			}
+			return nil, ERROR_HEADERS_TOO_LARGE
+		} else if err == io.EOF {
+			// Connection closed cleanly between requests
+			if request.state == StateInit && buffered == 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("unexpected EOF: request incomplete (state: %s)", request.state)
+		} else if err == io.ErrNoProgress {
 			return nil, fmt.Errorf("stuck: no data read and no parsing progress (state: %s)", request.state)
+		} else if err != nil {
+			return nil, err
 		}
-		copy(buf, buf[readN:bufLen])
-		bufLen -= readN
 	}
+}
+
+// Fill blocks until more bytes arrive or the connection fails, keeping what
+// was read for the next request. Fill does nothing if bytes are already
+// buffered.
+func (rr *Reader) Fill() error {
+	if rr.br.Buffered() > 0 {
+		return nil
+	}
+	_, err := rr.br.Peek(1)
+	return err
+}
 
-	return request, nil
+// HasPrefix reports whether the bytes after what was parsed so far start
+// with prefix, reading only as many as it takes to tell.
+func (rr *Reader) HasPrefix(prefix []byte) (bool, error) {
+	for n := 1; n <= len(prefix); n++ {
+		data, err := rr.br.Peek(n)
+		if err != nil {
+			return false, err
+		}
+		if data[n-1] != prefix[n-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Detach returns a reader for everything after what was parsed so far: the
+// bytes already buffered, then the connection. It is how a hijacked
+// connection gets back what the parser read ahead. The Reader must not be
+// used afterwards.
+func (rr *Reader) Detach() io.Reader {
+	buffered := bytes.Clone(rr.br.buf[rr.br.r:rr.br.w])
+	rr.br.Discard(len(buffered))
+	return io.MultiReader(bytes.NewReader(buffered), rr.br.src)
+}
+
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
 }
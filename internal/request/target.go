@@ -0,0 +1,95 @@
+package request
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TargetForm is one of the four request-target forms of RFC 9112,
+// section 3.2.
+type TargetForm int
+
+const (
+	// OriginForm is a path and query: "/where?q=now".
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a full URI, sent to proxies:
+	// "http://www.example.org/pub/WWW/".
+	AbsoluteForm
+	// AuthorityForm is a host and port, only used by CONNECT:
+	// "www.example.com:80".
+	AuthorityForm
+	// AsteriskForm is "*", only used by OPTIONS for the server as a whole.
+	AsteriskForm
+)
+
+func (f TargetForm) String() string {
+	switch f {
+	case AbsoluteForm:
+		return "absolute-form"
+	case AuthorityForm:
+		return "authority-form"
+	case AsteriskForm:
+		return "asterisk-form"
+	}
+	return "origin-form"
+}
+
+// Target is a request target split into its parts. Scheme, Host and Port
+// are only set for absolute-form and authority-form; Path and RawQuery are
+// still encoded.
+type Target struct {
+	Form     TargetForm
+	Scheme   string
+	Host     string
+	Port     string
+	Path     string
+	RawQuery string
+}
+
+// ParseTarget works out the form of a request target and splits it up.
+// Which forms are allowed depends on the method.
+func ParseTarget(method, target string) (Target, error) {
+	switch {
+	case method == "CONNECT":
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || !isPort(port) {
+			return Target{}, ERROR_MALFORMED_REQUESTLINE
+		}
+		return Target{Form: AuthorityForm, Host: host, Port: port}, nil
+	case target == "*":
+		if method != "OPTIONS" {
+			return Target{}, ERROR_MALFORMED_REQUESTLINE
+		}
+		return Target{Form: AsteriskForm}, nil
+	case strings.HasPrefix(target, "/"):
+		path, query, _ := strings.Cut(target, "?")
+		return Target{Form: OriginForm, Path: path, RawQuery: query}, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil || u.Fragment != "" {
+		return Target{}, ERROR_MALFORMED_REQUESTLINE
+	}
+	port := u.Port()
+	if port != "" && !isPort(port) {
+		return Target{}, ERROR_MALFORMED_REQUESTLINE
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return Target{
+		Form:     AbsoluteForm,
+		Scheme:   strings.ToLower(u.Scheme),
+		Host:     u.Hostname(),
+		Port:     port,
+		Path:     path,
+		RawQuery: u.RawQuery,
+	}, nil
+}
+
+func isPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 0 && n <= 65535 && !strings.HasPrefix(port, "+")
+}
@@ -0,0 +1,41 @@
+package request
+
+import "strings"
+
+// Fields that frame, route or authenticate a request must come before the
+// body and are never taken from trailers.
+var forbiddenTrailers = map[string]bool{
+	"authorization":     true,
+	"cache-control":     true,
+	"connection":        true,
+	"content-encoding":  true,
+	"content-length":    true,
+	"content-range":     true,
+	"content-type":      true,
+	"cookie":            true,
+	"expect":            true,
+	"host":              true,
+	"max-forwards":      true,
+	"range":             true,
+	"te":                true,
+	"trailer":           true,
+	"transfer-encoding": true,
+}
+
+// keepDeclaredTrailers drops trailer fields that were not announced in the
+// Trailer header, along with those that are not allowed as trailers.
+func (r *Request) keepDeclaredTrailers() {
+	declared := map[string]bool{}
+	for _, name := range r.headers.List("Trailer") {
+		declared[strings.ToLower(name)] = true
+	}
+	drop := []string{}
+	r.trailers.Foreach(func(n, v string) {
+		if key := strings.ToLower(n); !declared[key] || forbiddenTrailers[key] {
+			drop = append(drop, n)
+		}
+	})
+	for _, n := range drop {
+		r.trailers.Delete(n)
+	}
+}
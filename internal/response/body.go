@@ -0,0 +1,141 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"http/internal/headers"
+	"io"
+	"os"
+)
+
+// Header returns the headers WriteBodyReader sends when the handler has not
+// written any yet.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// WriteBodyReader streams r as the body. When the headers are not written
+// yet, Header() is sent with Content-Length if size is known (>= 0) and no
+// trailers were declared, and with chunked encoding otherwise. When they
+// are, the body is framed the way they declared.
+//
+// Chunked bodies are left open so trailers can follow: call Finish, or let
+// the server do it once the handler returns.
+func (w *Writer) WriteBodyReader(r io.Reader, size int64) error {
+	if !w.headersWritten {
+		h := w.Header()
+		if size >= 0 && len(w.trailerNames) == 0 {
+			h.Set("content-length", fmt.Sprintf("%d", size))
+			h.Delete("transfer-encoding")
+		} else {
+			h.Delete("content-length")
+			h.Set("transfer-encoding", "chunked")
+		}
+		if err := w.WriteHeaders(*h); err != nil {
+			return err
+		}
+	}
+	if w.chunked {
+		w.chunking = true
+	}
+	if w.head {
+		return nil
+	}
+	if !w.chunking && size >= 0 {
+		n, err := w.ReadFrom(io.LimitReader(r, size))
+		if err == nil && n < size {
+			err = fmt.Errorf("body ended after %d of %d bytes: %w", n, size, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			// A short body leaves the connection unusable.
+			w.closing = true
+		}
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.WriteBody(buf[:n]); werr != nil {
+				return werr
+			}
+			// A body of unknown length may be a stream the client
+			// should see as it comes, unless it is still short enough
+			// to get a Content-Length.
+			if w.held == nil {
+				if werr := w.Flush(); werr != nil {
+					return werr
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			w.closing = true
+			return err
+		}
+	}
+}
+
+// WriteBodyFrom is WriteBodyReader with the size taken from r when it can
+// tell: regular files, and readers with a Len method such as bytes.Reader.
+func (w *Writer) WriteBodyFrom(r io.Reader) error {
+	return w.WriteBodyReader(r, readerSize(r))
+}
+
+// readerSize is how much is left to read from r, or -1 if unknown.
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len())
+	case *os.File:
+		info, err := v.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		pos, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - pos
+	}
+	return -1
+}
+
+// ReadFrom copies r into the body until EOF, sending Header() first if no
+// headers were written. Chunked bodies get a chunk per read. Bodies sent as
+// they are, with nothing to filter or record, are copied straight to the
+// connection, which lets the OS send files without copying them (sendfile).
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if !w.headersWritten {
+		if err := w.WriteHeaders(*w.Header()); err != nil {
+			return 0, err
+		}
+	}
+	switch {
+	case w.head:
+		return 0, nil
+	case w.finished:
+		return 0, ERROR_BODY_FINISHED
+	case w.held != nil || w.filtered != nil || w.recording != nil || !bodyAllowed(w.status):
+		return io.Copy(bodyWriter{w}, r)
+	case w.chunking:
+		n, err := io.Copy(chunkWriter{rawWriter{w}}, r)
+		w.bodyBytes += n
+		return n, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if w.ctx != nil && w.ctx.Err() != nil {
+		w.closing = true
+		return 0, ERROR_CLIENT_ABORTED
+	}
+	n, err := io.Copy(w.writer, r)
+	w.bodyBytes += n
+	return n, w.checkSent(err)
+}
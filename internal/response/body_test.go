@@ -0,0 +1,216 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"http/internal/headers"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBodyReader(t *testing.T) {
+	read := func(out *bytes.Buffer) (*http.Response, string) {
+		res, err := http.ReadResponse(bufio.NewReader(out), nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(body)
+	}
+
+	// Test: Known sizes are sent with Content-Length
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.Header().Set("Content-Type", "text/plain")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteBodyReader(strings.NewReader("hello"), 5))
+	assert.True(t, w.KeepAlive())
+	res, body := read(out)
+	assert.Equal(t, int64(5), res.ContentLength)
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+	assert.Equal(t, "hello", body)
+
+	// Test: Unknown sizes fall back to chunked, with trailers
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteBodyReader(iotest.OneByteReader(strings.NewReader("streamed")), -1))
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Done", "yes")
+	require.NoError(t, w.Finish(trailers))
+	res, body = read(out)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "streamed", body)
+	assert.Equal(t, "yes", res.Trailer.Get("X-Done"))
+
+	// Test: Headers already written decide the framing
+	out.Reset()
+	w = NewWriter(out)
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*h))
+	require.NoError(t, w.WriteBodyReader(strings.NewReader("framed"), 6))
+	require.NoError(t, w.Finish(nil))
+	_, body = read(out)
+	assert.Equal(t, "framed", body)
+
+	// Test: A body shorter than announced fails and closes the connection
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	err := w.WriteBodyReader(strings.NewReader("short"), 10)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.False(t, w.KeepAlive())
+}
+
+func TestAutoContentLength(t *testing.T) {
+	unframed := func() headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+		return *h
+	}
+	read := func(out *bytes.Buffer) (*http.Response, string) {
+		res, err := http.ReadResponse(bufio.NewReader(out), nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(body)
+	}
+
+	// Test: Small bodies are buffered and sent with Content-Length
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(unframed()))
+	_, err := w.WriteBody([]byte("hello, "))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("world"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", out.String())
+	assert.False(t, w.KeepAlive())
+	require.NoError(t, w.Finish(nil))
+	assert.True(t, w.KeepAlive())
+	res, body := read(out)
+	assert.Equal(t, int64(12), res.ContentLength)
+	assert.Equal(t, "hello, world", body)
+
+	// Test: Large bodies switch to chunked
+	out.Reset()
+	w = NewWriter(out)
+	big := strings.Repeat("x", autoLengthLimit)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(unframed()))
+	_, err = w.WriteBody([]byte("a"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte(big))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(nil))
+	assert.True(t, w.KeepAlive())
+	res, body = read(out)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "a"+big, body)
+
+	// Test: HTTP/1.0 clients get large bodies unframed
+	out.Reset()
+	w = NewWriter(out)
+	w.SetHTTP10()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(unframed()))
+	_, err = w.WriteBody([]byte(big + "y"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(nil))
+	assert.False(t, w.KeepAlive())
+	assert.NotContains(t, out.String(), "Content-Length")
+	assert.Contains(t, out.String(), "Connection: close\r\n")
+
+	// Test: Responses without a body are not held back
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(304))
+	require.NoError(t, w.WriteHeaders(unframed()))
+	assert.Contains(t, out.String(), "\r\n\r\n")
+	assert.True(t, w.KeepAlive())
+}
+
+func TestSuppressBody(t *testing.T) {
+	// Test: Framing is computed as for GET, the body is dropped
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.SuppressBody()
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(nil))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\n", out.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Chunked bodies send neither chunks nor trailers
+	out.Reset()
+	w = NewWriter(out)
+	w.SuppressBody()
+	require.NoError(t, w.DeclareTrailers("X-Sum"))
+	require.NoError(t, w.WriteBodyReader(strings.NewReader("streamed"), -1))
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Sum", "1")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n", out.String())
+}
+
+func TestReadFrom(t *testing.T) {
+	// Test: Framed bodies are copied as they are
+	out := &bytes.Buffer{}
+	w := NewBufferedWriter(out, 0)
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(5)))
+	n, err := w.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nhello"))
+	assert.Equal(t, int64(5), w.BodyBytes())
+	assert.Equal(t, StatusOK, w.Status())
+
+	// Test: Chunked bodies get a chunk per read
+	out.Reset()
+	w = NewWriter(out)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err = w.WriteChunk(nil)
+	require.NoError(t, err)
+	_, err = w.ReadFrom(iotest.OneByteReader(strings.NewReader("abc")))
+	require.NoError(t, err)
+	require.NoError(t, w.FinishChunked(nil))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n1\r\na\r\n1\r\nb\r\n1\r\nc\r\n0\r\n\r\n"))
+	assert.Equal(t, int64(3), w.BodyBytes())
+
+	// Test: WriteBodyFrom knows the size of files and in-memory readers
+	file, err := os.CreateTemp(t.TempDir(), "body")
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString("0123456789")
+	require.NoError(t, err)
+	_, err = file.Seek(4, io.SeekStart)
+	require.NoError(t, err)
+	for _, r := range []io.Reader{file, bytes.NewBufferString("456789")} {
+		out.Reset()
+		w = NewWriter(out)
+		require.NoError(t, w.WriteBodyFrom(r))
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\n456789", out.String())
+	}
+
+	// Test: Other readers are sent chunked
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteBodyFrom(io.MultiReader(strings.NewReader("abc"))))
+	require.NoError(t, w.Finish(nil))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n", out.String())
+}
@@ -0,0 +1,50 @@
+package response
+
+import (
+	"fmt"
+	"http/internal/headers"
+)
+
+var ERROR_NOT_CHUNKED = fmt.Errorf("response headers do not declare chunked transfer encoding")
+var ERROR_BODY_FINISHED = fmt.Errorf("response body already finished")
+
+// WriteChunk sends p as the next chunk of a body whose headers declared
+// "Transfer-Encoding: chunked"; the Writer does the framing. An empty p
+// writes nothing, since a zero-size chunk would end the body: use
+// FinishChunked for that. For HTTP/1.0 clients p is sent unframed.
+func (w *Writer) WriteChunk(p []byte) (int, error) {
+	if !w.headersWritten || !w.declaredChunked {
+		return 0, ERROR_NOT_CHUNKED
+	}
+	if w.finished {
+		return 0, ERROR_BODY_FINISHED
+	}
+	if w.chunked {
+		w.chunking = true
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return w.WriteBody(p)
+}
+
+// FinishChunked ends a chunked body with the last chunk and the optional
+// trailers. Unlike Finish it reports misuse: calling it on a response that
+// is not chunked, or twice.
+func (w *Writer) FinishChunked(trailers *headers.Headers) error {
+	if !w.headersWritten || !w.declaredChunked {
+		return ERROR_NOT_CHUNKED
+	}
+	if w.finished {
+		return ERROR_BODY_FINISHED
+	}
+	if w.chunked {
+		w.chunking = true
+	}
+	if !w.chunking && len(w.chain) == 0 {
+		// HTTP/1.0: the body ends when the connection closes.
+		w.finished = true
+		return nil
+	}
+	return w.Finish(trailers)
+}
@@ -0,0 +1,134 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"http/internal/headers"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chunkedHeaders() headers.Headers {
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	return *h
+}
+
+func TestWriteChunk(t *testing.T) {
+	// Test: Chunks are framed by the writer and read back intact
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders()))
+	for _, c := range []string{"hello", "", ", ", strings.Repeat("x", 300)} {
+		n, err := w.WriteChunk([]byte(c))
+		require.NoError(t, err)
+		assert.Equal(t, len(c), n)
+	}
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Sum", "42")
+	require.NoError(t, w.FinishChunked(trailers))
+	raw := out.String()
+	assert.Contains(t, raw, "\r\n\r\n5\r\nhello\r\n2\r\n, \r\n12c\r\n")
+	assert.True(t, strings.HasSuffix(raw, "0\r\nX-Sum: 42\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, "+strings.Repeat("x", 300), string(body))
+	assert.Equal(t, "42", res.Trailer.Get("X-Sum"))
+
+	// Test: Chunks need chunked headers to be written first
+	w = NewWriter(&bytes.Buffer{})
+	_, err = w.WriteChunk([]byte("early"))
+	require.ErrorIs(t, err, ERROR_NOT_CHUNKED)
+	require.ErrorIs(t, w.FinishChunked(nil), ERROR_NOT_CHUNKED)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(*GetDefaultHeaders(5))
+	_, err = w.WriteChunk([]byte("fixed"))
+	require.ErrorIs(t, err, ERROR_NOT_CHUNKED)
+
+	// Test: Nothing can follow the last chunk
+	out.Reset()
+	w = NewWriter(out)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(chunkedHeaders())
+	require.NoError(t, w.FinishChunked(nil))
+	_, err = w.WriteChunk([]byte("late"))
+	require.ErrorIs(t, err, ERROR_BODY_FINISHED)
+	require.ErrorIs(t, w.FinishChunked(nil), ERROR_BODY_FINISHED)
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n0\r\n\r\n"))
+
+	// Test: HTTP/1.0 clients get the chunks unframed
+	out.Reset()
+	w = NewWriter(out)
+	w.SetHTTP10()
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(chunkedHeaders())
+	w.WriteChunk([]byte("plain "))
+	w.WriteChunk([]byte("bytes"))
+	require.NoError(t, w.FinishChunked(nil))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nplain bytes"))
+	assert.NotContains(t, out.String(), "Transfer-Encoding")
+}
+
+func TestTrailers(t *testing.T) {
+	read := func(out *bytes.Buffer) *http.Response {
+		res, err := http.ReadResponse(bufio.NewReader(out), nil)
+		require.NoError(t, err)
+		_, err = io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res
+	}
+
+	// Test: Declared trailers are announced and sent after the body
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.DeclareTrailers("X-Checksum", "X-Count"))
+	w.WriteStatusLine(StatusOK)
+	require.NoError(t, w.WriteBodyReader(strings.NewReader("data"), 4))
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	trailers.Set("x-count", "1")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Contains(t, out.String(), "Trailer: X-Checksum\r\nTrailer: X-Count\r\n")
+	res := read(out)
+	assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"))
+	assert.Equal(t, "1", res.Trailer.Get("X-Count"))
+
+	// Test: Undeclared and forbidden trailers are refused
+	out.Reset()
+	w = NewWriter(out)
+	require.ErrorIs(t, w.DeclareTrailers("Content-Length"), ERROR_FORBIDDEN_TRAILER)
+	require.ErrorIs(t, w.DeclareTrailers("Bad Name"), ERROR_FORBIDDEN_TRAILER)
+	require.NoError(t, w.DeclareTrailers("X-Checksum"))
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(chunkedHeaders())
+	require.ErrorIs(t, w.DeclareTrailers("X-Late"), ERROR_HEADERS_ALREADY_WRITTEN)
+	trailers.Set("X-Other", "no")
+	require.ErrorIs(t, w.WriteTrailers(trailers), ERROR_UNDECLARED_TRAILER)
+
+	// Test: Names from a Trailer header count as declared
+	out.Reset()
+	w = NewWriter(out)
+	h := chunkedHeaders()
+	h.Add("Trailer", "X-Checksum, X-Count")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(h)
+	w.WriteChunk([]byte("x"))
+	trailers.Delete("X-Other")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "abc", read(out).Trailer.Get("X-Checksum"))
+
+	// Test: Trailers cannot be announced on a response with a fixed length
+	w = NewWriter(&bytes.Buffer{})
+	w.DeclareTrailers("X-Checksum")
+	w.WriteStatusLine(StatusOK)
+	require.ErrorIs(t, w.WriteHeaders(*GetDefaultHeaders(4)), ERROR_TRAILERS_NEED_CHUNKED)
+}
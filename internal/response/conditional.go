@@ -0,0 +1,127 @@
+package response
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"http/internal/headers"
+	"strings"
+	"time"
+)
+
+// Validators identify the current representation of a resource for
+// conditional requests. Either may be left empty.
+type Validators struct {
+	// ETag is a quoted entity-tag, weak if prefixed with "W/".
+	ETag         string
+	LastModified time.Time
+}
+
+// StrongETag derives a strong entity-tag from the representation's bytes.
+func StrongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf(`"%x"`, sum[:16])
+}
+
+// WeakETag makes a weak entity-tag of tag, for representations that are
+// equivalent but not byte for byte the same, e.g. compressed on the fly.
+func WeakETag(tag string) string {
+	if strings.HasPrefix(tag, "W/") {
+		return tag
+	}
+	return "W/" + tag
+}
+
+// Set adds the validators to a response header block.
+func (v Validators) Set(h *headers.Headers) {
+	if v.ETag != "" {
+		h.Set("etag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.Set("last-modified", v.LastModified.UTC().Format(TimeFormat))
+	}
+}
+
+// etagMatches reports whether any entity-tag in list matches etag, by
+// strong comparison (weak tags never match) or by weak comparison (only
+// the opaque tags are compared). "*" matches any current representation.
+func etagMatches(list []string, etag string, weak bool) bool {
+	for _, tag := range list {
+		if tag == "*" {
+			return true
+		}
+		if etag == "" {
+			continue
+		}
+		if weak {
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if !strings.HasPrefix(tag, "W/") && !strings.HasPrefix(etag, "W/") && tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// modifiedSince reports whether lastModified is later than the HTTP date in
+// value. ok is false when either is missing or the date is invalid, in
+// which case the condition is ignored.
+func modifiedSince(value string, lastModified time.Time) (modified, ok bool) {
+	date, ok := parseHTTPDate(strings.TrimSpace(value))
+	if !ok || lastModified.IsZero() {
+		return false, false
+	}
+	return lastModified.Truncate(time.Second).After(date), true
+}
+
+// EvaluatePreconditions applies the conditional fields of a request with
+// method and header block h to a resource with validators v, in the order
+// of RFC 9110 section 13.2.2. It returns 0 when the request should be
+// served, and StatusNotModified or StatusPreconditionFailed otherwise.
+func EvaluatePreconditions(method string, h *headers.Headers, v Validators) StatusCode {
+	safe := method == "GET" || method == "HEAD"
+	if _, ok := h.Get("if-match"); ok {
+		if !etagMatches(h.List("if-match"), v.ETag, false) {
+			return StatusPreconditionFailed
+		}
+	} else if value, ok := h.Get("if-unmodified-since"); ok {
+		if modified, ok := modifiedSince(value, v.LastModified); ok && modified {
+			return StatusPreconditionFailed
+		}
+	}
+	if _, ok := h.Get("if-none-match"); ok {
+		if etagMatches(h.List("if-none-match"), v.ETag, true) {
+			if safe {
+				return StatusNotModified
+			}
+			return StatusPreconditionFailed
+		}
+	} else if value, ok := h.Get("if-modified-since"); ok && safe {
+		if modified, ok := modifiedSince(value, v.LastModified); ok && !modified {
+			return StatusNotModified
+		}
+	}
+	return 0
+}
+
+// CheckPreconditions evaluates the conditional request fields and, when
+// they decide the outcome, writes the 304 or 412 response with Header()
+// and the validators. It reports whether it did, in which case the handler
+// is done.
+func (w *Writer) CheckPreconditions(method string, h *headers.Headers, v Validators) bool {
+	status := EvaluatePreconditions(method, h, v)
+	if status == 0 {
+		return false
+	}
+	out := w.Header()
+	v.Set(out)
+	out.Delete("transfer-encoding")
+	if status == StatusNotModified {
+		out.Delete("content-length")
+	} else {
+		out.Set("content-length", "0")
+	}
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*out)
+	return true
+}
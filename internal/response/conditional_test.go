@@ -0,0 +1,81 @@
+package response
+
+import (
+	"bytes"
+	"http/internal/headers"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluatePreconditions(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	v := Validators{ETag: `"abc"`, LastModified: modTime}
+	eval := func(method string, fields ...string) StatusCode {
+		h := headers.NewHeaders()
+		for i := 0; i < len(fields); i += 2 {
+			h.Add(fields[i], fields[i+1])
+		}
+		return EvaluatePreconditions(method, h, v)
+	}
+	before := modTime.Add(-time.Hour).Format(TimeFormat)
+	after := modTime.Add(time.Hour).Format(TimeFormat)
+
+	// Test: Unconditional requests are served
+	assert.Equal(t, StatusCode(0), eval("GET"))
+
+	// Test: If-None-Match uses weak comparison
+	assert.Equal(t, StatusNotModified, eval("GET", "If-None-Match", `"x", W/"abc"`))
+	assert.Equal(t, StatusNotModified, eval("HEAD", "If-None-Match", "*"))
+	assert.Equal(t, StatusCode(0), eval("GET", "If-None-Match", `"x"`))
+	assert.Equal(t, StatusPreconditionFailed, eval("PUT", "If-None-Match", "*"))
+
+	// Test: If-Modified-Since only applies to GET and HEAD
+	assert.Equal(t, StatusNotModified, eval("GET", "If-Modified-Since", modTime.Format(TimeFormat)))
+	assert.Equal(t, StatusCode(0), eval("GET", "If-Modified-Since", before))
+	assert.Equal(t, StatusCode(0), eval("POST", "If-Modified-Since", after))
+	assert.Equal(t, StatusCode(0), eval("GET", "If-Modified-Since", "yesterday"))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	assert.Equal(t, StatusCode(0), eval("GET", "If-None-Match", `"x"`, "If-Modified-Since", after))
+
+	// Test: If-Match uses strong comparison
+	assert.Equal(t, StatusCode(0), eval("PUT", "If-Match", `"abc"`))
+	assert.Equal(t, StatusPreconditionFailed, eval("PUT", "If-Match", `W/"abc"`))
+	assert.Equal(t, StatusCode(0), eval("PUT", "If-Match", "*"))
+
+	// Test: If-Unmodified-Since is ignored when If-Match is present
+	assert.Equal(t, StatusPreconditionFailed, eval("PUT", "If-Unmodified-Since", before))
+	assert.Equal(t, StatusCode(0), eval("PUT", "If-Unmodified-Since", after))
+	assert.Equal(t, StatusCode(0), eval("PUT", "If-Match", `"abc"`, "If-Unmodified-Since", before))
+
+	// Test: A failed If-Match wins over a matching If-None-Match
+	assert.Equal(t, StatusPreconditionFailed, eval("GET", "If-Match", `"x"`, "If-None-Match", `"abc"`))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	v := Validators{ETag: StrongETag([]byte("hello")), LastModified: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	req := headers.NewHeaders()
+	req.Set("If-None-Match", v.ETag)
+
+	// Test: A matching request gets a 304 with the validators
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.Header().Set("Cache-Control", "max-age=60")
+	assert.True(t, w.CheckPreconditions("GET", req, v))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\nCache-Control: max-age=60\r\nETag: "+v.ETag+"\r\nLast-Modified: Fri, 01 Mar 2024 12:00:00 GMT\r\n\r\n", out.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Other requests are left to the handler
+	out.Reset()
+	w = NewWriter(out)
+	assert.False(t, w.CheckPreconditions("GET", headers.NewHeaders(), v))
+	assert.Empty(t, out.String())
+
+	// Test: Entity-tags
+	assert.Equal(t, v.ETag, StrongETag([]byte("hello")))
+	assert.NotEqual(t, v.ETag, StrongETag([]byte("hello!")))
+	assert.Equal(t, "W/"+v.ETag, WeakETag(v.ETag))
+	assert.Equal(t, "W/"+v.ETag, WeakETag(WeakETag(v.ETag)))
+}
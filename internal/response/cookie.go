@@ -0,0 +1,131 @@
+package response
+
+import (
+	"fmt"
+	"http/internal/headers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ERROR_INVALID_COOKIE = fmt.Errorf("invalid cookie")
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out, so browsers apply their own
+	// default.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// SameSiteNone requires Secure.
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is a cookie to set with a Set-Cookie field (RFC 6265). A zero
+// Expires is left out. MaxAge is in seconds: zero leaves it out, a negative
+// value deletes the cookie right away.
+type Cookie struct {
+	Name     string
+	Value    string
+	Path     string
+	Domain   string
+	Expires  time.Time
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// Serialize renders c as a Set-Cookie field value.
+func (c *Cookie) Serialize() (string, error) {
+	if c.Name == "" || !headers.IsToken(c.Name) {
+		return "", fmt.Errorf("%w: name %q", ERROR_INVALID_COOKIE, c.Name)
+	}
+	if !validCookieValue(c.Value) {
+		return "", fmt.Errorf("%w: value of %s", ERROR_INVALID_COOKIE, c.Name)
+	}
+	if !validAttribute(c.Path) || !validAttribute(c.Domain) {
+		return "", fmt.Errorf("%w: path or domain of %s", ERROR_INVALID_COOKIE, c.Name)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return "", fmt.Errorf("%w: SameSite=None without Secure on %s", ERROR_INVALID_COOKIE, c.Name)
+	}
+	b := strings.Builder{}
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	return b.String(), nil
+}
+
+// validCookieValue allows cookie-octets, optionally in double quotes.
+func validCookieValue(v string) bool {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// validAttribute rejects characters that would end or break an attribute.
+func validAttribute(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < ' ' || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// SetCookie adds a Set-Cookie field for c to the response. Each cookie gets
+// a field of its own, since their values may contain commas. It must be
+// called before the headers are written.
+func (w *Writer) SetCookie(c *Cookie) error {
+	if w.headersWritten {
+		return fmt.Errorf("%w: headers already written", ERROR_INVALID_COOKIE)
+	}
+	v, err := c.Serialize()
+	if err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, v)
+	return nil
+}
@@ -0,0 +1,72 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieSerialize(t *testing.T) {
+	// Test: All attributes are rendered
+	c := &Cookie{
+		Name:     "session",
+		Value:    "abc123",
+		Path:     "/app",
+		Domain:   ".example.com",
+		Expires:  time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SameSiteNone,
+	}
+	v, err := c.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc123; Path=/app; Domain=example.com; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None", v)
+
+	// Test: Negative MaxAge deletes the cookie
+	v, err = (&Cookie{Name: "old", MaxAge: -1, SameSite: SameSiteLax}).Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, "old=; Max-Age=0; SameSite=Lax", v)
+
+	// Test: Invalid cookies are refused
+	for _, c := range []*Cookie{
+		{Name: "bad name", Value: "v"},
+		{Name: "n", Value: "a;b"},
+		{Name: "n", Value: "a,b"},
+		{Name: "n", Value: "a b"},
+		{Name: "n", Value: "v", Path: "/;Domain=evil"},
+		{Name: "n", Value: "v", SameSite: SameSiteNone},
+	} {
+		_, err := c.Serialize()
+		require.ErrorIs(t, err, ERROR_INVALID_COOKIE, c.Name+"="+c.Value)
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+
+	// Test: Every cookie gets its own Set-Cookie field
+	require.NoError(t, w.SetCookie(&Cookie{Name: "a", Value: "1", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}))
+	require.NoError(t, w.SetCookie(&Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	require.Error(t, w.SetCookie(&Cookie{Name: "c", Value: "x\r\ny"}))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteBodyReader(strings.NewReader("ok"), 2))
+	assert.Equal(t, 2, strings.Count(out.String(), "Set-Cookie: "))
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	cookies := res.Cookies()
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "a", cookies[0].Name)
+	assert.Equal(t, 2030, cookies[0].Expires.Year())
+	assert.True(t, cookies[1].HttpOnly)
+
+	// Test: Cookies cannot be set once the headers are out
+	require.ErrorIs(t, w.SetCookie(&Cookie{Name: "late", Value: "1"}), ERROR_INVALID_COOKIE)
+}
@@ -0,0 +1,148 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"http/internal/headers"
+	"io"
+)
+
+// BodyFilter transforms a response body while it streams, e.g. to compress
+// it, inject markup or redact values. Filters never see the whole body at
+// once: Wrap gets the writer the transformed bytes go to and returns the
+// writer the original bytes are written into.
+type BodyFilter interface {
+	// Headers adjusts the header block before it is sent, e.g. to set
+	// Content-Encoding. Content-Length is always dropped by the Writer.
+	Headers(h *headers.Headers)
+	// Wrap starts filtering one response. Close flushes anything the
+	// filter still holds; it must not close dst.
+	Wrap(dst io.Writer) io.WriteCloser
+}
+
+// ConditionalFilter is a BodyFilter that may pass on a response once its
+// status and headers are known, e.g. to leave images uncompressed. A
+// response no filter applies to is framed as if it had none.
+type ConditionalFilter interface {
+	BodyFilter
+	Applies(status StatusCode, h headers.Headers) bool
+}
+
+// AddFilter registers f for the response; filters run in the order they were
+// added. It must be called before WriteHeaders.
+//
+// A filtered response is always sent chunked: WriteBody then takes plain
+// body bytes, the Writer frames the filtered output itself and Finish ends
+// the body.
+func (w *Writer) AddFilter(f BodyFilter) {
+	w.filters = append(w.filters, f)
+}
+
+// applicableFilters drops the conditional filters that pass on a response
+// with headers h.
+func (w *Writer) applicableFilters(h headers.Headers) []BodyFilter {
+	kept := w.filters[:0]
+	for _, f := range w.filters {
+		if c, ok := f.(ConditionalFilter); ok && !c.Applies(w.status, h) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// chunkWriter frames everything written to it as one chunk per Write.
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	frame := fmt.Appendf(nil, "%x\r\n", len(p))
+	frame = append(frame, p...)
+	frame = append(frame, "\r\n"...)
+	if _, err := c.w.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startFilters rewrites the header block for a filtered response and builds
+// the filter chain.
+func (w *Writer) startFilters(h headers.Headers) headers.Headers {
+	out := h.Clone()
+	for _, f := range w.filters {
+		f.Headers(out)
+	}
+	out.Delete("content-length")
+	var dst io.Writer = rawWriter{w}
+	if !w.http10 {
+		// Filters may add transfer codings; chunked always comes last.
+		if te, ok := out.Get("transfer-encoding"); ok && !out.HasToken("transfer-encoding", "chunked") {
+			out.Set("transfer-encoding", te+", chunked")
+		} else if !ok {
+			out.Set("transfer-encoding", "chunked")
+		}
+		dst = chunkWriter{dst}
+		w.chunking = true
+	}
+	for i := len(w.filters) - 1; i >= 0; i-- {
+		wc := w.filters[i].Wrap(dst)
+		w.chain = append(w.chain, wc)
+		dst = wc
+	}
+	w.filtered = dst
+	return *out
+}
+
+// Finish ends a body the Writer frames itself (filtered responses and
+// chunked WriteBodyReader calls): filters are flushed, then the last chunk
+// and the optional trailers are written. It does nothing for responses whose
+// handler frames the body, except to send headers held back for a
+// Content-Length. HTTP/1.0 clients get no last chunk or trailers. Aborted
+// responses are only flushed as they stand.
+func (w *Writer) Finish(trailers *headers.Headers) error {
+	if w.aborted {
+		if w.held != nil {
+			return w.flushHeld(false)
+		}
+		return nil
+	}
+	if w.held != nil {
+		return w.flushHeld(true)
+	}
+	if !bodyAllowed(w.status) {
+		return nil
+	}
+	if (!w.chunking && len(w.chain) == 0) || w.finished {
+		return nil
+	}
+	w.finished = true
+	// The chain was built back to front, so the first filter is last.
+	for i := len(w.chain) - 1; i >= 0; i-- {
+		if err := w.chain[i].Close(); err != nil {
+			return err
+		}
+	}
+	if !w.chunking {
+		return nil
+	}
+	if _, err := w.write([]byte("0\r\n")); err != nil {
+		return err
+	}
+	if trailers == nil {
+		trailers = headers.NewHeaders()
+	}
+	if w.preserveCase {
+		trailers = trailers.Clone()
+		trailers.SetPreserveCase(true)
+	}
+	var buf bytes.Buffer
+	if _, err := trailers.WriteTo(&buf); err != nil {
+		return err
+	}
+	_, err := w.write(buf.Bytes())
+	return err
+}
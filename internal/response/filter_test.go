@@ -0,0 +1,144 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"http/internal/headers"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redact replaces word in the body, holding back a possible partial match
+// until the next write.
+type redact struct {
+	word string
+}
+
+func (r redact) Headers(h *headers.Headers) {
+	h.Set("x-redacted", "true")
+}
+
+func (r redact) Wrap(dst io.Writer) io.WriteCloser {
+	return &redactWriter{word: r.word, dst: dst}
+}
+
+type redactWriter struct {
+	word    string
+	dst     io.Writer
+	pending []byte
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.pending = append(r.pending, p...)
+	out := bytes.ReplaceAll(r.pending, []byte(r.word), bytes.Repeat([]byte("*"), len(r.word)))
+	keep := min(len(r.word)-1, len(out))
+	r.pending = append([]byte{}, out[len(out)-keep:]...)
+	if _, err := r.dst.Write(out[:len(out)-keep]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *redactWriter) Close() error {
+	_, err := r.dst.Write(r.pending)
+	return err
+}
+
+type upper struct{}
+
+func (upper) Headers(h *headers.Headers) {}
+
+func (upper) Wrap(dst io.Writer) io.WriteCloser {
+	return upperWriter{dst}
+}
+
+type upperWriter struct {
+	dst io.Writer
+}
+
+func (u upperWriter) Write(p []byte) (int, error) {
+	return u.dst.Write(bytes.ToUpper(p))
+}
+
+func (u upperWriter) Close() error {
+	return nil
+}
+
+func TestBodyFilters(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.AddFilter(redact{word: "secret"})
+	w.AddFilter(upper{})
+	body := "the secret is not a sec" + "ret anymore"
+
+	// Test: Filters run in order across write boundaries, framed as chunked
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(len(body))))
+	for _, part := range []string{"the secret is ", "not a sec", "ret anymore"} {
+		_, err := w.WriteBody([]byte(part))
+		require.NoError(t, err)
+	}
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.Finish(trailers))
+
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, int64(-1), res.ContentLength)
+	assert.Equal(t, "true", res.Header.Get("X-Redacted"))
+	got, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, strings.ToUpper("the ****** is not a ****** anymore"), string(got))
+	assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"))
+
+	// Test: Finish is a no-op for unfiltered responses
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(2)))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(nil))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nok"))
+}
+
+func TestFilterHTTP10(t *testing.T) {
+	// Test: Filtered bodies to HTTP/1.0 clients are not chunked
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.SetHTTP10()
+	w.AddFilter(redact{word: "secret"})
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(*GetDefaultHeaders(42))
+	w.WriteBody([]byte("the secr"))
+	w.WriteBody([]byte("et is out"))
+	require.NoError(t, w.Finish(nil))
+	assert.NotContains(t, out.String(), "Transfer-Encoding")
+	assert.NotContains(t, out.String(), "Content-Length")
+	assert.True(t, strings.HasSuffix(out.String(), "Connection: close\r\n\r\nthe ****** is out"))
+	assert.False(t, w.KeepAlive())
+}
+
+func TestFilterWithoutBody(t *testing.T) {
+	// Test: 204 and 304 responses are not filtered or framed, and Finish
+	// adds no last chunk
+	for _, status := range []StatusCode{StatusNoContent, StatusNotModified} {
+		out := &bytes.Buffer{}
+		w := NewWriter(out)
+		w.AddFilter(redact{word: "secret"})
+		h := headers.NewHeaders()
+		h.Set("ETag", `"v1"`)
+		require.NoError(t, w.WriteStatusLine(status))
+		require.NoError(t, w.WriteHeaders(*h))
+		require.NoError(t, w.Finish(nil))
+		assert.Equal(t, fmt.Sprintf("HTTP/1.1 %d %s\r\nETag: \"v1\"\r\n\r\n", status, status.Text()), out.String())
+		assert.True(t, w.KeepAlive())
+	}
+}
@@ -0,0 +1,43 @@
+package response
+
+import (
+	"fmt"
+	"io"
+)
+
+var ERROR_NOT_HIJACKABLE = fmt.Errorf("connection cannot be hijacked")
+var ERROR_HIJACKED = fmt.Errorf("connection has been hijacked")
+
+// SetHijacker lets Hijack take over the connection through fn. The server
+// sets it for requests whose connection can be handed over.
+func (w *Writer) SetHijacker(fn func() (io.ReadWriteCloser, error)) {
+	w.hijacker = fn
+}
+
+// Hijack hands the connection over to the handler, which from then on
+// reads and writes it directly and must close it. What was written to the
+// Writer so far is sent first; later writes fail with ERROR_HIJACKED.
+// Reading the returned connection starts with whatever the client sent
+// after the request.
+func (w *Writer) Hijack() (io.ReadWriteCloser, error) {
+	if w.hijacked {
+		return nil, ERROR_HIJACKED
+	}
+	if w.hijacker == nil {
+		return nil, ERROR_NOT_HIJACKABLE
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	conn, err := w.hijacker()
+	if err != nil {
+		return nil, err
+	}
+	w.hijacked = true
+	return conn, nil
+}
+
+// Hijacked reports whether Hijack took the connection over.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
@@ -0,0 +1,43 @@
+package response
+
+import (
+	"bytes"
+	"http/internal/headers"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopConn struct {
+	*bytes.Buffer
+}
+
+func (nopConn) Close() error { return nil }
+
+func TestHijack(t *testing.T) {
+	// Test: Writers without a hijacker cannot be hijacked
+	_, err := NewWriter(&bytes.Buffer{}).Hijack()
+	assert.ErrorIs(t, err, ERROR_NOT_HIJACKABLE)
+
+	// Test: Buffered output is sent before the connection is handed over
+	out := &bytes.Buffer{}
+	w := NewBufferedWriter(out, 4096)
+	w.SetHijacker(func() (io.ReadWriteCloser, error) { return nopConn{out}, nil })
+	require.NoError(t, w.WriteStatusLine(StatusSwitchingProtocols))
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "test")
+	require.NoError(t, w.WriteHeaders(*h))
+	conn, err := w.Hijack()
+	require.NoError(t, err)
+	assert.True(t, w.Hijacked())
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\n\r\n", out.String())
+
+	// Test: The Writer is done with once hijacked
+	assert.ErrorIs(t, w.Flush(), ERROR_HIJACKED)
+	_, err = w.Hijack()
+	assert.ErrorIs(t, err, ERROR_HIJACKED)
+	conn.Write([]byte("raw"))
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\n\r\nraw", out.String())
+}
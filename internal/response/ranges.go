@@ -0,0 +1,192 @@
+package response
+
+import (
+	"cmp"
+	"crypto/rand"
+	"fmt"
+	"http/internal/headers"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ERROR_MALFORMED_RANGE = fmt.Errorf("malformed range")
+var ERROR_UNSATISFIABLE_RANGE = fmt.Errorf("range not satisfiable")
+var ERROR_TOO_MANY_RANGES = fmt.Errorf("too many ranges")
+
+// MaxRanges is how many ranges ParseRange accepts in one Range header.
+// Clients asking for more are better served the whole representation.
+const MaxRanges = 100
+
+// ByteRange is a satisfiable range of a representation, already clamped to
+// its size.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a "bytes=" Range header value against a representation of
+// the given size. Unsatisfiable ranges are dropped; if none are left the
+// error is ERROR_UNSATISFIABLE_RANGE. More than MaxRanges ranges fail with
+// ERROR_TOO_MANY_RANGES.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	unit, set, found := strings.Cut(value, "=")
+	if !found || strings.TrimSpace(unit) != "bytes" {
+		return nil, ERROR_MALFORMED_RANGE
+	}
+	specs := strings.Split(set, ",")
+	if len(specs) > MaxRanges {
+		return nil, ERROR_TOO_MANY_RANGES
+	}
+	ranges := []ByteRange{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, ERROR_MALFORMED_RANGE
+		}
+		if first == "" {
+			// suffix-range: the last N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, ERROR_MALFORMED_RANGE
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, ByteRange{Start: size - n, Length: n})
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, ERROR_MALFORMED_RANGE
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, ERROR_MALFORMED_RANGE
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, ByteRange{Start: start, Length: end - start + 1})
+	}
+	if len(ranges) == 0 {
+		return nil, ERROR_UNSATISFIABLE_RANGE
+	}
+	return ranges, nil
+}
+
+// CoalesceRanges merges overlapping and adjacent ranges, so no byte is sent
+// twice, and returns them in ascending order.
+func CoalesceRanges(ranges []ByteRange) []ByteRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b ByteRange) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	out := []ByteRange{}
+	for _, r := range sorted {
+		if n := len(out); n > 0 && r.Start <= out[n-1].Start+out[n-1].Length {
+			last := &out[n-1]
+			last.Length = max(last.Length, r.Start+r.Length-last.Start)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func newBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+func partHeader(boundary, contentType, contentRange string, first bool) []byte {
+	b := []byte{}
+	if !first {
+		b = append(b, "\r\n"...)
+	}
+	return fmt.Appendf(b, "--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, contentType, contentRange)
+}
+
+// WriteByteranges writes a complete 206 multipart/byteranges response for
+// ranges of content, with h as the base header set. Each part carries its
+// own Content-Type and Content-Range; Content-Length covers the whole body.
+func (w *Writer) WriteByteranges(h *headers.Headers, content io.ReaderAt, size int64, contentType string, ranges []ByteRange) error {
+	boundary := newBoundary()
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	length := int64(len(closing))
+	for i, r := range ranges {
+		length += int64(len(partHeader(boundary, contentType, r.ContentRange(size), i == 0))) + r.Length
+	}
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", fmt.Sprintf("%d", length))
+	if err := w.WriteStatusLine(StatusPartialContent); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	for i, r := range ranges {
+		if _, err := w.WriteBody(partHeader(boundary, contentType, r.ContentRange(size), i == 0)); err != nil {
+			return err
+		}
+		if _, err := io.Copy(bodyWriter{w}, io.NewSectionReader(content, r.Start, r.Length)); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteBody([]byte(closing))
+	return err
+}
+
+// httpDateFormats are the IMF-fixdate and the two obsolete formats recipients
+// must still accept (RFC 9110 section 5.6.7).
+var httpDateFormats = []string{TimeFormat, "Monday, 02-Jan-06 15:04:05 GMT", "Mon Jan _2 15:04:05 2006"}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	for _, format := range httpDateFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// IfRange reports whether a Range request carrying the If-Range value may be
+// answered with a partial response (RFC 9110 section 13.1.5). The validator
+// must match the current representation: an entity-tag by strong comparison
+// (weak tags never match), a date only if it equals lastModified and that is
+// a strong validator, i.e. at least a second old. Anything else means the
+// full representation is sent. An empty value always matches.
+func IfRange(value, etag string, lastModified time.Time) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return true
+	}
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return !strings.HasPrefix(value, "W/") && !strings.HasPrefix(etag, "W/") && value == etag
+	}
+	date, ok := parseHTTPDate(value)
+	if !ok || lastModified.IsZero() {
+		return false
+	}
+	lastModified = lastModified.Truncate(time.Second)
+	if time.Since(lastModified) < time.Second {
+		return false
+	}
+	return date.Equal(lastModified)
+}
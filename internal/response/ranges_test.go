@@ -0,0 +1,81 @@
+package response
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: Single and multiple ranges
+	ranges, err := ParseRange("bytes=0-4", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 5}}, ranges)
+	ranges, err = ParseRange("bytes=0-4, 10-, -5", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{0, 5}, {10, 90}, {95, 5}}, ranges)
+	assert.Equal(t, "bytes 95-99/100", ranges[2].ContentRange(100))
+
+	// Test: Ends past the representation are clamped
+	ranges, err = ParseRange("bytes=90-200,-500", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{90, 10}, {0, 100}}, ranges)
+
+	// Test: Unsatisfiable ranges are dropped
+	ranges, err = ParseRange("bytes=200-300,0-0", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{0, 1}}, ranges)
+	_, err = ParseRange("bytes=200-300", 100)
+	assert.Equal(t, ERROR_UNSATISFIABLE_RANGE, err)
+	_, err = ParseRange("bytes=-0", 100)
+	assert.Equal(t, ERROR_UNSATISFIABLE_RANGE, err)
+
+	// Test: Too many ranges
+	_, err = ParseRange("bytes="+strings.Repeat("0-0,", MaxRanges)+"1-1", 100)
+	assert.Equal(t, ERROR_TOO_MANY_RANGES, err)
+
+	// Test: Malformed ranges
+	for _, value := range []string{"items=0-4", "bytes=4", "bytes=5-1", "bytes=a-b", "bytes=--5", "0-4"} {
+		_, err = ParseRange(value, 100)
+		assert.Equal(t, ERROR_MALFORMED_RANGE, err, value)
+	}
+}
+
+func TestCoalesceRanges(t *testing.T) {
+	// Test: Overlapping and adjacent ranges merge, others are sorted
+	ranges := CoalesceRanges([]ByteRange{{50, 10}, {0, 5}, {5, 5}, {55, 20}, {90, 1}})
+	assert.Equal(t, []ByteRange{{0, 10}, {50, 25}, {90, 1}}, ranges)
+
+	// Test: Contained ranges disappear
+	ranges = CoalesceRanges([]ByteRange{{0, 100}, {10, 5}})
+	assert.Equal(t, []ByteRange{{0, 100}}, ranges)
+}
+
+func TestIfRange(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	etag := `"abc"`
+
+	// Test: No If-Range always allows a partial response
+	assert.True(t, IfRange("", etag, modTime))
+
+	// Test: Entity-tags use strong comparison
+	assert.True(t, IfRange(`"abc"`, etag, modTime))
+	assert.False(t, IfRange(`"abd"`, etag, modTime))
+	assert.False(t, IfRange(`W/"abc"`, etag, modTime))
+	assert.False(t, IfRange(`W/"abc"`, `W/"abc"`, modTime))
+
+	// Test: Dates must match Last-Modified exactly, in any accepted format
+	assert.True(t, IfRange("Fri, 01 Mar 2024 12:00:00 GMT", etag, modTime))
+	assert.True(t, IfRange("Friday, 01-Mar-24 12:00:00 GMT", etag, modTime))
+	assert.True(t, IfRange("Fri Mar  1 12:00:00 2024", etag, modTime))
+	assert.True(t, IfRange("Fri, 01 Mar 2024 12:00:00 GMT", etag, modTime.Add(300*time.Millisecond)))
+	assert.False(t, IfRange("Fri, 01 Mar 2024 11:59:59 GMT", etag, modTime))
+
+	// Test: Weak dates, missing validators and garbage never match
+	assert.False(t, IfRange(time.Now().UTC().Format(TimeFormat), etag, time.Now()))
+	assert.False(t, IfRange("Fri, 01 Mar 2024 12:00:00 GMT", etag, time.Time{}))
+	assert.False(t, IfRange("yesterday", etag, modTime))
+}
@@ -0,0 +1,33 @@
+package response
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+)
+
+var ERROR_INVALID_REDIRECT = fmt.Errorf("invalid redirect")
+
+// Redirect answers with a 3xx status sending the client to location, with a
+// short HTML body for browsers that do not follow it. location may be an
+// absolute URL or a reference relative to the request, which the client
+// resolves; characters not allowed in a URI are percent-encoded.
+func (w *Writer) Redirect(status StatusCode, location string) error {
+	switch status {
+	case 300, StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("%w: status %d does not redirect", ERROR_INVALID_REDIRECT, status)
+	}
+	u, err := url.Parse(location)
+	if err != nil || location == "" {
+		return fmt.Errorf("%w: location %q", ERROR_INVALID_REDIRECT, location)
+	}
+	location = u.String()
+	w.Header().Set("location", location)
+	reason := status.Text()
+	if reason == "" {
+		reason = "Redirect"
+	}
+	body := fmt.Sprintf("<a href=\"%s\">%s</a>.\n", html.EscapeString(location), reason)
+	return w.WriteHTML(status, body)
+}
@@ -0,0 +1,33 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirect(t *testing.T) {
+	// Test: Location is set and browsers get a link
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.Redirect(StatusFound, "https://example.com/a?b=1&c=2"))
+	body := "<a href=\"https://example.com/a?b=1&amp;c=2\">Found</a>.\n"
+	assert.Equal(t, "HTTP/1.1 302 Found\r\nLocation: https://example.com/a?b=1&c=2\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: 55\r\n\r\n"+body, out.String())
+
+	// Test: Relative targets are kept for the client to resolve, escaped
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.Redirect(StatusSeeOther, "../new place"))
+	assert.Contains(t, out.String(), "Location: ../new%20place\r\n")
+
+	// Test: Only redirecting statuses and valid targets are accepted
+	out.Reset()
+	w = NewWriter(out)
+	assert.ErrorIs(t, w.Redirect(StatusOK, "/"), ERROR_INVALID_REDIRECT)
+	assert.ErrorIs(t, w.Redirect(StatusNotModified, "/"), ERROR_INVALID_REDIRECT)
+	assert.ErrorIs(t, w.Redirect(StatusFound, ""), ERROR_INVALID_REDIRECT)
+	assert.ErrorIs(t, w.Redirect(StatusFound, "http://[::1"), ERROR_INVALID_REDIRECT)
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,43 @@
+package response
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// WriteJSON sends v, marshalled to JSON, as the whole response. Nothing is
+// written if v cannot be marshalled.
+func (w *Writer) WriteJSON(status StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.respond(status, "application/json", body)
+}
+
+// WriteHTML sends html as the whole response.
+func (w *Writer) WriteHTML(status StatusCode, html string) error {
+	return w.respond(status, "text/html; charset=utf-8", []byte(html))
+}
+
+// WriteText sends text as the whole plain-text response.
+func (w *Writer) WriteText(status StatusCode, text string) error {
+	return w.respond(status, "text/plain; charset=utf-8", []byte(text))
+}
+
+// respond writes status, Header() with the Content-Type and Content-Length
+// of body, and body.
+func (w *Writer) respond(status StatusCode, contentType string, body []byte) error {
+	h := w.Header()
+	h.Set("content-type", contentType)
+	h.Set("content-length", strconv.Itoa(len(body)))
+	h.Delete("transfer-encoding")
+	if err := w.WriteStatusLine(status); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
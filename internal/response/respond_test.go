@@ -0,0 +1,38 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespond(t *testing.T) {
+	// Test: JSON is marshalled and framed
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.Header().Set("X-Request", "1")
+	require.NoError(t, w.WriteJSON(StatusOK, map[string]int{"n": 1}))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nX-Request: 1\r\nContent-Type: application/json\r\nContent-Length: 7\r\n\r\n{\"n\":1}", out.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Values that cannot be marshalled write nothing
+	out.Reset()
+	w = NewWriter(out)
+	assert.Error(t, w.WriteJSON(StatusOK, make(chan int)))
+	assert.Empty(t, out.String())
+
+	// Test: HTML and text carry a charset
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteHTML(StatusNotFound, "<p>gone</p>"))
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: 11\r\n\r\n<p>gone</p>", out.String())
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteText(StatusBadRequest, "bad"))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: 3\r\n\r\nbad", out.String())
+
+	// Test: A response already started is not written again
+	assert.ErrorIs(t, w.WriteText(StatusOK, "again"), ERROR_STATUS_ALREADY_WRITTEN)
+}
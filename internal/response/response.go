@@ -1,9 +1,17 @@
 package response
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"http/internal/headers"
 	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
 type Response struct {
@@ -12,54 +20,637 @@ type Response struct {
 type StatusCode int
 
 const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	StatusContinue                    StatusCode = 100
+	StatusSwitchingProtocols          StatusCode = 101
+	StatusOK                          StatusCode = 200
+	StatusNoContent                   StatusCode = 204
+	StatusPartialContent              StatusCode = 206
+	StatusMovedPermanently            StatusCode = 301
+	StatusFound                       StatusCode = 302
+	StatusSeeOther                    StatusCode = 303
+	StatusNotModified                 StatusCode = 304
+	StatusTemporaryRedirect           StatusCode = 307
+	StatusPermanentRedirect           StatusCode = 308
+	StatusBadRequest                  StatusCode = 400
+	StatusUnauthorized                StatusCode = 401
+	StatusForbidden                   StatusCode = 403
+	StatusNotFound                    StatusCode = 404
+	StatusMethodNotAllowed            StatusCode = 405
+	StatusRequestTimeout              StatusCode = 408
+	StatusConflict                    StatusCode = 409
+	StatusPreconditionFailed          StatusCode = 412
+	StatusContentTooLarge             StatusCode = 413
+	StatusURITooLong                  StatusCode = 414
+	StatusRangeNotSatisfiable         StatusCode = 416
+	StatusExpectationFailed           StatusCode = 417
+	StatusUnprocessableEntity         StatusCode = 422
+	StatusUpgradeRequired             StatusCode = 426
+	StatusRequestHeaderFieldsTooLarge StatusCode = 431
+	StatusInternalServerError         StatusCode = 500
+	StatusNotImplemented              StatusCode = 501
+	StatusBadGateway                  StatusCode = 502
+	StatusServiceUnavailable          StatusCode = 503
+	StatusGatewayTimeout              StatusCode = 504
+	StatusHTTPVersionNotSupported     StatusCode = 505
 )
 
+var reasonPhrases = map[StatusCode]string{
+	StatusContinue:                    "Continue",
+	StatusSwitchingProtocols:          "Switching Protocols",
+	StatusOK:                          "OK",
+	StatusNoContent:                   "No Content",
+	StatusPartialContent:              "Partial Content",
+	StatusMovedPermanently:            "Moved Permanently",
+	StatusFound:                       "Found",
+	StatusSeeOther:                    "See Other",
+	StatusNotModified:                 "Not Modified",
+	StatusTemporaryRedirect:           "Temporary Redirect",
+	StatusPermanentRedirect:           "Permanent Redirect",
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusConflict:                    "Conflict",
+	StatusPreconditionFailed:          "Precondition Failed",
+	StatusContentTooLarge:             "Content Too Large",
+	StatusURITooLong:                  "URI Too Long",
+	StatusRangeNotSatisfiable:         "Range Not Satisfiable",
+	StatusExpectationFailed:           "Expectation Failed",
+	StatusUnprocessableEntity:         "Unprocessable Entity",
+	StatusUpgradeRequired:             "Upgrade Required",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusInternalServerError:         "Internal Server Error",
+	StatusNotImplemented:              "Not Implemented",
+	StatusBadGateway:                  "Bad Gateway",
+	StatusServiceUnavailable:          "Service Unavailable",
+	StatusGatewayTimeout:              "Gateway Timeout",
+	StatusHTTPVersionNotSupported:     "HTTP Version Not Supported",
+}
+
+// Text is the reason phrase of the status, or "" for unknown codes.
+func (s StatusCode) Text() string {
+	return reasonPhrases[s]
+}
+
+// GetDefaultHeaders returns a new header set for a plain-text response of
+// contentLen bytes. Every call builds its own, so handlers may change it
+// freely; use Clone to derive variants from one set. Connection is left to
+// the Writer, which knows the protocol version and whether the connection
+// stays open, and Date and Server to the server.
 func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
 	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
-	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 	return h
 }
 
+// ERROR_CLIENT_ABORTED is returned by Writer methods once the client has
+// gone away, so streaming handlers can stop early.
+var ERROR_CLIENT_ABORTED = fmt.Errorf("client aborted")
+
+// A response is written in order: status line, headers, body, then
+// optionally trailers. Calls out of that order fail with these errors;
+// skipped steps are filled in with defaults instead.
+var ERROR_STATUS_ALREADY_WRITTEN = fmt.Errorf("response status line already written")
+var ERROR_BODY_NOT_ALLOWED = fmt.Errorf("response status does not allow a body")
+
+// TimeFormat is the IMF-fixdate format used by Date and other HTTP dates.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 type Writer struct {
-	writer io.Writer
+	writer        io.Writer
+	buffered      *bufio.Writer
+	statusWritten bool
+	status        StatusCode
+	http10        bool
+	// head is set for HEAD requests, whose body is never sent.
+	head           bool
+	headersWritten bool
+	framed         bool
+	closing        bool
+	recording      *Recording
+	filters        []BodyFilter
+	chain          []io.WriteCloser
+	filtered       io.Writer
+	finished       bool
+	aborted        bool
+	chunking       bool
+	chunked        bool
+	// declaredChunked is set when the handler's headers asked for chunked,
+	// even if an HTTP/1.0 client gets the body unframed.
+	declaredChunked bool
+	header          *headers.Headers
+	defaults        *headers.Headers
+	cookies         []string
+	trailerNames    []string
+	// declared holds the lowercased trailer names the headers announced.
+	declared map[string]bool
+	// held is a header block without framing, kept back while the body
+	// is buffered in buf to see whether it is small enough for a
+	// Content-Length.
+	held         *headers.Headers
+	buf          []byte
+	preserveCase bool
+	bodyBytes    int64
+	// length is the declared Content-Length, when hasLength is set, and
+	// sent what went out of the body so far.
+	length    int64
+	hasLength bool
+	sent      int64
+	// failed is set once a write to the client fails.
+	failed   bool
+	hijacker func() (io.ReadWriteCloser, error)
+	hijacked bool
+	ctx      context.Context
 }
 
 func NewWriter(writer io.Writer) *Writer {
 	return &Writer{writer: writer}
 }
 
+// DefaultBufferSize is the buffer NewBufferedWriter uses when given none.
+const DefaultBufferSize = 4 << 10
+
+// NewBufferedWriter is NewWriter with writes collected in a buffer of size
+// bytes, so the many small writes of a response go out together. They are
+// sent once the buffer fills up, and on Flush.
+func NewBufferedWriter(writer io.Writer, size int) *Writer {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &Writer{writer: writer, buffered: bufio.NewWriterSize(writer, size)}
+}
+
+// Flush sends everything written so far to the client. Headers held back
+// for a Content-Length go out first; the body then continues chunked.
+// Streaming handlers call it whenever the client should see what they
+// wrote; the server flushes once the handler returns.
+func (w *Writer) Flush() error {
+	if w.hijacked {
+		return ERROR_HIJACKED
+	}
+	if w.held != nil {
+		if err := w.flushHeld(false); err != nil {
+			return err
+		}
+	}
+	if w.buffered == nil {
+		return nil
+	}
+	return w.checkSent(w.buffered.Flush())
+}
+
+// WriteStatusLine starts the response. It can only be called once.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	statusLine := []byte{}
-	switch statusCode {
-	case StatusOK:
-		statusLine = []byte("HTTP/1.1 200 OK\r\n")
-	case StatusBadRequest:
-		statusLine = []byte("HTTP/1.1 400 Bad Request\r\n")
-	case StatusInternalServerError:
-		statusLine = []byte("HTTP/1.1 500 Internal Server Error\r\n")
-	default:
+	if w.statusWritten {
+		return ERROR_STATUS_ALREADY_WRITTEN
+	}
+	// Codes without a known reason phrase (e.g. relayed from an upstream)
+	// are still valid as long as they have three digits.
+	reason, ok := reasonPhrases[statusCode]
+	if !ok && (statusCode < 100 || statusCode > 999) {
 		return fmt.Errorf("unrecognized error code")
 	}
-	_, err := w.writer.Write(statusLine)
+	statusLine := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, reason)
+	w.statusWritten = true
+	w.status = statusCode
+	if w.recording != nil {
+		w.recording.StatusCode = statusCode
+	}
+	_, err := w.send(statusLine)
+	return err
+}
+
+// WriteContinue sends the "100 Continue" interim response a client that
+// sent "Expect: 100-continue" waits for before sending its body. It does
+// nothing once the final status line has been written.
+func (w *Writer) WriteContinue() error {
+	// HTTP/1.0 clients do not know interim responses.
+	if w.statusWritten || w.http10 {
+		return nil
+	}
+	if _, err := w.send([]byte("HTTP/1.1 100 Continue\r\n\r\n")); err != nil {
+		return err
+	}
+	// The client is waiting for it.
+	if w.buffered == nil {
+		return nil
+	}
+	return w.checkSent(w.buffered.Flush())
+}
+
+// SetContext ties the writer to the request: once ctx is cancelled because
+// the client went away, writes fail with ERROR_CLIENT_ABORTED.
+func (w *Writer) SetContext(ctx context.Context) {
+	w.ctx = ctx
+}
+
+// send writes to the connection, or its buffer, reporting a client that
+// went away as ERROR_CLIENT_ABORTED.
+func (w *Writer) send(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ERROR_HIJACKED
+	}
+	if w.ctx != nil && w.ctx.Err() != nil {
+		w.closing = true
+		return 0, ERROR_CLIENT_ABORTED
+	}
+	var n int
+	var err error
+	if w.buffered != nil {
+		n, err = w.buffered.Write(p)
+	} else {
+		n, err = w.writer.Write(p)
+	}
+	if err != nil {
+		w.failed = true
+	}
+	return n, w.checkSent(err)
+}
+
+// checkSent reports a write error caused by the client going away as
+// ERROR_CLIENT_ABORTED.
+func (w *Writer) checkSent(err error) error {
+	if err != nil && isDisconnect(err) {
+		w.closing = true
+		return ERROR_CLIENT_ABORTED
+	}
 	return err
 }
 
+func isDisconnect(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
+
+// SetHTTP10 marks the client as speaking HTTP/1.0, which has no chunked
+// encoding: bodies that would be chunked are sent as they are and end when
+// the connection is closed. Responses that stay open say so with
+// "Connection: keep-alive".
+func (w *Writer) SetHTTP10() {
+	w.http10 = true
+}
+
+// SuppressBody makes the Writer answer a HEAD request: headers go out as
+// they would for GET, with the same Content-Length or Transfer-Encoding,
+// but body writes are discarded. Handlers written for GET need no changes.
+func (w *Writer) SuppressBody() {
+	w.head = true
+}
+
+// CloseAfterResponse marks this response as the last one on the connection:
+// the headers block is written with "Connection: close".
+func (w *Writer) CloseAfterResponse() {
+	w.closing = true
+}
+
+// Abort marks the response as cut short, e.g. by a handler that ran out of
+// time half-way. What was written still goes out, but Finish ends neither
+// the body nor the held headers as if complete, and the connection closes
+// after it, so the client cannot take the response for a whole one.
+func (w *Writer) Abort() {
+	w.aborted = true
+	w.closing = true
+}
+
+// KeepAlive reports whether the connection can be reused once the handler
+// is done, i.e. the response was framed and nobody asked to close.
+// Headers without framing are held back until the body is known to be
+// small (it gets a Content-Length) or not (it is chunked).
+func (w *Writer) KeepAlive() bool {
+	return w.headersWritten && w.framed && !w.closing
+}
+
+// Complete reports whether the whole response went out, once Finish has
+// run: the headers, and a body that was ended or ran to its Content-Length.
+// Aborted responses, failed writes and bodies that only end when the
+// connection closes are never complete.
+func (w *Writer) Complete() bool {
+	switch {
+	case w.aborted || w.failed || !w.headersWritten || w.held != nil:
+		return false
+	case w.head || !bodyAllowed(w.status):
+		return true
+	case w.chunking || len(w.chain) > 0:
+		return w.finished
+	case w.hasLength:
+		return w.sent == w.length
+	}
+	return false
+}
+
+// Started reports whether the status line has been written, after which
+// the response can no longer be replaced by another.
+func (w *Writer) Started() bool {
+	return w.statusWritten
+}
+
+// Status is the status written so far, or 0 before the status line.
+func (w *Writer) Status() StatusCode {
+	if !w.statusWritten {
+		return 0
+	}
+	return w.status
+}
+
+// BodyBytes is how much body the handler has written, before content
+// codings and transfer framing.
+func (w *Writer) BodyBytes() int64 {
+	return w.bodyBytes
+}
+
+// DefaultHeaders returns the fields added to the response headers unless
+// the handler sets them itself, so middleware can add its own. Vary tokens
+// are added to the handler's own.
+func (w *Writer) DefaultHeaders() *headers.Headers {
+	if w.defaults == nil {
+		w.defaults = headers.NewHeaders()
+	}
+	return w.defaults
+}
+
+// SetDefaultHeaders registers fields added to the response headers unless the
+// handler sets them itself.
+func (w *Writer) SetDefaultHeaders(h *headers.Headers) {
+	w.defaults = h
+}
+
+// SetPreserveHeaderCase makes WriteHeaders send field names as given
+// rather than in canonical form.
+func (w *Writer) SetPreserveHeaderCase(preserve bool) {
+	w.preserveCase = preserve
+}
+
+// WriteHeaders sends the response headers, after a "200 OK" status line if
+// none was written. It can only be called once: trailers go through
+// WriteTrailers or Finish.
 func (w *Writer) WriteHeaders(h headers.Headers) error {
-	b := []byte{}
-	h.Foreach(func(n, v string) {
-		b = fmt.Appendf(b, "%s: %s\r\n", n, v)
-	})
-	b = fmt.Append(b, "\r\n")
-	_, err := w.writer.Write(b)
+	if w.headersWritten {
+		return ERROR_HEADERS_ALREADY_WRITTEN
+	}
+	if !w.statusWritten {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+	}
+	if w.defaults != nil {
+		h = mergeDefaults(h, w.defaults)
+	}
+	if len(w.cookies) > 0 {
+		h = withCookies(h, w.cookies)
+	}
+	if len(w.trailerNames) > 0 {
+		h = withTrailerNames(h, w.trailerNames)
+	}
+	if len(w.filters) > 0 {
+		w.filters = w.applicableFilters(h)
+	}
+	// Responses that cannot have a body have nothing to filter, and
+	// framing one would break the message.
+	if !bodyAllowed(w.status) {
+		w.filters = nil
+	}
+	if err := w.checkTrailers(h); err != nil {
+		return err
+	}
+	w.headersWritten = true
+	length, hasLength := h.Get("content-length")
+	if hasLength {
+		w.length, _ = strconv.ParseInt(length, 10, 64)
+		w.hasLength = true
+	}
+	w.chunked = h.HasToken("transfer-encoding", "chunked")
+	w.declaredChunked = w.chunked
+	if w.http10 && w.chunked {
+		h = withoutChunked(h)
+		w.chunked = false
+	}
+	w.framed = hasLength || w.chunked
+	if h.HasToken("connection", "close") {
+		w.closing = true
+	}
+	if len(w.filters) > 0 {
+		h = w.startFilters(h)
+		w.framed = !w.http10
+	}
+	if !bodyAllowed(w.status) {
+		w.framed = true
+	}
+	if !w.framed && !w.declaredChunked && len(w.filters) == 0 {
+		w.held = h.Clone()
+		return nil
+	}
+	if w.http10 && !w.framed {
+		w.closing = true
+	}
+	return w.sendHeaders(h)
+}
+
+// autoLengthLimit is how much of a body without framing is buffered in the
+// hope that it ends soon enough to be sent with a Content-Length.
+const autoLengthLimit = 4 << 10
+
+// bodyAllowed reports whether responses with status may carry a body.
+func bodyAllowed(status StatusCode) bool {
+	return status == 0 || status >= 200 && status != 204 && status != 304
+}
+
+// flushHeld sends the held header block. With complete set the buffered
+// body is all there is and gets a Content-Length; otherwise the body goes
+// on chunked, or unframed to HTTP/1.0 clients.
+func (w *Writer) flushHeld(complete bool) error {
+	h, body := w.held, w.buf
+	w.held, w.buf = nil, nil
+	switch {
+	case complete:
+		h.Set("content-length", strconv.Itoa(len(body)))
+		w.length, w.hasLength = int64(len(body)), true
+		w.framed = true
+	case w.http10:
+		w.closing = true
+	default:
+		h.Set("transfer-encoding", "chunked")
+		w.chunked, w.chunking, w.framed = true, true, true
+	}
+	if err := w.sendHeaders(*h); err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	_, err := w.WriteBody(body)
 	return err
 }
 
+// sendHeaders writes the response header block.
+func (w *Writer) sendHeaders(h headers.Headers) error {
+	out := &h
+	if w.closing || w.http10 {
+		out = h.Clone()
+		out.Delete("connection")
+		if w.closing {
+			out.Add("connection", "close")
+		} else {
+			out.Add("connection", "keep-alive")
+		}
+	}
+	if w.preserveCase {
+		out.SetPreserveCase(true)
+	}
+	var buf bytes.Buffer
+	if _, err := out.WriteTo(&buf); err != nil {
+		return err
+	}
+	if w.recording != nil {
+		w.recording.Headers = h.Clone()
+	}
+	_, err := w.send(buf.Bytes())
+	return err
+}
+
+// withoutChunked drops chunked from the transfer codings of h.
+func withoutChunked(h headers.Headers) headers.Headers {
+	out := h.Clone()
+	codings := []string{}
+	for _, c := range out.List("transfer-encoding") {
+		if !strings.EqualFold(c, "chunked") {
+			codings = append(codings, c)
+		}
+	}
+	if len(codings) == 0 {
+		out.Delete("transfer-encoding")
+	} else {
+		out.Set("transfer-encoding", strings.Join(codings, ", "))
+	}
+	return *out
+}
+
+// withCookies adds a Set-Cookie field to h for every cookie.
+func withCookies(h headers.Headers, cookies []string) headers.Headers {
+	out := h.Clone()
+	for _, c := range cookies {
+		out.Add("set-cookie", c)
+	}
+	return *out
+}
+
+func mergeDefaults(h headers.Headers, defaults *headers.Headers) headers.Headers {
+	out := h.Clone()
+	defaults.Foreach(func(n, v string) {
+		if _, ok := h.Get(n); !ok {
+			out.Add(n, v)
+		}
+	})
+	// Vary lists what the response depends on, so the defaults' tokens
+	// join the handler's rather than give way to them.
+	if _, ok := h.Get("vary"); ok && !h.HasToken("vary", "*") {
+		for _, token := range defaults.List("vary") {
+			if !out.HasToken("vary", token) {
+				out.Add("vary", token)
+			}
+		}
+	}
+	return *out
+}
+
+// WriteBody sends p as part of the body. Before any headers are written it
+// sends Header() first.
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	n, err := w.writer.Write(p)
+	n, err := w.writeBody(p)
+	w.bodyBytes += int64(n)
 	return n, err
 }
+
+func (w *Writer) writeBody(p []byte) (int, error) {
+	if !w.headersWritten {
+		if err := w.WriteHeaders(*w.Header()); err != nil {
+			return 0, err
+		}
+	}
+	switch {
+	case len(p) > 0 && !bodyAllowed(w.status):
+		return 0, ERROR_BODY_NOT_ALLOWED
+	case w.held != nil:
+		if len(w.buf)+len(p) <= autoLengthLimit {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		if err := w.flushHeld(false); err != nil {
+			return 0, err
+		}
+		return w.writeBody(p)
+	case w.finished:
+		return 0, ERROR_BODY_FINISHED
+	case w.filtered != nil:
+		return w.filtered.Write(p)
+	case w.chunking:
+		return chunkWriter{rawWriter{w}}.Write(p)
+	}
+	return w.write(p)
+}
+
+// write sends bytes as they are, bypassing any filters. Nothing is sent
+// for HEAD requests.
+func (w *Writer) write(p []byte) (int, error) {
+	if w.head {
+		return len(p), nil
+	}
+	n, err := w.send(p)
+	w.sent += int64(n)
+	if w.recording != nil {
+		w.recording.Body = append(w.recording.Body, p[:n]...)
+	}
+	return n, err
+}
+
+// bodyWriter adapts WriteBody to io.Writer.
+type bodyWriter struct {
+	w *Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
+
+// rawWriter adapts write to io.Writer.
+type rawWriter struct {
+	w *Writer
+}
+
+func (r rawWriter) Write(p []byte) (int, error) {
+	return r.w.write(p)
+}
+
+// Recording is a copy of everything a handler sent through a Writer: the
+// status, the first header block and every byte after it (chunk framing and
+// trailers included).
+type Recording struct {
+	StatusCode StatusCode
+	Headers    *headers.Headers
+	Body       []byte
+}
+
+// Record starts capturing the response written through w while still
+// sending it to the client.
+func (w *Writer) Record() *Recording {
+	w.recording = &Recording{}
+	return w.recording
+}
+
+// Replay sends a recorded response through w.
+func (rec *Recording) Replay(w *Writer) error {
+	if rec.Headers == nil {
+		return fmt.Errorf("incomplete recording")
+	}
+	if err := w.WriteStatusLine(rec.StatusCode); err != nil {
+		return err
+	}
+	// Recordings are replayed to many writers at once.
+	if err := w.WriteHeaders(*rec.Headers.Clone()); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(rec.Body)
+	return err
+}
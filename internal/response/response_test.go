@@ -0,0 +1,213 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"http/internal/headers"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterOrder(t *testing.T) {
+	// Test: The status line can only be written once
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.ErrorIs(t, w.WriteStatusLine(StatusNotFound), ERROR_STATUS_ALREADY_WRITTEN)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", out.String())
+
+	// Test: Headers can only be written once
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+	written := out.String()
+	assert.ErrorIs(t, w.WriteHeaders(*GetDefaultHeaders(0)), ERROR_HEADERS_ALREADY_WRITTEN)
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ERROR_STATUS_ALREADY_WRITTEN)
+	assert.Equal(t, written, out.String())
+
+	// Test: Headers without a status line get "200 OK"
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nContent-Type: text/plain\r\n\r\n", out.String())
+
+	// Test: A body without headers sends Header() first
+	out.Reset()
+	w = NewWriter(out)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err := w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(nil))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nhi", out.String())
+
+	// Test: Statuses without a body refuse one
+	out.Reset()
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(204))
+	require.NoError(t, w.WriteHeaders(*headers.NewHeaders()))
+	_, err = w.WriteBody([]byte("nope"))
+	assert.ErrorIs(t, err, ERROR_BODY_NOT_ALLOWED)
+	_, err = w.WriteBody(nil)
+	assert.NoError(t, err)
+
+	// Test: Nothing is written after the body is finished
+	out.Reset()
+	w = NewWriter(out)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err = w.WriteChunk([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.FinishChunked(nil))
+	written = out.String()
+	_, err = w.WriteBody([]byte("late"))
+	assert.ErrorIs(t, err, ERROR_BODY_FINISHED)
+	assert.ErrorIs(t, w.FinishChunked(nil), ERROR_BODY_FINISHED)
+	assert.Equal(t, written, out.String())
+}
+
+func TestBufferedWriter(t *testing.T) {
+	// Test: Writes are held in the buffer until Flush
+	out := &bytes.Buffer{}
+	w := NewBufferedWriter(out, 0)
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Empty(t, out.String())
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nhello"))
+
+	// Test: Flush commits held headers to a chunked body
+	out.Reset()
+	w = NewBufferedWriter(out, 0)
+	require.NoError(t, w.WriteHeaders(*headers.NewHeaders()))
+	_, err = w.WriteBody([]byte("part"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\npart\r\n", out.String())
+	require.NoError(t, w.Finish(nil))
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(out.String(), "0\r\n\r\n"))
+
+	// Test: 100 Continue is sent right away
+	out.Reset()
+	w = NewBufferedWriter(out, 0)
+	require.NoError(t, w.WriteContinue())
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", out.String())
+}
+
+func TestAbort(t *testing.T) {
+	// Test: Held headers go out chunked, without a last chunk
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.WriteHeaders(*headers.NewHeaders()))
+	_, err := w.WriteBody([]byte("part"))
+	require.NoError(t, err)
+	w.Abort()
+	require.NoError(t, w.Finish(nil))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n4\r\npart\r\n", out.String())
+	assert.False(t, w.KeepAlive())
+
+	// Test: Chunked bodies under way are not ended
+	out.Reset()
+	w = NewWriter(out)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err = w.WriteChunk([]byte("part"))
+	require.NoError(t, err)
+	w.Abort()
+	require.NoError(t, w.Finish(nil))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n4\r\npart\r\n"))
+	assert.False(t, w.KeepAlive())
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	// Test: Defaults fill in the fields the handler did not set
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.DefaultHeaders().Set("X-Request-Id", "1")
+	w.DefaultHeaders().Set("Content-Type", "text/html")
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+	assert.Contains(t, out.String(), "X-Request-Id: 1\r\n")
+	assert.Contains(t, out.String(), "Content-Type: text/plain\r\n")
+	assert.NotContains(t, out.String(), "text/html")
+
+	// Test: Vary tokens join the handler's
+	out.Reset()
+	w = NewWriter(out)
+	w.DefaultHeaders().Add("Vary", "Origin")
+	w.DefaultHeaders().Add("Vary", "Accept-Encoding")
+	h := GetDefaultHeaders(0)
+	h.Set("Vary", "accept-encoding")
+	require.NoError(t, w.WriteHeaders(*h))
+	res := out.String()
+	assert.Contains(t, res, "Vary: accept-encoding\r\n")
+	assert.Contains(t, res, "Vary: Origin\r\n")
+	assert.Equal(t, 2, strings.Count(res, "Vary:"))
+
+	// Test: "Vary: *" already covers everything
+	out.Reset()
+	w = NewWriter(out)
+	w.DefaultHeaders().Add("Vary", "Origin")
+	h = GetDefaultHeaders(0)
+	h.Set("Vary", "*")
+	require.NoError(t, w.WriteHeaders(*h))
+	assert.Equal(t, 1, strings.Count(out.String(), "Vary:"))
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("write failed")
+}
+
+func TestComplete(t *testing.T) {
+	// Test: A body that runs to its Content-Length is complete
+	w := NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteText(StatusOK, "hello"))
+	require.NoError(t, w.Finish(nil))
+	assert.True(t, w.Complete())
+
+	// Test: One cut short of it is not
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hel"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(nil))
+	assert.False(t, w.Complete())
+
+	// Test: Held bodies are complete once Finish sends them
+	w = NewWriter(&bytes.Buffer{})
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+	assert.False(t, w.Complete())
+	require.NoError(t, w.Finish(nil))
+	assert.True(t, w.Complete())
+
+	// Test: Chunked bodies are complete once ended
+	w = NewWriter(&bytes.Buffer{})
+	w.Header().Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(*w.Header()))
+	_, err = w.WriteChunk([]byte("part"))
+	require.NoError(t, err)
+	assert.False(t, w.Complete())
+	require.NoError(t, w.FinishChunked(nil))
+	assert.True(t, w.Complete())
+
+	// Test: Aborted responses and failed writes are not
+	w = NewWriter(&bytes.Buffer{})
+	w.Header().Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(*w.Header()))
+	_, err = w.WriteChunk([]byte("part"))
+	require.NoError(t, err)
+	w.Abort()
+	require.NoError(t, w.Finish(nil))
+	assert.False(t, w.Complete())
+	w = NewWriter(failWriter{})
+	w.WriteText(StatusOK, "hello")
+	w.Finish(nil)
+	assert.False(t, w.Complete())
+}
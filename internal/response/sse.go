@@ -0,0 +1,122 @@
+package response
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ERROR_INVALID_EVENT = fmt.Errorf("event id or name contains a line break")
+
+// EventStream sends Server-Sent Events. Every event goes out in its own
+// chunk, flushed as soon as it is sent. Its methods are safe to call concurrently.
+type EventStream struct {
+	w    *Writer
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartEvents sends the headers of a "text/event-stream" response, along
+// with whatever is in Header(), and returns the stream to send events on.
+// With a positive heartbeat a comment is sent whenever that long passes,
+// so proxies keep the connection open. Close the stream before the handler
+// returns.
+func (w *Writer) StartEvents(heartbeat time.Duration) (*EventStream, error) {
+	h := w.Header()
+	h.Set("content-type", "text/event-stream")
+	h.Set("cache-control", "no-cache")
+	h.Delete("content-length")
+	h.Set("transfer-encoding", "chunked")
+	if err := w.WriteHeaders(*h); err != nil {
+		return nil, err
+	}
+	s := &EventStream{w: w, stop: make(chan struct{}), done: make(chan struct{})}
+	if heartbeat <= 0 {
+		close(s.done)
+		return s, nil
+	}
+	go s.heartbeat(heartbeat)
+	return s, nil
+}
+
+func (s *EventStream) heartbeat(every time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.Done():
+			return
+		case <-ticker.C:
+			if s.Comment("heartbeat") != nil {
+				return
+			}
+		}
+	}
+}
+
+// Done is closed once the client has gone away. It is nil, and so never
+// closed, if the Writer has no context.
+func (s *EventStream) Done() <-chan struct{} {
+	if s.w.ctx == nil {
+		return nil
+	}
+	return s.w.ctx.Done()
+}
+
+// SendEvent sends one event. Empty id and event are left out; data may
+// span several lines.
+func (s *EventStream) SendEvent(id, event, data string) error {
+	if strings.ContainsAny(id, "\r\n\x00") || strings.ContainsAny(event, "\r\n") {
+		return ERROR_INVALID_EVENT
+	}
+	var b strings.Builder
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.send(b.String())
+}
+
+// Comment sends a comment line, which clients ignore.
+func (s *EventStream) Comment(text string) error {
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+	return s.send(": " + text + "\n\n")
+}
+
+func (s *EventStream) send(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.WriteChunk([]byte(p)); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// Close stops the heartbeat and ends the stream.
+func (s *EventStream) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.FinishChunked(nil); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
@@ -0,0 +1,78 @@
+package response
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedBuffer lets a test read what a heartbeat goroutine writes.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestEventStream(t *testing.T) {
+	// Test: Events are framed one per chunk
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	s, err := w.StartEvents(0)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nCache-Control: no-cache\r\nTransfer-Encoding: chunked\r\n\r\n", out.String())
+	out.Reset()
+	require.NoError(t, s.SendEvent("1", "update", "line one\nline two"))
+	assert.Equal(t, "33\r\nid: 1\nevent: update\ndata: line one\ndata: line two\n\n\r\n", out.String())
+	out.Reset()
+	require.NoError(t, s.SendEvent("", "", ""))
+	assert.Equal(t, "8\r\ndata: \n\n\r\n", out.String())
+
+	// Test: Line breaks in the id or event name are refused
+	assert.ErrorIs(t, s.SendEvent("1\n2", "", "x"), ERROR_INVALID_EVENT)
+	assert.ErrorIs(t, s.SendEvent("", "a\rb", "x"), ERROR_INVALID_EVENT)
+
+	// Test: Close ends the chunked body
+	out.Reset()
+	require.NoError(t, s.Close())
+	assert.Equal(t, "0\r\n\r\n", out.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Heartbeats are sent until the stream is closed
+	locked := &lockedBuffer{}
+	w = NewWriter(locked)
+	s, err = w.StartEvents(5 * time.Millisecond)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(locked.String(), ": heartbeat\n\n")
+	}, time.Second, time.Millisecond)
+	require.NoError(t, s.Close())
+	assert.True(t, strings.HasSuffix(locked.String(), "0\r\n\r\n"))
+
+	// Test: A client going away closes Done and fails sends
+	ctx, cancel := context.WithCancel(context.Background())
+	w = NewWriter(&bytes.Buffer{})
+	w.SetContext(ctx)
+	s, err = w.StartEvents(time.Millisecond)
+	require.NoError(t, err)
+	cancel()
+	<-s.Done()
+	assert.ErrorIs(t, s.SendEvent("", "", "late"), ERROR_CLIENT_ABORTED)
+	assert.ErrorIs(t, s.Close(), ERROR_CLIENT_ABORTED)
+}
@@ -0,0 +1,93 @@
+package response
+
+import (
+	"fmt"
+	"http/internal/headers"
+	"strings"
+)
+
+var ERROR_HEADERS_ALREADY_WRITTEN = fmt.Errorf("response headers already written")
+var ERROR_FORBIDDEN_TRAILER = fmt.Errorf("field not allowed as a trailer")
+var ERROR_UNDECLARED_TRAILER = fmt.Errorf("trailer not declared")
+var ERROR_TRAILERS_NEED_CHUNKED = fmt.Errorf("trailers declared on a response that is not chunked")
+
+// Fields that frame, route or describe the response as a whole must come
+// before the body.
+var forbiddenTrailers = map[string]bool{
+	"cache-control":     true,
+	"connection":        true,
+	"content-encoding":  true,
+	"content-length":    true,
+	"content-range":     true,
+	"content-type":      true,
+	"date":              true,
+	"expires":           true,
+	"location":          true,
+	"set-cookie":        true,
+	"te":                true,
+	"trailer":           true,
+	"transfer-encoding": true,
+	"www-authenticate":  true,
+}
+
+// DeclareTrailers announces the fields WriteTrailers will send after the
+// body. They are listed in the Trailer header. The headers must still
+// declare "Transfer-Encoding: chunked", or WriteHeaders fails with
+// ERROR_TRAILERS_NEED_CHUNKED. It must be called before the headers are
+// written.
+func (w *Writer) DeclareTrailers(names ...string) error {
+	if w.headersWritten {
+		return ERROR_HEADERS_ALREADY_WRITTEN
+	}
+	for _, name := range names {
+		if !headers.IsToken(name) || name == "" || forbiddenTrailers[strings.ToLower(name)] {
+			return fmt.Errorf("%w: %q", ERROR_FORBIDDEN_TRAILER, name)
+		}
+	}
+	w.trailerNames = append(w.trailerNames, names...)
+	return nil
+}
+
+// withTrailerNames adds names missing from the Trailer field of h.
+func withTrailerNames(h headers.Headers, names []string) headers.Headers {
+	out := h.Clone()
+	for _, name := range names {
+		if !out.HasToken("trailer", name) {
+			out.Add("trailer", name)
+		}
+	}
+	return *out
+}
+
+// checkTrailers makes sure a response announcing trailers can send them,
+// and remembers which ones it announced.
+func (w *Writer) checkTrailers(h headers.Headers) error {
+	declared := h.List("trailer")
+	if len(declared) == 0 {
+		return nil
+	}
+	if !w.http10 && len(w.filters) == 0 && !h.HasToken("transfer-encoding", "chunked") {
+		return ERROR_TRAILERS_NEED_CHUNKED
+	}
+	w.declared = map[string]bool{}
+	for _, name := range declared {
+		w.declared[strings.ToLower(name)] = true
+	}
+	return nil
+}
+
+// WriteTrailers ends a chunked body with the last chunk and the trailers in
+// h, every one of which must have been declared, with DeclareTrailers or
+// in the Trailer header. HTTP/1.0 clients get no trailers.
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	var err error
+	h.Foreach(func(n, v string) {
+		if err == nil && !w.declared[strings.ToLower(n)] {
+			err = fmt.Errorf("%w: %q", ERROR_UNDECLARED_TRAILER, n)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return w.FinishChunked(h)
+}
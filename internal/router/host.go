@@ -0,0 +1,105 @@
+package router
+
+import (
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"strings"
+)
+
+// HostMux dispatches requests by the host they are for, as given by the
+// request's Host. Patterns are either exact host names such as
+// "example.com" or wildcards such as "*.example.com", which match any
+// subdomain at any depth but not "example.com" itself. Either may end in a
+// port, and then only matches requests for that port.
+//
+// An exact match beats a wildcard, a longer wildcard beats a shorter one,
+// and a pattern with a port beats one without.
+type HostMux struct {
+	exact     map[string]server.Handler
+	wildcards map[string]server.Handler
+	// Default answers requests for hosts no pattern matches; defaults to a
+	// bare 404.
+	Default server.Handler
+}
+
+func NewHostMux() *HostMux {
+	return &HostMux{exact: map[string]server.Handler{}, wildcards: map[string]server.Handler{}}
+}
+
+// Handle registers h for the hosts pattern matches. It panics if the
+// pattern is malformed.
+func (m *HostMux) Handle(pattern string, h server.Handler) {
+	p := normalizeHost(pattern)
+	suffix, wildcard := strings.CutPrefix(p, "*.")
+	if p == "" || strings.Contains(suffix, "*") || strings.HasPrefix(suffix, ".") || suffix == "" {
+		panic(fmt.Sprintf("router: malformed host pattern %q", pattern))
+	}
+	if wildcard {
+		m.wildcards[suffix] = h
+		return
+	}
+	m.exact[p] = h
+}
+
+// normalizeHost lowercases a host and drops the trailing dot of a fully
+// qualified name.
+func normalizeHost(host string) string {
+	name, port := splitHostPort(strings.ToLower(host))
+	name = strings.TrimSuffix(name, ".")
+	if port != "" {
+		return name + ":" + port
+	}
+	return name
+}
+
+// splitHostPort splits off the port of a host, if it has one.
+func splitHostPort(host string) (string, string) {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		return host[:i], host[i+1:]
+	}
+	return host, ""
+}
+
+// Handler returns the handler for requests to host, or nil when no
+// pattern matches.
+func (m *HostMux) Handler(host string) server.Handler {
+	name, port := splitHostPort(normalizeHost(host))
+	lookup := func(table map[string]server.Handler, key string) server.Handler {
+		if port != "" {
+			if h, ok := table[key+":"+port]; ok {
+				return h
+			}
+		}
+		return table[key]
+	}
+	if h := lookup(m.exact, name); h != nil {
+		return h
+	}
+	// From the longest suffix to the shortest.
+	for i := strings.IndexByte(name, '.'); i >= 0; {
+		if h := lookup(m.wildcards, name[i+1:]); h != nil {
+			return h
+		}
+		next := strings.IndexByte(name[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil
+}
+
+func (m *HostMux) Serve(w *response.Writer, req *request.Request) {
+	h := m.Handler(req.Host())
+	if h == nil {
+		h = m.Default
+	}
+	if h == nil {
+		w.WriteStatusLine(response.StatusNotFound)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+		return
+	}
+	h(w, req)
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"bytes"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveHost(t *testing.T, m *HostMux, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	m.Serve(response.NewWriter(out), req)
+	return out.String()
+}
+
+func TestHostMux(t *testing.T) {
+	m := NewHostMux()
+	named := func(name string) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.WriteText(response.StatusOK, name)
+		}
+	}
+	m.Handle("example.com", named("apex"))
+	m.Handle("*.example.com", named("any"))
+	m.Handle("*.api.example.com", named("api"))
+	m.Handle("admin.example.com", named("admin"))
+	m.Handle("Example.com:8080", named("apex 8080"))
+	get := func(host string) string {
+		return serveHost(t, m, "GET / HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	}
+
+	// Test: Exact names match regardless of case, port and trailing dot
+	assert.True(t, strings.HasSuffix(get("example.com"), "apex"))
+	assert.True(t, strings.HasSuffix(get("EXAMPLE.com."), "apex"))
+	assert.True(t, strings.HasSuffix(get("example.com:9000"), "apex"))
+	assert.True(t, strings.HasSuffix(get("admin.example.com"), "admin"))
+
+	// Test: A pattern with a port wins for that port
+	assert.True(t, strings.HasSuffix(get("example.com:8080"), "apex 8080"))
+
+	// Test: Wildcards match subdomains, the longest first
+	assert.True(t, strings.HasSuffix(get("www.example.com"), "any"))
+	assert.True(t, strings.HasSuffix(get("a.b.example.com"), "any"))
+	assert.True(t, strings.HasSuffix(get("v1.api.example.com"), "api"))
+	assert.True(t, strings.HasSuffix(get("api.example.com"), "any"))
+
+	// Test: The target's authority is used over the Host field
+	res := serveHost(t, m, "GET http://admin.example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "admin"))
+
+	// Test: Unknown hosts get 404, or the default handler
+	assert.True(t, strings.HasPrefix(get("example.org"), "HTTP/1.1 404"))
+	assert.True(t, strings.HasPrefix(get("[::1]:8080"), "HTTP/1.1 404"))
+	m.Default = named("default")
+	assert.True(t, strings.HasSuffix(get("example.org"), "default"))
+
+	// Test: Malformed patterns panic
+	for _, p := range []string{"", "*", "*.", "a.*.com", "*.*.com", "**.com"} {
+		assert.Panics(t, func() { m.Handle(p, named("x")) }, p)
+	}
+}
@@ -0,0 +1,350 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Router dispatches requests by method and path pattern. Patterns are made
+// of "/"-separated segments, each of them either
+//
+//   - a literal, matching only itself,
+//   - "{name}", matching any one non-empty segment, or
+//   - "{name...}", last in the pattern, matching the rest of the path.
+//
+// A pattern ending in "/" matches the whole subtree below it, like one
+// ending in an unnamed "{...}". The values matched by named segments are
+// available from the request's PathValue.
+//
+// When several patterns match, the most specific wins: at the first segment
+// where they differ a literal beats "{name}", which beats the rest of the
+// path, and among equally specific ones the first registered wins. HEAD
+// requests go to the GET handler of a pattern without a HEAD handler of its
+// own. Paths no pattern matches answer 404, and paths that are only
+// registered for other methods answer 405 with an Allow field.
+// OPTIONS requests without a handler of their own are answered 204 with the
+// Allow field, "OPTIONS *" with every method the router serves.
+type Router struct {
+	routes map[string]*pattern
+	// order holds the patterns as they were registered, which settles
+	// ties between equally specific ones.
+	order []*pattern
+	// Defaults apply to every route; a route's own options can only
+	// tighten them.
+	Defaults RouteOptions
+	// NotFound answers requests no pattern matches; defaults to a bare 404.
+	NotFound server.Handler
+}
+
+// RouteOptions limit what a single route accepts. Zero values mean no limit.
+type RouteOptions struct {
+	// MaxBodyBytes rejects larger request bodies with 413. Chunked bodies
+	// are not known up front; reading past the limit fails instead.
+	MaxBodyBytes int
+	// HandlerTimeout bounds the handler as server.Timeout does.
+	HandlerTimeout time.Duration
+	// ReadTimeout bounds reading the request body from when the route is
+	// matched, as server.LimitRead does.
+	ReadTimeout time.Duration
+}
+
+// tighten returns the stricter of each setting in o and other.
+func (o RouteOptions) tighten(other RouteOptions) RouteOptions {
+	if other.MaxBodyBytes > 0 && (o.MaxBodyBytes == 0 || other.MaxBodyBytes < o.MaxBodyBytes) {
+		o.MaxBodyBytes = other.MaxBodyBytes
+	}
+	if other.HandlerTimeout > 0 && (o.HandlerTimeout == 0 || other.HandlerTimeout < o.HandlerTimeout) {
+		o.HandlerTimeout = other.HandlerTimeout
+	}
+	if other.ReadTimeout > 0 && (o.ReadTimeout == 0 || other.ReadTimeout < o.ReadTimeout) {
+		o.ReadTimeout = other.ReadTimeout
+	}
+	return o
+}
+
+type route struct {
+	handler server.Handler
+	options RouteOptions
+}
+
+// Segment kinds, ordered from most to least specific.
+const (
+	literal = iota
+	param
+	rest
+)
+
+type segment struct {
+	kind int
+	// value is the literal, or the parameter name.
+	value string
+}
+
+type pattern struct {
+	segments []segment
+	methods  map[string]route
+}
+
+// parsePattern splits a pattern into segments, panicking on malformed ones
+// as they are programming errors.
+func parsePattern(s string) []segment {
+	if !strings.HasPrefix(s, "/") {
+		panic(fmt.Sprintf("router: pattern %q does not start with /", s))
+	}
+	parts := strings.Split(s[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	seen := map[string]bool{}
+	for i, part := range parts {
+		last := i == len(parts)-1
+		if last && part == "" {
+			segments = append(segments, segment{kind: rest})
+			break
+		}
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				panic(fmt.Sprintf("router: bad segment %q in pattern %q", part, s))
+			}
+			segments = append(segments, segment{kind: literal, value: part})
+			continue
+		}
+		name := part[1 : len(part)-1]
+		kind := param
+		if before, ok := strings.CutSuffix(name, "..."); ok {
+			if !last {
+				panic(fmt.Sprintf("router: %q is not last in pattern %q", part, s))
+			}
+			name, kind = before, rest
+		}
+		if (name == "" && kind == param) || strings.ContainsAny(name, "{}") || (name != "" && seen[name]) {
+			panic(fmt.Sprintf("router: bad segment %q in pattern %q", part, s))
+		}
+		seen[name] = true
+		segments = append(segments, segment{kind: kind, value: name})
+	}
+	return segments
+}
+
+// match reports whether path, split into its segments, matches p and
+// returns the captured parameters.
+func (p *pattern) match(parts []string) (map[string]string, bool) {
+	var params map[string]string
+	capture := func(name, value string) {
+		if name == "" {
+			return
+		}
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[name] = value
+	}
+	for i, seg := range p.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case literal:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case param:
+			if parts[i] == "" {
+				return nil, false
+			}
+			capture(seg.value, parts[i])
+		case rest:
+			capture(seg.value, strings.Join(parts[i:], "/"))
+			return params, true
+		}
+	}
+	return params, len(parts) == len(p.segments)
+}
+
+// moreSpecific reports whether p takes precedence over other.
+func (p *pattern) moreSpecific(other *pattern) bool {
+	for i := 0; i < len(p.segments) && i < len(other.segments); i++ {
+		if a, b := p.segments[i].kind, other.segments[i].kind; a != b {
+			return a < b
+		}
+	}
+	return len(p.segments) > len(other.segments)
+}
+
+func NewRouter() *Router {
+	return &Router{routes: map[string]*pattern{}}
+}
+
+// Handle registers h for method on pattern. It panics if the pattern is
+// malformed.
+func (rt *Router) Handle(method, pattern string, h server.Handler) {
+	rt.HandleWith(method, pattern, h, RouteOptions{})
+}
+
+// HandleWith registers h with options that tighten the router's Defaults for
+// this route only, e.g. a larger body limit cannot be granted but a smaller
+// one can.
+func (rt *Router) HandleWith(method, p string, h server.Handler, options RouteOptions) {
+	pat, ok := rt.routes[p]
+	if !ok {
+		pat = &pattern{segments: parsePattern(p), methods: map[string]route{}}
+		rt.routes[p] = pat
+		rt.order = append(rt.order, pat)
+	}
+	pat.methods[strings.ToUpper(method)] = route{handler: h, options: options}
+}
+
+// lookup finds the most specific pattern matching path that has a handler
+// for method. allow lists the methods of every matching pattern, for when
+// none has one; it is nil when no pattern matches.
+func (rt *Router) lookup(method, path string) (r route, params map[string]string, allow []string) {
+	if !strings.HasPrefix(path, "/") {
+		return route{}, nil, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var best *pattern
+	methods := map[string]bool{}
+	for _, pat := range rt.order {
+		matched, ok := pat.match(parts)
+		if !ok {
+			continue
+		}
+		for m := range pat.methods {
+			methods[m] = true
+		}
+		candidate, ok := pat.methods[method]
+		if !ok && method == "HEAD" {
+			candidate, ok = pat.methods["GET"]
+		}
+		if ok && (best == nil || pat.moreSpecific(best)) {
+			best, r, params = pat, candidate, matched
+		}
+	}
+	if best != nil {
+		return r, params, nil
+	}
+	if len(methods) == 0 {
+		return route{}, nil, nil
+	}
+	return route{}, nil, allowList(methods)
+}
+
+// allowList sorts methods for an Allow field, adding HEAD where GET is
+// served and OPTIONS, which always is.
+func allowList(methods map[string]bool) []string {
+	if methods["GET"] {
+		methods["HEAD"] = true
+	}
+	methods["OPTIONS"] = true
+	allow := make([]string, 0, len(methods))
+	for m := range methods {
+		allow = append(allow, m)
+	}
+	sort.Strings(allow)
+	return allow
+}
+
+// methods lists every method the router serves, for "OPTIONS *".
+func (rt *Router) methods() []string {
+	methods := map[string]bool{}
+	for _, pat := range rt.order {
+		for m := range pat.methods {
+			methods[m] = true
+		}
+	}
+	return allowList(methods)
+}
+
+// Allow returns the methods registered for the patterns matching path,
+// sorted, for use in Allow headers. It is nil when nothing matches.
+func (rt *Router) Allow(path string) []string {
+	_, _, allow := rt.lookup("", routePath(path))
+	return allow
+}
+
+// routePath strips the query and fragment from a request target.
+func routePath(target string) string {
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		return target[:i]
+	}
+	return target
+}
+
+// allowedKey holds what Allowed needs in the context of routed requests.
+type allowedKey struct{}
+
+type allowed struct {
+	rt   *Router
+	path string
+}
+
+// Allowed returns the methods the path of req is routed for, as sent in
+// Allow fields, to a handler the router dispatched it to, e.g. to answer
+// OPTIONS itself. It is nil for requests that came some other way.
+func Allowed(req *request.Request) []string {
+	a, ok := req.Context().Value(allowedKey{}).(allowed)
+	if !ok {
+		return nil
+	}
+	return a.rt.Allow(a.path)
+}
+
+// writeOptions answers an OPTIONS request with what it may do.
+func writeOptions(w *response.Writer, allow []string) {
+	h := headers.NewHeaders()
+	h.Set("Allow", strings.Join(allow, ", "))
+	w.WriteStatusLine(response.StatusNoContent)
+	w.WriteHeaders(*h)
+}
+
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	if req.Target().Form == request.AsteriskForm {
+		writeOptions(w, rt.methods())
+		return
+	}
+	r, params, allow := rt.lookup(req.RequestLine.Method, req.Path())
+	if r.handler == nil {
+		if allow != nil && req.RequestLine.Method == "OPTIONS" {
+			writeOptions(w, allow)
+			return
+		}
+		if allow != nil {
+			server.WriteMethodNotAllowed(w, allow)
+			return
+		}
+		if rt.NotFound != nil {
+			rt.NotFound(w, req)
+			return
+		}
+		w.WriteStatusLine(response.StatusNotFound)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+		return
+	}
+	if params != nil {
+		req = req.WithPathValues(params)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), allowedKey{}, allowed{rt, req.Path()}))
+	options := rt.Defaults.tighten(r.options)
+	if options.MaxBodyBytes > 0 {
+		if req.ContentLength() > int64(options.MaxBodyBytes) {
+			w.WriteStatusLine(response.StatusContentTooLarge)
+			w.WriteHeaders(*response.GetDefaultHeaders(0))
+			return
+		}
+		// Chunked bodies can only be cut off while they are read.
+		req.LimitBody(int64(options.MaxBodyBytes))
+	}
+	if options.ReadTimeout > 0 {
+		server.LimitRead(req, options.ReadTimeout)
+	}
+	if options.HandlerTimeout > 0 {
+		server.Timeout(options.HandlerTimeout)(r.handler)(w, req)
+		return
+	}
+	r.handler(w, req)
+}
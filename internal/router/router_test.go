@@ -0,0 +1,226 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveRoute(t *testing.T, rt *Router, method, target string, body ...string) string {
+	raw := method + " " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n"
+	if len(body) > 0 {
+		raw += fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body[0]), body[0])
+	} else {
+		raw += "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	rt.Serve(response.NewWriter(out), req)
+	return out.String()
+}
+
+func TestRouter(t *testing.T) {
+	rt := NewRouter()
+	named := func(name string) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(name)))
+			w.WriteBody([]byte(name))
+		}
+	}
+	rt.Handle("GET", "/files/", named("files"))
+	rt.Handle("GET", "/files/special", named("special"))
+	rt.Handle("DELETE", "/files/special", named("delete"))
+	rt.Handle("post", "/upload", named("upload"))
+
+	// Test: Longest matching pattern wins, query is ignored
+	assert.True(t, strings.HasSuffix(serveRoute(t, rt, "GET", "/files/a/b?x=1"), "files"))
+	assert.True(t, strings.HasSuffix(serveRoute(t, rt, "GET", "/files/special?x=1"), "special"))
+	assert.True(t, strings.HasSuffix(serveRoute(t, rt, "DELETE", "/files/special"), "delete"))
+	assert.True(t, strings.HasSuffix(serveRoute(t, rt, "POST", "/upload"), "upload"))
+
+	// Test: Routes match the decoded, normalized path
+	assert.True(t, strings.HasSuffix(serveRoute(t, rt, "GET", "/files/x/..%2Fspecial"), "special"))
+	assert.True(t, strings.HasSuffix(serveRoute(t, rt, "POST", "/files/%2e%2e/upload"), "upload"))
+
+	// Test: Unknown paths answer 404
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "GET", "/upload/more"), "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Known paths with other methods answer 405 with the registered methods
+	out := serveRoute(t, rt, "PUT", "/files/special")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "Allow: DELETE, GET, HEAD, OPTIONS\r\n")
+	assert.Contains(t, serveRoute(t, rt, "GET", "/upload"), "Allow: OPTIONS, POST\r\n")
+	assert.Equal(t, []string{"GET", "HEAD", "OPTIONS"}, rt.Allow("/files/x?y"))
+	assert.Nil(t, rt.Allow("/nothing"))
+
+	// Test: HEAD falls back to the GET handler
+	assert.True(t, strings.HasSuffix(serveRoute(t, rt, "HEAD", "/files/special"), "special"))
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "HEAD", "/upload"), "HTTP/1.1 405 Method Not Allowed\r\n"))
+}
+
+func TestPathParams(t *testing.T) {
+	rt := NewRouter()
+	show := func(name string, params ...string) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			out := name
+			for _, p := range params {
+				out += " " + p + "=" + req.PathValue(p)
+			}
+			w.WriteText(response.StatusOK, out)
+		}
+	}
+	rt.Handle("GET", "/users/{id}", show("user", "id"))
+	rt.Handle("GET", "/users/me", show("me"))
+	rt.Handle("DELETE", "/users/{id}", show("delete", "id"))
+	rt.Handle("GET", "/users/{id}/posts/{post}", show("post", "id", "post"))
+	rt.Handle("GET", "/files/{path...}", show("file", "path"))
+	rt.Handle("GET", "/files/{dir}/index", show("index", "dir"))
+	body := func(method, target string) string {
+		out := serveRoute(t, rt, method, target)
+		_, b, _ := strings.Cut(out, "\r\n\r\n")
+		return b
+	}
+
+	// Test: Named segments are captured
+	assert.Equal(t, "user id=42", body("GET", "/users/42"))
+	assert.Equal(t, "delete id=7", body("DELETE", "/users/7"))
+	assert.Equal(t, "post id=42 post=hello", body("GET", "/users/42/posts/hello"))
+
+	// Test: Literals beat parameters, which beat the rest of the path
+	assert.Equal(t, "me", body("GET", "/users/me"))
+	assert.Equal(t, "index dir=docs", body("GET", "/files/docs/index"))
+	assert.Equal(t, "file path=docs/a/b.txt", body("GET", "/files/docs/a/b.txt"))
+	assert.Equal(t, "file path=", body("GET", "/files/"))
+
+	// Test: Among equally specific patterns the first registered wins
+	rt.Handle("GET", "/teams/{id}", show("team", "id"))
+	rt.Handle("GET", "/teams/{name}", show("team", "name"))
+	for range 20 {
+		assert.Equal(t, "team id=a", body("GET", "/teams/a"))
+	}
+
+	// Test: Parameters match exactly one non-empty segment
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "GET", "/users/"), "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "GET", "/users/42/posts"), "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Values are decoded
+	assert.Equal(t, "user id=a b", body("GET", "/users/a%20b"))
+
+	// Test: Allow covers every pattern matching the path
+	out := serveRoute(t, rt, "PUT", "/users/me")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "Allow: DELETE, GET, HEAD, OPTIONS\r\n")
+
+	// Test: Malformed patterns are rejected when registered
+	for _, pattern := range []string{"users", "/{}", "/{a}/{a}", "/{rest...}/x", "/a{b}"} {
+		assert.Panics(t, func() { rt.Handle("GET", pattern, show("bad")) }, pattern)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	rt := NewRouter()
+	var allowed []string
+	ok := func(w *response.Writer, req *request.Request) {
+		allowed = Allowed(req)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}
+	rt.Handle("GET", "/files/", ok)
+	rt.Handle("DELETE", "/files/{name}", ok)
+	rt.Handle("PUT", "/upload", ok)
+	rt.Handle("OPTIONS", "/upload", func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "custom")
+	})
+
+	// Test: OPTIONS is answered with the methods of the path
+	out := serveRoute(t, rt, "OPTIONS", "/files/a")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, out, "Allow: DELETE, GET, HEAD, OPTIONS\r\n")
+	assert.NotContains(t, out, "Content-Length")
+
+	// Test: OPTIONS handlers of its own take precedence
+	assert.True(t, strings.HasSuffix(serveRoute(t, rt, "OPTIONS", "/upload"), "custom"))
+
+	// Test: Unknown paths still answer 404
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "OPTIONS", "/nothing"), "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: "OPTIONS *" lists every method served
+	out = serveRoute(t, rt, "OPTIONS", "*")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, out, "Allow: DELETE, GET, HEAD, OPTIONS, PUT\r\n")
+
+	// Test: Handlers learn the other methods of their path
+	serveRoute(t, rt, "DELETE", "/files/a")
+	assert.Equal(t, []string{"DELETE", "GET", "HEAD", "OPTIONS"}, allowed)
+	serveRoute(t, rt, "GET", "/files/a/b")
+	assert.Equal(t, []string{"GET", "HEAD", "OPTIONS"}, allowed)
+	assert.Nil(t, Allowed(&request.Request{}))
+}
+
+func TestRouteOptions(t *testing.T) {
+	rt := NewRouter()
+	rt.Defaults = RouteOptions{MaxBodyBytes: 8, HandlerTimeout: time.Minute}
+	var deadline time.Time
+	accept := func(w *response.Writer, req *request.Request) {
+		deadline, _ = req.Context().Deadline()
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}
+	rt.Handle("POST", "/default", accept)
+	rt.HandleWith("POST", "/upload", accept, RouteOptions{MaxBodyBytes: 1 << 20, HandlerTimeout: time.Second})
+	rt.HandleWith("POST", "/tiny", accept, RouteOptions{MaxBodyBytes: 2})
+
+	// Test: Server-wide defaults apply to plain routes
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "POST", "/default", "12345678"), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "POST", "/default", "123456789"), "HTTP/1.1 413 Content Too Large\r\n"))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	// Test: The tightest setting wins
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "POST", "/upload", "123456789"), "HTTP/1.1 413 Content Too Large\r\n"))
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "POST", "/upload", "1234"), "HTTP/1.1 200 OK\r\n"))
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, time.Second)
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "POST", "/tiny", "123"), "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Handlers running out of time are answered 503
+	rt.HandleWith("GET", "/slow", func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+	}, RouteOptions{HandlerTimeout: 10 * time.Millisecond})
+	assert.True(t, strings.HasPrefix(serveRoute(t, rt, "GET", "/slow"), "HTTP/1.1 503 Service Unavailable\r\n"))
+
+	// Test: Bodies slower than the read timeout fail to read
+	var bodyErr error
+	rt = NewRouter()
+	rt.HandleWith("POST", "/trickle", func(w *response.Writer, req *request.Request) {
+		_, bodyErr = io.ReadAll(req.BodyReader())
+		w.WriteText(response.StatusBadRequest, "slow body")
+	}, RouteOptions{ReadTimeout: 50 * time.Millisecond})
+	s, err := server.ServeAddr("127.0.0.1:0", rt.Serve)
+	require.NoError(t, err)
+	defer s.Close()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("POST /trickle HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\nab"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	var netErr net.Error
+	assert.True(t, errors.As(bodyErr, &netErr) && netErr.Timeout())
+}
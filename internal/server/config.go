@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"http/internal/request"
+	"log"
+)
+
+// Limits bound what clients may send. Zero fields keep the defaults.
+type Limits struct {
+	// MaxRequestLineBytes; see WithMaxRequestLineBytes.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes; see WithMaxHeaderBytes.
+	MaxHeaderBytes int
+	// MaxHeaderFields; see WithMaxHeaderFields.
+	MaxHeaderFields int
+	// MaxPipelined; see WithMaxPipelined. Negative turns the cap off.
+	MaxPipelined int
+	// Conns; see WithConnLimit.
+	Conns ConnLimit
+}
+
+// Config holds what a server needs to run. Knobs without a field here are
+// set with options, passed to New along with it.
+type Config struct {
+	// Addr is where to listen, as for ServeAddr.
+	Addr string
+	// ReusePort; see WithReusePort.
+	ReusePort bool
+	Handler   Handler
+	// Timeouts replace the defaults unless all zero; see WithTimeouts.
+	Timeouts Timeouts
+	Limits   Limits
+	// Parser says what request parsing tolerates; the zero value is
+	// request.Strict.
+	Parser request.ParserOptions
+	// TLS serves connections over TLS; see WithTLSConfig.
+	TLS *tls.Config
+	// Logger receives what the server logs; see WithLogger.
+	Logger *log.Logger
+	// ErrorHandler replaces DefaultErrorHandler.
+	ErrorHandler ErrorHandler
+	// Middleware wraps Handler, as with Use.
+	Middleware []Middleware
+}
+
+// options turns the config into the options that set it.
+func (c Config) options() []Option {
+	opts := []Option{}
+	if c.ReusePort {
+		opts = append(opts, WithReusePort(true))
+	}
+	if c.Timeouts != (Timeouts{}) {
+		opts = append(opts, WithTimeouts(c.Timeouts))
+	}
+	l := c.Limits
+	if l.MaxRequestLineBytes != 0 {
+		opts = append(opts, WithMaxRequestLineBytes(l.MaxRequestLineBytes))
+	}
+	if l.MaxHeaderBytes != 0 {
+		opts = append(opts, WithMaxHeaderBytes(l.MaxHeaderBytes))
+	}
+	if l.MaxHeaderFields != 0 {
+		opts = append(opts, WithMaxHeaderFields(l.MaxHeaderFields))
+	}
+	if l.MaxPipelined != 0 {
+		opts = append(opts, WithMaxPipelined(max(l.MaxPipelined, 0)))
+	}
+	if l.Conns != (ConnLimit{}) {
+		opts = append(opts, WithConnLimit(l.Conns))
+	}
+	if c.Parser != (request.ParserOptions{}) {
+		opts = append(opts, WithParserOptions(c.Parser))
+	}
+	if c.TLS != nil {
+		opts = append(opts, WithTLSConfig(c.TLS))
+	}
+	if c.Logger != nil {
+		opts = append(opts, WithLogger(c.Logger))
+	}
+	if c.ErrorHandler != nil {
+		opts = append(opts, WithErrorHandler(c.ErrorHandler))
+	}
+	if len(c.Middleware) > 0 {
+		opts = append(opts, Use(c.Middleware...))
+	}
+	return opts
+}
+
+// New listens on config.Addr and serves connections with config.Handler
+// in the background. opts apply after the config, so they win where both
+// set something.
+func New(config Config, opts ...Option) (*Server, error) {
+	server := &Server{
+		handler:      config.Handler,
+		hookTimeout:  DefaultShutdownHookTimeout,
+		maxPipelined: DefaultMaxPipelined,
+		serverHeader: DefaultServerHeader,
+		timeouts:     Timeouts{ReadHeader: DefaultReadHeaderTimeout, Idle: DefaultIdleTimeout},
+	}
+	for _, opt := range append(config.options(), opts...) {
+		opt(server)
+	}
+	listener, err := listen(config.Addr, server.reusePort)
+	if err != nil {
+		return nil, err
+	}
+	server.socket = listener
+	if server.baseCtx == nil {
+		server.baseCtx = context.Background()
+	}
+	server.baseCtx, server.cancelBase = context.WithCancel(server.baseCtx)
+	server.handler = Chain(server.middleware...)(server.traceHandler(config.Handler))
+	server.tls = server.serverTLSConfig()
+	server.listener = server.wrapListener(listener)
+	go runServer(server, server.listener)
+	return server, nil
+}
+
+// WithLogger sends what the server logs, such as parse errors and handler
+// panics, to logger instead of the standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	logs := &bytes.Buffer{}
+	tagged := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.DefaultHeaders().Set("X-Tagged", "yes")
+			next(w, req)
+		}
+	}
+	s, err := New(Config{
+		Addr:    "127.0.0.1:0",
+		Handler: echoTarget,
+		Timeouts: Timeouts{
+			ReadHeader: 100 * time.Millisecond,
+		},
+		Limits: Limits{MaxRequestLineBytes: 64},
+		Parser: request.ParserOptions{BareLF: true},
+		Logger: log.New(logs, "", 0),
+		ErrorHandler: func(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+			w.WriteText(status, fmt.Sprintf("custom %d", status))
+		},
+		Middleware: []Middleware{tagged},
+	}, WithServerHeader("configured"))
+	require.NoError(t, err)
+	defer s.Close()
+	send := func(raw string) (*http.Response, string) {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.WriteString(conn, raw)
+		require.NoError(t, err)
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	// Test: Handler and middleware serve requests, options still apply
+	res, body := send("GET /configured HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "/configured", body)
+	assert.Equal(t, "yes", res.Header.Get("X-Tagged"))
+	assert.Equal(t, "configured", res.Header.Get("Server"))
+
+	// Test: Parser options apply
+	_, body = send("GET /bare HTTP/1.1\nHost: x\n\n")
+	assert.Equal(t, "/bare", body)
+
+	// Test: Limits and the error handler are used
+	res, body = send("GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, http.StatusRequestURITooLong, res.StatusCode)
+	assert.Equal(t, "custom 414", body)
+
+	// Test: Timeouts replace the defaults
+	res, _ = send("GET / HTTP/1.1\r\n")
+	assert.Equal(t, http.StatusRequestTimeout, res.StatusCode)
+
+	// Test: The server logs to the configured logger
+	assert.Contains(t, logs.String(), "Request parsed successfully: GET /configured")
+	assert.Contains(t, logs.String(), "Request parsing failed")
+
+	// Test: Without an address to listen on New fails
+	_, err = New(Config{Addr: "127.0.0.1:-1", Handler: echoTarget})
+	assert.Error(t, err)
+}
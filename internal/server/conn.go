@@ -0,0 +1,115 @@
+package server
+
+import (
+	"http/internal/proxyproto"
+	"net"
+	"time"
+)
+
+// ConnEvent describes a single Read or Write on an accepted connection.
+type ConnEvent struct {
+	Conn     net.Conn
+	Bytes    int
+	Duration time.Duration
+	Err      error
+}
+
+// ConnHooks observe every read and write on accepted connections. Hooks run
+// synchronously right after the operation returns, so a hook that sleeps
+// slows the connection down, which is handy to simulate slow networks.
+type ConnHooks struct {
+	OnAccept func(conn net.Conn)
+	OnRead   func(ev ConnEvent)
+	OnWrite  func(ev ConnEvent)
+	OnClose  func(conn net.Conn)
+}
+
+// WithConnHooks installs hooks on every connection the server accepts. It is
+// a connection wrapper, so it sees bytes as left by the wrappers registered
+// before it.
+func WithConnHooks(hooks ConnHooks) Option {
+	return WithConnWrapper(func(conn net.Conn) net.Conn {
+		if hooks.OnAccept != nil {
+			hooks.OnAccept(conn)
+		}
+		return &hookedConn{Conn: conn, hooks: &hooks}
+	})
+}
+
+// WithListenerWrapper decorates the listening socket, e.g. to throttle
+// accepts. Wrappers are applied in registration order, the first one
+// wrapping the raw listener.
+func WithListenerWrapper(wrap func(net.Listener) net.Listener) Option {
+	return func(s *Server) {
+		s.listenerWrappers = append(s.listenerWrappers, wrap)
+	}
+}
+
+// WithConnWrapper decorates every accepted connection before the parser
+// reads from it, e.g. to strip a PROXY protocol header or mirror traffic.
+// Wrappers are applied in registration order, the first one wrapping the
+// raw connection.
+func WithConnWrapper(wrap func(net.Conn) net.Conn) Option {
+	return func(s *Server) {
+		s.connWrappers = append(s.connWrappers, wrap)
+	}
+}
+
+// WithProxyProtocol expects every connection to start with a PROXY protocol
+// header, version 1 or 2, as sent by load balancers such as HAProxy.
+// Requests then report the client address it gives as their RemoteAddr.
+// Connections without a valid header are closed. The header is stripped
+// before connection wrappers see the bytes.
+func WithProxyProtocol(enabled bool) Option {
+	return func(s *Server) {
+		s.proxyProtocol = enabled
+	}
+}
+
+type hookedConn struct {
+	net.Conn
+	hooks *ConnHooks
+}
+
+func (c *hookedConn) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Read(p)
+	if c.hooks.OnRead != nil {
+		c.hooks.OnRead(ConnEvent{Conn: c.Conn, Bytes: n, Duration: time.Since(start), Err: err})
+	}
+	return n, err
+}
+
+func (c *hookedConn) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Write(p)
+	if c.hooks.OnWrite != nil {
+		c.hooks.OnWrite(ConnEvent{Conn: c.Conn, Bytes: n, Duration: time.Since(start), Err: err})
+	}
+	return n, err
+}
+
+func (c *hookedConn) Close() error {
+	err := c.Conn.Close()
+	if c.hooks.OnClose != nil {
+		c.hooks.OnClose(c.Conn)
+	}
+	return err
+}
+
+func (s *Server) wrapListener(listener net.Listener) net.Listener {
+	for _, wrap := range s.listenerWrappers {
+		listener = wrap(listener)
+	}
+	return listener
+}
+
+func (s *Server) wrapConn(conn net.Conn) net.Conn {
+	if s.proxyProtocol {
+		conn = proxyproto.NewConn(conn, s.logf)
+	}
+	for _, wrap := range s.connWrappers {
+		conn = wrap(conn)
+	}
+	return conn
+}
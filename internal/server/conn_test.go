@@ -0,0 +1,390 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnHooks(t *testing.T) {
+	var mu sync.Mutex
+	read, written, accepted, closed := 0, 0, 0, 0
+	s := &Server{handler: echoTarget}
+	WithConnHooks(ConnHooks{
+		OnAccept: func(conn net.Conn) { accepted++ },
+		OnRead: func(ev ConnEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			read += ev.Bytes
+		},
+		OnWrite: func(ev ConnEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			written += ev.Bytes
+			// Simulate a slow network.
+			time.Sleep(10 * time.Millisecond)
+		},
+		OnClose: func(conn net.Conn) { closed++ },
+	})(s)
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		runConnection(s, s.wrapConn(conn))
+		close(done)
+	}()
+
+	// Test: Every byte read and written is reported
+	raw := "GET /hooked HTTP/1.1\r\nHost: localhost:42069\r\nConnection: close\r\n\r\n"
+	start := time.Now()
+	_, err := client.Write([]byte(raw))
+	require.NoError(t, err)
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	<-done
+	assert.Contains(t, string(out), "/hooked")
+	assert.Equal(t, len(raw), read)
+	assert.Equal(t, len(out), written)
+	assert.Equal(t, 1, accepted)
+	assert.Equal(t, 1, closed)
+
+	// Test: Hooks run inline, so they can slow the connection down
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+}
+
+// prefixConn replays prefix before the connection's own bytes.
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+type countingListener struct {
+	net.Listener
+	accepted *atomic.Int32
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestConnWrappers(t *testing.T) {
+	accepted := &atomic.Int32{}
+	order := []string{}
+	prefix := func(name, target string) func(net.Conn) net.Conn {
+		return func(conn net.Conn) net.Conn {
+			order = append(order, name)
+			return &prefixConn{Conn: conn, r: io.MultiReader(strings.NewReader(target), conn)}
+		}
+	}
+	s, err := Serve(0, echoTarget,
+		WithListenerWrapper(func(l net.Listener) net.Listener { return countingListener{l, accepted} }),
+		// The second wrapper reads first, so the parser sees "/a" then "/b".
+		WithConnWrapper(prefix("first", "/b HTTP/1.1\r\nHost: x\r\n\r\n")),
+		WithConnWrapper(prefix("second", "GET /a")),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Wrappers apply in registration order before the parser reads
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "/a/b", string(body))
+	assert.Equal(t, []string{"first", "second"}, order)
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestConnInfo(t *testing.T) {
+	var seen request.ConnInfo
+	handler := func(w *response.Writer, req *request.Request) {
+		seen = request.ConnInfo{RemoteAddr: req.RemoteAddr(), LocalAddr: req.LocalAddr(), TLS: req.TLS()}
+		echoTarget(w, req)
+	}
+
+	// Test: Plain connections carry both addresses
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	_, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, conn.LocalAddr().String(), seen.RemoteAddr.String())
+	assert.Equal(t, conn.RemoteAddr().String(), seen.LocalAddr.String())
+	assert.Nil(t, seen.TLS)
+
+	// Test: TLS connections expose the negotiated session
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	defer ts.Close()
+	serverConn, clientConn := net.Pipe()
+	go runConnection(&Server{handler: handler}, tls.Server(serverConn, ts.TLS))
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, ServerName: "example.com"})
+	defer client.Close()
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	_, err = http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	require.NotNil(t, seen.TLS)
+	assert.True(t, seen.TLS.HandshakeComplete)
+	assert.Equal(t, "example.com", seen.TLS.ServerName)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	var remote, local string
+	handler := func(w *response.Writer, req *request.Request) {
+		remote, local = req.RemoteAddr().String(), req.LocalAddr().String()
+		echoTarget(w, req)
+	}
+	s, err := Serve(0, handler, WithProxyProtocol(true))
+	require.NoError(t, err)
+	defer s.Close()
+	dial := func(preamble string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", s.listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte(preamble + "GET /proxied HTTP/1.1\r\nHost: x\r\n\r\n"))
+		return conn, bufio.NewReader(conn)
+	}
+
+	// Test: The addresses of the header become the request's
+	_, br := dial("PROXY TCP4 203.0.113.7 192.0.2.1 51000 443\r\n")
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "/proxied", string(body))
+	assert.Equal(t, "203.0.113.7:51000", remote)
+	assert.Equal(t, "192.0.2.1:443", local)
+
+	// Test: Connections without a valid header are closed unanswered
+	for _, preamble := range []string{"", "PROXY TCP4 203.0.113.7\r\n"} {
+		_, br = dial(preamble)
+		_, err = br.ReadByte()
+		assert.ErrorIs(t, err, io.EOF, preamble)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := &Server{handler: echoTarget}
+	WithIdleTimeout(50 * time.Millisecond)(s)
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		runConnection(s, conn)
+		close(done)
+	}()
+	br := bufio.NewReader(client)
+
+	// Test: The connection stays open across requests
+	for _, target := range []string{"/one", "/two"} {
+		_, err := client.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, target, string(body))
+		assert.False(t, res.Close)
+	}
+
+	// Test: It is closed once no request follows within the timeout
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+	_, err := br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestTimeouts(t *testing.T) {
+	start := func(s *Server) (net.Conn, chan struct{}) {
+		client, conn := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			runConnection(s, conn)
+			close(done)
+		}()
+		return client, done
+	}
+	wait := func(done chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("connection was not closed")
+		}
+	}
+
+	// Test: A head that trickles in too slowly is answered 408
+	s := &Server{handler: echoTarget}
+	WithTimeouts(Timeouts{ReadHeader: 50 * time.Millisecond})(s)
+	client, done := start(s)
+	_, err := client.Write([]byte("GET /slow HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	wait(done)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 408 Request Timeout\r\n"))
+	assert.Contains(t, string(out), "Connection: close\r\n")
+
+	// Test: Handlers reading a body past the read timeout get a timeout error
+	var bodyErr error
+	s = &Server{handler: func(w *response.Writer, req *request.Request) {
+		_, bodyErr = io.ReadAll(req.BodyReader())
+		w.WriteText(response.StatusBadRequest, "slow body")
+	}}
+	WithTimeouts(Timeouts{Read: 50 * time.Millisecond})(s)
+	client, done = start(s)
+	_, err = client.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\nab"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.True(t, isTimeout(bodyErr))
+	client.Close()
+	wait(done)
+
+	// Test: LimitRead gives the body less time than the server does
+	s = &Server{handler: func(w *response.Writer, req *request.Request) {
+		LimitRead(req, 50*time.Millisecond)
+		_, bodyErr = io.ReadAll(req.BodyReader())
+		w.WriteText(response.StatusBadRequest, "slow body")
+	}}
+	WithTimeouts(Timeouts{Read: time.Minute})(s)
+	client, done = start(s)
+	_, err = client.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\nab"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.True(t, isTimeout(bodyErr))
+	client.Close()
+	wait(done)
+
+	// Test: A client that does not read the response is dropped
+	s = &Server{handler: func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, strings.Repeat("x", 64<<10))
+	}}
+	WithTimeouts(Timeouts{Write: 50 * time.Millisecond})(s)
+	client, done = start(s)
+	_, err = client.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	wait(done)
+}
+
+func TestMinHeaderRate(t *testing.T) {
+	s := &Server{handler: echoTarget}
+	WithTimeouts(Timeouts{MinHeaderRate: 100})(s)
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		runConnection(s, conn)
+		close(done)
+	}()
+	br := bufio.NewReader(client)
+
+	// Test: Heads sent in one go are served, repeatedly
+	for _, target := range []string{"/one", "/two"} {
+		_, err := client.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, target, string(body))
+	}
+
+	// Test: A head trickling in below the rate is answered 408 once the
+	// grace period is over, with no ReadHeader timeout set
+	started := time.Now()
+	_, err := client.Write([]byte("GET /slow HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	go func() {
+		for {
+			time.Sleep(100 * time.Millisecond)
+			if _, err := client.Write([]byte("X")); err != nil {
+				return
+			}
+		}
+	}()
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestTimeout, res.StatusCode)
+	assert.Greater(t, time.Since(started), minRateGrace)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow connection was not closed")
+	}
+	client.Close()
+}
+
+func TestPipelinedMix(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		switch req.Path() {
+		case "/slow":
+			// A slow first response must not be overtaken.
+			time.Sleep(20 * time.Millisecond)
+			echoTarget(w, req)
+		case "/echo":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteBodyReader(req.BodyReader(), -1)
+		default:
+			echoTarget(w, req)
+		}
+	}
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		runConnection(&Server{handler: handler}, conn)
+		close(done)
+	}()
+	raw := "GET /slow HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+		"POST /skipped HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nskip" +
+		"HEAD /head HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"GET /last HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n" +
+		"GET /never HTTP/1.1\r\nHost: x\r\n\r\n"
+	go client.Write([]byte(raw))
+
+	// Test: Requests sent back to back are answered in order, bodies
+	// included, until one asks to close
+	br := bufio.NewReader(client)
+	for _, want := range []struct{ method, body string }{
+		{"GET", "/slow"}, {"POST", "hello"}, {"POST", "/skipped"}, {"HEAD", ""}, {"GET", "/last"},
+	} {
+		res, err := http.ReadResponse(br, &http.Request{Method: want.method})
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want.body, string(body))
+	}
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	<-done
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// WithBaseContext makes ctx the parent of every connection's context, and
+// so of every request's. It defaults to context.Background.
+func WithBaseContext(ctx context.Context) Option {
+	return func(s *Server) {
+		s.baseCtx = ctx
+	}
+}
+
+// WithConnContext lets fn derive the context of each accepted connection
+// from the base context, e.g. to attach values about the connection.
+func WithConnContext(fn func(ctx context.Context, conn net.Conn) context.Context) Option {
+	return func(s *Server) {
+		s.connContext = fn
+	}
+}
+
+// newConnContext returns the context of a connection being served. It is
+// cancelled when the server closes, or when the connection ends.
+func (s *Server) newConnContext(conn io.ReadWriteCloser) (context.Context, context.CancelFunc) {
+	ctx := s.baseCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = withServer(ctx, s)
+	if c, ok := conn.(net.Conn); ok && s.connContext != nil {
+		ctx = s.connContext(ctx, c)
+	}
+	return context.WithCancel(ctx)
+}
+
+// cancelContexts stops the work of every connection.
+func (s *Server) cancelContexts() {
+	if s.cancelBase != nil {
+		s.cancelBase()
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ctxKey string
+
+func TestConnContext(t *testing.T) {
+	started := make(chan context.Context, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/wait" {
+			started <- req.Context()
+			<-req.Context().Done()
+			return
+		}
+		w.WriteText(response.StatusOK, fmt.Sprint(req.Context().Value(ctxKey("base")), " ", req.Context().Value(ctxKey("conn"))))
+	}
+	base := context.WithValue(context.Background(), ctxKey("base"), "b")
+	serve := func() *Server {
+		s, err := ServeAddr("127.0.0.1:0", handler, WithBaseContext(base),
+			WithConnContext(func(ctx context.Context, conn net.Conn) context.Context {
+				return context.WithValue(ctx, ctxKey("conn"), conn.LocalAddr().String())
+			}))
+		require.NoError(t, err)
+		return s
+	}
+	dial := func(s *Server, target string) net.Conn {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: localhost\r\n\r\n", target)
+		return conn
+	}
+
+	// Test: Request contexts carry the values of the base and connection
+	// contexts
+	s := serve()
+	defer s.Close()
+	res, err := http.ReadResponse(bufio.NewReader(dial(s, "/")), nil)
+	require.NoError(t, err)
+	body := make([]byte, 64)
+	n, _ := res.Body.Read(body)
+	assert.Equal(t, "b "+s.Addr().String(), string(body[:n]))
+
+	// Test: Close cancels the contexts of running requests
+	dial(s, "/wait")
+	ctx := <-started
+	s.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("request context not cancelled by Close")
+	}
+
+	// Test: A Shutdown that runs out of time cancels them too
+	s = serve()
+	defer s.Close()
+	dial(s, "/wait")
+	ctx = <-started
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(shutdownCtx), context.DeadlineExceeded)
+	assert.Error(t, ctx.Err())
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"http/internal/request"
+	"http/internal/response"
+	"log"
+	"strings"
+)
+
+// ErrorHandler writes the response for a request the server could not
+// serve normally, e.g. because it failed to parse. req is nil when there
+// is no parsed request; err is the cause, if known. The response may
+// already have been started, in which case writing it fails.
+type ErrorHandler func(w *response.Writer, req *request.Request, status response.StatusCode, err error)
+
+// WithErrorHandler replaces DefaultErrorHandler.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(s *Server) {
+		s.errorHandler = h
+	}
+}
+
+func (s *Server) handleError(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	if s.errorHandler != nil {
+		s.errorHandler(w, req, status, err)
+		return
+	}
+	DefaultErrorHandler(w, req, status, err)
+}
+
+// errorBody is the JSON DefaultErrorHandler sends.
+type errorBody struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// DefaultErrorHandler sends a short page naming the status: JSON to clients
+// that prefer it by Accept, HTML otherwise. err is not shown, as it may
+// reveal internals.
+func DefaultErrorHandler(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	text := status.Text()
+	var werr error
+	if req != nil && req.Negotiate("text/html", "application/json") == "application/json" {
+		werr = w.WriteJSON(status, errorBody{Status: int(status), Error: text})
+	} else {
+		title := html.EscapeString(fmt.Sprintf("%d %s", status, text))
+		werr = w.WriteHTML(status, fmt.Sprintf(`<html>
+  <head>
+    <title>%s</title>
+  </head>
+  <body>
+    <h1>%s</h1>
+  </body>
+</html>`, title, html.EscapeString(text)))
+	}
+	if werr != nil {
+		log.Printf("Writing %d error response failed: %v", status, werr)
+	}
+}
+
+// WriteMethodNotAllowed answers 405 with the given Allow list. Callers get
+// the list from a router instead of spelling it out.
+func WriteMethodNotAllowed(w *response.Writer, allow []string) {
+	h := response.GetDefaultHeaders(0)
+	h.Set("Allow", strings.Join(allow, ", "))
+	w.WriteStatusLine(response.StatusMethodNotAllowed)
+	w.WriteHeaders(*h)
+}
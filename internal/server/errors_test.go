@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"http/internal/request"
+	"http/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorHandler(t *testing.T) {
+	// Test: Parse errors get an HTML page and close the connection
+	out := serveRaw(&Server{}, echoTarget, "GET / HTTP/1.1\r\n\r\n", 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, out, "Content-Type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.Contains(t, out, "<title>400 Bad Request</title>")
+
+	// Test: Clients preferring JSON get JSON
+	raw := "POST / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: application/json\r\nExpect: nothing\r\nContent-Length: 2\r\n\r\nhi"
+	out = serveRaw(&Server{}, echoTarget, raw, 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 417 Expectation Failed\r\n"))
+	assert.Contains(t, out, "Content-Type: application/json\r\n")
+	assert.True(t, strings.HasSuffix(out, `{"status":417,"error":"Expectation Failed"}`))
+
+	// Test: The handler can be replaced
+	var gotStatus response.StatusCode
+	var gotErr error
+	var gotReq *request.Request
+	s := &Server{}
+	WithErrorHandler(func(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+		gotStatus, gotErr, gotReq = status, err, req
+		w.WriteText(status, "custom")
+	})(s)
+	out = serveRaw(s, echoTarget, "BREW / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ncustom"))
+	assert.Equal(t, response.StatusNotImplemented, gotStatus)
+	assert.True(t, errors.Is(gotErr, request.ERROR_UNKNOWN_METHOD))
+	assert.Nil(t, gotReq)
+}
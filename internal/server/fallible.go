@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"http/internal/request"
+	"http/internal/response"
+	"io/fs"
+)
+
+// HandlerError is an error that says which status answers it. Message is
+// what Error returns; the response shows only the status, as rendered by
+// the error handler.
+type HandlerError struct {
+	StatusCode response.StatusCode
+	Message    string
+}
+
+func (e HandlerError) Error() string {
+	if e.Message == "" {
+		return e.StatusCode.Text()
+	}
+	return e.Message
+}
+
+func (e HandlerError) Status() response.StatusCode {
+	return e.StatusCode
+}
+
+// FallibleHandler is a handler that returns its errors instead of writing
+// a response for them; see HandleErrors.
+type FallibleHandler func(w *response.Writer, req *request.Request) error
+
+// errorStatus pairs an error with the status that answers it.
+type errorStatus struct {
+	target error
+	status response.StatusCode
+}
+
+// defaultErrorStatuses apply after those from WithErrorStatus.
+var defaultErrorStatuses = []errorStatus{
+	{fs.ErrNotExist, response.StatusNotFound},
+	{fs.ErrPermission, response.StatusForbidden},
+	{request.ERROR_BODY_TOO_LARGE, response.StatusContentTooLarge},
+}
+
+// WithErrorStatus answers errors returned by handlers wrapped in
+// HandleErrors that match target, by errors.Is, with status. Earlier
+// registrations win.
+func WithErrorStatus(target error, status response.StatusCode) Option {
+	return func(s *Server) {
+		s.errorStatuses = append(s.errorStatuses, errorStatus{target, status})
+	}
+}
+
+type serverKey struct{}
+
+// HandleErrors adapts h to a Handler. An error h returns is answered by
+// the server's error handler with the status it maps to:
+//   - the status of the first WithErrorStatus target it matches,
+//   - else that of an error in its chain with a Status method, such as
+//     HandlerError,
+//   - else 404, 403 or 413 for fs.ErrNotExist, fs.ErrPermission and
+//     request.ERROR_BODY_TOO_LARGE,
+//   - else 500.
+//
+// Errors mapping to 5xx are logged. If the response has started already,
+// the error is logged and the connection closed once it is done.
+func HandleErrors(h FallibleHandler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+		s := serverFrom(req.Context())
+		status := s.statusFor(err)
+		if w.Started() {
+			s.logf("Handler failed after responding to %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			w.CloseAfterResponse()
+			return
+		}
+		if status >= 500 {
+			s.logf("Handler failed on %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		}
+		s.handleError(w, req, status, err)
+	}
+}
+
+// statusFor maps an error returned by a handler to a status.
+func (s *Server) statusFor(err error) response.StatusCode {
+	for _, es := range s.errorStatuses {
+		if errors.Is(err, es.target) {
+			return es.status
+		}
+	}
+	var withStatus interface{ Status() response.StatusCode }
+	if errors.As(err, &withStatus) {
+		return withStatus.Status()
+	}
+	for _, es := range defaultErrorStatuses {
+		if errors.Is(err, es.target) {
+			return es.status
+		}
+	}
+	return response.StatusInternalServerError
+}
+
+// withServer makes s findable from ctx for HandleErrors and Timeout.
+func withServer(ctx context.Context, s *Server) context.Context {
+	return context.WithValue(ctx, serverKey{}, s)
+}
+
+// serverFrom returns the server serving the request with context ctx, or
+// one with the defaults for handlers called some other way.
+func serverFrom(ctx context.Context) *Server {
+	if s, ok := ctx.Value(serverKey{}).(*Server); ok {
+		return s
+	}
+	return &Server{}
+}
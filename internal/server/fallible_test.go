@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errNoSuchUser = errors.New("no such user")
+
+func TestHandleErrors(t *testing.T) {
+	var gotStatus response.StatusCode
+	var gotErr error
+	s := &Server{}
+	WithErrorStatus(errNoSuchUser, response.StatusNotFound)(s)
+	WithErrorStatus(os.ErrDeadlineExceeded, response.StatusGatewayTimeout)(s)
+	WithErrorHandler(func(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+		gotStatus, gotErr = status, err
+		w.WriteText(status, "custom "+status.Text())
+	})(s)
+	serve := func(h FallibleHandler) string {
+		gotStatus, gotErr = 0, nil
+		return serveRaw(s, HandleErrors(h), "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", 1)
+	}
+	failing := func(err error) FallibleHandler {
+		return func(w *response.Writer, req *request.Request) error {
+			return err
+		}
+	}
+
+	// Test: Handlers that succeed answer themselves
+	out := serve(func(w *response.Writer, req *request.Request) error {
+		return w.WriteText(response.StatusOK, "fine")
+	})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Zero(t, gotStatus)
+
+	// Test: Registered errors map to their status, also when wrapped, and
+	// reach the error handler
+	out = serve(failing(fmt.Errorf("loading profile: %w", errNoSuchUser)))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasSuffix(out, "custom Not Found"))
+	assert.ErrorIs(t, gotErr, errNoSuchUser)
+
+	// Test: Errors carrying a status use it
+	serve(failing(HandlerError{StatusCode: response.StatusConflict, Message: "version mismatch"}))
+	assert.Equal(t, response.StatusConflict, gotStatus)
+	serve(failing(fmt.Errorf("saving: %w", &HandlerError{StatusCode: response.StatusUnprocessableEntity})))
+	assert.Equal(t, response.StatusUnprocessableEntity, gotStatus)
+
+	// Test: Registrations win over the defaults and typed errors
+	serve(failing(fmt.Errorf("%w: %w", os.ErrDeadlineExceeded, HandlerError{StatusCode: response.StatusBadRequest})))
+	assert.Equal(t, response.StatusGatewayTimeout, gotStatus)
+
+	// Test: Defaults cover missing files, permissions and oversized bodies
+	serve(failing(os.ErrNotExist))
+	assert.Equal(t, response.StatusNotFound, gotStatus)
+	serve(failing(os.ErrPermission))
+	assert.Equal(t, response.StatusForbidden, gotStatus)
+	serve(failing(request.ERROR_BODY_TOO_LARGE))
+	assert.Equal(t, response.StatusContentTooLarge, gotStatus)
+
+	// Test: Anything else is a 500
+	out = serve(failing(errors.New("database down")))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+
+	// Test: Errors after the response started close the connection
+	gotStatus = 0
+	get := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+	out = serveRaw(s, HandleErrors(func(w *response.Writer, req *request.Request) error {
+		w.WriteText(response.StatusOK, "partial")
+		return errNoSuchUser
+	}), get+get, 2)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "))
+	assert.Zero(t, gotStatus)
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ERROR_INVALID_PATH = fmt.Errorf("invalid path")
+var ERROR_OUTSIDE_ROOT = fmt.Errorf("path escapes root")
+
+// fileMethods is what a FileServer answers, listed as a router would.
+var fileMethods = []string{"GET", "HEAD", "OPTIONS"}
+
+// FileServer serves files from Root to GET and HEAD requests, and says so
+// to OPTIONS requests. Symlinks are resolved and must stay inside Root
+// unless AllowSymlinks is set.
+// Directories are served by their index.html; requests for them without a
+// trailing slash are redirected to one, so relative links resolve.
+type FileServer struct {
+	Root          string
+	AllowSymlinks bool
+	// Prefix is stripped from request paths before they are mapped onto
+	// Root, e.g. "/static" to serve Root under /static/.
+	Prefix string
+}
+
+func FileHandler(root string) Handler {
+	f := &FileServer{Root: root}
+	return f.Handle
+}
+
+func isWithin(root, name string) bool {
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// resolve maps a decoded request path onto a path below Root.
+func (f *FileServer) resolve(p string) (string, error) {
+	if strings.ContainsAny(p, "\x00\\") {
+		return "", ERROR_INVALID_PATH
+	}
+	root, err := filepath.Abs(f.Root)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+p)))
+	if !isWithin(root, name) {
+		return "", ERROR_OUTSIDE_ROOT
+	}
+	if f.AllowSymlinks {
+		return name, nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realName, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	if !isWithin(realRoot, realName) {
+		return "", ERROR_OUTSIDE_ROOT
+	}
+	return realName, nil
+}
+
+func writeFileError(w *response.Writer, status response.StatusCode) {
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*response.GetDefaultHeaders(0))
+}
+
+// contentTypes covers extensions the mime package may not know, depending
+// on the system.
+var contentTypes = map[string]string{
+	".txt":  "text/plain; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".ico":  "image/x-icon",
+	".woff": "font/woff",
+	".ttf":  "font/ttf",
+}
+
+// contentType guesses the media type of a file from its extension.
+func contentType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func (f *FileServer) Handle(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD":
+	case "OPTIONS":
+		h := headers.NewHeaders()
+		h.Set("Allow", strings.Join(fileMethods, ", "))
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(*h)
+		return
+	default:
+		WriteMethodNotAllowed(w, fileMethods)
+		return
+	}
+	// The prefix must end on a segment boundary: "/static" does not
+	// serve "/staticfoo".
+	p, ok := strings.CutPrefix(req.Path(), f.Prefix)
+	if !ok || (p != "" && p[0] != '/' && !strings.HasSuffix(f.Prefix, "/")) {
+		writeFileError(w, response.StatusNotFound)
+		return
+	}
+	name, err := f.resolve(p)
+	if err == nil {
+		if info, statErr := os.Stat(name); statErr == nil && info.IsDir() {
+			if !strings.HasSuffix(p, "/") {
+				dir := &url.URL{Path: path.Base(req.Path()) + "/"}
+				w.Redirect(response.StatusMovedPermanently, dir.String())
+				return
+			}
+			name, err = f.resolve(path.Join(p, "index.html"))
+		}
+	}
+	switch {
+	case errors.Is(err, ERROR_INVALID_PATH):
+		writeFileError(w, response.StatusBadRequest)
+		return
+	case errors.Is(err, ERROR_OUTSIDE_ROOT):
+		writeFileError(w, response.StatusForbidden)
+		return
+	case errors.Is(err, fs.ErrNotExist):
+		writeFileError(w, response.StatusNotFound)
+		return
+	case err != nil:
+		writeFileError(w, response.StatusInternalServerError)
+		return
+	}
+	ServeFile(w, req, name)
+}
+
+// ServeFile answers req with the file name, honouring conditional requests
+// and Range.
+// HEAD requests get the headers only. name is used as it is: FileServer is
+// what keeps request paths inside a root.
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	file, err := os.Open(name)
+	if err != nil {
+		writeFileError(w, response.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		writeFileError(w, response.StatusNotFound)
+		return
+	}
+	contentType := contentType(name)
+	head := req.RequestLine.Method == "HEAD"
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	v := response.Validators{ETag: etag, LastModified: info.ModTime()}
+	if w.CheckPreconditions(req.RequestLine.Method, req.Headers(), v) {
+		return
+	}
+	validators := func(h *headers.Headers) *headers.Headers {
+		h.Set("Accept-Ranges", "bytes")
+		v.Set(h)
+		return h
+	}
+	rangeHeader, hasRange := req.Headers().Get("Range")
+	ifRange, _ := req.Headers().Get("If-Range")
+	if hasRange && !head && response.IfRange(ifRange, etag, info.ModTime()) {
+		ranges, err := response.ParseRange(rangeHeader, info.Size())
+		if err == nil && len(ranges) > 1 {
+			ranges = response.CoalesceRanges(ranges)
+		}
+		if errors.Is(err, response.ERROR_UNSATISFIABLE_RANGE) {
+			h := response.GetDefaultHeaders(0)
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size()))
+			w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+			w.WriteHeaders(*h)
+			return
+		}
+		if err == nil && len(ranges) > 1 {
+			w.WriteByteranges(validators(response.GetDefaultHeaders(0)), file, info.Size(), contentType, ranges)
+			return
+		}
+		if err == nil {
+			r := ranges[0]
+			if _, err := file.Seek(r.Start, io.SeekStart); err != nil {
+				writeFileError(w, response.StatusInternalServerError)
+				return
+			}
+			h := validators(response.GetDefaultHeaders(int(r.Length)))
+			h.Set("Content-Type", contentType)
+			h.Set("Content-Range", r.ContentRange(info.Size()))
+			w.WriteStatusLine(response.StatusPartialContent)
+			w.WriteHeaders(*h)
+			if err := w.WriteBodyReader(file, r.Length); err != nil {
+				log.Printf("serving %s: %v", name, err)
+			}
+			return
+		}
+		// Malformed ranges, and too many of them, are ignored and the
+		// whole file is sent.
+	}
+	h := validators(response.GetDefaultHeaders(int(info.Size())))
+	h.Set("Content-Type", contentType)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	if head {
+		return
+	}
+	if err := w.WriteBodyReader(file, info.Size()); err != nil {
+		log.Printf("serving %s: %v", name, err)
+	}
+}
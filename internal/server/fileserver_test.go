@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveFile(t *testing.T, f *FileServer, target string, headerLines ...string) string {
+	raw := "GET " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n"
+	for _, line := range headerLines {
+		raw += line + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	f.Handle(response.NewWriter(out), req)
+	return out.String()
+}
+
+func TestFileServerContainment(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "index.txt"), []byte("hello"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "index.txt"), filepath.Join(root, "inside.txt")))
+	f := &FileServer{Root: root}
+
+	// Test: Regular file
+	out := serveFile(t, f, "/index.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	// Test: Encoded traversal attempts never leave the root
+	for _, target := range []string{
+		"/../secret.txt",
+		"/..%2fsecret.txt",
+		"/%2e%2e/secret.txt",
+		"/%2e%2e%2f%2e%2e%2fsecret.txt",
+		"/a/b/../../../secret.txt",
+	} {
+		out = serveFile(t, f, target)
+		assert.NotContains(t, out, "secret", target)
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), target)
+	}
+
+	// Test: Encoded NUL and backslashes are rejected
+	out = serveFile(t, f, "/index.txt%00.png")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	out = serveFile(t, f, "/..%5csecret.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Symlink pointing outside the root is forbidden
+	out = serveFile(t, f, "/escape.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+	assert.NotContains(t, out, "secret")
+
+	// Test: Symlink pointing inside the root is allowed
+	out = serveFile(t, f, "/inside.txt")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	// Test: Opting in to symlinks follows them
+	f.AllowSymlinks = true
+	out = serveFile(t, f, "/escape.txt")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nsecret"))
+}
+
+func TestFileServerMultipleRanges(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "digits.txt"), []byte("0123456789"), 0o644))
+	f := &FileServer{Root: root}
+
+	// Test: Multiple ranges produce a multipart/byteranges body
+	out := serveFile(t, f, "/digits.txt", "Range: bytes=0-1, 4-5, -2")
+	res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out)), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 206, res.StatusCode)
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, res.ContentLength, int64(len(body)))
+
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	expected := []struct{ contentRange, data string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 4-5/10", "45"},
+		{"bytes 8-9/10", "89"},
+	}
+	for _, e := range expected {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		assert.Equal(t, e.contentRange, part.Header.Get("Content-Range"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, e.data, string(data))
+	}
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Malformed Range header is ignored
+	out = serveFile(t, f, "/digits.txt", "Range: bytes=5-1,2-3")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n0123456789"))
+
+	// Test: Overlapping ranges are merged into one part
+	out = serveFile(t, f, "/digits.txt", "Range: bytes=4-6, 0-3, 5-5")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "Content-Range: bytes 0-6/10\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n0123456"))
+
+	// Test: Too many ranges get the whole file
+	out = serveFile(t, f, "/digits.txt", "Range: bytes="+strings.Repeat("0-0,", response.MaxRanges)+"1-1")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestFileServerUnsatisfiableRange(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "digits.txt"), []byte("0123456789"), 0o644))
+	f := &FileServer{Root: root}
+
+	// Test: Ranges entirely past the end answer 416 with the current size
+	for _, value := range []string{"bytes=10-", "bytes=20-30", "bytes=10-12, 50-", "bytes=-0"} {
+		out := serveFile(t, f, "/digits.txt", "Range: "+value)
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out)), nil)
+		require.NoError(t, err)
+		assert.Equal(t, 416, res.StatusCode, value)
+		assert.Equal(t, "bytes */10", res.Header.Get("Content-Range"), value)
+		assert.Equal(t, "0", res.Header.Get("Content-Length"), value)
+		assert.NotContains(t, out, "0123456789", value)
+	}
+
+	// Test: One satisfiable range among unsatisfiable ones is still served
+	out := serveFile(t, f, "/digits.txt", "Range: bytes=50-60, 0-1, 9-")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.NotContains(t, out, "bytes */10")
+}
+
+func TestFileServerSingleRange(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "digits.txt"), []byte("0123456789"), 0o644))
+	// Last-Modified is only a strong validator once it is a second old.
+	modTime := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "digits.txt"), modTime, modTime))
+	f := &FileServer{Root: root}
+	get := func(headerLines ...string) (*http.Response, string) {
+		out := serveFile(t, f, "/digits.txt", headerLines...)
+		res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out)), nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(body)
+	}
+
+	// Test: A single range answers 206 with Content-Range
+	res, body := get("Range: bytes=3-5")
+	assert.Equal(t, 206, res.StatusCode)
+	assert.Equal(t, "bytes 3-5/10", res.Header.Get("Content-Range"))
+	assert.Equal(t, int64(3), res.ContentLength)
+	assert.Equal(t, "345", body)
+	res, body = get("Range: bytes=-3")
+	assert.Equal(t, "bytes 7-9/10", res.Header.Get("Content-Range"))
+	assert.Equal(t, "789", body)
+
+	// Test: Full responses advertise ranges and validators
+	res, _ = get()
+	assert.Equal(t, "bytes", res.Header.Get("Accept-Ranges"))
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+
+	// Test: If-Range with a current validator keeps the range
+	res, body = get("Range: bytes=0-1", "If-Range: "+etag)
+	assert.Equal(t, 206, res.StatusCode)
+	assert.Equal(t, "01", body)
+	res, _ = get("Range: bytes=0-1", "If-Range: "+lastModified)
+	assert.Equal(t, 206, res.StatusCode)
+
+	// Test: A stale or weak validator falls back to the full file
+	res, body = get("Range: bytes=0-1", `If-Range: "stale"`)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "0123456789", body)
+	res, _ = get("Range: bytes=0-1", "If-Range: W/"+etag)
+	assert.Equal(t, 200, res.StatusCode)
+	res, _ = get("Range: bytes=0-1", "If-Range: "+modTime.Add(-time.Hour).UTC().Format(response.TimeFormat))
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+func TestFileServerMethodsAndTypes(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "index.html"), []byte("<h1>docs</h1>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "app.css"), []byte("body{}"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "blob"), []byte("??"), 0o644))
+	f := &FileServer{Root: root, Prefix: "/static"}
+	serve := func(method, target string) *http.Response {
+		raw := method + " " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		out := &bytes.Buffer{}
+		f.Handle(response.NewWriter(out), req)
+		res, err := http.ReadResponse(bufio.NewReader(out), &http.Request{Method: method})
+		require.NoError(t, err)
+		return res
+	}
+
+	// Test: Content-Type follows the extension
+	res := serve("GET", "/static/app.css")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "text/css; charset=utf-8", res.Header.Get("Content-Type"))
+	res = serve("GET", "/static/blob")
+	assert.Equal(t, "application/octet-stream", res.Header.Get("Content-Type"))
+
+	// Test: The prefix only matches whole path segments
+	res = serve("GET", "/staticapp.css")
+	assert.Equal(t, 404, res.StatusCode)
+
+	// Test: Directories are served by their index.html
+	res = serve("GET", "/static/docs/")
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>docs</h1>", string(body))
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	res = serve("GET", "/static/")
+	assert.Equal(t, 404, res.StatusCode)
+
+	// Test: Directories without a trailing slash are redirected
+	res = serve("GET", "/static/docs")
+	assert.Equal(t, 301, res.StatusCode)
+	assert.Equal(t, "docs/", res.Header.Get("Location"))
+
+	// Test: Paths outside the prefix are not found
+	res = serve("GET", "/app.css")
+	assert.Equal(t, 404, res.StatusCode)
+
+	// Test: HEAD gets the headers without the body
+	raw := "HEAD /static/app.css HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	f.Handle(response.NewWriter(out), req)
+	assert.Contains(t, out.String(), "Content-Length: 6\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+
+	// Test: Other methods are not allowed
+	res = serve("POST", "/static/app.css")
+	assert.Equal(t, 405, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Header.Get("Allow"))
+
+	// Test: OPTIONS gets the same list
+	res = serve("OPTIONS", "/static/app.css")
+	assert.Equal(t, 204, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Header.Get("Allow"))
+}
+
+func TestFileServerConditional(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0o644))
+	f := &FileServer{Root: root}
+	res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(serveFile(t, f, "/a.txt"))), nil)
+	require.NoError(t, err)
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
+
+	// Test: Matching validators get a 304 without a body
+	out := serveFile(t, f, "/a.txt", "If-None-Match: "+etag)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, out, "ETag: "+etag+"\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	out = serveFile(t, f, "/a.txt", "If-Modified-Since: "+lastModified)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: A failed If-Match is a 412
+	out = serveFile(t, f, "/a.txt", `If-Match: "stale"`)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"))
+
+	// Test: Stale validators get the file
+	out = serveFile(t, f, "/a.txt", `If-None-Match: "stale"`)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+}
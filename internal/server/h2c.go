@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"http/internal/http2"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"net"
+	"time"
+)
+
+// WithH2C serves HTTP/2 over plain-text connections, both to clients that
+// start with the HTTP/2 preface and to those that ask to switch with
+// "Upgrade: h2c". Connections over TLS stay on HTTP/1.1.
+func WithH2C(enabled bool) Option {
+	return func(s *Server) {
+		s.h2c = enabled
+	}
+}
+
+// h2cSettings returns the decoded HTTP2-Settings of a request asking to
+// switch to HTTP/2 (RFC 7540 section 3.2), or false if r does not ask.
+func h2cSettings(r *request.Request) ([]byte, bool) {
+	h := r.Headers()
+	if r.RequestLine.HttpVersion != "1.1" || !h.HasToken("upgrade", "h2c") || !h.HasToken("connection", "http2-settings") {
+		return nil, false
+	}
+	values := h.Values("http2-settings")
+	if len(values) != 1 {
+		return nil, false
+	}
+	settings, err := base64.RawURLEncoding.DecodeString(values[0])
+	if err != nil {
+		return nil, false
+	}
+	// The request must be complete before the switch, and the server
+	// does not read bodies past it.
+	if _, chunked := h.Get("transfer-encoding"); chunked || r.ContentLength() > 0 {
+		return nil, false
+	}
+	return settings, true
+}
+
+// upgradeH2C answers r with 101 Switching Protocols and serves HTTP/2 on
+// conn, with r as its first stream.
+func (s *Server) upgradeH2C(ctx context.Context, conn io.ReadWriteCloser, reader *request.Reader, tracked *trackedConn, w *response.Writer, r *request.Request, settings []byte) {
+	out := w.Header()
+	out.Set("Connection", "Upgrade")
+	out.Set("Upgrade", "h2c")
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(*out); err != nil {
+		return
+	}
+	if err := w.Flush(); err != nil {
+		return
+	}
+	for _, name := range []string{"connection", "upgrade", "http2-settings"} {
+		r.Headers().Delete(name)
+	}
+	r.RequestLine.HttpVersion = "2.0"
+	s.serveH2(ctx, conn, reader, tracked, r, settings)
+}
+
+// serveH2 speaks HTTP/2 on conn until the client goes away. The connection
+// stays tracked, idle while it has no streams.
+func (s *Server) serveH2(ctx context.Context, conn io.ReadWriteCloser, reader *request.Reader, tracked *trackedConn, upgrade *request.Request, settings []byte) {
+	setReadDeadline(conn, time.Time{})
+	setWriteDeadline(conn, time.Time{})
+	buffered := reader.Detach()
+	var rwc io.ReadWriteCloser = &hijackedConn{ReadWriteCloser: conn, r: buffered}
+	if c, ok := conn.(net.Conn); ok {
+		rwc = &hijackedNetConn{Conn: c, r: buffered}
+	}
+	http2.ServeConn(rwc, s.runHandler, http2.Options{
+		Context:              ctx,
+		Conn:                 reader.Conn,
+		RejectEncodedControl: s.rejectControl,
+		PrepareWriter: func(w *response.Writer) {
+			w.SetDefaultHeaders(s.defaultHeaders())
+		},
+		HandleError: s.handleError,
+		Logf:        s.logf,
+		SetIdle: func(idle bool) bool {
+			return s.setIdle(tracked, idle)
+		},
+		Draining:          s.isShuttingDown,
+		IdleTimeout:       s.timeouts.Idle,
+		MaxHeaderListSize: uint32(max(s.maxHeaderBytes, 0)),
+		Upgrade:           upgrade,
+		UpgradeSettings:   settings,
+	})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"http/internal/hpack"
+	"http/internal/http2"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// h2Frame writes an HTTP/2 frame.
+func h2Frame(t *testing.T, w io.Writer, typ, flags byte, streamID uint32, payload []byte) {
+	n := len(payload)
+	b := []byte{byte(n >> 16), byte(n >> 8), byte(n), typ, flags}
+	b = binary.BigEndian.AppendUint32(b, streamID)
+	_, err := w.Write(append(b, payload...))
+	require.NoError(t, err)
+}
+
+// h2Response reads frames until stream 1 ends, returning its decoded
+// fields and body.
+func h2Response(t *testing.T, r io.Reader) (map[string]string, string) {
+	dec := hpack.NewDecoder(hpack.DefaultTableSize)
+	fields := map[string]string{}
+	body := ""
+	for {
+		var head [9]byte
+		_, err := io.ReadFull(r, head[:])
+		require.NoError(t, err)
+		payload := make([]byte, int(head[0])<<16|int(head[1])<<8|int(head[2]))
+		_, err = io.ReadFull(r, payload)
+		require.NoError(t, err)
+		if binary.BigEndian.Uint32(head[5:]) != 1 {
+			continue
+		}
+		switch head[3] {
+		case 0x0:
+			body += string(payload)
+		case 0x1:
+			list, err := dec.Decode(payload)
+			require.NoError(t, err)
+			for _, f := range list {
+				fields[f.Name] = f.Value
+			}
+		}
+		if head[4]&0x1 != 0 {
+			return fields, body
+		}
+	}
+}
+
+func TestH2C(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, req.RequestLine.HttpVersion+" "+req.Host())
+	}
+	s, err := ServeAddr("127.0.0.1:0", handler, WithH2C(true))
+	require.NoError(t, err)
+	defer s.Close()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	// Test: A connection that starts with the preface speaks HTTP/2
+	conn := dial()
+	_, err = conn.Write(http2.ClientPreface)
+	require.NoError(t, err)
+	h2Frame(t, conn, 0x4, 0, 0, nil)
+	block := hpack.NewEncoder().Encode(nil, []hpack.Field{
+		{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"}, {Name: ":authority", Value: "example.com"},
+	})
+	h2Frame(t, conn, 0x1, 0x5, 1, block)
+	fields, body := h2Response(t, conn)
+	assert.Equal(t, "200", fields[":status"])
+	assert.Equal(t, "http-from-scratch", fields["server"])
+	assert.Equal(t, "2.0 example.com", body)
+
+	// Test: "Upgrade: h2c" switches, and the request is answered as
+	// stream 1
+	conn = dial()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\n"))
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	assert.Equal(t, "h2c", res.Header.Get("Upgrade"))
+	_, err = conn.Write(http2.ClientPreface)
+	require.NoError(t, err)
+	h2Frame(t, conn, 0x4, 0, 0, nil)
+	fields, body = h2Response(t, br)
+	assert.Equal(t, "200", fields[":status"])
+	assert.Equal(t, "2.0 example.com", body)
+
+	// Test: Without WithH2C the upgrade is ignored
+	plain, err := ServeAddr("127.0.0.1:0", handler)
+	require.NoError(t, err)
+	defer plain.Close()
+	conn, err = net.Dial("tcp", plain.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
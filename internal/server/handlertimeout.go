@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"time"
+)
+
+var ERROR_HANDLER_TIMEOUT = fmt.Errorf("handler timed out")
+
+// Timeout gives every handler d to answer; see TimeoutFunc.
+func Timeout(d time.Duration) Middleware {
+	return TimeoutFunc(func(req *request.Request) time.Duration {
+		return d
+	})
+}
+
+// TimeoutFunc gives each handler the budget returns for its request, none
+// if zero. Once it is spent the request's context is cancelled and, when
+// the handler returns, a response it has not started is answered 503 by
+// the error handler with ERROR_HANDLER_TIMEOUT. One under way is aborted,
+// see response.Writer.Abort, as it may have been cut off anywhere.
+//
+// Handlers must return once the context is done; those that ignore it
+// keep the connection until they finish.
+func TimeoutFunc(budget func(req *request.Request) time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			d := budget(req)
+			if d <= 0 {
+				next(w, req)
+				return
+			}
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+			defer cancel()
+			next(w, req.WithContext(ctx))
+			// A parent context that ended, client gone or an outer
+			// budget spent, is not this budget's business.
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) || req.Context().Err() != nil || w.Hijacked() {
+				return
+			}
+			s := serverFrom(req.Context())
+			if w.Started() {
+				s.logf("Handler exceeded its %v budget after responding to %s %s, aborting", d, req.RequestLine.Method, req.RequestLine.RequestTarget)
+				w.Abort()
+				return
+			}
+			s.logf("Handler exceeded its %v budget on %s %s", d, req.RequestLine.Method, req.RequestLine.RequestTarget)
+			s.handleError(w, req, response.StatusServiceUnavailable, ERROR_HANDLER_TIMEOUT)
+		}
+	}
+}
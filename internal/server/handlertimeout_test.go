@@ -0,0 +1,63 @@
+package server
+
+import (
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	get := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+	var gotErr error
+	s := &Server{}
+	WithErrorHandler(func(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+		gotErr = err
+		DefaultErrorHandler(w, req, status, err)
+	})(s)
+	slow := func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+	}
+
+	// Test: Handlers within their budget answer themselves
+	out := serveRaw(s, Timeout(time.Second)(echoTarget), get, 1)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Nil(t, gotErr)
+
+	// Test: Handlers past it see their context cancelled and are answered 503
+	out = serveRaw(s, Timeout(10*time.Millisecond)(slow), get, 1)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.ErrorIs(t, gotErr, ERROR_HANDLER_TIMEOUT)
+
+	// Test: Responses cut off half-way are not ended as if complete, and
+	// the connection closes
+	out = serveRaw(s, Timeout(10*time.Millisecond)(func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteHeaders(*h)
+		w.WriteChunk([]byte("part"))
+		w.Flush()
+		slow(w, req)
+	}), get+get, 2)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n4\r\npart\r\n"))
+
+	// Test: Budgets are per request, and zero means none
+	var deadlines []bool
+	budget := TimeoutFunc(func(req *request.Request) time.Duration {
+		if req.Path() == "/report" {
+			return 0
+		}
+		return time.Second
+	})
+	serveRaw(s, budget(func(w *response.Writer, req *request.Request) {
+		_, ok := req.Context().Deadline()
+		deadlines = append(deadlines, ok)
+		w.WriteText(response.StatusOK, "ok")
+	}), get+strings.Replace(get, "/", "/report", 1), 2)
+	assert.Equal(t, []bool{true, false}, deadlines)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"http/internal/request"
+	"io"
+	"net"
+	"time"
+)
+
+// hijackable reports whether a handler may take over the connection of r:
+// upgrade requests and CONNECT tunnels. Other requests are watched for the
+// client hanging up while they are handled, which reads the connection.
+func hijackable(r *request.Request) bool {
+	return r.RequestLine.Method == "CONNECT" || r.Headers().HasToken("connection", "upgrade")
+}
+
+// hijackedConn reads what the parser read ahead before the connection.
+type hijackedConn struct {
+	io.ReadWriteCloser
+	r io.Reader
+}
+
+func (c *hijackedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// hijackedNetConn keeps a net.Conn's addresses and deadlines available.
+type hijackedNetConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *hijackedNetConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// hijack hands conn over, with deadlines cleared, after the server stops
+// tracking it.
+func (s *Server) hijack(conn io.ReadWriteCloser, reader *request.Reader, tracked *trackedConn) io.ReadWriteCloser {
+	s.untrack(tracked)
+	setReadDeadline(conn, time.Time{})
+	setWriteDeadline(conn, time.Time{})
+	if c, ok := conn.(net.Conn); ok {
+		return &hijackedNetConn{Conn: c, r: reader.Detach()}
+	}
+	return &hijackedConn{ReadWriteCloser: conn, r: reader.Detach()}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"http/internal/response"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+var ERROR_TOO_MANY_CONNECTIONS = fmt.Errorf("too many concurrent connections")
+
+// ConnLimit caps how many connections are served at once, bounding the
+// goroutines and buffers they hold.
+type ConnLimit struct {
+	// MaxConcurrentConnections is the cap; zero means none.
+	MaxConcurrentConnections int
+	// Queue stops accepting while at the cap, leaving new connections in
+	// the listen backlog until one closes. Otherwise they are answered 503
+	// and closed.
+	Queue bool
+	// RetryAfter is sent with 503 responses, in whole seconds. It
+	// defaults to one second.
+	RetryAfter time.Duration
+}
+
+// rejectTimeout bounds sending the 503 to a connection over the limit.
+const rejectTimeout = 5 * time.Second
+
+// maxRejecters bounds the goroutines answering connections over the
+// limit; past it they are closed without a response.
+const maxRejecters = 16
+
+// WithConnLimit caps concurrent connections.
+func WithConnLimit(limit ConnLimit) Option {
+	return func(s *Server) {
+		s.connLimit = limit
+		s.slots = nil
+		s.rejecters = nil
+		if limit.MaxConcurrentConnections > 0 {
+			s.slots = make(chan struct{}, limit.MaxConcurrentConnections)
+			s.rejecters = make(chan struct{}, maxRejecters)
+		}
+	}
+}
+
+// ConnStats counts the server's connections.
+type ConnStats struct {
+	// Active connections are open, Idle ones among them are waiting for
+	// a request.
+	Active int
+	Idle   int
+	// Accepted and Rejected count connections since the server started;
+	// rejected ones were over the limit.
+	Accepted uint64
+	Rejected uint64
+}
+
+func (s *Server) Stats() ConnStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := ConnStats{
+		Active:   len(s.conns),
+		Accepted: s.accepted.Load(),
+		Rejected: s.rejected.Load(),
+	}
+	for tc := range s.conns {
+		if tc.idle {
+			stats.Idle++
+		}
+	}
+	return stats
+}
+
+// waitSlot blocks until a connection may be accepted, when queueing.
+func (s *Server) waitSlot() {
+	if s.slots != nil && s.connLimit.Queue {
+		s.slots <- struct{}{}
+	}
+}
+
+// takeSlot claims a slot for a new connection. It reports false when the
+// connection is over the limit and must be rejected.
+func (s *Server) takeSlot() bool {
+	if s.slots == nil || s.connLimit.Queue {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// startReject answers a connection over the limit off the accept loop,
+// or closes it outright when too many are being answered already.
+func (s *Server) startReject(conn net.Conn) {
+	select {
+	case s.rejecters <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+	go func() {
+		defer func() { <-s.rejecters }()
+		s.reject(s.prepareConn(conn))
+	}()
+}
+
+// reject answers a connection over the limit with 503 and closes it.
+func (s *Server) reject(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	if c, ok := conn.(net.Conn); ok {
+		c.SetDeadline(time.Now().Add(rejectTimeout))
+	}
+	retryAfter := int64(1)
+	if s.connLimit.RetryAfter > time.Second {
+		retryAfter = int64((s.connLimit.RetryAfter + time.Second - 1) / time.Second)
+	}
+	w := response.NewWriter(conn)
+	w.SetDefaultHeaders(s.defaultHeaders())
+	w.CloseAfterResponse()
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	s.handleError(w, nil, response.StatusServiceUnavailable, ERROR_TOO_MANY_CONNECTIONS)
+	if err := w.Finish(nil); err != nil {
+		s.logf("Rejecting connection failed: %v", err)
+	}
+}
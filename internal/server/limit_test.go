@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimit(t *testing.T) {
+	get := func(conn net.Conn) (*http.Response, error) {
+		conn.Write([]byte("GET /limited HTTP/1.1\r\nHost: x\r\n\r\n"))
+		return http.ReadResponse(bufio.NewReader(conn), nil)
+	}
+
+	// Test: Connections over the limit are answered 503 and closed
+	s, err := Serve(0, echoTarget, WithConnLimit(ConnLimit{MaxConcurrentConnections: 1, RetryAfter: 1500 * time.Millisecond}))
+	require.NoError(t, err)
+	defer s.Close()
+	first, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	res, err := get(first)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	second, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	res, err = http.ReadResponse(bufio.NewReader(second), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "2", res.Header.Get("Retry-After"))
+	assert.True(t, res.Close)
+
+	// Test: Stats count open, idle and rejected connections
+	stats := s.Stats()
+	assert.Equal(t, 1, stats.Active)
+	assert.Equal(t, 1, stats.Idle)
+	assert.Equal(t, uint64(1), stats.Accepted)
+	assert.Equal(t, uint64(1), stats.Rejected)
+
+	// Test: Past the rejecter bound, connections are closed unanswered
+	for i := 0; i < maxRejecters; i++ {
+		s.rejecters <- struct{}{}
+	}
+	third, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer third.Close()
+	third.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := third.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, uint64(2), s.Stats().Rejected)
+	for i := 0; i < maxRejecters; i++ {
+		<-s.rejecters
+	}
+
+	// Test: Queued connections are served once a slot frees up
+	q, err := Serve(0, echoTarget, WithConnLimit(ConnLimit{MaxConcurrentConnections: 1, Queue: true}))
+	require.NoError(t, err)
+	defer q.Close()
+	first, err = net.Dial("tcp", q.listener.Addr().String())
+	require.NoError(t, err)
+	res, err = get(first)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	second, err = net.Dial("tcp", q.listener.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = get(second)
+	assert.True(t, isTimeout(err))
+	first.Close()
+	second.SetReadDeadline(time.Time{})
+	res, err = http.ReadResponse(bufio.NewReader(second), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, uint64(0), q.Stats().Rejected)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ERROR_INVALID_FD = fmt.Errorf("invalid inherited listener file descriptor")
+var ERROR_REUSEPORT_UNSUPPORTED = fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+var ERROR_NO_LISTENER_FILE = fmt.Errorf("listener has no file to hand over")
+
+// unixPrefix marks Unix domain socket addresses.
+const unixPrefix = "unix:"
+
+// fdPrefix marks listeners inherited from the parent process by their file
+// descriptor, as in "fd:3".
+const fdPrefix = "fd:"
+
+// listen opens a TCP or, for "unix:" addresses, Unix domain socket
+// listener on addr, or takes over an inherited one for "fd:" addresses.
+// reusePort sets SO_REUSEPORT on TCP sockets.
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	if fd, ok := strings.CutPrefix(addr, fdPrefix); ok {
+		return inheritListener(fd)
+	}
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if ok {
+		removeStaleSocket(path)
+		return net.Listen("unix", path)
+	}
+	lc := net.ListenConfig{}
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// inheritListener serves the listening socket at file descriptor fd, passed
+// down by the process that started this one.
+func inheritListener(fd string) (net.Listener, error) {
+	n, err := strconv.Atoi(fd)
+	if err != nil || n < 3 {
+		return nil, fmt.Errorf("%w: %q", ERROR_INVALID_FD, fd)
+	}
+	f := os.NewFile(uintptr(n), "listener")
+	// FileListener works on a duplicate, so the original is closed either way.
+	defer f.Close()
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w %d: %v", ERROR_INVALID_FD, n, err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a server that did
+// not shut down cleanly. Sockets something still listens on are kept, as
+// are files that are not sockets, so that listening fails on them.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// WithReusePort sets SO_REUSEPORT on the TCP socket New listens on, so
+// that several processes can listen on the same port, as during a rolling
+// deploy. The kernel spreads connections among them.
+func WithReusePort(enabled bool) Option {
+	return func(s *Server) {
+		s.reusePort = enabled
+	}
+}
+
+// ListenerFile returns a duplicate of the listening socket for a new
+// process to take over, typically as an entry of exec.Cmd's ExtraFiles:
+// the first is descriptor 3 in the child, which serves it with the address
+// "fd:3". The server keeps accepting until it is shut down, and a Unix
+// socket's file is no longer removed when it stops.
+func (s *Server) ListenerFile() (*os.File, error) {
+	if u, ok := s.socket.(*net.UnixListener); ok {
+		u.SetUnlinkOnClose(false)
+	}
+	filer, ok := s.socket.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, ERROR_NO_LISTENER_FILE
+	}
+	return filer.File()
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getAddr requests /addr over a new connection and returns the body.
+func getAddr(t *testing.T, network, addr string) string {
+	conn, err := net.Dial(network, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /addr HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestServeAddr(t *testing.T) {
+	// Test: TCP addresses bind the given interface, port 0 a free port
+	s, err := ServeAddr("127.0.0.1:0", echoTarget)
+	require.NoError(t, err)
+	defer s.Close()
+	addr, ok := s.Addr().(*net.TCPAddr)
+	require.True(t, ok)
+	assert.Equal(t, "127.0.0.1", addr.IP.String())
+	assert.NotZero(t, addr.Port)
+	assert.Equal(t, "/addr", getAddr(t, "tcp", s.Addr().String()))
+
+	// Test: "unix:" addresses listen on a Unix domain socket
+	path := filepath.Join(t.TempDir(), "app.sock")
+	u, err := ServeAddr("unix:"+path, echoTarget)
+	require.NoError(t, err)
+	assert.Equal(t, "/addr", getAddr(t, "unix", path))
+
+	// Test: Sockets in use are not taken over, stale ones are
+	_, err = ServeAddr("unix:"+path, echoTarget)
+	assert.Error(t, err)
+	u.listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	u.Close()
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+	u, err = ServeAddr("unix:"+path, echoTarget)
+	require.NoError(t, err)
+	defer u.Close()
+	assert.Equal(t, "/addr", getAddr(t, "unix", path))
+
+	// Test: Other files are left alone
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	_, err = ServeAddr("unix:"+file, echoTarget)
+	assert.Error(t, err)
+}
+
+func TestReusePort(t *testing.T) {
+	// Test: Without SO_REUSEPORT a port can only be listened on once
+	s, err := ServeAddr("127.0.0.1:0", echoTarget)
+	require.NoError(t, err)
+	_, err = ServeAddr(s.Addr().String(), echoTarget)
+	assert.Error(t, err)
+	s.Close()
+
+	// Test: With it several servers share the port, and it stays served as
+	// long as one of them is left
+	a, err := New(Config{Addr: "127.0.0.1:0", Handler: echoTarget, ReusePort: true})
+	if errors.Is(err, ERROR_REUSEPORT_UNSUPPORTED) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	b, err := ServeAddr(a.Addr().String(), echoTarget, WithReusePort(true))
+	require.NoError(t, err)
+	defer b.Close()
+	a.Close()
+	assert.Equal(t, "/addr", getAddr(t, "tcp", b.Addr().String()))
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dupAddr returns an "fd:" address for a copy of f's descriptor, which the
+// server then owns, as it would an inherited one.
+func dupAddr(t *testing.T, f *os.File) string {
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	return fmt.Sprintf("fd:%d", fd)
+}
+
+// handOver returns the address of s's listener as passed to a new process.
+func handOver(t *testing.T, s *Server) string {
+	f, err := s.ListenerFile()
+	require.NoError(t, err)
+	defer f.Close()
+	return dupAddr(t, f)
+}
+
+func TestInheritListener(t *testing.T) {
+	// Test: A listener handed over by file keeps serving on the same
+	// address once the old server is gone
+	old, err := ServeAddr("127.0.0.1:0", echoTarget)
+	require.NoError(t, err)
+	s, err := ServeAddr(handOver(t, old), echoTarget)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, old.Addr().String(), s.Addr().String())
+	old.Close()
+	assert.Equal(t, "/addr", getAddr(t, "tcp", s.Addr().String()))
+
+	// Test: Unix sockets are not removed by the server handing them over
+	path := filepath.Join(t.TempDir(), "app.sock")
+	old, err = ServeAddr("unix:"+path, echoTarget)
+	require.NoError(t, err)
+	u, err := ServeAddr(handOver(t, old), echoTarget)
+	require.NoError(t, err)
+	defer u.Close()
+	old.Close()
+	assert.Equal(t, "/addr", getAddr(t, "unix", path))
+
+	// Test: Descriptors that are not listeners, or not descriptors, fail
+	for _, addr := range []string{"fd:x", "fd:1", "fd:99999"} {
+		_, err = ServeAddr(addr, echoTarget)
+		assert.ErrorIs(t, err, ERROR_INVALID_FD, addr)
+	}
+	file, err := os.CreateTemp(t.TempDir(), "file")
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = ServeAddr(dupAddr(t, file), echoTarget)
+	assert.ErrorIs(t, err, ERROR_INVALID_FD)
+}
@@ -0,0 +1,25 @@
+package server
+
+// Middleware wraps a handler with behaviour shared by many routes, such as
+// logging, recovery, compression or authentication.
+type Middleware func(Handler) Handler
+
+// Chain combines middleware into one. The first wraps all the others, so it
+// sees the request first and the response last.
+func Chain(mw ...Middleware) Middleware {
+	return func(h Handler) Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			h = mw[i](h)
+		}
+		return h
+	}
+}
+
+// Use wraps the server's handler in mw, in the order given. Middleware from
+// several Use options is applied as if it were all passed to one, in option
+// order.
+func Use(mw ...Middleware) Option {
+	return func(s *Server) {
+		s.middleware = append(s.middleware, mw...)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	tag := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name+" in")
+				next(w, req)
+				calls = append(calls, name+" out")
+			}
+		}
+	}
+	handler := func(w *response.Writer, req *request.Request) {
+		calls = append(calls, "handler")
+		w.WriteText(response.StatusOK, "ok")
+	}
+
+	// Test: The first middleware is the outermost
+	Chain(tag("a"), tag("b"))(handler)(response.NewWriter(io.Discard), nil)
+	assert.Equal(t, []string{"a in", "b in", "handler", "b out", "a out"}, calls)
+
+	// Test: Use options accumulate in order and wrap the server's handler
+	calls = nil
+	s := &Server{}
+	for _, opt := range []Option{Use(tag("a")), Use(tag("b"), tag("c"))} {
+		opt(s)
+	}
+	out := serveRaw(s, Chain(s.middleware...)(handler), pipelinedRequests("/"), 1)
+	assert.Contains(t, out, "HTTP/1.1 200 OK\r\n")
+	assert.Equal(t, []string{"a in", "b in", "c in", "handler", "c out", "b out", "a out"}, calls)
+
+	// Test: An empty chain leaves the handler as it is
+	calls = nil
+	Chain()(handler)(response.NewWriter(io.Discard), nil)
+	assert.Equal(t, []string{"handler"}, calls)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"runtime/debug"
+)
+
+var ERROR_HANDLER_PANIC = fmt.Errorf("handler panicked")
+
+// runHandler calls the handler, recovering from a panic in it: the stack
+// is logged, the connection is marked to close and, if the response has
+// not started yet, the error handler answers 500. It reports false when the
+// panic came after the response started, which then can only be cut short
+// by dropping the connection.
+func (s *Server) runHandler(w *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		s.logf("Handler panicked on %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+		w.CloseAfterResponse()
+		if w.Started() {
+			return
+		}
+		ok = true
+		s.handleError(w, req, response.StatusInternalServerError, fmt.Errorf("%w: %v", ERROR_HANDLER_PANIC, v))
+	}()
+	s.handler(w, req)
+	return true
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"http/internal/request"
+	"http/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	serve := func(handler Handler) string {
+		conn := &fakeConn{Reader: strings.NewReader(pipelinedRequests("/boom", "/next"))}
+		runConnection(&Server{handler: handler}, conn)
+		return conn.out.String()
+	}
+
+	// Test: A panic before the response starts is answered 500 and the
+	// connection is closed
+	out := serve(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "))
+
+	// Test: The error handler learns about the panic
+	var seen error
+	s := &Server{}
+	WithErrorHandler(func(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+		seen = err
+		w.WriteText(status, "oops")
+	})(s)
+	s.handler = func(w *response.Writer, req *request.Request) { panic("boom") }
+	conn := &fakeConn{Reader: strings.NewReader(pipelinedRequests("/boom"))}
+	runConnection(s, conn)
+	assert.ErrorIs(t, seen, ERROR_HANDLER_PANIC)
+	assert.ErrorContains(t, seen, "boom")
+	assert.True(t, strings.HasSuffix(conn.out.String(), "oops"))
+
+	// Test: A panic mid-response drops the connection without completing it
+	out = serve(func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(100)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte("partial"))
+		w.Flush()
+		panic("boom")
+	})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "partial"))
+	assert.NotContains(t, out, "500")
+}
@@ -0,0 +1,9 @@
+//go:build !(darwin || dragonfly || freebsd || netbsd || openbsd || (linux && !(mips || mipsle || mips64 || mips64le)))
+
+package server
+
+import "syscall"
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return ERROR_REUSEPORT_UNSUPPORTED
+}
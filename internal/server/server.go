@@ -1,67 +1,543 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"http/internal/headers"
+	"http/internal/http2"
 	"http/internal/request"
 	"http/internal/response"
+	"http/internal/transfer"
 	"io"
 	"log"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const DefaultMaxPipelined = 16
+
+// maxBodyDrain is how much of an unread request body is skipped to keep the
+// connection open; past it the connection is closed instead.
+const maxBodyDrain = 256 << 10
+
 type Server struct {
-	closed  bool
-	handler Handler
+	closed   atomic.Bool
+	listener net.Listener
+	// socket is the listener before wrapping, for ListenerFile.
+	socket           net.Listener
+	reusePort        bool
+	mu               sync.Mutex
+	conns            map[*trackedConn]struct{}
+	shuttingDown     bool
+	onShutdown       []func(ctx context.Context) error
+	hookTimeout      time.Duration
+	handler          Handler
+	maxPipelined     int
+	listenerWrappers []func(net.Listener) net.Listener
+	connWrappers     []func(net.Conn) net.Conn
+	parserOptions    request.ParserOptions
+	rejectControl    bool
+	maxRequestLine   int
+	maxHeaderBytes   int
+	maxHeaderFields  int
+	serverHeader     string
+	noDate           bool
+	defaults         *headers.Headers
+	preserveCase     bool
+	h2c              bool
+	proxyProtocol    bool
+	trace            bool
+	logger           *log.Logger
+	errorHandler     ErrorHandler
+	errorStatuses    []errorStatus
+	middleware       []Middleware
+	timeouts         Timeouts
+	tlsConfig        *tls.Config
+	certificates     []tls.Certificate
+	// tls is the completed TLS configuration, nil for plain text.
+	tls       *tls.Config
+	connLimit ConnLimit
+	// slots holds a token per connection being served, when limited.
+	slots chan struct{}
+	// rejecters holds a token per connection being answered 503.
+	rejecters chan struct{}
+	accepted  atomic.Uint64
+	rejected  atomic.Uint64
+	// baseCtx is the parent of connection contexts; cancelBase cancels
+	// it once the server stops.
+	baseCtx     context.Context
+	cancelBase  context.CancelFunc
+	connContext func(ctx context.Context, conn net.Conn) context.Context
+}
+
+type Option func(*Server)
+
+// WithMaxPipelined caps how many requests a client may queue on a connection
+// without waiting for responses. Once the cap is reached the current response
+// is sent with "Connection: close" and the remaining requests are dropped.
+// Zero disables the cap.
+func WithMaxPipelined(n int) Option {
+	return func(s *Server) {
+		s.maxPipelined = n
+	}
+}
+
+// DefaultServerHeader is sent as the Server field unless changed with
+// WithServerHeader.
+const DefaultServerHeader = "http-from-scratch"
+
+// WithServerHeader sets the Server field sent with every response; an empty
+// name suppresses it.
+func WithServerHeader(name string) Option {
+	return func(s *Server) {
+		s.serverHeader = name
+	}
+}
+
+// WithDateHeader controls whether a Date field is added to every response.
+// It is on by default.
+func WithDateHeader(enabled bool) Option {
+	return func(s *Server) {
+		s.noDate = !enabled
+	}
+}
+
+// WithDefaultHeaders adds h to every response. Fields set by the handler
+// take precedence.
+func WithDefaultHeaders(h *headers.Headers) Option {
+	return func(s *Server) {
+		s.defaults = h
+	}
+}
+
+// defaultHeaders builds the fields merged into one response.
+func (s *Server) defaultHeaders() *headers.Headers {
+	h := headers.NewHeaders()
+	if s.defaults != nil {
+		s.defaults.Foreach(h.Add)
+	}
+	if s.serverHeader != "" {
+		h.Set("server", s.serverHeader)
+	}
+	if !s.noDate {
+		h.Set("date", httpDate(time.Now()))
+	}
+	return h
+}
+
+// dateValue caches the formatted Date for the current second.
+type dateValue struct {
+	unix  int64
+	value string
+}
+
+var currentDate atomic.Pointer[dateValue]
+
+// httpDate formats now as an IMF-fixdate, reusing the string while the
+// second has not changed.
+func httpDate(now time.Time) string {
+	sec := now.Unix()
+	if d := currentDate.Load(); d != nil && d.unix == sec {
+		return d.value
+	}
+	d := &dateValue{unix: sec, value: now.UTC().Format(response.TimeFormat)}
+	currentDate.Store(d)
+	return d.value
 }
 
-type HandlerError struct {
-	StatusCode response.StatusCode
-	Message    string
+// WithParseMode sets how forgiving request parsing is; the default is
+// request.Strict.
+func WithParseMode(mode request.ParseMode) Option {
+	return WithParserOptions(mode.Options())
+}
+
+// WithParserOptions picks the deviations from RFC 9112 request parsing
+// tolerates, for finer control than WithParseMode.
+func WithParserOptions(opts request.ParserOptions) Option {
+	return func(s *Server) {
+		s.parserOptions = opts
+	}
+}
+
+// WithRejectEncodedControl answers 400 to requests whose path encodes NUL,
+// CR or LF, instead of passing them on decoded.
+func WithRejectEncodedControl(reject bool) Option {
+	return func(s *Server) {
+		s.rejectControl = reject
+	}
+}
+
+// WithMaxRequestLineBytes caps the request line; longer ones are answered
+// with 414. The default is request.DefaultLimits.MaxRequestLineBytes.
+func WithMaxRequestLineBytes(n int) Option {
+	return func(s *Server) {
+		s.maxRequestLine = n
+	}
+}
+
+// WithMaxHeaderBytes caps the header section; larger ones are answered with
+// 431. The default is request.DefaultLimits.MaxHeaderBytes.
+func WithMaxHeaderBytes(n int) Option {
+	return func(s *Server) {
+		s.maxHeaderBytes = n
+	}
+}
+
+// WithMaxHeaderFields caps the number of header fields; requests with more
+// are answered with 431. The default is
+// request.DefaultLimits.MaxHeaderFields.
+func WithMaxHeaderFields(n int) Option {
+	return func(s *Server) {
+		s.maxHeaderFields = n
+	}
+}
+
+// WithPreserveHeaderCase sends response field names the way handlers spelled
+// them instead of in canonical form.
+func WithPreserveHeaderCase(preserve bool) Option {
+	return func(s *Server) {
+		s.preserveCase = preserve
+	}
 }
 
 type Handler func(w *response.Writer, req *request.Request)
 
 func runConnection(s *Server, conn io.ReadWriteCloser) {
-	defer conn.Close()
-	responseWriter := response.NewWriter(conn)
-	r, err := request.RequestFromReader(conn)
+	var src io.Reader = conn
+	var rate *rateReader
+	if s.timeouts.MinHeaderRate > 0 {
+		rate = &rateReader{conn: conn, rate: s.timeouts.MinHeaderRate}
+		src = rate
+	}
+	reader := request.NewReader(src)
+	reader.Options = s.parserOptions
+	reader.RejectEncodedControl = s.rejectControl
+	if s.maxRequestLine > 0 {
+		reader.Limits.MaxRequestLineBytes = s.maxRequestLine
+	}
+	if s.maxHeaderBytes > 0 {
+		reader.Limits.MaxHeaderBytes = s.maxHeaderBytes
+	}
+	if s.maxHeaderFields > 0 {
+		reader.Limits.MaxHeaderFields = s.maxHeaderFields
+	}
+	pipelined := 0
+	queued := false
+	connCtx, cancelConn := s.newConnContext(conn)
+	defer cancelConn()
+	tracked := s.track(conn)
+	var watching chan struct{}
+	hijacked := false
+	defer func() {
+		if hijacked {
+			return
+		}
+		s.untrack(tracked)
+		// Closing unblocks a pending background read.
+		conn.Close()
+		if watching != nil {
+			<-watching
+		}
+	}()
+	// The first request, TLS handshake included, may take as long to
+	// start as any later one.
+	setReadDeadline(conn, deadline(time.Now(), s.timeouts.Idle))
+	info, err := connInfo(conn)
 	if err != nil {
-		log.Printf("Request parsing failed: %v", err)
-		responseWriter.WriteStatusLine(response.StatusBadRequest)
-		responseWriter.WriteHeaders(*response.GetDefaultHeaders(0))
+		s.logf("TLS handshake failed: %v", err)
 		return
 	}
-	log.Printf("Request parsed successfully: %s %s", r.RequestLine.Method, r.RequestLine.RequestTarget)
-	s.handler(responseWriter, r)
+	reader.Conn = info
+	for first := true; ; first = false {
+		// Bytes already buffered means the client sent this request
+		// before reading the previous response.
+		if queued {
+			pipelined++
+		} else {
+			pipelined = 0
+		}
+		responseWriter := response.NewBufferedWriter(conn, 0)
+		responseWriter.SetDefaultHeaders(s.defaultHeaders())
+		responseWriter.SetPreserveHeaderCase(s.preserveCase)
+		// The interim response goes out when the handler first reads the
+		// body; a handler that answers without reading it never sends it.
+		reader.OnContinue = responseWriter.WriteContinue
+		if !s.setIdle(tracked, reader.Buffered() == 0) {
+			return
+		}
+		// While the handler runs, keep reading so a client that hangs up
+		// is noticed right away instead of on the next failed write. This
+		// starts once the body has been read, as until then the handler
+		// reads the connection itself. Bytes that arrive meanwhile are
+		// kept for the next request.
+		ctx, cancel := context.WithCancel(connCtx)
+		var r *request.Request
+		bodyRead := false
+		watch := func() {
+			watching = make(chan struct{})
+			go func(watching chan struct{}) {
+				defer close(watching)
+				if err := reader.Fill(); err != nil {
+					cancel()
+				}
+			}(watching)
+		}
+		reader.OnBodyRead = func() {
+			// The watcher's read must not trip the request's timeout.
+			bodyRead = true
+			setReadDeadline(conn, time.Time{})
+			queued = reader.Buffered() > 0
+			// Requests without a body get here while they are parsed;
+			// their watcher starts once it is known whether the handler
+			// may take the connection over instead.
+			if r != nil && !hijackable(r) {
+				watch()
+			}
+		}
+		// Wait for the request to start, unless the watcher already did.
+		if err := reader.Fill(); err != nil {
+			if isTimeout(err) {
+				s.logf("Connection idle for %v, closing", s.timeouts.Idle)
+			}
+			cancel()
+			return
+		}
+		if first && s.h2c && info.TLS == nil {
+			if h2, err := reader.HasPrefix(http2.ClientPreface); err == nil && h2 {
+				cancel()
+				s.serveH2(connCtx, conn, reader, tracked, nil, nil)
+				return
+			}
+		}
+		start := time.Now()
+		headerDeadline := deadline(start, s.timeouts.headerTimeout())
+		setReadDeadline(conn, headerDeadline)
+		if rate != nil {
+			rate.begin(start, headerDeadline, reader.Buffered())
+		}
+		r, err := reader.ReadRequest()
+		if rate != nil {
+			rate.end()
+		}
+		setWriteDeadline(conn, deadline(time.Now(), s.timeouts.Write))
+		if err == nil && !bodyRead {
+			// Reading the body counts against Read too.
+			setReadDeadline(conn, deadline(start, s.timeouts.Read))
+		}
+		if err == nil && bodyRead && !hijackable(r) {
+			watch()
+		}
+		if !s.setIdle(tracked, false) {
+			cancel()
+			return
+		}
+		if err != nil && (s.isShuttingDown() || s.closed.Load()) {
+			cancel()
+			return
+		}
+		if err != nil {
+			cancel()
+			s.logf("Request parsing failed: %v", err)
+			status := response.StatusBadRequest
+			if errors.Is(err, transfer.ERROR_UNKNOWN_CODING) || errors.Is(err, request.ERROR_UNKNOWN_METHOD) {
+				status = response.StatusNotImplemented
+			} else if errors.Is(err, request.ERROR_UNSUPPORTED_HTTP_VERSION) {
+				status = response.StatusHTTPVersionNotSupported
+			} else if errors.Is(err, request.ERROR_REQUEST_LINE_TOO_LONG) {
+				status = response.StatusURITooLong
+			} else if errors.Is(err, request.ERROR_HEADERS_TOO_LARGE) {
+				status = response.StatusRequestHeaderFieldsTooLarge
+			} else if isTimeout(err) {
+				status = response.StatusRequestTimeout
+			}
+			responseWriter.CloseAfterResponse()
+			s.handleError(responseWriter, nil, status, err)
+			responseWriter.Flush()
+			return
+		}
+		s.logf("Request parsed successfully: %s %s", r.RequestLine.Method, r.RequestLine.RequestTarget)
+		if expect, ok := r.Headers().Get("Expect"); ok && !strings.EqualFold(expect, "100-continue") {
+			// 100-continue is the only expectation there is.
+			responseWriter.CloseAfterResponse()
+			s.handleError(responseWriter, r, response.StatusExpectationFailed, nil)
+			responseWriter.Flush()
+			cancel()
+			return
+		}
+		if r.RequestLine.HttpVersion == "1.0" {
+			// HTTP/1.0 connections only stay open when asked to.
+			responseWriter.SetHTTP10()
+			if !r.Headers().HasToken("connection", "keep-alive") {
+				responseWriter.CloseAfterResponse()
+			}
+		}
+		if r.RequestLine.Method == "HEAD" {
+			responseWriter.SuppressBody()
+		}
+		if r.Headers().HasToken("connection", "close") || s.isShuttingDown() {
+			responseWriter.CloseAfterResponse()
+		}
+		if s.maxPipelined > 0 && pipelined >= s.maxPipelined {
+			s.logf("Pipelining cap of %d reached, closing connection", s.maxPipelined)
+			responseWriter.CloseAfterResponse()
+		}
+		responseWriter.SetContext(ctx)
+		if settings, ok := h2cSettings(r); ok && s.h2c && info.TLS == nil && !s.isShuttingDown() {
+			s.upgradeH2C(connCtx, conn, reader, tracked, responseWriter, r, settings)
+			cancel()
+			return
+		}
+		if hijackable(r) {
+			responseWriter.SetHijacker(func() (io.ReadWriteCloser, error) {
+				hijacked = true
+				return s.hijack(conn, reader, tracked), nil
+			})
+		}
+		readBy := deadline(start, s.timeouts.Read)
+		limitRead := func(d time.Duration) {
+			t := time.Now().Add(d)
+			if bodyRead || (!readBy.IsZero() && readBy.Before(t)) {
+				return
+			}
+			setReadDeadline(conn, t)
+		}
+		reqCtx := context.WithValue(ctx, limitReadKey{}, limitRead)
+		if !s.runHandler(responseWriter, r.WithContext(reqCtx)) || hijacked {
+			cancel()
+			return
+		}
+		if err := responseWriter.Finish(nil); err != nil {
+			s.logf("Finishing response failed: %v", err)
+		}
+		if err := responseWriter.Flush(); err != nil {
+			s.logf("Sending response failed: %v", err)
+			cancel()
+			return
+		}
+		aborted := ctx.Err() != nil
+		if !aborted && responseWriter.KeepAlive() {
+			// Skip what the handler left of the body so the next request
+			// can be read, unless there is too much of it.
+			if err := r.DiscardBody(maxBodyDrain); err != nil {
+				s.logf("Skipping request body failed, closing connection: %v", err)
+				cancel()
+				return
+			}
+		}
+		if aborted || !responseWriter.KeepAlive() {
+			if aborted {
+				s.logf("Client went away: %s %s", r.RequestLine.Method, r.RequestLine.RequestTarget)
+			}
+			cancel()
+			return
+		}
+		if !s.setIdle(tracked, true) {
+			cancel()
+			return
+		}
+		// Waiting for the next request happens in the watcher's read.
+		setWriteDeadline(conn, time.Time{})
+		setReadDeadline(conn, deadline(time.Now(), s.timeouts.Idle))
+		if watching != nil {
+			<-watching
+			watching = nil
+		}
+		cancel()
+	}
+}
+
+// connInfo collects what handlers may want to know about conn, completing
+// the TLS handshake first if there is one.
+func connInfo(conn io.ReadWriteCloser) (request.ConnInfo, error) {
+	info := request.ConnInfo{}
+	if c, ok := conn.(net.Conn); ok {
+		info.RemoteAddr = c.RemoteAddr()
+		info.LocalAddr = c.LocalAddr()
+	}
+	if c, ok := conn.(interface {
+		Handshake() error
+		ConnectionState() tls.ConnectionState
+	}); ok {
+		if err := c.Handshake(); err != nil {
+			return info, err
+		}
+		state := c.ConnectionState()
+		info.TLS = &state
+	}
+	return info, nil
 }
 
 func runServer(s *Server, listener net.Listener) {
 	for {
+		s.waitSlot()
 		conn, err := listener.Accept()
-		if s.closed {
+		if s.closed.Load() || s.isShuttingDown() {
+			if err == nil {
+				conn.Close()
+			}
 			return
 		}
 		if err != nil {
 			return
 		}
-		go runConnection(s, conn)
+		// Wrapping happens off the accept loop, as wrappers may read,
+		// e.g. the PROXY header.
+		if !s.takeSlot() {
+			s.rejected.Add(1)
+			s.startReject(conn)
+			continue
+		}
+		s.accepted.Add(1)
+		go func() {
+			defer s.releaseSlot()
+			runConnection(s, s.prepareConn(conn))
+		}()
 	}
 }
 
-func Serve(port uint16, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return nil, err
-	}
-	server := &Server{
-		closed:  false,
-		handler: handler,
+// prepareConn applies the connection wrappers, then TLS on top, so the
+// wrappers see the raw bytes.
+func (s *Server) prepareConn(conn net.Conn) net.Conn {
+	c := s.wrapConn(conn)
+	if s.tls != nil {
+		c = tls.Server(c, s.tls)
 	}
-	go runServer(server, listener)
-	return server, nil
+	return c
 }
 
+// Serve listens on port on all interfaces; see ServeAddr.
+func Serve(port uint16, handler Handler, opts ...Option) (*Server, error) {
+	return ServeAddr(fmt.Sprintf(":%d", port), handler, opts...)
+}
+
+// ServeAddr listens on addr, either a TCP address like "127.0.0.1:8080"
+// or "[::1]:8080", "unix:" followed by the path of a Unix domain socket,
+// or "fd:" followed by the file descriptor of a listener inherited from
+// the parent process, and serves connections in the background. Port 0
+// picks a free port; Addr tells which.
+func ServeAddr(addr string, handler Handler, opts ...Option) (*Server, error) {
+	return New(Config{Addr: addr, Handler: handler}, opts...)
+}
+
+// Addr is the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the server at once, dropping every connection and cancelling
+// the contexts of their requests. Use Shutdown to let in-flight requests
+// finish.
 func (s *Server) Close() error {
-	s.closed = true
-	return nil
+	s.closed.Store(true)
+	s.cancelContexts()
+	s.closeConns(false)
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
 }
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn replays requests from Reader. Like a well-behaved client it only
+// hangs up, once they are consumed, after hangup is closed.
+type fakeConn struct {
+	io.Reader
+	out    bytes.Buffer
+	hangup chan struct{}
+}
+
+func (c *fakeConn) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	if err == io.EOF && c.hangup != nil {
+		<-c.hangup
+	}
+	return n, err
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func echoTarget(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	h := response.GetDefaultHeaders(len(body))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
+
+// serveRaw runs raw through a connection to s, hanging up once handler has
+// answered responses requests.
+func serveRaw(s *Server, handler Handler, raw string, responses int) string {
+	conn := &fakeConn{Reader: strings.NewReader(raw), hangup: make(chan struct{})}
+	answered := 0
+	s.handler = func(w *response.Writer, req *request.Request) {
+		handler(w, req)
+		answered++
+		if answered == responses {
+			close(conn.hangup)
+		}
+	}
+	runConnection(s, conn)
+	return conn.out.String()
+}
+
+func pipelinedRequests(targets ...string) string {
+	out := ""
+	for _, target := range targets {
+		out += fmt.Sprintf("GET %s HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", target)
+	}
+	return out
+}
+
+func TestPipelining(t *testing.T) {
+	// Test: Pipelined requests are answered in order
+	out := serveRaw(&Server{}, echoTarget, pipelinedRequests("/a", "/b", "/c"), 3)
+	assert.Equal(t, 3, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.Index(out, "/a") < strings.Index(out, "/b"))
+	assert.True(t, strings.Index(out, "/b") < strings.Index(out, "/c"))
+	assert.NotContains(t, out, "Connection: close")
+
+	// Test: Pipelining cap closes the connection after the cap is reached
+	out = serveRaw(&Server{maxPipelined: 2}, echoTarget, pipelinedRequests("/a", "/b", "/c", "/d"), 3)
+	assert.Equal(t, 3, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "Connection: close\r\n\r\n/c"))
+	assert.NotContains(t, out, "/d")
+
+	// Test: Connection: close from the client ends the loop
+	out = serveRaw(&Server{}, echoTarget,
+		"GET /a HTTP/1.1\r\nHost: localhost:42069\r\nConnection: close\r\n\r\n"+pipelinedRequests("/b"), 1)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "Connection: close\r\n")
+}
+
+func TestRequestBodies(t *testing.T) {
+	post := func(target, body string) string {
+		return fmt.Sprintf("POST %s HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: %d\r\n\r\n%s", target, len(body), body)
+	}
+	echoBody := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteBodyReader(req.BodyReader(), req.ContentLength())
+	}
+
+	// Test: Handlers stream the body, unread bodies are skipped
+	out := serveRaw(&Server{}, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/echo" {
+			echoBody(w, req)
+			return
+		}
+		echoTarget(w, req)
+	}, post("/ignore", "skip me")+post("/echo", "hello")+pipelinedRequests("/last"), 3)
+	assert.Equal(t, 3, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "\r\n\r\nhello")
+	assert.NotContains(t, out, "skip me")
+	assert.True(t, strings.HasSuffix(out, "/last"))
+
+	// Test: Too much unread body closes the connection
+	big := strings.Repeat("x", maxBodyDrain+1)
+	out = serveRaw(&Server{}, echoTarget, post("/ignore", big)+pipelinedRequests("/last"), 1)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.NotContains(t, out, "/last")
+}
+
+func TestExpectContinue(t *testing.T) {
+	expecting := func(expect string) string {
+		return "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nExpect: " + expect + "\r\nContent-Length: 5\r\n\r\nhello"
+	}
+
+	// Test: 100 Continue goes out when the handler reads the body
+	out := serveRaw(&Server{}, func(w *response.Writer, req *request.Request) {
+		body := req.Body()
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, expecting("100-continue"), 1)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "hello"))
+
+	// Test: Rejecting without reading the body skips it and closes
+	out = serveRaw(&Server{}, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		w.WriteStatusLine(response.StatusContentTooLarge)
+		w.WriteHeaders(*h)
+	}, expecting("100-continue")+pipelinedRequests("/next"), 1)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+	assert.NotContains(t, out, "100 Continue")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1"))
+
+	// Test: Unknown expectations fail
+	out = serveRaw(&Server{}, echoTarget, expecting("something-else"), 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 417 Expectation Failed\r\n"))
+}
+
+func TestHTTP10(t *testing.T) {
+	get := func(target string, fields ...string) string {
+		return "GET " + target + " HTTP/1.0\r\n" + strings.Join(fields, "") + "\r\n"
+	}
+	stream := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteBodyReader(strings.NewReader("streamed"), -1)
+	}
+
+	// Test: Connections close unless keep-alive is asked for
+	out := serveRaw(&Server{}, echoTarget, get("/a")+get("/b"), 1)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "Connection: close\r\n")
+
+	// Test: Keep-alive is confirmed in the response
+	out = serveRaw(&Server{}, echoTarget, get("/a", "Connection: keep-alive\r\n")+get("/b"), 2)
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "Connection: keep-alive\r\n\r\n/a")
+	assert.True(t, strings.HasSuffix(out, "Connection: close\r\n\r\n/b"))
+
+	// Test: Bodies of unknown length are not chunked, the connection ends them
+	out = serveRaw(&Server{}, stream, get("/", "Connection: keep-alive\r\n")+get("/b"), 1)
+	assert.NotContains(t, out, "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(out, "Connection: close\r\n\r\nstreamed"))
+
+	// Test: Other versions are not supported
+	out = serveRaw(&Server{}, echoTarget, "GET / HTTP/2.0\r\n\r\n", 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 505 HTTP Version Not Supported\r\n"))
+}
+
+func TestHeaderLimits(t *testing.T) {
+	s := &Server{}
+	WithMaxRequestLineBytes(64)(s)
+	WithMaxHeaderBytes(128)(s)
+
+	// Test: Oversized request lines answer 414 and close
+	out := serveRaw(s, echoTarget, pipelinedRequests("/"+strings.Repeat("a", 64)), 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 414 URI Too Long\r\n"))
+	assert.Contains(t, out, "Connection: close\r\n")
+
+	// Test: Oversized header sections answer 431 and close
+	raw := "GET / HTTP/1.1\r\nCookie: " + strings.Repeat("c", 128) + "\r\n\r\n"
+	out = serveRaw(s, echoTarget, raw, 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 Request Header Fields Too Large\r\n"))
+	assert.Contains(t, out, "Connection: close\r\n")
+
+	// Test: Too many header fields answer 431
+	s = &Server{}
+	WithMaxHeaderFields(5)(s)
+	raw = "GET / HTTP/1.1\r\nHost: localhost:42069\r\n" + strings.Repeat("X-A: 1\r\n", 5) + "\r\n"
+	out = serveRaw(s, echoTarget, raw, 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 Request Header Fields Too Large\r\n"))
+}
+
+func TestUnknownMethods(t *testing.T) {
+	// Test: Unknown methods answer 501, malformed ones 400
+	out := serveRaw(&Server{}, echoTarget, "BREW /pot HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented\r\n"))
+	out = serveRaw(&Server{}, echoTarget, "BR{EW /pot HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestMissingHost(t *testing.T) {
+	// Test: HTTP/1.1 requests without exactly one Host header answer 400
+	out := serveRaw(&Server{}, echoTarget, "GET / HTTP/1.1\r\n\r\n", 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	out = serveRaw(&Server{}, echoTarget, "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", 0)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	serve := func(s *Server, handler Handler) string {
+		return serveRaw(s, handler, pipelinedRequests("/a"), 1)
+	}
+
+	// Test: Server and Date are added by default
+	s := &Server{serverHeader: DefaultServerHeader}
+	out := serve(s, echoTarget)
+	assert.Contains(t, out, "Server: http-from-scratch\r\n")
+	assert.Regexp(t, `Date: \w{3}, \d{2} \w{3} \d{4} \d{2}:\d{2}:\d{2} GMT\r\n`, out)
+
+	// Test: Both can be suppressed, extra defaults are merged
+	extra := headers.NewHeaders()
+	extra.Set("X-Frame-Options", "DENY")
+	extra.Set("Content-Type", "text/html")
+	s = &Server{}
+	for _, opt := range []Option{WithServerHeader(""), WithDateHeader(false), WithDefaultHeaders(extra)} {
+		opt(s)
+	}
+	out = serve(s, echoTarget)
+	assert.NotContains(t, out, "Server:")
+	assert.NotContains(t, out, "Date:")
+	assert.Contains(t, out, "X-Frame-Options: DENY\r\n")
+
+	// Test: Fields set by the handler win
+	assert.Contains(t, out, "Content-Type: text/plain\r\n")
+	assert.NotContains(t, out, "text/html")
+
+	// Test: Names can be sent the way they were spelled
+	extra = headers.NewHeaders()
+	extra.Set("x-FRAME-options", "DENY")
+	s = &Server{}
+	WithDefaultHeaders(extra)(s)
+	WithPreserveHeaderCase(true)(s)
+	out = serve(s, echoTarget)
+	assert.Contains(t, out, "x-FRAME-options: DENY\r\n")
+
+	// Test: Connection follows the protocol version and keep-alive state
+	s = &Server{}
+	out = serve(s, echoTarget)
+	assert.NotContains(t, out, "Connection:")
+	out = serveRaw(s, echoTarget, "GET /a HTTP/1.0\r\n\r\n", 1)
+	assert.Contains(t, out, "Connection: close\r\n")
+	out = serveRaw(s, echoTarget, "GET /a HTTP/1.1\r\nHost: localhost:42069\r\nConnection: close\r\n\r\n", 1)
+	assert.Contains(t, out, "Connection: close\r\n")
+
+	// Test: Date is formatted as an IMF-fixdate and refreshed each second
+	now := time.Date(2024, time.March, 5, 7, 8, 9, 0, time.FixedZone("CET", 3600))
+	assert.Equal(t, "Tue, 05 Mar 2024 06:08:09 GMT", httpDate(now))
+	assert.Equal(t, "Tue, 05 Mar 2024 06:08:09 GMT", httpDate(now.Add(500*time.Millisecond)))
+	assert.Equal(t, "Tue, 05 Mar 2024 06:08:10 GMT", httpDate(now.Add(time.Second)))
+}
+
+func TestClientAbort(t *testing.T) {
+	// Test: A client hanging up mid-response cancels the request and
+	// fails writes with ERROR_CLIENT_ABORTED
+	conn := &fakeConn{Reader: strings.NewReader(pipelinedRequests("/stream")), hangup: make(chan struct{})}
+	var writeErr error
+	s := &Server{handler: func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		close(conn.hangup)
+		select {
+		case <-req.Context().Done():
+		case <-time.After(time.Second):
+			t.Error("request context was not cancelled")
+		}
+		_, writeErr = w.WriteBody([]byte("5\r\nhello\r\n"))
+	}}
+	runConnection(s, conn)
+	assert.ErrorIs(t, writeErr, response.ERROR_CLIENT_ABORTED)
+	assert.NotContains(t, conn.out.String(), "hello")
+}
+
+func TestHead(t *testing.T) {
+	page := func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "hello")
+	}
+
+	// Test: HEAD responses go out without a body and keep the connection
+	raw := "HEAD /page HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" + pipelinedRequests("/page")
+	out := serveRaw(&Server{}, page, raw, 2)
+	responses := strings.SplitAfter(out, "\r\n\r\n")
+	require.Len(t, responses, 3)
+	assert.Contains(t, responses[0], "Content-Length: 5\r\n")
+	assert.True(t, strings.HasPrefix(responses[1], "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, "hello", responses[2])
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const DefaultShutdownHookTimeout = 5 * time.Second
+
+// WithShutdownHookTimeout bounds how long each OnShutdown hook may run.
+func WithShutdownHookTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.hookTimeout = d
+	}
+}
+
+// trackedConn is a connection the server can close on shutdown. Idle
+// connections are waiting for the next request and can be closed without
+// cutting a response short.
+type trackedConn struct {
+	conn io.Closer
+	idle bool
+}
+
+func (s *Server) track(conn io.Closer) *trackedConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = map[*trackedConn]struct{}{}
+	}
+	tc := &trackedConn{conn: conn}
+	s.conns[tc] = struct{}{}
+	return tc
+}
+
+func (s *Server) untrack(tc *trackedConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, tc)
+}
+
+// setIdle records whether tc waits for a request. It reports false when the
+// connection should be dropped because the server is shutting down.
+func (s *Server) setIdle(tc *trackedConn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tc.idle = idle
+	return !(idle && s.shuttingDown) && !s.closed.Load()
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
+func (s *Server) closeConns(idleOnly bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tc := range s.conns {
+		if tc.idle || !idleOnly {
+			tc.conn.Close()
+		}
+	}
+}
+
+func (s *Server) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// OnShutdown registers fn to run during Shutdown, once connections have
+// drained or the shutdown context expired, and before the remaining ones are
+// force-closed. Hooks run in registration order, each with its own timeout
+// (see WithShutdownHookTimeout); a hook that overruns is abandoned and the
+// next one starts.
+func (s *Server) OnShutdown(fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for the
+// others to finish their current response (they are not kept alive). When
+// ctx expires first, Shutdown stops waiting and cancels the contexts of the
+// requests still running; either way the OnShutdown hooks run and whatever
+// is still open is then closed. It returns ctx.Err() if connections had to
+// be cut.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	hooks := append([]func(ctx context.Context) error{}, s.onShutdown...)
+	s.mu.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.closeConns(true)
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	var err error
+	for s.activeConns() > 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+			// Connections may have gone idle since the last pass.
+			s.closeConns(true)
+		}
+	}
+
+	// Handlers still running are told to give up.
+	s.cancelContexts()
+	for i, hook := range hooks {
+		s.runHook(ctx, i, hook)
+	}
+	s.closed.Store(true)
+	s.closeConns(false)
+	return err
+}
+
+func (s *Server) runHook(ctx context.Context, i int, hook func(ctx context.Context) error) {
+	// Hooks get their full timeout even if draining used up ctx.
+	hookCtx := context.WithoutCancel(ctx)
+	cancel := func() {}
+	if s.hookTimeout > 0 {
+		hookCtx, cancel = context.WithTimeout(hookCtx, s.hookTimeout)
+	}
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- hook(hookCtx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			s.logf("Shutdown hook %d failed: %v", i, err)
+		}
+	case <-hookCtx.Done():
+		s.logf("Shutdown hook %d timed out", i)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		echoTarget(w, req)
+	}, WithShutdownHookTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	addr := s.listener.Addr().String()
+
+	var mu sync.Mutex
+	order := []string{}
+	hook := func(name string, d time.Duration) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+	s.OnShutdown(hook("flush", 0))
+	s.OnShutdown(hook("stuck", time.Minute))
+	s.OnShutdown(hook("deregister", 0))
+
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	busy, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer busy.Close()
+	fmt.Fprint(busy, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	done := make(chan error)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+
+	// Test: Idle connections are closed, new ones refused
+	_, err = bufio.NewReader(idle).ReadByte()
+	assert.Error(t, err)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		time.Sleep(time.Millisecond)
+	}
+
+	// Test: Hooks wait for in-flight requests, whose connection is closed after
+	// the response
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, order)
+	mu.Unlock()
+	close(release)
+	br := bufio.NewReader(busy)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	io.Copy(io.Discard, res.Body)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Hooks run in order, a stuck one is abandoned after its timeout
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"flush", "deregister"}, order)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	ran := false
+	s.OnShutdown(func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	// Test: Hooks still run and connections are cut when ctx expires
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	assert.True(t, ran)
+	_, err = bufio.NewReader(conn).ReadByte()
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"http/internal/request"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Timeouts bound how long each phase of a connection may take. Zero fields
+// mean no limit.
+type Timeouts struct {
+	// ReadHeader bounds reading a request's line and headers, from its
+	// first byte. It defaults to Read.
+	ReadHeader time.Duration
+	// Read bounds reading a whole request, body included, from its first
+	// byte. A handler reading the body past it gets a timeout error.
+	Read time.Duration
+	// Write bounds sending a response, from the end of the request's
+	// headers. Writes past it fail and the connection is closed.
+	Write time.Duration
+	// Idle bounds the wait for a request to start, on a new connection or
+	// after a kept-alive response.
+	Idle time.Duration
+	// MinHeaderRate, in bytes per second, is how fast a request's head
+	// must arrive on average once it has taken a second. Clients that
+	// trickle it in more slowly, to hold connections open, are answered
+	// 408 as for ReadHeader. Zero means no minimum.
+	MinHeaderRate int
+}
+
+// DefaultIdleTimeout is how long a kept-alive connection may sit between
+// requests unless changed with WithIdleTimeout.
+const DefaultIdleTimeout = 2 * time.Minute
+
+// DefaultReadHeaderTimeout is how long a request's head may take to arrive
+// unless changed with WithTimeouts.
+const DefaultReadHeaderTimeout = 10 * time.Second
+
+// WithTimeouts replaces the server's timeouts, defaults included.
+// Requests whose line and headers do not arrive in time are answered 408
+// and the connection is closed; connections that stay idle are closed
+// without a response.
+func WithTimeouts(t Timeouts) Option {
+	return func(s *Server) {
+		s.timeouts = t
+	}
+}
+
+// WithIdleTimeout closes kept-alive connections on which no new request
+// starts within d of the previous response. Zero keeps them open until the
+// client hangs up.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.timeouts.Idle = d
+	}
+}
+
+// headerTimeout is the limit on reading a request's head.
+func (t Timeouts) headerTimeout() time.Duration {
+	if t.ReadHeader > 0 {
+		return t.ReadHeader
+	}
+	return t.Read
+}
+
+type limitReadKey struct{}
+
+// LimitRead gives req's body d from now to arrive, for handlers that allow
+// less than the server's Read timeout, which it never extends. Reading past
+// it fails with a timeout error. It does nothing once the body has been
+// read, or for requests not read off a connection of their own, such as
+// HTTP/2 streams.
+func LimitRead(req *request.Request, d time.Duration) {
+	if limit, ok := req.Context().Value(limitReadKey{}).(func(time.Duration)); ok && d > 0 {
+		limit(d)
+	}
+}
+
+// deadline is d from start, or the zero time, meaning none, if d is zero.
+func deadline(start time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return start.Add(d)
+}
+
+type deadlineConn interface {
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// setReadDeadline sets the read deadline of connections that have one.
+func setReadDeadline(conn io.ReadWriteCloser, t time.Time) {
+	if c, ok := conn.(deadlineConn); ok {
+		c.SetReadDeadline(t)
+	}
+}
+
+// setWriteDeadline sets the write deadline of connections that have one.
+func setWriteDeadline(conn io.ReadWriteCloser, t time.Time) {
+	if c, ok := conn.(deadlineConn); ok {
+		c.SetWriteDeadline(t)
+	}
+}
+
+// isTimeout reports whether err comes from a deadline passing.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// minRateGrace is how long a request head may take before MinHeaderRate
+// applies, so that the first packets need not carry much.
+const minRateGrace = time.Second
+
+// rateReader enforces MinHeaderRate. While a request head is read, every
+// read moves the deadline to when the client must have sent more to keep
+// up the rate, never past the ReadHeader deadline.
+type rateReader struct {
+	conn     io.ReadWriteCloser
+	rate     int
+	active   atomic.Bool
+	start    time.Time
+	limit    time.Time
+	received int
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	if r.active.Load() {
+		r.received += n
+		r.extend()
+	}
+	return n, err
+}
+
+// begin starts enforcing the rate on a head that started at start, of
+// which buffered bytes have arrived already. limit is the ReadHeader
+// deadline, if any.
+func (r *rateReader) begin(start, limit time.Time, buffered int) {
+	r.start, r.limit, r.received = start, limit, buffered
+	r.extend()
+	r.active.Store(true)
+}
+
+func (r *rateReader) end() {
+	r.active.Store(false)
+}
+
+func (r *rateReader) extend() {
+	d := r.start.Add(minRateGrace + time.Duration(r.received)*time.Second/time.Duration(r.rate))
+	if !r.limit.IsZero() && d.After(r.limit) {
+		d = r.limit
+	}
+	setReadDeadline(r.conn, d)
+}
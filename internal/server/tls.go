@@ -0,0 +1,51 @@
+package server
+
+import (
+	"crypto/tls"
+)
+
+// WithTLSConfig serves connections over TLS with config, which is used as
+// is for ALPN, client authentication and the like. Certificates may come
+// from it or, with ServeTLS, from files. Left unset, MinVersion defaults to
+// TLS 1.2 and NextProtos to "http/1.1".
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+func withCertificate(cert tls.Certificate) Option {
+	return func(s *Server) {
+		s.certificates = append(s.certificates, cert)
+	}
+}
+
+// ServeTLS is Serve over TLS, with the certificate and key read from PEM
+// files. Use WithTLSConfig for anything beyond the certificate.
+func ServeTLS(port uint16, certFile, keyFile string, handler Handler, opts ...Option) (*Server, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return Serve(port, handler, append([]Option{withCertificate(cert)}, opts...)...)
+}
+
+// serverTLSConfig completes the configured TLS settings, or returns nil
+// for plain-text servers.
+func (s *Server) serverTLSConfig() *tls.Config {
+	if s.tlsConfig == nil && len(s.certificates) == 0 {
+		return nil
+	}
+	config := &tls.Config{}
+	if s.tlsConfig != nil {
+		config = s.tlsConfig.Clone()
+	}
+	config.Certificates = append(config.Certificates, s.certificates...)
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+	return config
+}
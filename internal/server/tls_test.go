@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"http/internal/request"
+	"http/internal/response"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeTLS(t *testing.T) {
+	// Borrow a self-signed certificate from httptest.
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	defer ts.Close()
+	cert := ts.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+
+	var state *tls.ConnectionState
+	handler := func(w *response.Writer, req *request.Request) {
+		state = req.TLS()
+		w.WriteText(response.StatusOK, "secure")
+	}
+	s, err := ServeTLS(0, certFile, keyFile, handler)
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.listener.Addr().String()
+
+	// Test: Requests are served over TLS and see the negotiated session
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	require.NotNil(t, state)
+	assert.Equal(t, "http/1.1", state.NegotiatedProtocol)
+	assert.GreaterOrEqual(t, state.Version, uint16(tls.VersionTLS12))
+
+	// Test: Old protocol versions are refused by default
+	_, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11})
+	assert.Error(t, err)
+
+	// Test: Missing files fail up front
+	_, err = ServeTLS(0, filepath.Join(dir, "missing.pem"), keyFile, handler)
+	assert.Error(t, err)
+
+	// Test: Client certificates can be required through the config
+	s2, err := ServeTLS(0, certFile, keyFile, handler, WithTLSConfig(&tls.Config{ClientAuth: tls.RequireAnyClientCert}))
+	require.NoError(t, err)
+	defer s2.Close()
+	conn2, err := tls.Dial("tcp", s2.listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		// TLS 1.3 clients only learn of the rejection on their first read.
+		defer conn2.Close()
+		conn2.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		_, err = http.ReadResponse(bufio.NewReader(conn2), nil)
+	}
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"http/internal/headers"
+	"http/internal/request"
+	"http/internal/response"
+	"strconv"
+	"strings"
+)
+
+// traceExcluded are the fields left out of TRACE echoes, as they carry
+// credentials (RFC 9110, section 9.3.8).
+var traceExcluded = []string{"authorization", "proxy-authorization", "cookie"}
+
+// WithTrace answers TRACE requests by echoing them, so clients can see
+// what reached the server through any intermediaries, instead of passing
+// them to the handler; see WriteTrace. Middleware still sees them, and
+// fields it adds to the request show in the echo. It is off by default,
+// as reflecting requests can help scripts read what they otherwise could
+// not. Gateways that pass TRACE on to a proxy.ReverseProxy leave it off:
+// the proxy answers once Max-Forwards runs out.
+func WithTrace(enabled bool) Option {
+	return func(s *Server) {
+		s.trace = enabled
+	}
+}
+
+// WriteTrace answers req with its own head as message/http, the way it
+// was received but for the fields that carry credentials.
+func WriteTrace(w *response.Writer, req *request.Request) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/%s\r\n", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion)
+	echo := headers.NewHeaders()
+	req.Headers().Foreach(func(n, v string) {
+		for _, excluded := range traceExcluded {
+			if strings.EqualFold(n, excluded) {
+				return
+			}
+		}
+		echo.Add(n, v)
+	})
+	echo.SetPreserveCase(true)
+	if _, err := echo.WriteTo(&b); err != nil {
+		return err
+	}
+	h := w.Header()
+	h.Set("content-type", "message/http")
+	h.Set("content-length", strconv.Itoa(b.Len()))
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(b.Bytes())
+	return err
+}
+
+// traceHandler wraps next to answer TRACE itself when enabled.
+func (s *Server) traceHandler(next Handler) Handler {
+	if !s.trace {
+		return next
+	}
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "TRACE" {
+			next(w, req)
+			return
+		}
+		WriteTrace(w, req)
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrace(t *testing.T) {
+	head := "TRACE /a?b=c HTTP/1.1\r\nHost: localhost:42069\r\nAuthorization: Basic c2VjcmV0\r\nX-Hop: proxy-1\r\ncookie: session=1\r\nx-hop: proxy-2\r\nProxy-Authorization: Bearer t\r\n\r\n"
+	s := &Server{}
+	WithTrace(true)(s)
+
+	// Test: The request head is echoed as message/http, as received but
+	// for credentials
+	out := serveRaw(s, s.traceHandler(echoTarget), head, 1)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "Content-Type: message/http\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nTRACE /a?b=c HTTP/1.1\r\nHost: localhost:42069\r\nX-Hop: proxy-1\r\nx-hop: proxy-2\r\n\r\n"))
+
+	// Test: Other methods reach the handler
+	out = serveRaw(s, s.traceHandler(echoTarget), "GET /a HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", 1)
+	assert.True(t, strings.HasSuffix(out, "/a"))
+
+	// Test: Without the option TRACE goes to the handler too
+	s = &Server{}
+	out = serveRaw(s, s.traceHandler(echoTarget), head, 1)
+	assert.True(t, strings.HasSuffix(out, "/a?b=c"))
+}
@@ -0,0 +1,327 @@
+package transfer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"http/internal/headers"
+	"http/internal/response"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ERROR_UNKNOWN_CODING = fmt.Errorf("unknown transfer coding")
+var ERROR_CHUNKED_NOT_LAST = fmt.Errorf("chunked is not the final transfer coding")
+
+// Coding is a transfer coding (RFC 9112 section 7) such as chunked or gzip.
+// Codings are looked up by name when a Transfer-Encoding field is decoded or
+// a response is encoded, so new ones only need to be registered.
+type Coding interface {
+	Name() string
+	// NewReader decodes r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	// NewWriter encodes into w; Close finishes the coding without closing w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Coding{}
+)
+
+// Register makes c available under its lowercased name, replacing any
+// coding registered before under that name.
+func Register(c Coding) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[strings.ToLower(c.Name())] = c
+}
+
+func Lookup(name string) (Coding, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := registry[strings.ToLower(name)]
+	return c, ok
+}
+
+func init() {
+	Register(identity{})
+	Register(chunked{})
+	Register(gzipCoding{})
+	Register(deflateCoding{})
+}
+
+// Parse splits a Transfer-Encoding value into coding names, in the order
+// they were applied. Parameters are dropped and every name must be
+// registered.
+func Parse(value string) ([]string, error) {
+	names := []string{}
+	for _, part := range headers.SplitList(value) {
+		name, _, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := Lookup(name); !ok {
+			return nil, fmt.Errorf("%w: %q", ERROR_UNKNOWN_CODING, name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Known is Parse skipping codings that are not registered, for lenient
+// parsers that pass such bodies on undecoded.
+func Known(value string) []string {
+	names := []string{}
+	for _, part := range headers.SplitList(value) {
+		name, _, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := Lookup(name); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Decode undoes the codings listed in names, last applied first.
+func Decode(r io.Reader, names []string) (io.Reader, error) {
+	for i := len(names) - 1; i >= 0; i-- {
+		c, ok := Lookup(names[i])
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ERROR_UNKNOWN_CODING, names[i])
+		}
+		dec, err := c.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = dec
+	}
+	return r, nil
+}
+
+// encoder chains the writers of several codings.
+type encoder struct {
+	io.Writer
+	writers []io.WriteCloser
+}
+
+// Close finishes every coding, outermost first, so each flushes into the
+// next.
+func (e *encoder) Close() error {
+	for _, w := range e.writers {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode applies the codings listed in names, in order, to whatever is
+// written to the returned writer.
+func Encode(w io.Writer, names []string) (io.WriteCloser, error) {
+	e := &encoder{Writer: w}
+	for i := len(names) - 1; i >= 0; i-- {
+		c, ok := Lookup(names[i])
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ERROR_UNKNOWN_CODING, names[i])
+		}
+		enc, err := c.NewWriter(e.Writer)
+		if err != nil {
+			return nil, err
+		}
+		e.Writer = enc
+		e.writers = append([]io.WriteCloser{enc}, e.writers...)
+	}
+	return e, nil
+}
+
+// filter applies transfer codings to a response body. Chunked framing is
+// left to the response.Writer.
+type filter struct {
+	names []string
+}
+
+// Filter returns a response.BodyFilter that sends the body with the given
+// codings, e.g. Filter("gzip"). The response ends up with
+// "Transfer-Encoding: gzip, chunked".
+func Filter(names ...string) (response.BodyFilter, error) {
+	f := filter{}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := Lookup(name); !ok {
+			return nil, fmt.Errorf("%w: %q", ERROR_UNKNOWN_CODING, name)
+		}
+		if name == "chunked" {
+			return nil, ERROR_CHUNKED_NOT_LAST
+		}
+		f.names = append(f.names, name)
+	}
+	return f, nil
+}
+
+func (f filter) Headers(h *headers.Headers) {
+	// Chunked is dropped here, the writer puts it back last.
+	codings := []string{}
+	for _, name := range h.List("transfer-encoding") {
+		if !strings.EqualFold(name, "chunked") {
+			codings = append(codings, name)
+		}
+	}
+	codings = append(codings, f.names...)
+	if len(codings) > 0 {
+		h.Set("transfer-encoding", strings.Join(codings, ", "))
+	} else {
+		h.Delete("transfer-encoding")
+	}
+}
+
+func (f filter) Wrap(dst io.Writer) io.WriteCloser {
+	// The names were checked by Filter, and the built-in codings never
+	// fail to create a writer.
+	enc, err := Encode(dst, f.names)
+	if err != nil {
+		panic(err)
+	}
+	return enc
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+type identity struct{}
+
+func (identity) Name() string {
+	return "identity"
+}
+
+func (identity) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+func (identity) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopCloser{w}, nil
+}
+
+type chunked struct{}
+
+func (chunked) Name() string {
+	return "chunked"
+}
+
+func (chunked) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(&chunkedReader{br: bufio.NewReader(r)}), nil
+}
+
+func (chunked) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &chunkedWriter{w: w}, nil
+}
+
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	frame := fmt.Appendf(nil, "%x\r\n", len(p))
+	frame = append(frame, p...)
+	frame = append(frame, "\r\n"...)
+	if _, err := c.w.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *chunkedWriter) Close() error {
+	_, err := c.w.Write([]byte("0\r\n\r\n"))
+	return err
+}
+
+// chunkedReader decodes chunked framing, skipping extensions and trailers.
+type chunkedReader struct {
+	br        *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		line, err := c.br.ReadString('\n')
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		size, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("malformed chunk size %q", size)
+		}
+		if n == 0 {
+			c.done = true
+			// Trailer section, ended by an empty line.
+			for {
+				line, err := c.br.ReadString('\n')
+				if err != nil {
+					return 0, io.ErrUnexpectedEOF
+				}
+				if strings.TrimRight(line, "\r\n") == "" {
+					return 0, io.EOF
+				}
+			}
+		}
+		c.remaining = n
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 && err == nil {
+		if crlf, e := c.br.ReadString('\n'); e != nil || crlf != "\r\n" {
+			return n, fmt.Errorf("malformed chunk data end")
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+type gzipCoding struct{}
+
+func (gzipCoding) Name() string {
+	return "gzip"
+}
+
+func (gzipCoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipCoding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// deflateCoding is the "deflate" coding, which despite its name is the
+// zlib format (RFC 9110 section 8.4.1.2).
+type deflateCoding struct{}
+
+func (deflateCoding) Name() string {
+	return "deflate"
+}
+
+func (deflateCoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func (deflateCoding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
@@ -0,0 +1,96 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"http/internal/response"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type base64Coding struct{}
+
+func (base64Coding) Name() string {
+	return "x-base64"
+}
+
+func (base64Coding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(base64.NewDecoder(base64.StdEncoding, r)), nil
+}
+
+func (base64Coding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return base64.NewEncoder(base64.StdEncoding, w), nil
+}
+
+func TestCodings(t *testing.T) {
+	// Test: Names are parsed in order, parameters dropped
+	names, err := Parse("GZIP;level=1 , chunked")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gzip", "chunked"}, names)
+	_, err = Parse("br, chunked")
+	assert.ErrorIs(t, err, ERROR_UNKNOWN_CODING)
+
+	// Test: Encode and Decode round-trip through several codings
+	for _, names := range [][]string{{"identity"}, {"chunked"}, {"gzip", "chunked"}, {"deflate", "gzip"}} {
+		out := &bytes.Buffer{}
+		enc, err := Encode(out, names)
+		require.NoError(t, err)
+		io.WriteString(enc, "hello, ")
+		io.WriteString(enc, "world")
+		require.NoError(t, enc.Close())
+		dec, err := Decode(out, names)
+		require.NoError(t, err)
+		body, err := io.ReadAll(dec)
+		require.NoError(t, err)
+		assert.Equal(t, "hello, world", string(body), names)
+	}
+
+	// Test: Chunked output is framed on the wire
+	out := &bytes.Buffer{}
+	enc, _ := Encode(out, []string{"chunked"})
+	io.WriteString(enc, "hello")
+	enc.Close()
+	assert.Equal(t, "5\r\nhello\r\n0\r\n\r\n", out.String())
+
+	// Test: Registered codings are picked up by name
+	Register(base64Coding{})
+	names, err = Parse("x-base64, chunked")
+	require.NoError(t, err)
+	dec, err := Decode(strings.NewReader("aGVsbG8="), names[:1])
+	require.NoError(t, err)
+	body, _ := io.ReadAll(dec)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestFilter(t *testing.T) {
+	f, err := Filter("gzip")
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	w := response.NewWriter(out)
+	w.AddFilter(f)
+
+	// Test: Responses are sent with the coding, chunked last
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*response.GetDefaultHeaders(5))
+	w.WriteBody([]byte("hello"))
+	require.NoError(t, w.Finish(nil))
+	head, body, ok := strings.Cut(out.String(), "\r\n\r\n")
+	require.True(t, ok)
+	assert.Contains(t, head+"\r\n", "Transfer-Encoding: gzip, chunked\r\n")
+	assert.NotContains(t, head, "Content-Length")
+	dec, err := Decode(strings.NewReader(body), []string{"gzip", "chunked"})
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(decoded))
+
+	// Test: Chunked and unknown codings are refused
+	_, err = Filter("chunked")
+	assert.ErrorIs(t, err, ERROR_CHUNKED_NOT_LAST)
+	_, err = Filter("br")
+	assert.ErrorIs(t, err, ERROR_UNKNOWN_CODING)
+}
@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Opcodes of RFC 6455 section 5.2.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxControlPayload is the largest payload a control frame may carry.
+const maxControlPayload = 125
+
+var ERROR_FRAME_TOO_LARGE = fmt.Errorf("websocket frame too large")
+
+type frame struct {
+	fin    bool
+	rsv    byte
+	opcode byte
+	masked bool
+	// payload is unmasked once read.
+	payload []byte
+}
+
+func (f frame) control() bool {
+	return f.opcode&0x8 != 0
+}
+
+// readFrame reads one frame, refusing payloads over max bytes when max is
+// positive.
+func readFrame(r *bufio.Reader, max int64) (frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin:    head[0]&0x80 != 0,
+		rsv:    head[0] & 0x70,
+		opcode: head[0] & 0x0f,
+		masked: head[1]&0x80 != 0,
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return frame{}, ERROR_FRAME_TOO_LARGE
+		}
+	}
+	if max > 0 && length > uint64(max) {
+		return frame{}, ERROR_FRAME_TOO_LARGE
+	}
+	var key [4]byte
+	if f.masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+	if f.masked {
+		mask(f.payload, key)
+	}
+	return f, nil
+}
+
+// appendFrame encodes a final frame, masking the payload with key when it
+// is given, as clients must.
+func appendFrame(dst []byte, opcode byte, fin bool, payload []byte, key *[4]byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	var b1 byte
+	if key != nil {
+		b1 = 0x80
+	}
+	n := len(payload)
+	switch {
+	case n <= 125:
+		dst = append(dst, b0, b1|byte(n))
+	case n <= 0xffff:
+		dst = append(dst, b0, b1|126)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(n))
+	default:
+		dst = append(dst, b0, b1|127)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(n))
+	}
+	if key == nil {
+		return append(dst, payload...)
+	}
+	dst = append(dst, key[:]...)
+	start := len(dst)
+	dst = append(dst, payload...)
+	mask(dst[start:], *key)
+	return dst
+}
+
+// mask applies, or removes, a masking key in place.
+func mask(p []byte, key [4]byte) {
+	for i := range p {
+		p[i] ^= key[i%4]
+	}
+}
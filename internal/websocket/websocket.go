@@ -0,0 +1,336 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"http/internal/request"
+	"http/internal/response"
+	"io"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// acceptGUID is appended to the client's key to derive Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize bounds messages unless an Upgrader says otherwise.
+const DefaultMaxMessageSize = 1 << 20
+
+var ERROR_NOT_WEBSOCKET = fmt.Errorf("not a websocket handshake")
+var ERROR_BAD_VERSION = fmt.Errorf("unsupported websocket version")
+var ERROR_ORIGIN_DENIED = fmt.Errorf("websocket origin not allowed")
+var ERROR_MESSAGE_TOO_LARGE = fmt.Errorf("websocket message too large")
+var ERROR_CLOSED = fmt.Errorf("websocket connection closed")
+
+// MessageType tells text messages, which are UTF-8, from binary ones.
+type MessageType int
+
+const (
+	TextMessage   MessageType = opText
+	BinaryMessage MessageType = opBinary
+)
+
+// Close codes of RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+// CloseError is returned by ReadMessage once the peer closed the
+// connection, with the code and reason it gave.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// AcceptKey derives the Sec-WebSocket-Accept value for a client's
+// Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upgrader turns requests into WebSocket connections. The zero value
+// accepts any origin and no subprotocol.
+type Upgrader struct {
+	// Subprotocols the server speaks, in order of preference.
+	Subprotocols []string
+	// CheckOrigin, when set, rejects requests it returns false for with
+	// 403, e.g. to stop other sites' pages from connecting.
+	CheckOrigin func(req *request.Request) bool
+	// MaxMessageSize bounds incoming messages; it defaults to
+	// DefaultMaxMessageSize.
+	MaxMessageSize int64
+}
+
+// Upgrade completes the opening handshake of RFC 6455 section 4.2 and takes
+// the connection over from the server. Requests that are not a valid
+// handshake are answered with an error status and not upgraded.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	h := req.Headers()
+	key, _ := h.Get("sec-websocket-key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	switch {
+	case req.RequestLine.Method != "GET" || req.RequestLine.HttpVersion != "1.1" ||
+		!h.HasToken("connection", "upgrade") || !h.HasToken("upgrade", "websocket"):
+		w.WriteText(response.StatusBadRequest, "websocket handshake expected")
+		return nil, ERROR_NOT_WEBSOCKET
+	case err != nil || len(decoded) != 16:
+		w.WriteText(response.StatusBadRequest, "invalid Sec-WebSocket-Key")
+		return nil, ERROR_NOT_WEBSOCKET
+	case !h.HasToken("sec-websocket-version", "13"):
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteText(response.StatusUpgradeRequired, "unsupported websocket version")
+		return nil, ERROR_BAD_VERSION
+	case u.CheckOrigin != nil && !u.CheckOrigin(req):
+		w.WriteText(response.StatusForbidden, "origin not allowed")
+		return nil, ERROR_ORIGIN_DENIED
+	}
+	out := w.Header()
+	out.Set("Upgrade", "websocket")
+	out.Set("Connection", "Upgrade")
+	out.Set("Sec-WebSocket-Accept", AcceptKey(key))
+	protocol := u.selectSubprotocol(h.List("sec-websocket-protocol"))
+	if protocol != "" {
+		out.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(*out); err != nil {
+		return nil, err
+	}
+	rwc, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	max := u.MaxMessageSize
+	if max <= 0 {
+		max = DefaultMaxMessageSize
+	}
+	c := newConn(rwc, true, max)
+	c.Subprotocol = protocol
+	return c, nil
+}
+
+func (u *Upgrader) selectSubprotocol(offered []string) string {
+	for _, p := range u.Subprotocols {
+		for _, o := range offered {
+			if o == p {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// Upgrade upgrades with a zero Upgrader.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	return (&Upgrader{}).Upgrade(w, req)
+}
+
+// Conn is an open WebSocket connection. One goroutine may read while
+// others write; writes are serialized. Pings are answered while reading.
+type Conn struct {
+	rwc io.ReadWriteCloser
+	br  *bufio.Reader
+	// server connections expect masked frames and send unmasked ones,
+	// client connections the other way round.
+	server bool
+	max    int64
+	// Subprotocol is the one agreed on in the handshake, if any.
+	Subprotocol string
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+func newConn(rwc io.ReadWriteCloser, server bool, max int64) *Conn {
+	return &Conn{rwc: rwc, br: bufio.NewReader(rwc), server: server, max: max}
+}
+
+// ReadMessage returns the next text or binary message, reassembled from
+// its fragments. Once the peer closes the connection it returns a
+// *CloseError; when the peer breaks the protocol it closes the connection
+// with the matching code and returns the cause.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var message []byte
+	inMessage := false
+	for {
+		f, err := readFrame(c.br, c.max)
+		if errors.Is(err, ERROR_FRAME_TOO_LARGE) {
+			return 0, nil, c.fail(CloseMessageTooBig, ERROR_MESSAGE_TOO_LARGE)
+		}
+		if err != nil {
+			c.rwc.Close()
+			return 0, nil, err
+		}
+		if f.rsv != 0 || f.masked != c.server {
+			return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("websocket: bad frame header"))
+		}
+		if f.control() {
+			if !f.fin || len(f.payload) > maxControlPayload {
+				return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("websocket: bad control frame"))
+			}
+			switch f.opcode {
+			case opPing:
+				if err := c.writeFrame(opPong, f.payload); err != nil && !errors.Is(err, ERROR_CLOSED) {
+					return 0, nil, err
+				}
+			case opPong:
+			case opClose:
+				return 0, nil, c.closed(f.payload)
+			default:
+				return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("websocket: unknown opcode %d", f.opcode))
+			}
+			continue
+		}
+		switch {
+		case f.opcode == opContinuation && !inMessage:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("websocket: continuation without a message"))
+		case f.opcode == opText || f.opcode == opBinary:
+			if inMessage {
+				return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("websocket: message interrupted"))
+			}
+			typ, inMessage = MessageType(f.opcode), true
+		case f.opcode != opContinuation:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("websocket: unknown opcode %d", f.opcode))
+		}
+		if int64(len(message)+len(f.payload)) > c.max {
+			return 0, nil, c.fail(CloseMessageTooBig, ERROR_MESSAGE_TOO_LARGE)
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if typ == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, fmt.Errorf("websocket: text message is not UTF-8"))
+		}
+		if message == nil {
+			message = []byte{}
+		}
+		return typ, message, nil
+	}
+}
+
+// closed answers the peer's close frame and closes the connection.
+func (c *Conn) closed(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	if len(payload) == 1 {
+		return c.fail(CloseProtocolError, fmt.Errorf("websocket: bad close frame"))
+	}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) || !utf8.Valid(payload[2:]) {
+			return c.fail(CloseProtocolError, fmt.Errorf("websocket: bad close frame"))
+		}
+	}
+	// Echo the code, as section 5.5.1 asks.
+	echo := payload
+	if len(echo) > 2 {
+		echo = echo[:2]
+	}
+	c.writeFrame(opClose, echo)
+	c.rwc.Close()
+	return closeErr
+}
+
+// validCloseCode reports whether a peer may send code in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail closes the connection with code and returns err.
+func (c *Conn) fail(code int, err error) error {
+	c.Close(code, "")
+	return err
+}
+
+// WriteMessage sends data as one message of type typ.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: unknown message type %d", typ)
+	}
+	return c.writeFrame(byte(typ), data)
+}
+
+// Ping sends a ping; the peer answers it with a pong, which ReadMessage
+// consumes.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return ERROR_FRAME_TOO_LARGE
+	}
+	return c.writeFrame(opPing, data)
+}
+
+// Close sends a close frame with code and reason, then closes the
+// connection without waiting for the peer's answer.
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	err := c.writeFrame(opClose, payload)
+	if cerr := c.rwc.Close(); err == nil {
+		err = cerr
+	}
+	if errors.Is(err, ERROR_CLOSED) {
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ERROR_CLOSED
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	var key *[4]byte
+	if !c.server {
+		key = new([4]byte)
+		rand.Read(key[:])
+	}
+	_, err := c.rwc.Write(appendFrame(nil, opcode, true, payload, key))
+	return err
+}
+
+// String describes the message type.
+func (t MessageType) String() string {
+	switch t {
+	case TextMessage:
+		return "text"
+	case BinaryMessage:
+		return "binary"
+	}
+	return "MessageType(" + strconv.Itoa(int(t)) + ")"
+}
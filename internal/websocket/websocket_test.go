@@ -0,0 +1,211 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"http/internal/request"
+	"http/internal/response"
+	"http/internal/server"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// echo answers every message with itself until the client closes.
+func echo(w *response.Writer, req *request.Request) {
+	u := Upgrader{Subprotocols: []string{"chat"}, MaxMessageSize: 1 << 16}
+	c, err := u.Upgrade(w, req)
+	if err != nil {
+		return
+	}
+	for {
+		typ, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := c.WriteMessage(typ, msg); err != nil {
+			return
+		}
+	}
+}
+
+// clientConn reads through the buffer the handshake response was read with.
+type clientConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *clientConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+// dial performs the handshake, sending early right behind it.
+func dial(t *testing.T, addr string, extra string, early []byte) (*http.Response, *Conn) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	handshake := "GET /ws HTTP/1.1\r\nHost: x\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Key: " + testKey + "\r\n" + extra + "\r\n"
+	_, err = conn.Write(append([]byte(handshake), early...))
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	return res, newConn(&clientConn{Conn: conn, br: br}, false, DefaultMaxMessageSize)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Test: The example of RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey(testKey))
+}
+
+func TestFrames(t *testing.T) {
+	key := [4]byte{1, 2, 3, 4}
+	for _, n := range []int{0, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte("x"), n)
+		for _, k := range []*[4]byte{nil, &key} {
+			// Test: Every length encoding round-trips, masked or not
+			f, err := readFrame(bufio.NewReader(bytes.NewReader(appendFrame(nil, opBinary, true, payload, k))), 0)
+			require.NoError(t, err)
+			assert.True(t, f.fin)
+			assert.Equal(t, byte(opBinary), f.opcode)
+			assert.Equal(t, k != nil, f.masked)
+			assert.Equal(t, payload, f.payload)
+		}
+	}
+
+	// Test: Frames over the limit are refused before their payload is read
+	_, err := readFrame(bufio.NewReader(bytes.NewReader(appendFrame(nil, opText, true, make([]byte, 200), nil))), 100)
+	assert.ErrorIs(t, err, ERROR_FRAME_TOO_LARGE)
+}
+
+func TestUpgrade(t *testing.T) {
+	s, err := server.ServeAddr("127.0.0.1:0", echo)
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Addr().String()
+
+	// Test: The handshake switches protocols and agrees on a subprotocol
+	early := appendFrame(nil, opText, true, []byte("early"), &[4]byte{9, 8, 7, 6})
+	res, c := dial(t, addr, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: other, chat\r\n", early)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	assert.Equal(t, "websocket", res.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", res.Header.Get("Connection"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", res.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "chat", res.Header.Get("Sec-WebSocket-Protocol"))
+	assert.Empty(t, res.Header.Get("Content-Length"))
+
+	// Test: Frames sent right behind the handshake are not lost
+	typ, msg, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, typ)
+	assert.Equal(t, "early", string(msg))
+
+	// Test: Messages are echoed, binary and large ones included
+	big := bytes.Repeat([]byte{0xff}, 40000)
+	require.NoError(t, c.WriteMessage(BinaryMessage, big))
+	typ, msg, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, typ)
+	assert.Equal(t, big, msg)
+
+	// Test: Fragments are reassembled, with a ping answered in between
+	key := &[4]byte{1, 1, 1, 1}
+	var frames []byte
+	frames = appendFrame(frames, opText, false, []byte("hel"), key)
+	frames = appendFrame(frames, opPing, true, []byte("p"), key)
+	frames = appendFrame(frames, opContinuation, true, []byte("lo"), key)
+	c.rwc.Write(frames)
+	f, err := readFrame(c.br, 0)
+	require.NoError(t, err)
+	assert.Equal(t, byte(opPong), f.opcode)
+	assert.Equal(t, "p", string(f.payload))
+	typ, msg, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, typ)
+	assert.Equal(t, "hello", string(msg))
+
+	// Test: Closing is echoed with the same code
+	c.rwc.Write(appendFrame(nil, opClose, true, binary.BigEndian.AppendUint16(nil, CloseNormalClosure), key))
+	f, err = readFrame(c.br, 0)
+	require.NoError(t, err)
+	assert.Equal(t, byte(opClose), f.opcode)
+	assert.Equal(t, uint16(CloseNormalClosure), binary.BigEndian.Uint16(f.payload))
+	_, err = c.br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Invalid UTF-8 in text messages closes with 1007
+	_, c = dial(t, addr, "Sec-WebSocket-Version: 13\r\n", nil)
+	c.rwc.Write(appendFrame(nil, opText, true, []byte{0xc3, 0x28}, key))
+	f, err = readFrame(c.br, 0)
+	require.NoError(t, err)
+	assert.Equal(t, byte(opClose), f.opcode)
+	assert.Equal(t, uint16(CloseInvalidPayload), binary.BigEndian.Uint16(f.payload))
+
+	// Test: Unmasked client frames are a protocol error
+	_, c = dial(t, addr, "Sec-WebSocket-Version: 13\r\n", nil)
+	c.rwc.Write(appendFrame(nil, opText, true, []byte("hi"), nil))
+	f, err = readFrame(c.br, 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(CloseProtocolError), binary.BigEndian.Uint16(f.payload))
+
+	// Test: Messages over the limit close with 1009
+	_, c = dial(t, addr, "Sec-WebSocket-Version: 13\r\n", nil)
+	c.rwc.Write(appendFrame(nil, opBinary, true, make([]byte, 1<<16+1), key))
+	f, err = readFrame(c.br, 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(CloseMessageTooBig), binary.BigEndian.Uint16(f.payload))
+
+	// Test: Other versions are answered 426 with the one supported
+	res, _ = dial(t, addr, "Sec-WebSocket-Version: 8\r\n", nil)
+	assert.Equal(t, http.StatusUpgradeRequired, res.StatusCode)
+	assert.Equal(t, "13", res.Header.Get("Sec-WebSocket-Version"))
+
+	// Test: Plain requests are not upgraded
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	body, _ := io.ReadAll(res.Body)
+	assert.True(t, strings.Contains(string(body), "websocket"))
+}
+
+func TestCheckOrigin(t *testing.T) {
+	u := Upgrader{CheckOrigin: func(req *request.Request) bool {
+		origin, _ := req.Headers().Get("origin")
+		return origin == "https://example.com"
+	}}
+	s, err := server.ServeAddr("127.0.0.1:0", func(w *response.Writer, req *request.Request) {
+		if c, err := u.Upgrade(w, req); err == nil {
+			c.Close(CloseGoingAway, "bye")
+		}
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Foreign origins are refused with 403
+	res, _ := dial(t, s.Addr().String(), "Sec-WebSocket-Version: 13\r\nOrigin: https://evil.example\r\n", nil)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// Test: Allowed origins are upgraded, and Close sends code and reason
+	res, c := dial(t, s.Addr().String(), "Sec-WebSocket-Version: 13\r\nOrigin: https://example.com\r\n", nil)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	_, _, err = c.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+}